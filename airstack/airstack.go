@@ -11,6 +11,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	ac "github.com/vocdoni/vote-frame/airstack/client"
+	"github.com/vocdoni/vote-frame/tokenholders"
 	"go.vocdoni.io/dvote/log"
 )
 
@@ -61,6 +62,30 @@ func (a *Airstack) MaxHolders() uint32 {
 	return a.maxHolders
 }
 
+// TokenDecimals returns the number of decimals of the token with the given
+// address in the given blockchain. The Airstack client uses its own context.
+func (a *Airstack) TokenDecimals(_ context.Context, address common.Address, blockchain string) (int, error) {
+	return a.TokenDecimalsByToken(address.Hex(), blockchain)
+}
+
+// TokenHolders returns the holders of the token with the given address in the
+// given blockchain and their balances. The Airstack client uses its own
+// context.
+func (a *Airstack) TokenHolders(_ context.Context, address common.Address, blockchain string) ([]*tokenholders.TokenHolder, error) {
+	balances, err := a.TokenBalances(address, blockchain)
+	if err != nil {
+		return nil, err
+	}
+	holders := make([]*tokenholders.TokenHolder, 0, len(balances))
+	for _, balance := range balances {
+		holders = append(holders, &tokenholders.TokenHolder{
+			Address: balance.Address,
+			Balance: balance.Balance,
+		})
+	}
+	return holders, nil
+}
+
 func (a *Airstack) TokenDecimalsByToken(tokenAddress, blockchain string) (int, error) {
 	td, err := a.TokenDetails(common.HexToAddress(tokenAddress), blockchain)
 	if err != nil {
@@ -14,28 +14,51 @@ import (
 // defaultCoolDown is the default time to wait between casts
 const defaultCoolDown = time.Second * 10
 
-// muteRequestContent is the content of a cast that requests to mute a user that
-// created a poll and wants to avoid notifications from that user.
-const muteRequestContent = "mute"
+// maxRecentCasts is the number of hashes of the last casts received that the
+// bot keeps in memory to discard the duplicated ones.
+const maxRecentCasts = 1024
+
+// StateStore is the interface that the bot uses to persist its state between
+// restarts: the cursor of the mentions stream and the hashes of the casts
+// already handled.
+type StateStore interface {
+	BotCursor(fid uint64) (uint64, error)
+	SetBotCursor(fid, cursor uint64) error
+	BotCastHandled(fid uint64, hash string) (bool, error)
+	AddBotCast(fid uint64, hash string) (bool, error)
+}
 
 // BotConfig is the configuration definition for the bot, it includes the API
-// instance and the cool down time between casts (default is 10 seconds)
+// instance and the cool down time between casts (default is 10 seconds). If a
+// mentions stream is provided, the bot uses it instead of polling the API for
+// new mentions. If a state store is provided, the bot resumes the stream from
+// the last cursor stored and discards the casts already handled before a
+// restart.
 type BotConfig struct {
 	API      farcasterapi.API
+	Stream   farcasterapi.MentionStreamer
+	Store    StateStore
 	CoolDown time.Duration
 }
 
 // Bot struct represents a bot that listens for new casts and sends them to a
 // channel, it also has a cool down time to avoid spamming the API and a last
 // cast timestamp to retrieve new casts from that point, ensuring no cast is
-// missed or duplicated
+// missed or duplicated. Every message sent to the Messages channel must be
+// acknowledged with Handled once it has been processed, the bot waits for it
+// before registering the cast as handled and receiving the next one.
 type Bot struct {
 	UserData *farcasterapi.Userdata
 	api      farcasterapi.API
+	stream   farcasterapi.MentionStreamer
+	store    StateStore
 	ctx      context.Context
 	cancel   context.CancelFunc
 	coolDown time.Duration
 	lastCast uint64
+	recent   map[string]struct{}
+	order    []string
+	handled  chan struct{}
 	Messages chan *farcasterapi.APIMessage
 }
 
@@ -50,8 +73,12 @@ func New(config BotConfig) (*Bot, error) {
 	}
 	bot := &Bot{
 		api:      config.API,
+		stream:   config.Stream,
+		store:    config.Store,
 		coolDown: config.CoolDown,
 		lastCast: uint64(time.Now().Unix()),
+		recent:   make(map[string]struct{}),
+		handled:  make(chan struct{}),
 		Messages: make(chan *farcasterapi.APIMessage),
 	}
 	// retrieve the bot user data from the API
@@ -65,10 +92,15 @@ func New(config BotConfig) (*Bot, error) {
 }
 
 // Start function starts the bot, it listens for new casts and sends them to the
-// Messages channel. It does this in a goroutine to avoid blocking the main and
-// every cool down time.
+// Messages channel. It does this in a goroutine to avoid blocking the main. If
+// the bot has a mentions stream it receives the mentions from it, else it
+// polls the API every cool down time.
 func (b *Bot) Start(ctx context.Context) {
 	b.ctx, b.cancel = context.WithCancel(ctx)
+	if b.stream != nil {
+		go b.streamMentions()
+		return
+	}
 	go func() {
 		ticker := time.NewTicker(b.coolDown)
 		defer ticker.Stop()
@@ -84,9 +116,9 @@ func (b *Bot) Start(ctx context.Context) {
 					continue
 				}
 				b.lastCast = lastCast
-				if len(messages) > 0 {
-					for _, msg := range messages {
-						b.Messages <- msg
+				for _, msg := range messages {
+					if !b.deliver(msg) {
+						return
 					}
 				}
 				// wait for the cool down time
@@ -96,6 +128,126 @@ func (b *Bot) Start(ctx context.Context) {
 	}()
 }
 
+// streamMentions receives the mentions from the stream of the bot and sends
+// them to the Messages channel. It resumes the stream from the last cursor
+// stored and stores the cursor of every event once its mention has been
+// handled, so no mention is missed after a restart. If the stream fails, it is restarted from the last
+// cursor after the cool down time.
+func (b *Bot) streamMentions() {
+	cursor := uint64(0)
+	if b.store != nil {
+		var err error
+		if cursor, err = b.store.BotCursor(b.api.FID()); err != nil {
+			log.Warnw("error retrieving bot cursor, streaming mentions from now", "error", err)
+		}
+	}
+	for {
+		events := make(chan *farcasterapi.MentionEvent)
+		done := make(chan error, 1)
+		go func(from uint64) {
+			done <- b.stream.StreamMentions(b.ctx, from, events)
+		}(cursor)
+	stream:
+		for {
+			select {
+			case event := <-events:
+				if event.Message != nil && !b.deliver(event.Message) {
+					return
+				}
+				cursor = event.Cursor
+				if b.store != nil {
+					if err := b.store.SetBotCursor(b.api.FID(), cursor); err != nil {
+						log.Warnw("error storing bot cursor", "cursor", cursor, "error", err)
+					}
+				}
+			case err := <-done:
+				if err != nil {
+					log.Errorw(err, "error streaming mentions")
+				}
+				break stream
+			}
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(b.coolDown):
+		}
+	}
+}
+
+// deliver sends the message provided to the Messages channel, unless it has
+// already been handled, and waits until it is handled. Then the cast is
+// registered as handled. It returns false if the bot has been stopped.
+func (b *Bot) deliver(msg *farcasterapi.APIMessage) bool {
+	if b.isHandled(msg.Hash) {
+		log.Debugw("discarding duplicated cast", "hash", msg.Hash)
+		return true
+	}
+	select {
+	case b.Messages <- msg:
+	case <-b.ctx.Done():
+		return false
+	}
+	select {
+	case <-b.handled:
+	case <-b.ctx.Done():
+		return false
+	}
+	b.markHandled(msg.Hash)
+	return true
+}
+
+// Handled acknowledges that the last message received from the Messages
+// channel has been processed, so the bot can register it and send the next
+// one.
+func (b *Bot) Handled() {
+	select {
+	case b.handled <- struct{}{}:
+	case <-b.ctx.Done():
+	}
+}
+
+// isHandled returns true if the cast with the given hash has already been
+// handled by the bot. The last hashes are kept in memory and, if the bot has
+// a state store, the store is also checked to discard the casts handled
+// before a restart.
+func (b *Bot) isHandled(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	if _, ok := b.recent[hash]; ok {
+		return true
+	}
+	if b.store != nil {
+		handled, err := b.store.BotCastHandled(b.api.FID(), hash)
+		if err != nil {
+			log.Warnw("error checking bot cast", "hash", hash, "error", err)
+			return false
+		}
+		return handled
+	}
+	return false
+}
+
+// markHandled registers the cast with the given hash as handled, in memory
+// and, if the bot has a state store, in the store.
+func (b *Bot) markHandled(hash string) {
+	if hash == "" {
+		return
+	}
+	b.recent[hash] = struct{}{}
+	b.order = append(b.order, hash)
+	if len(b.order) > maxRecentCasts {
+		delete(b.recent, b.order[0])
+		b.order = b.order[1:]
+	}
+	if b.store != nil {
+		if _, err := b.store.AddBotCast(b.api.FID(), hash); err != nil {
+			log.Warnw("error storing bot cast", "hash", hash, "error", err)
+		}
+	}
+}
+
 // Stop function stops the bot and its goroutine, and closes the Messages channel.
 func (b *Bot) Stop() {
 	if err := b.api.Stop(); err != nil {
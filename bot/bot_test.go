@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+const testBotFID = 100
+
+// testStream is a mentions stream that sends its mentions from the cursor
+// provided, which is the index of the mention, or from the start if
+// ignoreCursor is set. Then it waits until the context is done.
+type testStream struct {
+	mentions     []*farcasterapi.APIMessage
+	ignoreCursor bool
+}
+
+func (s *testStream) StreamMentions(ctx context.Context, cursor uint64, events chan<- *farcasterapi.MentionEvent) error {
+	if s.ignoreCursor {
+		cursor = 0
+	}
+	for i := cursor; i < uint64(len(s.mentions)); i++ {
+		select {
+		case events <- &farcasterapi.MentionEvent{Message: s.mentions[i], Cursor: i + 1}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// testStore is a StateStore that keeps the state in memory.
+type testStore struct {
+	mtx    sync.Mutex
+	cursor uint64
+	casts  map[string]bool
+}
+
+func (s *testStore) BotCursor(_ uint64) (uint64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.cursor, nil
+}
+
+func (s *testStore) SetBotCursor(_, cursor uint64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.cursor = cursor
+	return nil
+}
+
+func (s *testStore) BotCastHandled(_ uint64, hash string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.casts[hash], nil
+}
+
+func (s *testStore) AddBotCast(_ uint64, hash string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.casts == nil {
+		s.casts = make(map[string]bool)
+	}
+	isNew := !s.casts[hash]
+	s.casts[hash] = true
+	return isNew, nil
+}
+
+func (s *testStore) state() (uint64, int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.cursor, len(s.casts)
+}
+
+// startTestBot creates and starts a bot with the stream and store provided.
+// The bot is stopped when the test ends.
+func startTestBot(c *qt.C, stream farcasterapi.MentionStreamer, store StateStore) *Bot {
+	api := mock.NewMockAPI()
+	api.AddFixtures(&mock.Fixtures{Users: []*mock.UserFixture{{FID: testBotFID, Username: testBotUsername}}})
+	c.Assert(api.SetFarcasterUser(testBotFID, ""), qt.IsNil)
+	b, err := New(BotConfig{API: api, Stream: stream, Store: store, CoolDown: 10 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+	b.Start(context.Background())
+	c.Cleanup(b.Stop)
+	return b
+}
+
+// receive returns the next message of the bot, or fails if it takes too long.
+func receive(c *qt.C, b *Bot) *farcasterapi.APIMessage {
+	select {
+	case msg := <-b.Messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		c.Fatal("timeout waiting for a bot message")
+		return nil
+	}
+}
+
+// noMessage fails if the bot sends a message in a short time.
+func noMessage(c *qt.C, b *Bot) {
+	select {
+	case msg := <-b.Messages:
+		c.Fatalf("unexpected bot message %s", msg.Hash)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBotRestart(t *testing.T) {
+	c := qt.New(t)
+
+	stream := &testStream{mentions: []*farcasterapi.APIMessage{
+		{Hash: "0x01", Author: 1, Content: "@vote help"},
+		{Hash: "0x02", Author: 2, Content: "@vote help"},
+	}}
+	store := &testStore{}
+
+	// the bot stops before handling the first mention, so neither the cursor
+	// is advanced nor the cast is registered
+	b := startTestBot(c, stream, store)
+	c.Assert(receive(c, b).Hash, qt.Equals, "0x01")
+	b.cancel()
+	cursor, casts := store.state()
+	c.Assert(cursor, qt.Equals, uint64(0))
+	c.Assert(casts, qt.Equals, 0)
+
+	// after the restart, the mention is received again and, once handled,
+	// the cursor is advanced
+	b = startTestBot(c, stream, store)
+	c.Assert(receive(c, b).Hash, qt.Equals, "0x01")
+	b.Handled()
+	c.Assert(receive(c, b).Hash, qt.Equals, "0x02")
+	cursor, casts = store.state()
+	c.Assert(cursor, qt.Equals, uint64(1))
+	c.Assert(casts, qt.Equals, 1)
+	b.Handled()
+	for cursor != 2 {
+		time.Sleep(time.Millisecond)
+		cursor, _ = store.state()
+	}
+	b.cancel()
+
+	// the next restart resumes the stream after the last mention handled
+	b = startTestBot(c, stream, store)
+	noMessage(c, b)
+}
+
+func TestBotDuplicatedMentions(t *testing.T) {
+	c := qt.New(t)
+
+	mention := &farcasterapi.APIMessage{Hash: "0x01", Author: 1, Content: "@vote help"}
+	stream := &testStream{mentions: []*farcasterapi.APIMessage{mention, mention}, ignoreCursor: true}
+	store := &testStore{}
+
+	// the duplicated mentions of the stream are discarded
+	b := startTestBot(c, stream, store)
+	c.Assert(receive(c, b).Hash, qt.Equals, "0x01")
+	b.Handled()
+	noMessage(c, b)
+	b.cancel()
+
+	// the mentions handled before a restart are discarded too
+	b = startTestBot(c, stream, store)
+	noMessage(c, b)
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+// The commands that the bot understands. Every mention of the bot that does
+// not start with one of them is handled as a new poll.
+const (
+	CommandPoll    = "poll"
+	CommandResults = "results"
+	CommandClose   = "close"
+	CommandRemind  = "remind"
+	CommandHelp    = "help"
+	CommandMute    = "mute"
+)
+
+// The census modifiers that can be included in a new poll to set its census
+// instead of the default one.
+const (
+	CensusChannel   = "channel"
+	CensusFollowers = "followers"
+	CensusToken     = "token"
+)
+
+const (
+	// censusModifierKeyword is the first word of the line of a new poll that
+	// sets its census.
+	censusModifierKeyword = "census"
+	// TokenTypeERC20 and TokenTypeNFT are the types of token that a token
+	// census modifier can include, the default one is ERC20.
+	TokenTypeERC20 = "erc20"
+	TokenTypeNFT   = "nft"
+)
+
+// commandKeywords are the keywords of the commands that are not polls.
+var commandKeywords = map[string]bool{
+	CommandResults: true,
+	CommandClose:   true,
+	CommandRemind:  true,
+	CommandHelp:    true,
+	CommandMute:    true,
+}
+
+// Command is a command received by the bot in a mention. The name is one of
+// the commands that the bot understands and the args are the rest of the words
+// of the command. For the new polls, the body is the poll text and the census
+// is the census modifier included, if any.
+type Command struct {
+	Name    string
+	Args    []string
+	Body    string
+	Census  *CensusModifier
+	Message *farcasterapi.APIMessage
+}
+
+// CensusModifier is the census set in a new poll with a line that follows the
+// format:
+//
+//	census channel:<channel id>
+//	census followers:[<username>]
+//	census token:[erc20|nft:]<blockchain>:<address>
+type CensusModifier struct {
+	Type  string
+	Value string
+}
+
+// Token returns the type, blockchain and address of the token of a token
+// census modifier.
+func (m *CensusModifier) Token() (string, string, string, error) {
+	parts := strings.Split(m.Value, ":")
+	switch len(parts) {
+	case 2:
+		return TokenTypeERC20, parts[0], parts[1], nil
+	case 3:
+		tokenType := strings.ToLower(parts[0])
+		if tokenType != TokenTypeERC20 && tokenType != TokenTypeNFT {
+			return "", "", "", fmt.Errorf("%w: unknown token type %s", ErrInvalidCensus, parts[0])
+		}
+		return tokenType, parts[1], parts[2], nil
+	default:
+		return "", "", "", fmt.Errorf("%w: token must be [erc20|nft:]<blockchain>:<address>", ErrInvalidCensus)
+	}
+}
+
+// ParseCommand parses the content of a mention of the bot with the given
+// username. The bot mention at the beginning of the content is ignored. If
+// the content is a single line that starts with a command keyword, it returns
+// that command, else it returns a new poll command with the census modifier
+// line, if any, removed from its body.
+func ParseCommand(content, botUsername string) (*Command, error) {
+	content = strings.TrimSpace(content)
+	if botUsername != "" {
+		mention := "@" + botUsername
+		if len(content) >= len(mention) && strings.EqualFold(content[:len(mention)], mention) {
+			content = strings.TrimSpace(content[len(mention):])
+		}
+	}
+	if content == "" {
+		return nil, ErrEmptyCommand
+	}
+	if !strings.Contains(content, "\n") {
+		words := strings.Fields(content)
+		if keyword := strings.ToLower(words[0]); commandKeywords[keyword] {
+			return &Command{Name: keyword, Args: words[1:]}, nil
+		}
+	}
+	cmd := &Command{Name: CommandPoll}
+	body := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.ToLower(words[0]) != censusModifierKeyword {
+			body = append(body, line)
+			continue
+		}
+		if cmd.Census != nil {
+			return nil, fmt.Errorf("%w: only one census can be set", ErrInvalidCensus)
+		}
+		census, err := parseCensusModifier(words[1:])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Census = census
+	}
+	cmd.Body = strings.TrimSpace(strings.Join(body, "\n"))
+	return cmd, nil
+}
+
+// parseCensusModifier parses the words of a census modifier line after the
+// census keyword.
+func parseCensusModifier(words []string) (*CensusModifier, error) {
+	if len(words) != 1 {
+		return nil, fmt.Errorf("%w: census must be channel:, followers: or token:", ErrInvalidCensus)
+	}
+	censusType, value, ok := strings.Cut(words[0], ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: census must be channel:, followers: or token:", ErrInvalidCensus)
+	}
+	census := &CensusModifier{Type: strings.ToLower(censusType), Value: value}
+	switch census.Type {
+	case CensusChannel:
+		if census.Value == "" {
+			return nil, fmt.Errorf("%w: missing channel", ErrInvalidCensus)
+		}
+	case CensusFollowers:
+		census.Value = strings.TrimPrefix(census.Value, "@")
+	case CensusToken:
+		if _, _, _, err := census.Token(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown census type %s", ErrInvalidCensus, censusType)
+	}
+	return census, nil
+}
+
+// NumberArg returns the argument of the command in the given position as a
+// positive number. It returns the default value provided if the command has
+// no argument in that position.
+func (c *Command) NumberArg(i, defaultValue int) (int, error) {
+	if i >= len(c.Args) {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(c.Args[i])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%w: %s is not a positive number", ErrInvalidArgs, c.Args[i])
+	}
+	return n, nil
+}
@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+const testBotUsername = "vote"
+
+const pollMessage = `What is your favourite colour?
+- Red
+- Blue`
+
+func TestParseCommand(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name     string
+		content  string
+		expected *Command
+		err      error
+	}{
+		{
+			name:     "results",
+			content:  "results",
+			expected: &Command{Name: CommandResults, Args: []string{}},
+		},
+		{
+			name:     "bot mention and upper case",
+			content:  "@Vote  Close",
+			expected: &Command{Name: CommandClose, Args: []string{}},
+		},
+		{
+			name:     "remind with args",
+			content:  "remind 10",
+			expected: &Command{Name: CommandRemind, Args: []string{"10"}},
+		},
+		{
+			name:     "help",
+			content:  "@vote help",
+			expected: &Command{Name: CommandHelp, Args: []string{}},
+		},
+		{
+			name:     "mute",
+			content:  " mute ",
+			expected: &Command{Name: CommandMute, Args: []string{}},
+		},
+		{
+			name:     "poll",
+			content:  "@vote " + pollMessage,
+			expected: &Command{Name: CommandPoll, Body: pollMessage},
+		},
+		{
+			name:    "multiline poll starting with a keyword",
+			content: "Results of the game?\n- Red\n- Blue",
+			expected: &Command{
+				Name: CommandPoll,
+				Body: "Results of the game?\n- Red\n- Blue",
+			},
+		},
+		{
+			name:    "poll with channel census",
+			content: "census channel:vocdoni\n" + pollMessage,
+			expected: &Command{
+				Name:   CommandPoll,
+				Body:   pollMessage,
+				Census: &CensusModifier{Type: CensusChannel, Value: "vocdoni"},
+			},
+		},
+		{
+			name:    "poll with followers census at the end",
+			content: pollMessage + "\nCensus followers:@alice",
+			expected: &Command{
+				Name:   CommandPoll,
+				Body:   pollMessage,
+				Census: &CensusModifier{Type: CensusFollowers, Value: "alice"},
+			},
+		},
+		{
+			name:    "poll with own followers census",
+			content: "census followers:\n" + pollMessage,
+			expected: &Command{
+				Name:   CommandPoll,
+				Body:   pollMessage,
+				Census: &CensusModifier{Type: CensusFollowers},
+			},
+		},
+		{
+			name:    "poll with token census",
+			content: "census token:nft:base:0x1234\n" + pollMessage,
+			expected: &Command{
+				Name:   CommandPoll,
+				Body:   pollMessage,
+				Census: &CensusModifier{Type: CensusToken, Value: "nft:base:0x1234"},
+			},
+		},
+		{name: "empty", content: "@vote ", err: ErrEmptyCommand},
+		{name: "missing channel", content: "census channel:\n" + pollMessage, err: ErrInvalidCensus},
+		{name: "unknown census", content: "census users:alice\n" + pollMessage, err: ErrInvalidCensus},
+		{name: "census without type", content: "census vocdoni\n" + pollMessage, err: ErrInvalidCensus},
+		{name: "invalid token", content: "census token:0x1234\n" + pollMessage, err: ErrInvalidCensus},
+		{name: "invalid token type", content: "census token:erc721:base:0x1234\n" + pollMessage, err: ErrInvalidCensus},
+		{
+			name:    "two censuses",
+			content: "census channel:vocdoni\ncensus followers:\n" + pollMessage,
+			err:     ErrInvalidCensus,
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			cmd, err := ParseCommand(test.content, testBotUsername)
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(cmd, qt.DeepEquals, test.expected)
+		})
+	}
+}
+
+func TestCensusModifierToken(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		value      string
+		tokenType  string
+		blockchain string
+		address    string
+		err        error
+	}{
+		{value: "base:0x1234", tokenType: TokenTypeERC20, blockchain: "base", address: "0x1234"},
+		{value: "ERC20:ethereum:0x1234", tokenType: TokenTypeERC20, blockchain: "ethereum", address: "0x1234"},
+		{value: "nft:base:0x1234", tokenType: TokenTypeNFT, blockchain: "base", address: "0x1234"},
+		{value: "0x1234", err: ErrInvalidCensus},
+		{value: "erc721:base:0x1234", err: ErrInvalidCensus},
+	}
+	for _, test := range tests {
+		c.Run(test.value, func(c *qt.C) {
+			census := &CensusModifier{Type: CensusToken, Value: test.value}
+			tokenType, blockchain, address, err := census.Token()
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(tokenType, qt.Equals, test.tokenType)
+			c.Assert(blockchain, qt.Equals, test.blockchain)
+			c.Assert(address, qt.Equals, test.address)
+		})
+	}
+}
+
+func TestCommandNumberArg(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		args     []string
+		expected int
+		err      error
+	}{
+		{args: nil, expected: 5},
+		{args: []string{"10"}, expected: 10},
+		{args: []string{"0"}, err: ErrInvalidArgs},
+		{args: []string{"ten"}, err: ErrInvalidArgs},
+	}
+	for _, test := range tests {
+		c.Run(fmt.Sprint(test.args), func(c *qt.C) {
+			n, err := (&Command{Args: test.args}).NumberArg(0, 5)
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(n, qt.Equals, test.expected)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	c := qt.New(t)
+
+	const creatorFID, otherFID = 1, 2
+	pollCreator := func(_ context.Context, cmd *Command) error {
+		if cmd.Message.Author != creatorFID {
+			return fmt.Errorf("only the poll creator can run %s", cmd.Name)
+		}
+		return nil
+	}
+	handled := []string{}
+	handler := func(_ context.Context, cmd *Command) error {
+		handled = append(handled, cmd.Name)
+		return nil
+	}
+	router := NewRouter(testBotUsername)
+	router.Handle(CommandPoll, "<question> - <option>...", nil, handler)
+	router.Handle(CommandResults, "results", RequireReply, handler)
+	router.Handle(CommandClose, "close", AllOf(RequireReply, pollCreator), handler)
+	router.Handle(CommandHelp, "help", nil, handler)
+
+	reply := &farcasterapi.ParentAPIMessage{FID: creatorFID, Hash: "0x01"}
+	tests := []struct {
+		name    string
+		msg     *farcasterapi.APIMessage
+		handled string
+		err     error
+	}{
+		{
+			name:    "poll",
+			msg:     &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: pollMessage},
+			handled: CommandPoll,
+		},
+		{
+			name:    "results",
+			msg:     &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: "results", Parent: reply},
+			handled: CommandResults,
+		},
+		{
+			name: "results without reply",
+			msg:  &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: "results"},
+			err:  ErrNotReply,
+		},
+		{
+			name:    "close by the creator",
+			msg:     &farcasterapi.APIMessage{IsMention: true, Author: creatorFID, Content: "close", Parent: reply},
+			handled: CommandClose,
+		},
+		{
+			name: "close by other user",
+			msg:  &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: "close", Parent: reply},
+			err:  ErrNotAllowed,
+		},
+		{
+			name: "not registered command",
+			msg:  &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: "mute", Parent: reply},
+			err:  ErrUnknownCommand,
+		},
+		{
+			name: "invalid census",
+			msg:  &farcasterapi.APIMessage{IsMention: true, Author: otherFID, Content: "census foo:bar\n" + pollMessage},
+			err:  ErrInvalidCensus,
+		},
+		{
+			name: "not a mention",
+			msg:  &farcasterapi.APIMessage{Author: otherFID, Content: "help"},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			handled = []string{}
+			_, err := router.Route(context.Background(), test.msg)
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				c.Assert(handled, qt.HasLen, 0)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			if test.handled == "" {
+				c.Assert(handled, qt.HasLen, 0)
+				return
+			}
+			c.Assert(handled, qt.DeepEquals, []string{test.handled})
+		})
+	}
+
+	c.Assert(router.Usage(), qt.Equals, "<question> - <option>...\nresults\nclose\nhelp")
+}
@@ -17,4 +17,20 @@ var (
 	// ErrGettingParentCast is returned when there is an error getting the parent
 	// cast during the mute notifications message handler.
 	ErrGettingParentCast = fmt.Errorf("error getting parent cast")
+	// ErrEmptyCommand is returned when a mention of the bot has no content.
+	ErrEmptyCommand = fmt.Errorf("empty command")
+	// ErrUnknownCommand is returned when a mention of the bot includes a
+	// command that has not been registered in the router.
+	ErrUnknownCommand = fmt.Errorf("unknown command")
+	// ErrInvalidArgs is returned when the arguments of a command are invalid.
+	ErrInvalidArgs = fmt.Errorf("invalid command arguments")
+	// ErrInvalidCensus is returned when the census modifier of a new poll is
+	// invalid.
+	ErrInvalidCensus = fmt.Errorf("invalid census")
+	// ErrNotAllowed is returned when the author of a command is not allowed to
+	// run it.
+	ErrNotAllowed = fmt.Errorf("command not allowed")
+	// ErrNotReply is returned when a command that must be a reply to a cast is
+	// not.
+	ErrNotReply = fmt.Errorf("the command must be a reply to the cast of the poll")
 )
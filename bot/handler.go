@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/vocdoni/vote-frame/bot/poll"
@@ -21,58 +20,50 @@ Now copy the URL, paste the Frame into a cast and share it with others!
 
 👇`
 
-// PollMessageHandler is a function that handles a new cast and checks if it is a
-// poll, if it is, it parses the poll and returns the user data and the poll. If
-// it is not a poll, it returns false. It returns an error if something goes
-// wrong, including when message parsing fails.
-func (b *Bot) PollMessageHandler(ctx context.Context, msg *farcasterapi.APIMessage, maxDuration time.Duration) (*farcasterapi.Userdata, *poll.Poll, bool, error) {
-	// when a new cast is received, check if it is a mention and if
-	// it is not, continue to the next cast
-	if !msg.IsMention {
-		return nil, nil, false, nil
-	}
-	// try to parse the message as a poll, if it fails continue to
-	// the next cast
-	pollConf := poll.DefaultConfig
+// PollCommandHandler parses the poll of the new poll command provided and
+// returns the user data of its author and the poll. It returns an error if
+// the poll can not be parsed or the user data can not be retrieved.
+func (b *Bot) PollCommandHandler(ctx context.Context, cmd *Command, maxDuration time.Duration) (*farcasterapi.Userdata, *poll.Poll, error) {
+	pollConf := *poll.DefaultConfig
 	pollConf.DefaultDuration = maxDuration
-	poll, err := poll.ParseString(msg.Content, pollConf)
+	poll, err := poll.ParseString(cmd.Body, &pollConf)
 	if err != nil {
-		return nil, nil, false, errors.Join(ErrParsingPoll, err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrParsingPoll, err)
 	}
-	// get the user data such as username, custody address and
-	// verification addresses to create the election frame
-	userdata, err := b.api.UserDataByFID(ctx, msg.Author)
+	userdata, err := b.api.UserDataByFID(ctx, cmd.Message.Author)
 	if err != nil {
-		return nil, nil, true, errors.Join(ErrGettingUserData, err)
+		return nil, nil, errors.Join(ErrGettingUserData, err)
 	}
-	return userdata, poll, true, nil
+	return userdata, poll, nil
 }
 
-func (b *Bot) MuteRequestHandler(ctx context.Context, msg *farcasterapi.APIMessage) (*farcasterapi.Userdata, *farcasterapi.APIMessage, bool, error) {
-	// when a new cast is received, check if it is a mention and if
-	// it is not, continue to the next cast
-	if !msg.IsMention {
-		return nil, nil, false, nil
+// UserDataByFID returns the user data of the user with the given fid.
+func (b *Bot) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.Userdata, error) {
+	userdata, err := b.api.UserDataByFID(ctx, fid)
+	if err != nil {
+		return nil, errors.Join(ErrGettingUserData, err)
 	}
+	return userdata, nil
+}
+
+// ParentCast returns the cast that the message provided replies to.
+func (b *Bot) ParentCast(ctx context.Context, msg *farcasterapi.APIMessage) (*farcasterapi.APIMessage, error) {
 	if msg.Parent == nil {
-		return nil, nil, false, nil
-	}
-	// check if the content of the cast is a mute request, if it is
-	if strings.TrimSpace(msg.Content) != muteRequestContent {
-		return nil, nil, false, nil
-	}
-	// get the user data such as username, custody address and verification
-	// addresses of the user that wants to mute the creator of the poll
-	userdata, err := b.api.UserDataByFID(ctx, msg.Author)
-	if err != nil {
-		return nil, nil, true, errors.Join(ErrGettingUserData, err)
+		return nil, ErrNotReply
 	}
-	// get the parent message to recover the poll
 	parentMsg, err := b.api.GetCast(ctx, msg.Parent.FID, msg.Parent.Hash)
 	if err != nil {
-		return nil, nil, true, errors.Join(ErrGettingParentCast, err)
+		return nil, errors.Join(ErrGettingParentCast, err)
+	}
+	return parentMsg, nil
+}
+
+// Reply replies to the message provided with the given content and embeds.
+func (b *Bot) Reply(ctx context.Context, msg *farcasterapi.APIMessage, content string, embeds ...string) error {
+	if err := b.api.Reply(ctx, msg, content, nil, embeds...); err != nil {
+		return errors.Join(ErrReplyingToCast, err)
 	}
-	return userdata, parentMsg, true, nil
+	return nil
 }
 
 func (b *Bot) ReplyWithPollURL(ctx context.Context, msg *farcasterapi.APIMessage, pollURL string) error {
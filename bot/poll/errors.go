@@ -18,4 +18,22 @@ var (
 	// question content is set, and the number of options is greater than the
 	// maximum number of options.
 	ErrMaxOptionsReached = fmt.Errorf("max number of options reached")
+	// ErrNoOptions is returned when the poll command is recognised but it has
+	// no options, so the message is probably not a poll.
+	ErrNoOptions = fmt.Errorf("no options found")
+	// ErrInvalidOption is returned when the poll command is recognised, the
+	// question content is set, and an option is empty or it is listed after
+	// the duration and the flags.
+	ErrInvalidOption = fmt.Errorf("invalid option")
+	// ErrParsingEndTime is returned when the poll command is recognised, the
+	// question and options are set, and the end time content is set but it
+	// cannot be parsed or it is out of the duration limits.
+	ErrParsingEndTime = fmt.Errorf("error parsing end time")
+	// ErrInvalidFlag is returned when the poll command is recognised, the
+	// question and options are set, and a flag is set with an invalid value.
+	ErrInvalidFlag = fmt.Errorf("invalid flag")
+	// ErrUnknownSetting is returned when the poll command is recognised, the
+	// question and options are set, and a line after the options is not a
+	// duration, an end time or a flag.
+	ErrUnknownSetting = fmt.Errorf("unknown setting")
 )
@@ -3,35 +3,81 @@
 // also contains the default configuration for a poll.
 // The parser follows the format:
 // <question>
+//
+// <description*>
 // - <option 1>
 // - <option 2>
 // - <option 3*>
 // - <option 4*>
-// <duration*>
-// The duration is optional and if not set, it takes the default duration. The
-// minimum and maximum number of options are also configurable. The question
-// can be set in multiple lines, but the options must be set in a single line.
+// <duration or end time*>
+// <flags*>
+// The question can be set in multiple lines and the description is the text
+// between the question and the options, separated from the question by an
+// empty line. The options must be set in a single line each, prefixed by a
+// dash, an asterisk, a bullet, a number followed by a dot or a parenthesis,
+// or a keycap emoji. The duration (e.g. 90m, 12h, 3 days or 1w) or the end
+// time (e.g. ends 2024-05-01 18:00 Europe/Madrid) is optional and if not set,
+// it takes the default duration. The flags are 'overwrite', to allow the
+// voters to change their vote, and 'community:<id>', to create the poll for a
+// community. The minimum and maximum number of options and durations are
+// configurable.
 package poll
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	// embed the time zone database to parse the end times in any time zone
+	_ "time/tzdata"
+	"unicode"
 )
 
 const (
-	optionPrefix = "-"
-	linebreak    = "\n"
-	space        = " "
+	linebreak = "\n"
+	space     = " "
+	// overwriteFlag is the flag that allows the voters to overwrite their vote
+	overwriteFlag = "overwrite"
+	// communityFlag is the prefix of the flag that sets the community of the
+	// poll, followed by the community id
+	communityFlag = "community:"
 )
 
-// durationRgx var contains the regular expression to parse the duration from a
-// string message
-var durationRgx = regexp.MustCompile(`^(\d{1,2})\s*[hours|hour|h]+$`)
+var (
+	// durationRgx var contains the regular expression to parse the duration
+	// from a string message, a number followed by a unit of minutes, hours,
+	// days or weeks
+	durationRgx = regexp.MustCompile(`(?i)^(\d{1,4})\s*(m|mins?|minutes?|h|hrs?|hours?|d|days?|w|weeks?)$`)
+	// endTimeRgx var contains the regular expression to get the end time of
+	// the poll from a string message
+	endTimeRgx = regexp.MustCompile(`(?i)^(?:ends?|until)\s*:?\s+(.+)$`)
+	// optionRgx var contains the regular expression to parse an option from a
+	// string message, with its marker: a keycap emoji, a dash, an asterisk, a
+	// bullet or a number followed by a dot or a parenthesis
+	optionRgx = regexp.MustCompile(`^(?:[0-9#*]\x{FE0F}?\x{20E3}|\x{1F51F}|[-*•]|\d{1,2}[.)])\s*(.*)$`)
+	// endTimeLayouts var contains the layouts supported for the end times
+	// that include the time zone offset
+	endTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02 15:04Z07:00",
+		"2006-01-02 15:04 Z07:00",
+		"2006-01-02 15:04 -0700",
+	}
+	// localEndTimeLayouts var contains the layouts supported for the end times
+	// without time zone offset, which are parsed in the time zone provided or
+	// in UTC
+	localEndTimeLayouts = []string{
+		"2006-01-02T15:04",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	}
+	// timeNow var contains the function to get the current time, to be
+	// replaced in the tests
+	timeNow = time.Now
+)
 
 // DefaultConfig var contains the default configuration for a poll with a
 // minimum of 2 options, a maximum of 4 options, a minimum duration of 1 hour,
@@ -54,36 +100,30 @@ type PollConfig struct {
 	DefaultDuration time.Duration
 }
 
-// Poll represents a poll with a question, options and duration
+// Poll represents a poll with a question, options and duration. The end time
+// is only set if the poll message includes it instead of a duration. The
+// overwrite and community are set by the flags of the poll message.
 type Poll struct {
-	Question string
-	Options  []string
-	Duration time.Duration
+	Question    string
+	Description string
+	Options     []string
+	Duration    time.Duration
+	EndTime     time.Time
+	Overwrite   bool
+	CommunityID *uint64
 }
 
 // ParseString parses a string message and returns a Poll struct with the
-// question, options and duration. The message should follow the format:
-// <question>
-// - <option 1>
-// - <option 2>
-// - <option 3*>
-// - <option 4*>
-// <duration*>
-// The duration is optional and by default is 24 hours. If the message does not
-// follow the format, an error is returned.
+// question, description, options, duration and flags. The message should
+// follow the format described in the package documentation. If the message
+// does not follow the format, an error is returned explaining what failed.
+// If the message has no options, the error is ErrNoOptions, since it is not
+// a poll.
 func ParseString(message string, config *PollConfig) (*Poll, error) {
-	// create vars to store the question, options and duration
-	var question string
-	var options []string
-	var duration time.Duration = config.DefaultDuration
-	// poll message follows the format:
-	// <question>
-	// - <option 1>
-	// - <option 2>
-	// - <option 3*>
-	// - <option 4*>
-	// <duration*>
-
+	// create vars to store the question, description and the poll
+	question, description := []string{}, []string{}
+	questionEnded, settingsStarted, durationSet := false, false, false
+	poll := &Poll{Duration: config.DefaultDuration}
 	// create a new reader from the message content and a new scanner from
 	// the reader
 	reader := strings.NewReader(message)
@@ -91,79 +131,198 @@ func ParseString(message string, config *PollConfig) (*Poll, error) {
 	// read every line from the message
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		// if the line is empty, continue
+		// if the line is empty, it ends the question if it has been set
 		if line == "" {
+			questionEnded = len(question) > 0
 			continue
 		}
-		// line is a <question> if:
-		//  - it not starts with a dash
-		//  - any question has been set
-		// line is a <option-n> if:
-		//  - it starts with a dash
+		// line is an <option-n> if:
+		//  - it starts with an option marker
 		//  - the question has been set
-		//  - the number of options is less than the max number of options
-		// line is a <duration> if:
-		//  - it not starts with a dash
-		//  - the question has been set
-		//  - at least the min number of options has been set
-		//  - by default, the duration is 24 hours
-		startWithDash := strings.HasPrefix(line, optionPrefix)
-		numOfQuestions := len(options)
-		if !startWithDash {
-			// if the line is a question append it to the question and continue
-			if numOfQuestions == 0 {
-				question += fmt.Sprintf("%s%s", line, linebreak)
-				continue
+		//  - the duration and flags have not been set
+		if option, isOption := parseOption(line); isOption && len(question) > 0 {
+			if settingsStarted {
+				return nil, fmt.Errorf("%w: the options must be listed before the duration and the flags", ErrInvalidOption)
 			}
-			// if the line is a duration, try to parse it, if it fails, return
-			// an error, otherwise, break the loop and return the result
-			var err error
-			if duration, err = parseDuration(line); err != nil {
-				return nil, errors.Join(ErrParsingDuration, err)
+			// if the number of options is greater than the max number of
+			// options, return an error
+			if len(poll.Options) >= config.MaxOptions {
+				return nil, fmt.Errorf("%w: %d", ErrMaxOptionsReached, config.MaxOptions)
 			}
-			if duration < config.MinDuration || duration > config.MaxDuration {
-				return nil, fmt.Errorf("duration out of range: %w", ErrParsingDuration)
+			if option == "" {
+				return nil, fmt.Errorf("%w: option %d is empty", ErrInvalidOption, len(poll.Options)+1)
 			}
-			break
+			poll.Options = append(poll.Options, option)
+			continue
 		}
-		// if the line is an option and the number of options is greater than
-		// the max number of options, return an error
-		if numOfQuestions >= config.MaxOptions {
-			return nil, fmt.Errorf("%w: %d", ErrMaxOptionsReached, config.MaxOptions)
+		// line is a <question> or <description> if no options have been set,
+		// it is part of the description if the question ended with an empty
+		// line
+		if len(poll.Options) == 0 {
+			if questionEnded {
+				description = append(description, line)
+			} else {
+				question = append(question, line)
+			}
+			continue
+		}
+		// line is a <duration>, an <end time> or a <flag> if the options have
+		// been set
+		settingsStarted = true
+		if err := poll.parseSetting(line, config, &durationSet); err != nil {
+			return nil, err
 		}
-		// append the option to the options
-		optionText := strings.TrimSpace(strings.TrimPrefix(line, optionPrefix))
-		options = append(options, optionText)
 	}
 	// check poll content
-	if question == "" {
+	if len(question) == 0 {
 		return nil, ErrQuestionNotSet
 	}
-	if len(options) < config.MinOptions {
+	if len(poll.Options) == 0 {
+		return nil, fmt.Errorf("%w: %w: %d", ErrNoOptions, ErrMinOptionsNotReached, config.MinOptions)
+	}
+	if len(poll.Options) < config.MinOptions {
 		return nil, fmt.Errorf("%w: %d", ErrMinOptionsNotReached, config.MinOptions)
 	}
 	// return the results
-	return &Poll{
-		Question: strings.TrimSpace(strings.ReplaceAll(question, linebreak, space)),
-		Options:  options,
-		Duration: duration,
-	}, nil
+	poll.Question = strings.Join(question, space)
+	poll.Description = strings.Join(description, linebreak)
+	return poll, nil
+}
+
+// parseOption parses a line and returns the option without its marker and
+// true if the line is an option, or false if it is not.
+func parseOption(line string) (string, bool) {
+	match := optionRgx.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	return strings.TrimSpace(match[1]), true
+}
+
+// parseSetting parses a line after the options of the poll, which can be the
+// duration, the end time or a flag, and sets it in the poll. Only one of the
+// duration or the end time can be set, the durationSet argument tracks it.
+func (p *Poll) parseSetting(line string, config *PollConfig, durationSet *bool) error {
+	lower := strings.ToLower(line)
+	switch {
+	case lower == overwriteFlag:
+		p.Overwrite = true
+		return nil
+	case strings.HasPrefix(lower, communityFlag):
+		id, err := strconv.ParseUint(strings.TrimSpace(line[len(communityFlag):]), 10, 64)
+		if err != nil || id == 0 {
+			return fmt.Errorf("%w: %q must be community:<id>, e.g. community:12", ErrInvalidFlag, line)
+		}
+		p.CommunityID = &id
+		return nil
+	}
+	// the rest of the settings are the duration or the end time
+	if *durationSet {
+		return fmt.Errorf("%w: %q is not a flag and the duration or end time is already set", ErrParsingDuration, line)
+	}
+	if match := endTimeRgx.FindStringSubmatch(line); match != nil {
+		endTime, err := parseEndTime(match[1])
+		if err != nil {
+			return err
+		}
+		duration := endTime.Sub(timeNow())
+		if duration < config.MinDuration || duration > config.MaxDuration {
+			return fmt.Errorf("%w: the end time must be between %s and %s from now",
+				ErrParsingEndTime, formatDuration(config.MinDuration), formatDuration(config.MaxDuration))
+		}
+		p.EndTime = endTime
+		p.Duration = duration
+		*durationSet = true
+		return nil
+	}
+	if unicode.IsDigit([]rune(line)[0]) {
+		duration, err := parseDuration(line)
+		if err != nil {
+			return err
+		}
+		if duration < config.MinDuration || duration > config.MaxDuration {
+			return fmt.Errorf("%w: the duration must be between %s and %s",
+				ErrParsingDuration, formatDuration(config.MinDuration), formatDuration(config.MaxDuration))
+		}
+		p.Duration = duration
+		*durationSet = true
+		return nil
+	}
+	return fmt.Errorf("%w: %q is not a duration, an end time or a flag (overwrite, community:<id>)", ErrUnknownSetting, line)
 }
 
 // parseDuration parses a string and returns a time.Duration. The string should
-// follow the format: <hours> (hours|hour|h). If the string does not follow the
-// format, an error is returned.
+// follow the format: <number> <unit>, where the unit is minutes (m, min, mins,
+// minute, minutes), hours (h, hr, hrs, hour, hours), days (d, day, days) or
+// weeks (w, week, weeks). If the string does not follow the format, an error
+// is returned.
 func parseDuration(line string) (time.Duration, error) {
-	if !durationRgx.MatchString(line) {
-		return 0, errors.Join(ErrParsingDuration, errors.New("invalid duration format"))
-	}
-	hours := durationRgx.FindStringSubmatch(line)[1]
-	if hours == "" {
-		return 0, errors.Join(ErrParsingDuration, errors.New("invalid duration format"))
+	match := durationRgx.FindStringSubmatch(line)
+	if match == nil {
+		return 0, fmt.Errorf("%w: %q is not a valid duration, use a number followed by m, h, d or w (e.g. 90m, 12h, 3d or 1w)",
+			ErrParsingDuration, line)
 	}
-	iHours, err := strconv.Atoi(hours)
+	n, err := strconv.Atoi(match[1])
 	if err != nil {
-		return 0, errors.Join(ErrParsingDuration, err)
+		return 0, fmt.Errorf("%w: %w", ErrParsingDuration, err)
+	}
+	var unit time.Duration
+	switch strings.ToLower(match[2])[0] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// parseEndTime parses a string and returns the time it represents. The string
+// should be a date and time with a time zone offset (e.g. 2024-05-01T18:00Z,
+// 2024-05-01 18:00 +02:00) or a date and time followed by a time zone name
+// (e.g. 2024-05-01 18:00 Europe/Madrid). If there is no time zone, the time is
+// in UTC. If the string does not follow the format, an error is returned.
+func parseEndTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range endTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	location := time.UTC
+	if i := strings.LastIndex(value, space); i > 0 && unicode.IsLetter([]rune(value[i+1:])[0]) {
+		zone := value[i+1:]
+		value = strings.TrimSpace(value[:i])
+		switch strings.ToUpper(zone) {
+		case "UTC", "GMT", "Z":
+		default:
+			var err error
+			if location, err = time.LoadLocation(zone); err != nil {
+				return time.Time{}, fmt.Errorf("%w: unknown time zone %q, use UTC, an offset like +02:00 or a zone like Europe/Madrid",
+					ErrParsingEndTime, zone)
+			}
+		}
+	}
+	for _, layout := range localEndTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q is not a valid end time, use e.g. 'ends 2024-05-01 18:00 UTC'", ErrParsingEndTime, value)
+}
+
+// formatDuration returns a short representation of a duration in days, hours
+// or minutes, to be included in the error messages.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
 	}
-	return time.Hour * time.Duration(iHours), nil
 }
@@ -163,3 +163,133 @@ func TestParseString(t *testing.T) {
 	_, err = ParseString(randomMessage, DefaultConfig)
 	c.Assert(err, qt.ErrorIs, ErrMinOptionsNotReached)
 }
+
+func TestParseStringSyntax(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	communityID := uint64(12)
+
+	tests := []struct {
+		name     string
+		message  string
+		expected *Poll
+		err      error
+	}{
+		{
+			name:    "minutes",
+			message: "Question?\n- A\n- B\n90m",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 90 * time.Minute,
+			},
+		},
+		{
+			name:    "days",
+			message: "Question?\n- A\n- B\n3 days",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 3 * 24 * time.Hour,
+			},
+		},
+		{
+			name:    "weeks",
+			message: "Question?\n- A\n- B\n2W",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 2 * 7 * 24 * time.Hour,
+			},
+		},
+		{
+			name:    "end time in UTC",
+			message: "Question?\n- A\n- B\nends 2024-05-02 18:00 UTC",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 30 * time.Hour,
+				EndTime:  time.Date(2024, 5, 2, 18, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "end time with offset",
+			message: "Question?\n- A\n- B\nuntil 2024-05-02T18:00+02:00",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 28 * time.Hour,
+				EndTime:  time.Date(2024, 5, 2, 16, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "end time with time zone name",
+			message: "Question?\n- A\n- B\nEnds: 2024-05-03 Europe/Madrid",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B"},
+				Duration: 34 * time.Hour,
+				EndTime:  time.Date(2024, 5, 2, 22, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "numbered options",
+			message: "Question?\n1. A\n2) B\n3.C",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B", "C"},
+				Duration: DefaultConfig.DefaultDuration,
+			},
+		},
+		{
+			name:    "emoji and bullet options",
+			message: "Question?\n1️⃣ A\n2⃣ B\n• C\n* D",
+			expected: &Poll{
+				Question: "Question?",
+				Options:  []string{"A", "B", "C", "D"},
+				Duration: DefaultConfig.DefaultDuration,
+			},
+		},
+		{
+			name:    "description and flags",
+			message: "Question\nin two lines?\n\nSome context\nabout it\n- A\n- B\n1w\noverwrite\nCommunity:12",
+			expected: &Poll{
+				Question:    "Question in two lines?",
+				Description: "Some context\nabout it",
+				Options:     []string{"A", "B"},
+				Duration:    7 * 24 * time.Hour,
+				Overwrite:   true,
+				CommunityID: &communityID,
+			},
+		},
+		{name: "garbage duration", message: "Question?\n- A\n- B\n5 sour", err: ErrParsingDuration},
+		{name: "duration too short", message: "Question?\n- A\n- B\n30m", err: ErrParsingDuration},
+		{name: "duration too long", message: "Question?\n- A\n- B\n3w", err: ErrParsingDuration},
+		{name: "duration and end time", message: "Question?\n- A\n- B\n1d\nends 2024-05-03", err: ErrParsingDuration},
+		{name: "end time in the past", message: "Question?\n- A\n- B\nends 2024-04-30 12:00", err: ErrParsingEndTime},
+		{name: "unknown time zone", message: "Question?\n- A\n- B\nends 2024-05-03 Mars/Olympus", err: ErrParsingEndTime},
+		{name: "invalid end time", message: "Question?\n- A\n- B\nends tomorrow", err: ErrParsingEndTime},
+		{name: "empty option", message: "Question?\n- A\n-\n- B", err: ErrInvalidOption},
+		{name: "option after duration", message: "Question?\n- A\n- B\n1d\n- C", err: ErrInvalidOption},
+		{name: "invalid community", message: "Question?\n- A\n- B\ncommunity:abc", err: ErrInvalidFlag},
+		{name: "unknown setting", message: "Question?\n- A\n- B\nprivate", err: ErrUnknownSetting},
+		{name: "no options", message: "Just a mention\n\nwith some text", err: ErrNoOptions},
+		{name: "empty", message: "", err: ErrQuestionNotSet},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			poll, err := ParseString(test.message, DefaultConfig)
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(poll.EndTime.Equal(test.expected.EndTime), qt.IsTrue)
+			poll.EndTime = test.expected.EndTime
+			c.Assert(poll, qt.DeepEquals, test.expected)
+		})
+	}
+}
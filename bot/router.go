@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+// CommandHandler is a function that runs a command received by the bot. It
+// returns an error if something goes wrong.
+type CommandHandler func(ctx context.Context, cmd *Command) error
+
+// Permission is a function that checks if the author of a command is allowed
+// to run it. It returns an error with the reason if it is not.
+type Permission func(ctx context.Context, cmd *Command) error
+
+// route is a command registered in the router with its usage, the permission
+// required to run it and its handler.
+type route struct {
+	usage      string
+	permission Permission
+	handler    CommandHandler
+}
+
+// Router parses the mentions of the bot into commands and runs the handler
+// registered for each command if its author has the required permission.
+type Router struct {
+	botUsername string
+	routes      map[string]*route
+	names       []string
+}
+
+// NewRouter creates a new router for the mentions of the bot with the given
+// username.
+func NewRouter(botUsername string) *Router {
+	return &Router{
+		botUsername: botUsername,
+		routes:      make(map[string]*route),
+	}
+}
+
+// Handle registers the handler of the command with the given name and usage.
+// The permission is checked before running the handler, a nil permission
+// allows anyone to run the command.
+func (r *Router) Handle(name, usage string, permission Permission, handler CommandHandler) {
+	if _, ok := r.routes[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.routes[name] = &route{
+		usage:      usage,
+		permission: permission,
+		handler:    handler,
+	}
+}
+
+// Route parses the message provided into a command and runs its handler. It
+// returns the command parsed, if any, and an error if the command is invalid,
+// unknown, its author is not allowed to run it or its handler fails. The
+// messages that are not mentions are ignored.
+func (r *Router) Route(ctx context.Context, msg *farcasterapi.APIMessage) (*Command, error) {
+	if !msg.IsMention {
+		return nil, nil
+	}
+	cmd, err := ParseCommand(msg.Content, r.botUsername)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Message = msg
+	route, ok := r.routes[cmd.Name]
+	if !ok {
+		return cmd, fmt.Errorf("%w: %s", ErrUnknownCommand, cmd.Name)
+	}
+	if route.permission != nil {
+		if err := route.permission(ctx, cmd); err != nil {
+			return cmd, errors.Join(ErrNotAllowed, err)
+		}
+	}
+	return cmd, route.handler(ctx, cmd)
+}
+
+// Usage returns the usage of the commands registered, one per line and in the
+// order they were registered.
+func (r *Router) Usage() string {
+	lines := []string{}
+	for _, name := range r.names {
+		lines = append(lines, r.routes[name].usage)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RequireReply is a permission that only allows to run the command in a reply
+// to another cast, such as the commands about a poll, which must be replies
+// to the cast of the poll.
+func RequireReply(_ context.Context, cmd *Command) error {
+	if cmd.Message == nil || cmd.Message.Parent == nil {
+		return ErrNotReply
+	}
+	return nil
+}
+
+// AllOf returns a permission that requires all the permissions provided, in
+// the same order.
+func AllOf(permissions ...Permission) Permission {
+	return func(ctx context.Context, cmd *Command) error {
+		for _, permission := range permissions {
+			if err := permission(ctx, cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
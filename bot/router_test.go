@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock/mocktest"
+)
+
+const testPollURL = "https://example.com/poll"
+
+// newTestRouter creates a bot backed by a MockAPI, with the user 1 as author
+// of the commands, and a router with the poll and results commands. The poll
+// command replies with the poll URL and the results command, which requires a
+// reply, replies with the question of the poll.
+func newTestRouter(c *qt.C) (*mock.MockAPI, *Router) {
+	api := mock.NewMockAPI()
+	api.AddFixtures(&mock.Fixtures{Users: []*mock.UserFixture{
+		{FID: testBotFID, Username: testBotUsername},
+		{FID: 1, Username: "alice"},
+	}})
+	c.Assert(api.SetFarcasterUser(testBotFID, ""), qt.IsNil)
+	b, err := New(BotConfig{API: api})
+	c.Assert(err, qt.IsNil)
+
+	router := NewRouter(testBotUsername)
+	router.Handle(CommandPoll, "poll", nil, func(ctx context.Context, cmd *Command) error {
+		if _, _, err := b.PollCommandHandler(ctx, cmd, 0); err != nil {
+			return err
+		}
+		return b.ReplyWithPollURL(ctx, cmd.Message, testPollURL)
+	})
+	router.Handle(CommandResults, "results", RequireReply, func(ctx context.Context, cmd *Command) error {
+		return b.Reply(ctx, cmd.Message, "What is your favourite colour?")
+	})
+	return api, router
+}
+
+func TestRouterReplies(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	api, router := newTestRouter(c)
+
+	// the poll command replies to the mention with the poll URL
+	msg := &farcasterapi.APIMessage{IsMention: true, Hash: "0x01", Author: 1, Content: "@vote " + pollMessage}
+	cmd, err := router.Route(ctx, msg)
+	c.Assert(err, qt.IsNil)
+	c.Assert(cmd.Name, qt.Equals, CommandPoll)
+	reply := mocktest.AssertReplied(t, api, "0x01", testPollURL)
+	c.Assert(reply.Embeds, qt.DeepEquals, []string{testPollURL})
+
+	// the results command replies to the mention in a reply to the poll
+	api.Reset()
+	msg = &farcasterapi.APIMessage{
+		IsMention: true, Hash: "0x02", Author: 1, Content: "@vote results",
+		Parent: &farcasterapi.ParentAPIMessage{FID: testBotFID, Hash: "0x01"},
+	}
+	_, err = router.Route(ctx, msg)
+	c.Assert(err, qt.IsNil)
+	mocktest.AssertReplied(t, api, "0x02", "favourite colour")
+}
+
+func TestRouterErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	api, router := newTestRouter(c)
+
+	// the commands that are not allowed, unknown or invalid are not run
+	msg := &farcasterapi.APIMessage{IsMention: true, Hash: "0x01", Author: 1, Content: "@vote results"}
+	_, err := router.Route(ctx, msg)
+	c.Assert(err, qt.ErrorIs, ErrNotAllowed)
+	c.Assert(err, qt.ErrorIs, ErrNotReply)
+
+	msg = &farcasterapi.APIMessage{IsMention: true, Hash: "0x02", Author: 1, Content: "@vote close"}
+	_, err = router.Route(ctx, msg)
+	c.Assert(err, qt.ErrorIs, ErrUnknownCommand)
+
+	msg = &farcasterapi.APIMessage{IsMention: true, Hash: "0x03", Author: 1, Content: "@vote What?"}
+	_, err = router.Route(ctx, msg)
+	c.Assert(err, qt.ErrorIs, ErrParsingPoll)
+
+	// the poll command fails if the author is unknown
+	api.SetError("UserDataByFID", farcasterapi.ErrNoDataFound)
+	msg = &farcasterapi.APIMessage{IsMention: true, Hash: "0x04", Author: 2, Content: "@vote " + pollMessage}
+	_, err = router.Route(ctx, msg)
+	c.Assert(err, qt.ErrorIs, ErrGettingUserData)
+
+	// the messages that are not mentions are ignored
+	cmd, err := router.Route(ctx, &farcasterapi.APIMessage{Hash: "0x05", Author: 1, Content: "@vote results"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(cmd, qt.IsNil)
+	mocktest.AssertNotPublished(t, api)
+}
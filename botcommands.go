@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vocdoni/vote-frame/bot"
+	"github.com/vocdoni/vote-frame/bot/poll"
+	fapi "github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// botCensusTimeout is the maximum time to wait for the census of a poll
+	// created with a census modifier.
+	botCensusTimeout = 10 * time.Minute
+	// botCensusCheckInterval is the time between checks of the census job of
+	// a poll created with a census modifier.
+	botCensusCheckInterval = 5 * time.Second
+	// botCensusesPerAuthor is the maximum number of censuses that an author
+	// can create with census modifiers during botCensusesWindow.
+	botCensusesPerAuthor = 5
+	// botCensusesWindow is the time window of the limit of censuses created
+	// by an author with census modifiers.
+	botCensusesWindow = 24 * time.Hour
+	// electionIDLen is the length in bytes of the election IDs.
+	electionIDLen = 32
+)
+
+const (
+	botHelpTemplate = "Commands:\n%s\nReply to a poll to use results, close, remind or mute."
+	// botResultsTemplate must be formatted with the results frame URL.
+	botResultsTemplate = "📊 Here are the current results of the poll!\n\n%s"
+	// botCloseTemplate must be formatted with the results frame URL.
+	botCloseTemplate = "🔒 The poll has been closed, the final results will be ready in a few minutes.\n\n%s"
+	// botRemindersTemplate must be formatted with the number of reminders.
+	botRemindersTemplate = "🔔 Sending %d reminders to the voters of the poll."
+	// botReminderContentTemplate must be formatted with the poll question and
+	// the poll frame URL.
+	botReminderContentTemplate = "🗳️ Reminder: the poll \"%s\" is still open and you have not voted yet!\n\n%s"
+)
+
+// newBotRouter returns the router of the bot commands: new polls (with the
+// default census or with the census set by a census modifier), the results
+// of a poll, closing a poll, sending reminders of a community poll, muting the
+// creator of a poll and the help message. The limiter provided limits the
+// censuses created by the census modifiers.
+func newBotRouter(handler *vocdoniHandler, voteBot *bot.Bot, defaultCensus *CensusInfo,
+	limiter *botCensusLimiter,
+) *bot.Router {
+	router := bot.NewRouter(voteBot.UserData.Username)
+	router.Handle(bot.CommandPoll,
+		"• <question> and '- <option>' lines: new poll, add 'census channel:<id>', 'census followers:' or 'census token:<chain>:<addr>'",
+		nil, botPollCommand(handler, voteBot, limiter, defaultCensus))
+	router.Handle(bot.CommandResults, "• results: poll results",
+		bot.RequireReply, botResultsCommand(handler, voteBot))
+	router.Handle(bot.CommandClose, "• close: end your poll",
+		bot.RequireReply, botCloseCommand(handler, voteBot))
+	router.Handle(bot.CommandRemind, "• remind [n]: remind voters (community admins)",
+		bot.RequireReply, botRemindCommand(handler, voteBot))
+	router.Handle(bot.CommandMute, "• mute: mute the poll creator",
+		bot.RequireReply, botMuteCommand(handler, voteBot))
+	router.Handle(bot.CommandHelp, "• help: this message", nil,
+		func(ctx context.Context, cmd *bot.Command) error {
+			return voteBot.Reply(ctx, cmd.Message, fmt.Sprintf(botHelpTemplate, router.Usage()))
+		})
+	return router
+}
+
+// handleBotCommandError handles the error of a bot command. The mentions that
+// are not commands nor polls are ignored, since the bot can be mentioned in any
+// cast. The errors caused by the command author, including the polls that
+// cannot be parsed, are replied to the author, the rest are logged.
+func handleBotCommandError(ctx context.Context, voteBot *bot.Bot, msg *fapi.APIMessage, err error) {
+	switch {
+	case errors.Is(err, poll.ErrNoOptions), errors.Is(err, bot.ErrEmptyCommand), errors.Is(err, bot.ErrUnknownCommand):
+		log.Debugw("ignoring bot mention", "msg-hash", msg.Hash, "error", err)
+	case errors.Is(err, bot.ErrParsingPoll), errors.Is(err, bot.ErrNotAllowed),
+		errors.Is(err, bot.ErrInvalidCensus), errors.Is(err, bot.ErrInvalidArgs),
+		errors.Is(err, poll.ErrParsingEndTime):
+		if err := voteBot.Reply(ctx, msg, "⚠️ "+err.Error()); err != nil {
+			log.Warnw("error replying to bot command", "msg-hash", msg.Hash, "error", err)
+		}
+	default:
+		log.Errorw(err, fmt.Sprintf("error handling bot command %s", msg.Hash))
+	}
+}
+
+// botPendingPoll is a poll with a census modifier that is waiting for its
+// census. It is stored until the poll is created, so it is resumed if the bot
+// is restarted meanwhile.
+type botPendingPoll struct {
+	CensusID  types.HexBytes   `json:"censusId"`
+	Poll      *poll.Poll       `json:"poll"`
+	User      *fapi.Userdata   `json:"user"`
+	Message   *fapi.APIMessage `json:"message"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// botPollCommand returns the handler of the new poll command. If the poll
+// includes a census modifier, the census is created in background and the
+// poll is created once the census is ready. As the census HTTP routes, the
+// census modifiers require the author to be a registered user, and the
+// censuses created by every author are limited.
+func botPollCommand(handler *vocdoniHandler, voteBot *bot.Bot, limiter *botCensusLimiter,
+	defaultCensus *CensusInfo,
+) bot.CommandHandler {
+	return func(ctx context.Context, cmd *bot.Command) error {
+		user, poll, err := voteBot.PollCommandHandler(ctx, cmd, maxElectionDuration)
+		if err != nil {
+			return err
+		}
+		// check if the author can create polls for the community, if any
+		if poll.CommunityID != nil {
+			if !handler.db.IsCommunityAdmin(user.FID, *poll.CommunityID) {
+				return fmt.Errorf("%w: only the admins of the community can create its polls", bot.ErrNotAllowed)
+			}
+			if handler.db.IsCommunityDisabled(*poll.CommunityID) {
+				return fmt.Errorf("%w: the community is disabled", bot.ErrNotAllowed)
+			}
+		}
+		if cmd.Census == nil {
+			return pollToCast(ctx, handler, poll, user, cmd.Message, voteBot, defaultCensus)
+		}
+		if !handler.db.UserExists(user.FID) {
+			return fmt.Errorf("%w: sign in to the app to create polls with a census", bot.ErrNotAllowed)
+		}
+		if err := limiter.acquire(user.FID); err != nil {
+			return err
+		}
+		// validate the census modifier before creating the census in background
+		censusID, err := botPollCensus(ctx, handler, cmd.Census, user)
+		if err != nil {
+			limiter.release(user.FID)
+			return err
+		}
+		pending := &botPendingPoll{
+			CensusID:  censusID,
+			Poll:      poll,
+			User:      user,
+			Message:   cmd.Message,
+			CreatedAt: time.Now(),
+		}
+		// store the poll before the command is marked as handled, so it is
+		// resumed if the bot is restarted before the census is ready
+		data, err := json.Marshal(pending)
+		if err == nil {
+			err = handler.db.AddBotPendingPoll(voteBot.UserData.FID, cmd.Message.Hash, data)
+		}
+		if err != nil {
+			log.Warnw("error storing bot pending poll", "msg-hash", cmd.Message.Hash, "error", err)
+		}
+		go botPollWithCensus(ctx, handler, voteBot, limiter, pending)
+		return nil
+	}
+}
+
+// botPollWithCensus waits for the census of the pending poll and creates the
+// poll once the census is ready, or replies to the author if the census fails
+// or it is not ready before botCensusTimeout since the poll was requested.
+// Then the pending poll is removed and the census of the author released.
+func botPollWithCensus(ctx context.Context, handler *vocdoniHandler, voteBot *bot.Bot,
+	limiter *botCensusLimiter, pending *botPendingPoll,
+) {
+	defer limiter.release(pending.User.FID)
+	// the census is waited with its own context, since the poll is created
+	// once the command has been handled
+	censusCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx),
+		botCensusTimeout-time.Since(pending.CreatedAt))
+	defer cancel()
+	census, err := handler.waitForCensusJob(censusCtx, pending.CensusID)
+	if err != nil {
+		log.Warnw("error creating bot poll census", "census", pending.CensusID, "error", err)
+		content := fmt.Sprintf("⚠️ the census of the poll could not be created: %v", err)
+		if err := voteBot.Reply(context.WithoutCancel(ctx), pending.Message, content); err != nil {
+			log.Warnw("error replying to bot command", "msg-hash", pending.Message.Hash, "error", err)
+		}
+	} else if err := pollToCast(censusCtx, handler, pending.Poll, pending.User, pending.Message, voteBot, census); err != nil {
+		handleBotCommandError(censusCtx, voteBot, pending.Message, err)
+	}
+	if err := handler.db.DelBotPendingPoll(pending.Message.Hash); err != nil {
+		log.Warnw("error removing bot pending poll", "msg-hash", pending.Message.Hash, "error", err)
+	}
+}
+
+// resumeBotPendingPolls resumes the polls of the bot that were waiting for
+// their census when the bot was stopped. The censuses interrupted by the stop
+// fail once their lease expires, and their authors are notified.
+func resumeBotPendingPolls(ctx context.Context, handler *vocdoniHandler, voteBot *bot.Bot,
+	limiter *botCensusLimiter,
+) {
+	polls, err := handler.db.BotPendingPolls(voteBot.UserData.FID)
+	if err != nil {
+		log.Warnw("error getting bot pending polls", "error", err)
+		return
+	}
+	for _, p := range polls {
+		pending := &botPendingPoll{}
+		if err := json.Unmarshal(p.Data, pending); err != nil || pending.User == nil || pending.Message == nil {
+			log.Warnw("invalid bot pending poll", "msg-hash", p.Hash, "error", err)
+			if err := handler.db.DelBotPendingPoll(p.Hash); err != nil {
+				log.Warnw("error removing bot pending poll", "msg-hash", p.Hash, "error", err)
+			}
+			continue
+		}
+		log.Infow("resuming bot pending poll", "msg-hash", p.Hash, "census", pending.CensusID)
+		limiter.resume(pending.User.FID, pending.CreatedAt)
+		go botPollWithCensus(ctx, handler, voteBot, limiter, pending)
+	}
+}
+
+// botPollCensus starts the creation of the census of a new poll from the census
+// modifier provided. It returns the ID of the census job.
+func botPollCensus(ctx context.Context, handler *vocdoniHandler, modifier *bot.CensusModifier,
+	author *fapi.Userdata,
+) (types.HexBytes, error) {
+	switch modifier.Type {
+	case bot.CensusChannel:
+		exists, err := handler.fcapi.ChannelExists(ctx, modifier.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: channel %s not found", bot.ErrInvalidCensus, modifier.Value)
+		}
+		censusID, err := handler.cli.NewCensus(api.CensusTypeWeighted)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := handler.censusWarpcastChannel(censusID, modifier.Value, author.FID, nil); err != nil {
+			return nil, err
+		}
+		return censusID, nil
+	case bot.CensusFollowers:
+		userFID := author.FID
+		if modifier.Value != "" {
+			user, err := handler.fcapi.UserDataByUsername(ctx, modifier.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: user %s not found", bot.ErrInvalidCensus, modifier.Value)
+			}
+			userFID = user.FID
+		}
+		censusID, err := handler.cli.NewCensus(api.CensusTypeWeighted)
+		if err != nil {
+			return nil, err
+		}
+		handler.startCensusJob(censusID, author.FID)
+		go handler.censusFromFollowers(censusID, userFID, author.FID, nil)
+		return censusID, nil
+	case bot.CensusToken:
+		if handler.tokenHolders == nil {
+			return nil, fmt.Errorf("%w: token censuses are not available", bot.ErrInvalidCensus)
+		}
+		tokenType, blockchain, address, err := modifier.Token()
+		if err != nil {
+			return nil, err
+		}
+		tokens := []*CensusToken{{Address: address, Blockchain: blockchain}}
+		if err := handler.checkTokens(tokens); err != nil {
+			return nil, errors.Join(bot.ErrInvalidCensus, err)
+		}
+		censusType := ERC20type
+		if tokenType == bot.TokenTypeNFT {
+			censusType = NFTtype
+		}
+		data, err := handler.censusTokenAirstack(tokens, censusType, author.FID, nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		res := struct {
+			CensusID types.HexBytes `json:"censusId"`
+		}{}
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("cannot decode census job: %w", err)
+		}
+		return res.CensusID, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown census type %s", bot.ErrInvalidCensus, modifier.Type)
+	}
+}
+
+// botCensusLimiter limits the censuses created by every author with census
+// modifiers: an author can only create one census at a time and up to
+// botCensusesPerAuthor during botCensusesWindow.
+type botCensusLimiter struct {
+	mtx     sync.Mutex
+	running map[uint64]bool
+	created map[uint64][]time.Time
+}
+
+func newBotCensusLimiter() *botCensusLimiter {
+	return &botCensusLimiter{
+		running: make(map[uint64]bool),
+		created: make(map[uint64][]time.Time),
+	}
+}
+
+// acquire registers a new census of the given author. It returns an error if
+// the author is already creating a census or has reached the limit of
+// censuses. The census must be released once it is finished.
+func (l *botCensusLimiter) acquire(fid uint64) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.running[fid] {
+		return fmt.Errorf("%w: wait until your previous census is ready", bot.ErrNotAllowed)
+	}
+	since := time.Now().Add(-botCensusesWindow)
+	recent := []time.Time{}
+	for _, t := range l.created[fid] {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= botCensusesPerAuthor {
+		l.created[fid] = recent
+		return fmt.Errorf("%w: you can create up to %d polls with a census every %s",
+			bot.ErrNotAllowed, botCensusesPerAuthor, botCensusesWindow)
+	}
+	l.running[fid] = true
+	l.created[fid] = append(recent, time.Now())
+	return nil
+}
+
+// resume registers a census of the given author created before the bot was
+// restarted, without checking the limits. The census must be released once it
+// is finished.
+func (l *botCensusLimiter) resume(fid uint64, createdAt time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.running[fid] = true
+	l.created[fid] = append(l.created[fid], createdAt)
+}
+
+// release registers that the census of the given author is finished.
+func (l *botCensusLimiter) release(fid uint64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.running, fid)
+}
+
+// waitForCensusJob waits until the census job with the given ID is finished or
+// the context is done. It returns the census created or an error if the
+// census job failed.
+func (v *vocdoniHandler) waitForCensusJob(ctx context.Context, censusID types.HexBytes) (*CensusInfo, error) {
+	for {
+		census, err := v.censusJob(censusID)
+		if err != nil {
+			return nil, err
+		}
+		if census.Finished() {
+			if census.Error != "" {
+				return nil, errors.New(census.Error)
+			}
+			if census.Canceled {
+				return nil, fmt.Errorf("census creation canceled")
+			}
+			return census, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("census creation timeout")
+		case <-time.After(botCensusCheckInterval):
+		}
+	}
+}
+
+// botResultsCommand returns the handler of the results command, which replies
+// with the results frame of the poll.
+func botResultsCommand(handler *vocdoniHandler, voteBot *bot.Bot) bot.CommandHandler {
+	return func(ctx context.Context, cmd *bot.Command) error {
+		election, err := botCommandElection(ctx, handler, voteBot, cmd)
+		if err != nil {
+			return err
+		}
+		resultsURL := fmt.Sprintf("%s/poll/results/%s", serverURL, election.ElectionID)
+		return voteBot.Reply(ctx, cmd.Message, fmt.Sprintf(botResultsTemplate, resultsURL), resultsURL)
+	}
+}
+
+// botCloseCommand returns the handler of the close command, which ends the
+// poll before its end date. Only the creator of the poll can close it.
+func botCloseCommand(handler *vocdoniHandler, voteBot *bot.Bot) bot.CommandHandler {
+	return func(ctx context.Context, cmd *bot.Command) error {
+		election, err := botCommandElection(ctx, handler, voteBot, cmd)
+		if err != nil {
+			return err
+		}
+		if err := botPollCreator(election, cmd); err != nil {
+			return err
+		}
+		if time.Now().After(election.EndTime) {
+			return fmt.Errorf("%w: the poll has already ended", bot.ErrInvalidArgs)
+		}
+		electionID, err := hex.DecodeString(election.ElectionID)
+		if err != nil {
+			return fmt.Errorf("error decoding election ID: %w", err)
+		}
+		if err := handler.closeElection(electionID); err != nil {
+			return err
+		}
+		log.Infow("poll closed by its creator", "electionID", election.ElectionID, "fid", cmd.Message.Author)
+		resultsURL := fmt.Sprintf("%s/poll/results/%s", serverURL, election.ElectionID)
+		return voteBot.Reply(ctx, cmd.Message, fmt.Sprintf(botCloseTemplate, resultsURL), resultsURL)
+	}
+}
+
+// botRemindCommand returns the handler of the remind command, which sends a
+// reminder to the voters of the poll that have not voted yet. If a number is
+// provided, only that number of voters are reminded, ranked by weight. Only
+// the admins of the community of the poll can send reminders.
+func botRemindCommand(handler *vocdoniHandler, voteBot *bot.Bot) bot.CommandHandler {
+	return func(ctx context.Context, cmd *bot.Command) error {
+		election, err := botCommandElection(ctx, handler, voteBot, cmd)
+		if err != nil {
+			return err
+		}
+		if err := botCommunityAdmin(handler, election, cmd); err != nil {
+			return err
+		}
+		numberOfUsers, err := cmd.NumberArg(0, 0)
+		if err != nil {
+			return err
+		}
+		frameURL := fmt.Sprintf("%s/%s", serverURL, election.ElectionID)
+		req := &ReminderRequest{
+			Type:          RankedRemindersType,
+			Content:       fmt.Sprintf(botReminderContentTemplate, election.Question, frameURL),
+			NumberOfUsers: numberOfUsers,
+		}
+		if numberOfUsers == 0 {
+			electionID, err := hex.DecodeString(election.ElectionID)
+			if err != nil {
+				return fmt.Errorf("error decoding election ID: %w", err)
+			}
+			if req.Users, _, err = handler.db.RemindersOfElection(electionID); err != nil {
+				return fmt.Errorf("error getting remindable voters: %w", err)
+			}
+			req.Type = IndividualRemindersType
+		}
+		if _, err := handler.queueReminders(cmd.Message.Author, election, req); err != nil {
+			var reqErr remindersRequestError
+			if errors.As(err, &reqErr) {
+				return fmt.Errorf("%w: %s", bot.ErrInvalidArgs, reqErr)
+			}
+			return err
+		}
+		total := numberOfUsers
+		if total == 0 {
+			total = len(req.Users)
+		}
+		return voteBot.Reply(ctx, cmd.Message, fmt.Sprintf(botRemindersTemplate, total))
+	}
+}
+
+// botMuteCommand returns the handler of the mute command, which mutes the
+// notifications of the creator of the poll for the command author.
+func botMuteCommand(handler *vocdoniHandler, voteBot *bot.Bot) bot.CommandHandler {
+	return func(ctx context.Context, cmd *bot.Command) error {
+		user, err := voteBot.UserDataByFID(ctx, cmd.Message.Author)
+		if err != nil {
+			return err
+		}
+		parentMsg, err := voteBot.ParentCast(ctx, cmd.Message)
+		if err != nil {
+			return err
+		}
+		if err := mutePollCreator(handler, user, parentMsg); err != nil {
+			return fmt.Errorf("error muting user: %w", err)
+		}
+		log.Debugw("poll creator muted",
+			"user", user,
+			"poll", parentMsg.Embeds)
+		return nil
+	}
+}
+
+// botPollCreator checks that the author of the command is the creator of the
+// poll of the election provided.
+func botPollCreator(election *mongo.Election, cmd *bot.Command) error {
+	if election.UserID != cmd.Message.Author {
+		return fmt.Errorf("%w: only the creator of the poll can %s it", bot.ErrNotAllowed, cmd.Name)
+	}
+	return nil
+}
+
+// botCommunityAdmin checks that the author of the command is an admin of the
+// community of the poll of the election provided.
+func botCommunityAdmin(handler *vocdoniHandler, election *mongo.Election, cmd *bot.Command) error {
+	if election.Community == nil || election.Community.ID == 0 {
+		return fmt.Errorf("%w: the poll is not a community poll", bot.ErrNotAllowed)
+	}
+	if !handler.db.IsCommunityAdmin(cmd.Message.Author, election.Community.ID) {
+		return fmt.Errorf("%w: only the admins of the community can run %s", bot.ErrNotAllowed, cmd.Name)
+	}
+	return nil
+}
+
+// botCommandElection returns the election of the poll included in the cast
+// that the command replies to.
+func botCommandElection(ctx context.Context, handler *vocdoniHandler, voteBot *bot.Bot,
+	cmd *bot.Command,
+) (*mongo.Election, error) {
+	parentMsg, err := voteBot.ParentCast(ctx, cmd.Message)
+	if err != nil {
+		return nil, err
+	}
+	election, err := electionFromCast(handler, parentMsg)
+	if err != nil {
+		if errors.Is(err, mongo.ErrElectionUnknown) || errors.Is(err, errNoPollInCast) {
+			return nil, fmt.Errorf("%w: the cast is not a poll", bot.ErrInvalidArgs)
+		}
+		return nil, err
+	}
+	return election, nil
+}
+
+// errNoPollInCast is returned when a cast does not include a poll frame.
+var errNoPollInCast = fmt.Errorf("election not found in embeds")
+
+// electionFromCast returns the election of the poll frame embedded in the
+// cast provided.
+func electionFromCast(handler *vocdoniHandler, cast *fapi.APIMessage) (*mongo.Election, error) {
+	var electionID types.HexBytes
+	for _, embed := range cast.Embeds {
+		if electionID = electionIDFromURL(embed); electionID != nil {
+			break
+		}
+	}
+	if electionID == nil {
+		return nil, errNoPollInCast
+	}
+	election, err := handler.db.Election(electionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting election from the database: %w", err)
+	}
+	return election, nil
+}
+
+// electionIDFromURL returns the election ID of the poll frame URL provided, or
+// nil if the URL is not a poll frame URL of this server. The election ID is
+// the path segment of the URL, relative to the server URL, that is an hex
+// encoded election ID, so the query, the fragment and the trailing slashes
+// are ignored.
+func electionIDFromURL(frameURL string) types.HexBytes {
+	server, err := url.Parse(serverURL)
+	if err != nil {
+		return nil
+	}
+	frame, err := url.Parse(frameURL)
+	if err != nil || !strings.EqualFold(frame.Host, server.Host) {
+		return nil
+	}
+	path, ok := strings.CutPrefix(frame.Path, strings.TrimSuffix(server.Path, "/")+"/")
+	if !ok {
+		return nil
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if electionID, err := hex.DecodeString(segment); err == nil && len(electionID) == electionIDLen {
+			return electionID
+		}
+	}
+	return nil
+}
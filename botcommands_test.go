@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/bot"
+)
+
+func TestBotCensusLimiter(t *testing.T) {
+	c := qt.New(t)
+	limiter := newBotCensusLimiter()
+
+	// one census at a time by author
+	c.Assert(limiter.acquire(1), qt.IsNil)
+	c.Assert(limiter.acquire(1), qt.ErrorIs, bot.ErrNotAllowed)
+	c.Assert(limiter.acquire(2), qt.IsNil)
+	limiter.release(1)
+	c.Assert(limiter.acquire(1), qt.IsNil)
+	limiter.release(1)
+
+	// the resumed censuses are running and count for the limit
+	limiter.resume(3, time.Now())
+	c.Assert(limiter.acquire(3), qt.ErrorIs, bot.ErrNotAllowed)
+	limiter.release(3)
+	for i := 1; i < botCensusesPerAuthor; i++ {
+		c.Assert(limiter.acquire(3), qt.IsNil)
+		limiter.release(3)
+	}
+	c.Assert(limiter.acquire(3), qt.ErrorIs, bot.ErrNotAllowed)
+
+	// the censuses created before the window are not counted
+	limiter.resume(4, time.Now().Add(-botCensusesWindow-time.Minute))
+	limiter.release(4)
+	for i := 0; i < botCensusesPerAuthor; i++ {
+		c.Assert(limiter.acquire(4), qt.IsNil)
+		limiter.release(4)
+	}
+}
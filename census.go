@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,6 +10,7 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,6 +22,7 @@ import (
 	"github.com/vocdoni/vote-frame/farcasterapi/neynar"
 	"github.com/vocdoni/vote-frame/helpers"
 	"github.com/vocdoni/vote-frame/mongo"
+	"github.com/vocdoni/vote-frame/tokenholders"
 	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/apiclient"
 	"go.vocdoni.io/dvote/httprouter"
@@ -74,19 +75,56 @@ const (
 	FrameCensusTypeNFT
 	// FrameCensusTypeERC20 is a census created from the token holders of an ERC20
 	FrameCensusTypeERC20
+	// FrameCensusTypeComposite is a census created from the combination of
+	// other census sources using set operations.
+	FrameCensusTypeComposite
+	// FrameCensusTypeCastReactions is a census created from the users who
+	// reacted to (liked, recasted or replied) a specific cast.
+	FrameCensusTypeCastReactions
+	// FrameCensusTypeFollowGraph is a census created from the follow graph of
+	// some users (mutuals, following or followers of any of them).
+	FrameCensusTypeFollowGraph
+	// FrameCensusTypeElectionVoters is a census created from the voters of a
+	// previous election.
+	FrameCensusTypeElectionVoters
+	// FrameCensusTypeElectionCensus is a census created from the census of a
+	// previous election.
+	FrameCensusTypeElectionCensus
 )
 
 // CensusInfo contains the information of a census.
 type CensusInfo struct {
-	Root               types.HexBytes `json:"root"`
-	Url                string         `json:"uri"`
-	Size               uint64         `json:"size"`
-	Usernames          []string       `json:"usernames,omitempty"`
-	FromTotalAddresses uint32         `json:"fromTotalAddresses,omitempty"`
-
-	Error    string          `json:"-"`
-	Progress uint32          `json:"-"` // Progress of the census creation process (0-100)
-	Type     FrameCensusType `json:"-"` // Type of the census
+	CensusID           types.HexBytes              `json:"censusId,omitempty"`
+	Root               types.HexBytes              `json:"root"`
+	Url                string                      `json:"uri"`
+	Size               uint64                      `json:"size"`
+	Usernames          []string                    `json:"usernames,omitempty"`
+	FromTotalAddresses uint32                      `json:"fromTotalAddresses,omitempty"`
+	Failures           []*CensusRecordFailure      `json:"failures,omitempty"`
+	Filtered           *CensusFilterReport         `json:"filtered,omitempty"`
+	Snapshot           *mongo.CensusSnapshot       `json:"snapshot,omitempty"`
+	WeightStrategy     *mongo.CensusWeightStrategy `json:"weightStrategy,omitempty"`
+
+	Error      string          `json:"-"`
+	Progress   uint32          `json:"-"` // Progress of the census creation process (0-100)
+	Type       FrameCensusType `json:"-"` // Type of the census
+	Canceled   bool            `json:"-"` // The census creation was canceled
+	FinishedAt time.Time       `json:"-"` // When the census creation finished
+}
+
+// Finished returns true if the census creation process is not running
+// anymore, because it succeeded, failed or was canceled.
+func (c *CensusInfo) Finished() bool {
+	return c.Root != nil || c.Error != "" || c.Canceled
+}
+
+// CensusRecordFailure describes a record of a plain-text census that is invalid
+// or could not be resolved to a Farcaster user. The row is the line of the
+// record in the census, starting at 1.
+type CensusRecordFailure struct {
+	Row    int    `json:"row"`
+	Record string `json:"record"`
+	Error  string `json:"error"`
 }
 
 // FromFile loads the census information from a file.
@@ -182,6 +220,74 @@ func CreateCensus(cli *apiclient.HTTPclient, participants []*FarcasterParticipan
 	}, nil
 }
 
+// uniqueCensusParticipants returns the weight of every participant of a census
+// by username and the total weight of the census. Since each participant can
+// have multiple signers, only the weight of the first signer of every user is
+// taken into account.
+func uniqueCensusParticipants(participants []*FarcasterParticipant) (map[string]*big.Int, *big.Int) {
+	unique := make(map[string]*big.Int)
+	totalWeight := new(big.Int)
+	for _, p := range participants {
+		if _, ok := unique[p.Username]; ok {
+			continue
+		}
+		unique[p.Username] = p.Weight
+		totalWeight.Add(totalWeight, p.Weight)
+	}
+	return unique, totalWeight
+}
+
+// censusParticipantsByFID returns the weight of every participant of a census
+// by FID. As in uniqueCensusParticipants, only the weight of the first signer
+// of every user is taken into account.
+func censusParticipantsByFID(participants []*FarcasterParticipant) map[uint64]*big.Int {
+	byFID := make(map[uint64]*big.Int)
+	for _, p := range participants {
+		if _, ok := byFID[p.FID]; !ok {
+			byFID[p.FID] = p.Weight
+		}
+	}
+	return byFID
+}
+
+// storeCensusParticipants finishes the census job of a census built from the
+// participants provided. It includes the usernames of the participants in the
+// census info and stores it as the result of the job. Then, unless the job
+// was discarded, it stores the participants, the snapshot and the weight
+// strategy of the census info in the census. It returns false if the job was
+// discarded, so the builder of the census can discard it too.
+func (v *vocdoniHandler) storeCensusParticipants(censusID types.HexBytes, ci *CensusInfo,
+	participants []*FarcasterParticipant,
+) bool {
+	unique, totalWeight := uniqueCensusParticipants(participants)
+	ci.Usernames = make([]string, 0, len(unique))
+	for username := range unique {
+		ci.Usernames = append(ci.Usernames, username)
+	}
+	if !v.storeCensusJob(censusID, *ci) {
+		return false
+	}
+	if err := v.db.AddParticipantsToCensus(censusID, unique, censusParticipantsByFID(participants),
+		ci.FromTotalAddresses, totalWeight, ci.Url); err != nil {
+		log.Errorw(err, fmt.Sprintf("failed to add participants to census %s", censusID.String()))
+	}
+	if ci.Snapshot != nil {
+		if err := v.db.SetSnapshotForCensus(censusID, ci.Snapshot); err != nil {
+			log.Errorw(err, fmt.Sprintf("failed to set snapshot of census %s", censusID.String()))
+		}
+	}
+	if ci.WeightStrategy != nil {
+		if err := v.db.SetWeightStrategyForCensus(censusID, ci.WeightStrategy); err != nil {
+			log.Errorw(err, fmt.Sprintf("failed to set weight strategy of census %s", censusID.String()))
+		}
+	}
+	log.Debugw("census participants stored",
+		"censusID", censusID.String(),
+		"participants", len(unique),
+		"totalWeight", totalWeight.String())
+	return true
+}
+
 // censusFromDatabaseByElectionID retrieves a census from the database by its election ID.
 func (v *vocdoniHandler) censusFromDatabaseByElectionID(_ *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	eID, err := hex.DecodeString(ctx.URLParam("electionID"))
@@ -216,75 +322,103 @@ func (v *vocdoniHandler) censusFromDatabaseByRoot(_ *apirest.APIdata, ctx *httpr
 	return ctx.Send(data, http.StatusOK)
 }
 
-// censusCSV creates a new census from a CSV file containing Ethereum addresses and weights.
+// censusCSV creates a new census from a CSV file containing Ethereum addresses,
+// FIDs or @usernames and optional weights. The CSV can be sent as the request
+// body or as the file field of a multipart form.
 // It builds the census async and returns the census ID.
 func (v *vocdoniHandler) censusCSV(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
-	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	reader, err := csvFromRequest(msg, ctx)
 	if err != nil {
-		return err
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	return v.censusFromCSVReader(msg, ctx, io.NopCloser(reader))
+}
+
+// censusFromCSVReader creates a new census from the CSV read from the reader
+// provided, which is closed once the CSV is parsed. The maximum number of
+// records depends on the reputation of the user. It builds the census async
+// and returns the census ID.
+func (v *vocdoniHandler) censusFromCSVReader(msg *apirest.APIdata, ctx *httprouter.HTTPContext,
+	reader io.ReadCloser,
+) error {
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		reader.Close()
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		reader.Close()
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
 	}
-	v.backgroundQueue.Store(censusID.String(), CensusInfo{})
 	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
 	if err != nil {
+		reader.Close()
 		return fmt.Errorf("cannot get user from auth token: %w", err)
 	}
+	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	v.startCensusJob(censusID, userFID)
 	if err := v.db.AddCensus(censusID, userFID); err != nil {
+		reader.Close()
 		return fmt.Errorf("cannot add census to database: %w", err)
 	}
+	maxRecords := v.userCSVRecordsLimit(userFID)
 	totalCSVaddresses := uint32(0)
 	go func() {
 		startTime := time.Now()
-		log.Debugw("building census from csv", "censusID", censusID)
+		log.Debugw("building census from csv", "censusID", censusID, "maxRecords", maxRecords)
 		var participants []*FarcasterParticipant
+		var failures []*CensusRecordFailure
 		var err error
 		v.trackStepProgress(censusID, 1, 2, func(progress chan int) {
-			participants, totalCSVaddresses, err = v.farcasterCensusFromEthereumCSV(msg.Data, progress)
+			participants, totalCSVaddresses, failures, err = v.farcasterCensusFromCSV(reader, maxRecords, progress)
+			if closeErr := reader.Close(); closeErr != nil {
+				log.Warnw("cannot close csv", "censusID", censusID, "error", closeErr)
+			}
 		})
 		if err != nil {
-			log.Warnw("failed to build census from ethereum csv", "err", err.Error())
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			log.Warnw("failed to build census from csv", "err", err.Error())
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error(), Failures: failures})
+			return
+		}
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants after filtering", Failures: failures, Filtered: filtered})
 			return
 		}
+		applyCensusWeightStrategy(participants, weightStrategy)
 		var ci *CensusInfo
 		v.trackStepProgress(censusID, 2, 2, func(progress chan int) {
 			ci, err = CreateCensus(v.cli, participants, FrameCensusTypeCSV, progress)
 		})
 		if err != nil {
 			log.Errorw(err, "failed to create census")
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
-		// since each participant can have multiple signers, we need to get the unique usernames
-		uniqueParticipantsMap := make(map[string]*big.Int)
-		totalWeight := new(big.Int).SetUint64(0)
-		for _, p := range participants {
-			if _, ok := uniqueParticipantsMap[p.Username]; ok {
-				// if the username is already in the map, continue
-				continue
-			}
-			uniqueParticipantsMap[p.Username] = p.Weight
-			totalWeight.Add(totalWeight, p.Weight)
-		}
-		uniqueParticipants := []string{}
-		for k := range uniqueParticipantsMap {
-			uniqueParticipants = append(uniqueParticipants, k)
-		}
-		ci.Usernames = uniqueParticipants
 		ci.FromTotalAddresses = totalCSVaddresses
+		ci.Failures = failures
+		ci.Filtered = filtered
+		ci.WeightStrategy = weightStrategy
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, ci, participants) {
+			return
+		}
 		log.Infow("census created from CSV",
 			"censusID", censusID.String(),
 			"size", len(ci.Usernames),
-			"totalWeight", totalWeight.String(),
 			"duration", time.Since(startTime),
-			"fromTotalAddresses", totalCSVaddresses)
-
-		// store the census info in the map
-		v.backgroundQueue.Store(censusID.String(), *ci)
-
-		// add participants to the census in the database
-		if err := v.db.AddParticipantsToCensus(censusID, uniqueParticipantsMap, ci.FromTotalAddresses, totalWeight, ci.Url); err != nil {
-			log.Errorw(err, fmt.Sprintf("failed to add participants to census %s", censusID.String()))
-		}
+			"fromTotalAddresses", totalCSVaddresses,
+			"failures", len(failures))
 	}()
 	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
 	if err != nil {
@@ -339,12 +473,16 @@ func (v *vocdoniHandler) censusChannel(msg *apirest.APIdata, ctx *httprouter.HTT
 	if !exists {
 		return ctx.Send([]byte("channel not found"), http.StatusNotFound)
 	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 	// create a censusID for the queue and store into it
 	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
 	if err != nil {
 		return err
 	}
-	data, err := v.censusWarpcastChannel(censusID, channelID, userFID)
+	data, err := v.censusWarpcastChannel(censusID, channelID, userFID, filters)
 	if err != nil {
 		log.Warnf("error creating census for the chanel: %s: %v", channelID, err)
 		return ctx.Send([]byte("error creating channel census"), http.StatusInternalServerError)
@@ -365,55 +503,27 @@ func (v *vocdoniHandler) censusFollowers(msg *apirest.APIdata, ctx *httprouter.H
 	if strUserFid == "" {
 		return ctx.Send([]byte("userFid is required"), http.StatusBadRequest)
 	}
+	createdBy, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
 	userFid, err := strconv.ParseUint(strUserFid, 10, 64)
 	if err != nil {
 		return ctx.Send([]byte("invalid userFid"), http.StatusBadRequest)
 	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 	// create a censusID for the queue and store into it
 	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
 	if err != nil {
 		return err
 	}
-	v.backgroundQueue.Store(censusID.String(), CensusInfo{})
+	v.startCensusJob(censusID, createdBy)
 	// run a goroutine to create the census, update the queue with the progress,
 	// and update the queue result when it's ready
-	go func() {
-		internalCtx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		users, err := v.fcapi.UserFollowers(internalCtx, userFid)
-		if err != nil {
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
-			return
-		}
-		// include poll author in the census
-		users = append(users, req.Profile.FID)
-		// create the participants from the database users using the fids
-		var participants []*FarcasterParticipant
-		v.trackStepProgress(censusID, 1, 2, func(progress chan int) {
-			participants = v.farcasterCensusFromFids(users, progress)
-		})
-		if len(participants) == 0 {
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: "no valid participants"})
-			return
-		}
-		// create the census from the participants
-		var censusInfo *CensusInfo
-		v.trackStepProgress(censusID, 2, 2, func(progress chan int) {
-			censusInfo, err = CreateCensus(v.cli, participants, FrameCensusTypeChannelGated, progress)
-		})
-		if err != nil {
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
-			return
-		}
-		for _, p := range participants {
-			censusInfo.Usernames = append(censusInfo.Usernames, p.Username)
-		}
-		censusInfo.FromTotalAddresses = uint32(len(censusInfo.Usernames))
-		v.backgroundQueue.Store(censusID.String(), *censusInfo)
-		log.Infow("census created from user followers",
-			"fid", userFid,
-			"participants", len(censusInfo.Usernames))
-	}()
+	go v.censusFromFollowers(censusID, userFid, req.Profile.FID, filters)
 	// return the censusID to the client
 	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
 	if err != nil {
@@ -422,6 +532,53 @@ func (v *vocdoniHandler) censusFollowers(msg *apirest.APIdata, ctx *httprouter.H
 	return ctx.Send(data, http.StatusOK)
 }
 
+// censusFromFollowers creates the census with the given ID from the followers
+// of the user with the given FID and the poll author, updating the census job
+// with the progress and the result.
+func (v *vocdoniHandler) censusFromFollowers(censusID types.HexBytes, userFid, authorFID uint64,
+	filters *CensusFilters,
+) {
+	users, err := v.userFollowers(userFid)
+	if err != nil {
+		v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+		return
+	}
+	// include poll author in the census
+	users = append(users, authorFID)
+	// create the participants from the database users using the fids
+	var participants []*FarcasterParticipant
+	v.trackStepProgress(censusID, 1, 2, func(progress chan int) {
+		participants = v.farcasterCensusFromFids(users, progress)
+	})
+	var filtered *CensusFilterReport
+	if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+		v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+		return
+	}
+	if len(participants) == 0 {
+		v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants", Filtered: filtered})
+		return
+	}
+	// create the census from the participants
+	var censusInfo *CensusInfo
+	v.trackStepProgress(censusID, 2, 2, func(progress chan int) {
+		censusInfo, err = CreateCensus(v.cli, participants, FrameCensusTypeChannelGated, progress)
+	})
+	if err != nil {
+		v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+		return
+	}
+	for _, p := range participants {
+		censusInfo.Usernames = append(censusInfo.Usernames, p.Username)
+	}
+	censusInfo.FromTotalAddresses = uint32(len(censusInfo.Usernames))
+	censusInfo.Filtered = filtered
+	v.storeCensusJob(censusID, *censusInfo)
+	log.Infow("census created from user followers",
+		"fid", userFid,
+		"participants", len(censusInfo.Usernames))
+}
+
 // censusCommunity creates a new census from a community. The census of the
 // community can be of type channel, NFT, or ERC20. If the community is a
 // channel, the census is created from the users who follow the channel, and
@@ -442,6 +599,14 @@ func (v *vocdoniHandler) censusCommunity(msg *apirest.APIdata, ctx *httprouter.H
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		return err
 	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 
 	// get the community from the database
 	community, err := v.db.Community(req.CommunityID)
@@ -468,7 +633,7 @@ func (v *vocdoniHandler) censusCommunity(msg *apirest.APIdata, ctx *httprouter.H
 		// if the census type is a channel, create the census from the users who
 		// follow the channel, the process is async so return add the censusID
 		// to the queue and return it to the client
-		data, err := v.censusWarpcastChannel(censusID, community.Census.Channel, userFID)
+		data, err := v.censusWarpcastChannel(censusID, community.Census.Channel, userFID, filters)
 		if err != nil {
 			log.Warnf("error creating census for the chanel: %s: %v", community.Census.Channel, err)
 			return ctx.Send([]byte("error creating channel census"), http.StatusInternalServerError)
@@ -476,7 +641,7 @@ func (v *vocdoniHandler) censusCommunity(msg *apirest.APIdata, ctx *httprouter.H
 		return ctx.Send(data, http.StatusOK)
 	}
 	// if the census type is not a channel, the type is NFT or ERC20, so create
-	// the census sync and from the token holders from airstack
+	// the census sync and from the token holders provider
 	censusAddresses := []*CensusToken{}
 	for _, addr := range community.Census.Addresses {
 		censusAddresses = append(censusAddresses, &CensusToken{
@@ -502,7 +667,7 @@ func (v *vocdoniHandler) censusCommunity(msg *apirest.APIdata, ctx *httprouter.H
 		}
 	}
 	// create the census from the token holders
-	data, err := v.censusTokenAirstack(censusAddresses, censusType, userFID)
+	data, err := v.censusTokenAirstack(censusAddresses, censusType, userFID, filters, nil, weightStrategy)
 	if err != nil {
 		return fmt.Errorf("cannot create erc20/nft based census: %w", err)
 	}
@@ -518,15 +683,33 @@ func (v *vocdoniHandler) censusQueueInfo(msg *apirest.APIdata, ctx *httprouter.H
 	if err != nil {
 		return err
 	}
-	iCensusInfo, ok := v.backgroundQueue.Load(censusID.String())
-	if !ok {
-		return ctx.Send(nil, http.StatusNotFound)
+	censusInfo, err := v.censusJob(censusID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrCensusJobUnknown) {
+			return ctx.Send(nil, http.StatusNotFound)
+		}
+		return err
 	}
-	censusInfo, ok := iCensusInfo.(CensusInfo)
-	if !ok {
-		return ctx.Send(nil, http.StatusNotFound)
+	if censusInfo.Canceled {
+		return ctx.Send([]byte("census creation canceled"), http.StatusGone)
 	}
 	if censusInfo.Error != "" {
+		// if some records failed or the filters dropped participants,
+		// include them in the response to allow the client to fix them
+		if len(censusInfo.Failures) > 0 || censusInfo.Filtered != nil {
+			response := map[string]any{"error": censusInfo.Error}
+			if len(censusInfo.Failures) > 0 {
+				response["failures"] = censusInfo.Failures
+			}
+			if censusInfo.Filtered != nil {
+				response["filtered"] = censusInfo.Filtered
+			}
+			data, err := json.Marshal(response)
+			if err != nil {
+				return err
+			}
+			return ctx.Send(data, http.StatusInternalServerError)
+		}
 		return ctx.Send([]byte(censusInfo.Error), http.StatusInternalServerError)
 	}
 	if len(censusInfo.Usernames) > maxUsersNamesToReturn {
@@ -562,7 +745,7 @@ const (
 )
 
 func (v *vocdoniHandler) censusBlockchainsAirstack(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
-	data, err := json.Marshal(map[string][]string{"blockchains": v.airstack.Blockchains()})
+	data, err := json.Marshal(map[string][]string{"blockchains": v.tokenHolders.Blockchains()})
 	if err != nil {
 		return err
 	}
@@ -589,8 +772,20 @@ func (v *vocdoniHandler) censusTokenNFTAirstack(msg *apirest.APIdata, ctx *httpr
 	if err := v.checkTokens(req.Tokens); err != nil {
 		return err
 	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 
-	data, err := v.censusTokenAirstack(req.Tokens, NFTtype, userFID)
+	snapshot, err := v.tokenCensusSnapshot(req)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	data, err := v.censusTokenAirstack(req.Tokens, NFTtype, userFID, filters, snapshot, weightStrategy)
 	if err != nil {
 		return fmt.Errorf("cannot create nft census: %w", err)
 	}
@@ -598,12 +793,15 @@ func (v *vocdoniHandler) censusTokenNFTAirstack(msg *apirest.APIdata, ctx *httpr
 }
 
 func (v *vocdoniHandler) checkTokens(tokens []*CensusToken) error {
+	if v.tokenHolders == nil {
+		return fmt.Errorf("token holder provider not available")
+	}
 	for _, token := range tokens {
 		if len(token.Address) == 0 {
 			return fmt.Errorf("invalid token information: %v", token)
 		}
 		ok := false
-		for _, bk := range v.airstack.Blockchains() {
+		for _, bk := range v.tokenHolders.Blockchains() {
 			if bk == token.Blockchain {
 				ok = true
 			}
@@ -611,15 +809,20 @@ func (v *vocdoniHandler) checkTokens(tokens []*CensusToken) error {
 		if !ok {
 			return fmt.Errorf("invalid blockchain for token %s provided", token.Address)
 		}
-		// check max holders
+		// check max holders if the provider has a limit, the number of holders
+		// is provided by the Airstack support API
+		maxHolders := v.tokenHolders.MaxHolders()
+		if maxHolders == 0 || v.airstack == nil {
+			continue
+		}
 		if holders, err := v.airstack.NumHoldersByTokenAnkrAPI(token.Address, token.Blockchain); err != nil {
 			log.Warnf("cannot get holders for token %s: %s", token.Address, err)
-		} else if holders > v.airstack.MaxHolders() {
+		} else if holders > maxHolders {
 			// check whitelist
 			if _, ok := v.airstack.TokenWhitelist()[token.Address]; ok {
 				continue
 			}
-			return fmt.Errorf("token %s has too many holders: %d, maximum allowed is %d", token.Address, holders, v.airstack.MaxHolders())
+			return fmt.Errorf("token %s has too many holders: %d, maximum allowed is %d", token.Address, holders, maxHolders)
 		}
 	}
 	return nil
@@ -644,17 +847,31 @@ func (v *vocdoniHandler) censusTokenERC20Airstack(msg *apirest.APIdata, ctx *htt
 	if err := v.checkTokens(req.Tokens); err != nil {
 		return err
 	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 
-	data, err := v.censusTokenAirstack(req.Tokens, ERC20type, userFID)
+	snapshot, err := v.tokenCensusSnapshot(req)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	data, err := v.censusTokenAirstack(req.Tokens, ERC20type, userFID, filters, snapshot, weightStrategy)
 	if err != nil {
 		return fmt.Errorf("cannot create erc20 census: %w", err)
 	}
 	return ctx.Send(data, http.StatusOK)
 }
 
-func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType int, createdByFID uint64) ([]byte, error) {
-	if v.airstack == nil {
-		return nil, fmt.Errorf("airstack service not available")
+func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType int, createdByFID uint64,
+	filters *CensusFilters, snapshot *mongo.CensusSnapshot, weightStrategy *mongo.CensusWeightStrategy,
+) ([]byte, error) {
+	if v.tokenHolders == nil {
+		return nil, fmt.Errorf("token holder provider not available")
 	}
 	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
 	if err != nil {
@@ -663,19 +880,28 @@ func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType in
 	if err := v.db.AddCensus(censusID, createdByFID); err != nil {
 		return nil, fmt.Errorf("cannot add census to database: %w", err)
 	}
-	v.backgroundQueue.Store(censusID.String(), CensusInfo{})
+	v.startCensusJob(censusID, createdByFID)
 	go func() {
 		startTime := time.Now()
-		log.Debugw("building Airstack based census", "censusID", censusID)
-		// get holders for each token
+		log.Debugw("building token based census", "censusID", censusID)
+		// resolve the snapshot block, if any, and get holders for each token
 		var holders [][]string
 		var err error
+		snapshotBlock := uint64(0)
+		if snapshot != nil {
+			if err := v.resolveCensusSnapshot(snapshot); err != nil {
+				log.Warnw("failed to resolve census snapshot", "blockchain", snapshot.Blockchain, "err", err.Error())
+				v.storeCensusJob(censusID, CensusInfo{Error: fmt.Sprintf("cannot resolve snapshot: %v", err)})
+				return
+			}
+			snapshotBlock = snapshot.Block
+		}
 		v.trackStepProgress(censusID, 1, 3, func(progress chan int) {
-			holders, err = v.getTokenHoldersFromAirstack(tokens, censusID, progress)
+			holders, err = v.getTokenHolders(tokens, censusID, snapshotBlock, progress)
 		})
 		if err != nil {
 			log.Warnw("failed to build census from NFT, cannot get holders", "err", err.Error())
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
 		// create census from token holders
@@ -685,9 +911,19 @@ func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType in
 		})
 		if err != nil {
 			log.Warnw("failed to build census from NFT", "err", err.Error())
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants after filtering", Filtered: filtered})
+			return
+		}
+		applyCensusWeightStrategy(participants, weightStrategy)
 		var ci *CensusInfo
 		v.trackStepProgress(censusID, 3, 3, func(progress chan int) {
 			if tokenType == ERC20type {
@@ -698,47 +934,25 @@ func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType in
 		})
 		if err != nil {
 			log.Errorw(err, "failed to create census")
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
 
-		// since each participant can have multiple signers, we need to get the unique usernames
-		uniqueParticipantsMap := make(map[string]*big.Int)
-		totalWeight := new(big.Int).SetUint64(0)
-		for _, p := range participants {
-			if _, ok := uniqueParticipantsMap[p.Username]; ok {
-				// if the username is already in the map, continue
-				continue
-			}
-			uniqueParticipantsMap[p.Username] = p.Weight
-			totalWeight.Add(totalWeight, p.Weight)
-		}
-		uniqueParticipants := []string{}
-		for k := range uniqueParticipantsMap {
-			uniqueParticipants = append(uniqueParticipants, k)
-		}
-		ci.Usernames = uniqueParticipants
 		ci.FromTotalAddresses = uint32(len(holders))
-		log.Infow("census created from Airstack",
+		ci.Filtered = filtered
+		ci.Snapshot = snapshot
+		ci.WeightStrategy = weightStrategy
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, ci, participants) {
+			return
+		}
+		log.Infow("census created from token holders",
 			"censusID", censusID.String(),
 			"size", len(ci.Usernames),
-			"totalWeight", totalWeight.String(),
 			"duration", time.Since(startTime),
 			"totalAddresses", ci.FromTotalAddresses,
-			"participants", len(ci.Usernames),
 		)
-		// store the census info in the memory map
-		v.backgroundQueue.Store(censusID.String(), *ci)
-		// add participants to the census in the database
-		if err := v.db.AddParticipantsToCensus(
-			censusID,
-			uniqueParticipantsMap,
-			ci.FromTotalAddresses,
-			totalWeight,
-			ci.Url,
-		); err != nil {
-			log.Errorw(err, fmt.Sprintf("failed to add participants to census %s", censusID.String()))
-		}
 	}()
 	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
 	if err != nil {
@@ -747,11 +961,62 @@ func (v *vocdoniHandler) censusTokenAirstack(tokens []*CensusToken, tokenType in
 	return data, nil
 }
 
+// tokenCensusSnapshot returns the snapshot requested for a token based census,
+// or nil if the token balances must be resolved at the last block. The block
+// of the snapshot is resolved when the census is built if only the timestamp
+// is provided.
+func (v *vocdoniHandler) tokenCensusSnapshot(req *CensusTokensRequest) (*mongo.CensusSnapshot, error) {
+	if req.Block == 0 && req.Timestamp == 0 {
+		return nil, nil
+	}
+	if req.Block != 0 && req.Timestamp != 0 {
+		return nil, fmt.Errorf("block and timestamp cannot be used together")
+	}
+	if v.tokenSnaps == nil {
+		return nil, fmt.Errorf("token snapshots not available")
+	}
+	if req.Timestamp > time.Now().Unix() {
+		return nil, fmt.Errorf("timestamp is in the future")
+	}
+	blockchain := req.Tokens[0].Blockchain
+	for _, token := range req.Tokens[1:] {
+		if token.Blockchain != blockchain {
+			return nil, fmt.Errorf("snapshot censuses require all the tokens in the same blockchain")
+		}
+	}
+	if !slices.Contains(v.tokenSnaps.Blockchains(), blockchain) {
+		return nil, fmt.Errorf("snapshots not supported for blockchain %s", blockchain)
+	}
+	return &mongo.CensusSnapshot{
+		Blockchain: blockchain,
+		Block:      req.Block,
+		Timestamp:  req.Timestamp,
+	}, nil
+}
+
+// resolveCensusSnapshot sets the block of the snapshot from its timestamp, if
+// the block is not provided, and then the timestamp of the block.
+func (v *vocdoniHandler) resolveCensusSnapshot(snapshot *mongo.CensusSnapshot) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var err error
+	if snapshot.Block == 0 {
+		if snapshot.Block, err = v.tokenSnaps.BlockByTimestamp(ctx, snapshot.Blockchain, snapshot.Timestamp); err != nil {
+			return err
+		}
+	}
+	snapshot.Timestamp, err = v.tokenSnaps.BlockTime(ctx, snapshot.Blockchain, snapshot.Block)
+	return err
+}
+
 // censusWarpcastChannel helper method creates a new census from a Warpcast
 // Channel. The process is async and returns the json encoded censusID. It
-// updates the progress in the queue and the result when it's ready.
-func (v *vocdoniHandler) censusWarpcastChannel(censusID types.HexBytes, channelID string, authorFID uint64) ([]byte, error) {
-	v.backgroundQueue.Store(censusID.String(), CensusInfo{})
+// updates the progress in the queue and the result when it's ready. The
+// participants are filtered with the census filters provided, if any.
+func (v *vocdoniHandler) censusWarpcastChannel(censusID types.HexBytes, channelID string, authorFID uint64,
+	filters *CensusFilters,
+) ([]byte, error) {
+	v.startCensusJob(censusID, authorFID)
 	if err := v.db.AddCensus(censusID, authorFID); err != nil {
 		return nil, fmt.Errorf("cannot add census to database: %w", err)
 	}
@@ -769,12 +1034,12 @@ func (v *vocdoniHandler) censusWarpcastChannel(censusID types.HexBytes, channelI
 		})
 		if err != nil {
 			log.Errorw(err, "failed to get channel fids from farcaster API")
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
 		if len(users) == 0 {
 			log.Errorw(fmt.Errorf("no valid participants found for the channel %s", channelID), "")
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: "no valid participants found for the channel"})
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants found for the channel"})
 			return
 		}
 		// create the participants from the database users using the fids
@@ -782,9 +1047,14 @@ func (v *vocdoniHandler) censusWarpcastChannel(censusID types.HexBytes, channelI
 		v.trackStepProgress(censusID, 2, 3, func(progress chan int) {
 			participants = v.farcasterCensusFromFids(users, progress)
 		})
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
 		if len(participants) == 0 {
 			log.Errorw(fmt.Errorf("no valid participant signers found for the channel %s", channelID), "")
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: "no valid participant signers found for the channel"})
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participant signers found for the channel", Filtered: filtered})
 			return
 		}
 		// create the census from the participants
@@ -793,29 +1063,15 @@ func (v *vocdoniHandler) censusWarpcastChannel(censusID types.HexBytes, channelI
 			censusInfo, err = CreateCensus(v.cli, participants, FrameCensusTypeChannelGated, progress)
 		})
 		if err != nil {
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{Error: err.Error()})
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
 			return
 		}
-		uniqueParticipantsMap := make(map[string]*big.Int)
-		for _, p := range participants {
-			if _, ok := uniqueParticipantsMap[p.Username]; !ok {
-				uniqueParticipantsMap[p.Username] = new(big.Int).SetUint64(1)
-			}
-		}
-		for username := range uniqueParticipantsMap {
-			censusInfo.Usernames = append(censusInfo.Usernames, username)
-		}
 		censusInfo.FromTotalAddresses = uint32(len(users))
-		v.backgroundQueue.Store(censusID.String(), *censusInfo)
-		// add participants to the census in the database
-		if err := v.db.AddParticipantsToCensus(
-			censusID,
-			uniqueParticipantsMap,
-			censusInfo.FromTotalAddresses,
-			new(big.Int).SetUint64(uint64(len(uniqueParticipantsMap))),
-			censusInfo.Url,
-		); err != nil {
-			log.Errorw(err, fmt.Sprintf("failed to add participants to census %s", censusID.String()))
+		censusInfo.Filtered = filtered
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, censusInfo, participants) {
+			return
 		}
 		log.Infow("census created from channel",
 			"channelID", channelID,
@@ -834,11 +1090,12 @@ func (v *vocdoniHandler) checkNFTContractHandler(msg *apirest.APIdata, ctx *http
 	return ctx.Send([]byte("ok"), http.StatusOK)
 }
 
-// getTokenHoldersFromAirstack retuns a list of token holders ans their balances given a list of tokens
-// It fetches the information of the token holders by consuming the Airstack API
+// getTokenHolders retuns a list of token holders ans their balances given a list of tokens
+// It fetches the information of the token holders from the token holder provider
 // The holders list balances is truncated to the number of decimals of the token (if any).
-func (v *vocdoniHandler) getTokenHoldersFromAirstack(
-	tokens []*CensusToken, censusID types.HexBytes, progress chan int,
+// If the block is not 0, the balances are resolved at that block by the token snapshots provider.
+func (v *vocdoniHandler) getTokenHolders(
+	tokens []*CensusToken, censusID types.HexBytes, block uint64, progress chan int,
 ) ([][]string, error) {
 	holders := make([][]string, 0)
 	processedTokens := 0
@@ -847,15 +1104,20 @@ func (v *vocdoniHandler) getTokenHoldersFromAirstack(
 	for _, token := range tokens {
 		tokenAddress := common.HexToAddress(token.Address)
 		// Get the number of decimals for the token
-		decimals, err := v.airstack.TokenDecimalsByToken(token.Address, token.Blockchain)
+		decimals, err := v.tokenHolders.TokenDecimals(context.Background(), tokenAddress, token.Blockchain)
 		if err != nil {
 			log.Warnw("failed to fetch token details", "token", token.Address, "error", err)
 		}
 
-		tokenHolders, err := v.airstack.TokenBalances(tokenAddress, token.Blockchain)
+		var tokenHolders []*tokenholders.TokenHolder
+		if block != 0 {
+			tokenHolders, err = v.tokenSnaps.TokenHoldersAt(context.Background(), tokenAddress, token.Blockchain, block)
+		} else {
+			tokenHolders, err = v.tokenHolders.TokenHolders(context.Background(), tokenAddress, token.Blockchain)
+		}
 		if err != nil {
 			log.Warnw("failed to create census for token %s: %v", token.Address, err)
-			v.backgroundQueue.Store(censusID.String(), CensusInfo{
+			v.storeCensusJob(censusID, CensusInfo{
 				Error: fmt.Sprintf("cannot get token %s details: %v", token.Address, err),
 			})
 			return nil, err
@@ -877,16 +1139,267 @@ func (v *vocdoniHandler) getTokenHoldersFromAirstack(
 			}
 		}
 	}
-	log.Debugf("airstack total holders found: %d", totalHolders)
+	log.Debugf("total token holders found: %d", totalHolders)
 	return holders, nil
 }
 
-func (v *vocdoniHandler) farcasterCensusFromEthereumCSV(csv []byte, progress chan int) ([]*FarcasterParticipant, uint32, error) {
-	records, err := ParseCSV(csv)
+// farcasterCensusFromCSV creates a list of Farcaster participants from a CSV
+// whose records are Ethereum addresses, FIDs or @usernames with an optional
+// weight. The CSV is parsed while it is read, and it can have up to the given
+// number of records. The addresses are resolved to Farcaster users by their
+// verified addresses, while the FIDs and usernames are resolved directly. It
+// returns the participants, the total number of unique records and the
+// records that are invalid or could not be resolved.
+func (v *vocdoniHandler) farcasterCensusFromCSV(reader io.Reader, maxRecords int,
+	progress chan int,
+) ([]*FarcasterParticipant, uint32, []*CensusRecordFailure, error) {
+	addressRecords := [][]string{}
+	identityRecords := []*censusIdentityRecord{}
+	failures, err := streamCSV(reader, maxRecords, func(line int, record []string) error {
+		addressRecord, identityRecord, err := parseCensusRecord(line, record)
+		switch {
+		case err != nil:
+			return err
+		case addressRecord != nil:
+			addressRecords = append(addressRecords, addressRecord)
+		default:
+			identityRecords = append(identityRecords, identityRecord)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, failures, err
 	}
-	return v.processCensusRecords(records, progress)
+	participants := []*FarcasterParticipant{}
+	total := uint32(0)
+	if len(addressRecords) > 0 {
+		// the progress is only tracked for the biggest group of records
+		addressProgress := progress
+		if len(identityRecords) > len(addressRecords) {
+			addressProgress = nil
+		}
+		participants, total, err = v.processCensusRecords(addressRecords, addressProgress)
+		if err != nil && !errors.Is(err, ErrNoValidParticipants) {
+			return nil, 0, failures, err
+		}
+	}
+	if len(identityRecords) > 0 {
+		identityProgress := progress
+		if len(identityRecords) <= len(addressRecords) {
+			identityProgress = nil
+		}
+		identityParticipants, identityTotal, identityFailures := v.processCensusIdentityRecords(identityRecords, participants, identityProgress)
+		participants = append(participants, identityParticipants...)
+		total += identityTotal
+		failures = append(failures, identityFailures...)
+	}
+	if len(participants) == 0 {
+		return nil, total, failures, ErrNoValidParticipants
+	}
+	return participants, total, failures, nil
+}
+
+// censusIdentityRecord is a record of a plain-text census that identifies a
+// Farcaster user by its FID or its username.
+type censusIdentityRecord struct {
+	row      int
+	fid      uint64
+	username string
+	weight   *big.Int
+}
+
+// parseCensusRecord parses a record of a plain-text census. The records with
+// an Ethereum address in any of their fields are returned as they are, once
+// their weight is validated, to be resolved by processCensusRecords. The rest
+// are parsed as identity records.
+func parseCensusRecord(row int, record []string) ([]string, *censusIdentityRecord, error) {
+	if common.IsHexAddress(record[0]) || common.IsHexAddress(record[1]) {
+		weightRecord := record[1]
+		if common.IsHexAddress(record[1]) {
+			weightRecord = record[0]
+		}
+		if weightRecord != "" {
+			if weight, ok := new(big.Int).SetString(weightRecord, 10); !ok || weight.Sign() < 0 {
+				return nil, nil, fmt.Errorf("invalid weight %s", weightRecord)
+			}
+		}
+		return record, nil, nil
+	}
+	identityRecord, err := parseCensusIdentityRecord(row, record)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, identityRecord, nil
+}
+
+// parseCensusIdentityRecord parses a record of a plain-text census whose first
+// field is a FID or an @username and the second one an optional weight.
+func parseCensusIdentityRecord(row int, record []string) (*censusIdentityRecord, error) {
+	identity := strings.TrimSpace(record[0])
+	weightRecord := strings.TrimSpace(record[1])
+	if weightRecord == "" {
+		weightRecord = "1"
+	}
+	weight, ok := new(big.Int).SetString(weightRecord, 10)
+	if !ok || weight.Sign() < 0 {
+		return nil, fmt.Errorf("invalid weight %s", weightRecord)
+	}
+	identityRecord := &censusIdentityRecord{row: row, weight: weight}
+	if username, ok := strings.CutPrefix(identity, "@"); ok {
+		if username == "" {
+			return nil, fmt.Errorf("empty username")
+		}
+		identityRecord.username = strings.ToLower(username)
+		return identityRecord, nil
+	}
+	fid, err := strconv.ParseUint(identity, 10, 64)
+	if err != nil || fid == 0 {
+		return nil, fmt.Errorf("invalid address, fid or username")
+	}
+	identityRecord.fid = fid
+	return identityRecord, nil
+}
+
+// failure returns the failure of the record with the error provided, with the
+// record in its normalized form.
+func (r *censusIdentityRecord) failure(err error) *CensusRecordFailure {
+	identity := fmt.Sprint(r.fid)
+	if r.username != "" {
+		identity = "@" + r.username
+	}
+	return &CensusRecordFailure{
+		Row:    r.row,
+		Record: fmt.Sprintf("%s,%s", identity, r.weight),
+		Error:  err.Error(),
+	}
+}
+
+// processCensusIdentityRecords resolves the Farcaster users of the identity
+// records provided, querying the database and falling back to the Farcaster
+// API. The weights of the records of the same user are added up, and if the
+// user is already in the participants provided (from other records), its
+// weight is added to them instead of creating new participants. It returns the
+// new participants, the number of unique users resolved and the failures.
+func (v *vocdoniHandler) processCensusIdentityRecords(records []*censusIdentityRecord,
+	participants []*FarcasterParticipant, progress chan int,
+) ([]*FarcasterParticipant, uint32, []*CensusRecordFailure) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	concurrencyLimit := make(chan struct{}, 10)
+	users := make(map[uint64]*mongo.User)
+	weights := make(map[uint64]*big.Int)
+	failures := []*CensusRecordFailure{}
+	var processed atomic.Uint32
+	for _, record := range records {
+		concurrencyLimit <- struct{}{}
+		wg.Add(1)
+		go func(record *censusIdentityRecord) {
+			defer wg.Done()
+			defer func() { <-concurrencyLimit }()
+			user, err := v.censusIdentityUser(ctx, record)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				failures = append(failures, record.failure(err))
+			} else {
+				users[user.UserID] = user
+				if _, ok := weights[user.UserID]; !ok {
+					weights[user.UserID] = new(big.Int)
+				}
+				weights[user.UserID].Add(weights[user.UserID], record.weight)
+			}
+			if progress != nil {
+				progress <- int(100 * processed.Add(1) / uint32(len(records)))
+			}
+		}(record)
+	}
+	wg.Wait()
+	close(concurrencyLimit)
+
+	// add the weights of the users that are already participants
+	for _, p := range participants {
+		if weight, ok := weights[p.FID]; ok {
+			p.Weight = new(big.Int).Add(p.Weight, weight)
+			delete(users, p.FID)
+		}
+	}
+	newParticipants := []*FarcasterParticipant{}
+	for fid, user := range users {
+		for _, signer := range user.ActiveSigners() {
+			signerBytes, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
+			if err != nil {
+				log.Warnw("error decoding signer", "signer", signer, "err", err)
+				continue
+			}
+			newParticipants = append(newParticipants, &FarcasterParticipant{
+				PubKey:   signerBytes,
+				Weight:   weights[fid],
+				Username: user.Username,
+				FID:      fid,
+			})
+		}
+	}
+	return newParticipants, uint32(len(weights)), failures
+}
+
+// censusIdentityUser returns the user of the identity record provided. If the
+// user is not in the database, it is fetched from the Farcaster API and stored
+// in the database. It returns an error if the user has no signers.
+func (v *vocdoniHandler) censusIdentityUser(ctx context.Context, record *censusIdentityRecord) (*mongo.User, error) {
+	var user *mongo.User
+	var err error
+	if record.username != "" {
+		user, err = v.db.UserByUsername(record.username)
+	} else {
+		user, err = v.db.User(record.fid)
+	}
+	if err != nil || len(user.ActiveSigners()) == 0 {
+		internalCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		var userData *farcasterapi.Userdata
+		var fcErr error
+		if record.username != "" {
+			userData, fcErr = v.fcapi.UserDataByUsername(internalCtx, record.username)
+		} else {
+			userData, fcErr = v.fcapi.UserDataByFID(internalCtx, record.fid)
+		}
+		if fcErr != nil {
+			if errors.Is(fcErr, farcasterapi.ErrNoDataFound) {
+				return nil, ErrUserNotFoundInFarcaster
+			}
+			return nil, fmt.Errorf("cannot get user from farcaster: %w", fcErr)
+		}
+		if user == nil {
+			if err := v.db.AddUser(
+				userData.FID,
+				userData.Username,
+				userData.Displayname,
+				userData.VerificationsAddresses,
+				userData.Signers,
+				userData.CustodyAddress,
+				0,
+			); err != nil {
+				log.Warnw("cannot add user to database", "fid", userData.FID, "error", err)
+			}
+			user = &mongo.User{UserID: userData.FID}
+		} else {
+			user.Addresses = userData.VerificationsAddresses
+			user.Signers = userData.Signers
+			user.CustodyAddress = userData.CustodyAddress
+			if err := v.db.UpdateUser(user); err != nil {
+				log.Warnw("cannot update user on database", "fid", user.UserID, "error", err)
+			}
+		}
+		user.Username = userData.Username
+		user.Signers = userData.Signers
+	}
+	if len(user.ActiveSigners()) == 0 {
+		return nil, fmt.Errorf("user has no signers")
+	}
+	return user, nil
 }
 
 // farcasterCensusFromFids creates a list of Farcaster participants from a list
@@ -934,8 +1447,9 @@ func (v *vocdoniHandler) farcasterCensusFromFids(fids []uint64, progress chan in
 				}
 				return
 			}
-			// create a participant for each signer of the user
-			for _, signer := range user.Signers {
+			// create a participant for each signer of the user, skipping the
+			// revoked ones
+			for _, signer := range user.ActiveSigners() {
 				signerBytes, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
 				if err != nil {
 					log.Warnw("error decoding signer", "signer", signer, "err", err)
@@ -945,6 +1459,7 @@ func (v *vocdoniHandler) farcasterCensusFromFids(fids []uint64, progress chan in
 					PubKey:   signerBytes,
 					Weight:   big.NewInt(1),
 					Username: user.Username,
+					FID:      user.UserID,
 				}
 			}
 			// update the progress if the progress channel is provided
@@ -983,13 +1498,10 @@ func (v *vocdoniHandler) trackStepProgress(censusID types.HexBytes, step, totalS
 				chanClosed.Store(true)
 				return
 			}
-			censusInfo, ok := v.backgroundQueue.Load(censusID.String())
-			if !ok {
-				return
-			}
-			ci, ok := censusInfo.(CensusInfo)
-			if !ok || ci.Root != nil {
-				return
+			ci, err := v.censusJob(censusID)
+			if err != nil || ci.Finished() {
+				// keep consuming the progress updates to not block the action
+				continue
 			}
 			// calc partial progress of current step
 			stepIndex := uint32(step - 1)
@@ -997,7 +1509,7 @@ func (v *vocdoniHandler) trackStepProgress(censusID types.HexBytes, step, totalS
 			stepProgress := stepIndex*partialStep + uint32(p)/uint32(totalSteps)
 			// update the census progress
 			ci.Progress = stepProgress
-			v.backgroundQueue.Store(censusID.String(), ci)
+			v.storeCensusJob(censusID, *ci)
 		}
 	}()
 	action(progress)
@@ -1056,116 +1568,66 @@ func (v *vocdoniHandler) processCensusRecords(records [][]string, progress chan
 		return nil, 0, ErrNoValidParticipants
 	}
 
-	// Fetch the users from the database concurrently
-	var wg sync.WaitGroup
-	participantsCh := make(chan *FarcasterParticipant) // Channel to collect participants
-	pendingAddressesCh := make(chan string)            // Channel to collect pending addresses
-	concurrencyLimit := make(chan struct{}, 10)        // Concurrency limiter, N is the max number of goroutines
+	// Fetch the users from the database in batches, the addresses that are
+	// not found or whose users have no signers are fetched from the API
+	addresses := make([]string, 0, len(addressMap))
+	for address := range addressMap {
+		addresses = append(addresses, address)
+	}
 	participants := []*FarcasterParticipant{}
-	pendingAddresses := []string{}
-	var processedAddresses atomic.Uint32
-
-	// Start goroutines to consume data from channels
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case participant, ok := <-participantsCh:
-				if !ok {
-					log.Debugw("collected valid database participants", "count", len(participants))
-					return
-				}
-				participants = append(participants, participant)
-			}
+	resolvedAddresses := make(map[string]bool)
+	resolvedUsers := make(map[uint64]bool)
+	processedAddresses := 0
+	for i := 0; i < len(addresses); i += csvAddressesBatchSize {
+		to := i + csvAddressesBatchSize
+		if to > len(addresses) {
+			to = len(addresses)
 		}
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case addr, ok := <-pendingAddressesCh:
-				if !ok {
-					log.Debugw("collected pending participants", "count", len(pendingAddresses))
-					return
-				}
-				pendingAddresses = append(pendingAddresses, addr)
-			}
-		}
-	}()
-
-	// Progress update goroutine
-	go func() {
-		logTicker := time.NewTicker(2 * time.Second)
-		defer logTicker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-logTicker.C:
-				if progress == nil {
-					return
-				}
-				progress <- int(100 * processedAddresses.Load() / uniqueAddressesCount)
-			}
+		users, err := v.db.UsersByAddresses(addresses[i:to])
+		if err != nil {
+			log.Warnw("error fetching users from database", "from", i, "to", to, "error", err)
 		}
-	}()
-
-	// Processing addresses
-	for address := range addressMap {
-		concurrencyLimit <- struct{}{}
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			defer func() { <-concurrencyLimit }() // Release semaphore
-
-			user, err := v.db.UserByAddress(addr)
-			if err != nil {
-				if !errors.Is(err, mongo.ErrUserUnknown) {
-					log.Warnw("error fetching user from database", "address", addr, "error", err)
-				} else {
-					pendingAddressesCh <- addr
-				}
-				return
-			}
-			if len(user.Signers) == 0 {
-				pendingAddressesCh <- addr
-				return
+		for _, user := range users {
+			signers := user.ActiveSigners()
+			if len(signers) == 0 || resolvedUsers[user.UserID] {
+				continue
 			}
-
-			// find the addres on the map to get the weight
+			resolvedUsers[user.UserID] = true
 			// the weight is the sum of the weights of all the addresses of the user
 			weight := new(big.Int).SetUint64(0)
 			for _, addr := range user.Addresses {
-				weightAddress, ok := addressMap[common.HexToAddress(addr).Hex()]
-				if ok {
+				address := common.HexToAddress(addr).Hex()
+				if weightAddress, ok := addressMap[address]; ok && !resolvedAddresses[address] {
+					resolvedAddresses[address] = true
 					weight = weight.Add(weight, weightAddress)
+					processedAddresses++
 				}
 			}
-
-			for _, signer := range user.Signers {
+			for _, signer := range signers {
 				signerBytes, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
 				if err != nil {
 					log.Warnw("error decoding signer", "signer", signer, "err", err)
 					continue
 				}
-				participantsCh <- &FarcasterParticipant{
+				participants = append(participants, &FarcasterParticipant{
 					PubKey:   signerBytes,
 					Weight:   weight,
 					Username: user.Username,
 					FID:      user.UserID,
-				}
+				})
 			}
-			processedAddresses.Add(1)
-		}(address)
+		}
+		if progress != nil {
+			progress <- 100 * processedAddresses / len(addresses)
+		}
+	}
+	log.Debugw("collected valid database participants", "count", len(participants))
+	pendingAddresses := []string{}
+	for _, address := range addresses {
+		if !resolvedAddresses[address] {
+			pendingAddresses = append(pendingAddresses, address)
+		}
 	}
-
-	wg.Wait()
-	close(participantsCh)
-	close(pendingAddressesCh)
-	close(concurrencyLimit)
 
 	// Fetch the remaining users from the farcaster API
 	count := 0
@@ -1187,7 +1649,12 @@ func (v *vocdoniHandler) processCensusRecords(records [][]string, progress chan
 			log.Errorw(err, "error fetching users from Neynar API")
 		}
 		for _, userData := range usersData {
+			if resolvedUsers[userData.FID] {
+				continue
+			}
+			resolvedUsers[userData.FID] = true
 			// Add or update the user on the database
+			signers := userData.Signers
 			dbUser, err := v.db.User(userData.FID)
 			if err != nil {
 				log.Debugw("adding new user to database", "fid", userData.FID)
@@ -1211,6 +1678,7 @@ func (v *vocdoniHandler) processCensusRecords(records [][]string, progress chan
 				if err := v.db.UpdateUser(dbUser); err != nil {
 					return nil, 0, err
 				}
+				signers = dbUser.ActiveSigners()
 			}
 			// find the addres on the map to get the weight
 			// the weight is the sum of the weights of all the addresses of the user
@@ -1223,7 +1691,7 @@ func (v *vocdoniHandler) processCensusRecords(records [][]string, progress chan
 			}
 
 			// Add the user to the participants list (with all the signers)
-			for _, signer := range userData.Signers {
+			for _, signer := range signers {
 				signerBytes, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
 				if err != nil {
 					log.Warnw("error decoding signer", "signer", signer, "err", err)
@@ -1238,76 +1706,13 @@ func (v *vocdoniHandler) processCensusRecords(records [][]string, progress chan
 			}
 			count++
 		}
-		processedAddresses.Add(uint32(to - i))
+		processedAddresses += to - i
+		if progress != nil {
+			progress <- 100 * processedAddresses / len(addresses)
+		}
 	}
 	if len(pendingAddresses) > 0 {
 		log.Infow("users found on farcaster", "count", count, "ratio", fmt.Sprintf("%.2f%%", 100*float64(count)/float64(len(pendingAddresses))))
 	}
 	return participants, uint32(len(addressMap)), nil
 }
-
-func ParseCSV(csvData []byte) ([][]string, error) {
-	if len(csvData) == 0 {
-		return nil, fmt.Errorf("empty csv")
-	}
-	csvType := "Weight-Balance"
-
-	// get the first line of the csv to check if it's a POAP csv
-	firstLine := strings.Split(string(csvData), "\n")[0]
-	if strings.Contains(firstLine, POAP_CSV_HEADER) {
-		csvType = "POAP"
-	}
-	var records [][]string
-	log.Infow("parsing csv", "type", csvType)
-	switch csvType {
-	case "Weight-Balance":
-		// Convert the byte slice to a reader
-		r := csv.NewReader(strings.NewReader(string(csvData)))
-		r.Comment = '#'
-		r.TrimLeadingSpace = true // trim leading space of each field
-		r.FieldsPerRecord = 2     // expect 2 fields per record
-		count := 0
-		for {
-			record, err := r.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, err
-			}
-			records = append(records, record)
-			count++
-			if count > maxNumOfCsvRecords {
-				return nil, fmt.Errorf("max number of records exceeded")
-			}
-		}
-	case "POAP":
-		// Convert the byte slice to a reader
-		r := csv.NewReader(strings.NewReader(string(csvData)))
-		r.Comment = '#'
-		r.TrimLeadingSpace = true // trim leading space of each field
-		r.FieldsPerRecord = 6     // expect 6 fields per record
-		count := 0
-		firstLine := true
-		for {
-			record, err := r.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, err
-			}
-			if firstLine {
-				firstLine = false
-				continue
-			}
-			records = append(records, []string{record[1], "1"})
-			count++
-			if count > maxNumOfCsvRecords {
-				return nil, fmt.Errorf("max number of records exceeded")
-			}
-		}
-
-	}
-	return records, nil
-}
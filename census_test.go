@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestUniqueCensusParticipants(t *testing.T) {
+	c := qt.New(t)
+	unique, totalWeight := uniqueCensusParticipants([]*FarcasterParticipant{
+		{FID: 1, Username: "alice", PubKey: []byte{1}, Weight: big.NewInt(3)},
+		{FID: 1, Username: "alice", PubKey: []byte{2}, Weight: big.NewInt(3)},
+		{FID: 2, Username: "bob", PubKey: []byte{3}, Weight: big.NewInt(2)},
+	})
+	c.Assert(unique, qt.HasLen, 2)
+	c.Assert(unique["alice"].Int64(), qt.Equals, int64(3))
+	c.Assert(unique["bob"].Int64(), qt.Equals, int64(2))
+	c.Assert(totalWeight.Int64(), qt.Equals, int64(5))
+
+	unique, totalWeight = uniqueCensusParticipants(nil)
+	c.Assert(unique, qt.HasLen, 0)
+	c.Assert(totalWeight.Int64(), qt.Equals, int64(0))
+}
+
+func TestCensusParticipantsByFID(t *testing.T) {
+	c := qt.New(t)
+	byFID := censusParticipantsByFID([]*FarcasterParticipant{
+		{FID: 1, Username: "alice", PubKey: []byte{1}, Weight: big.NewInt(3)},
+		{FID: 1, Username: "alice", PubKey: []byte{2}, Weight: big.NewInt(3)},
+		{FID: 2, Username: "bob", PubKey: []byte{3}, Weight: big.NewInt(2)},
+	})
+	c.Assert(byFID, qt.HasLen, 2)
+	c.Assert(byFID[1].Int64(), qt.Equals, int64(3))
+	c.Assert(byFID[2].Int64(), qt.Equals, int64(2))
+}
+
+func TestParseCensusRecord(t *testing.T) {
+	c := qt.New(t)
+	const address = "0x71C7656EC7ab88b098defB751B7401B5f6d8976F"
+	tests := []struct {
+		name     string
+		record   []string
+		address  bool
+		fid      uint64
+		username string
+		weight   int64
+		err      string
+	}{
+		{name: "address", record: []string{address, ""}, address: true},
+		{name: "address and weight", record: []string{address, "10"}, address: true},
+		{name: "weight and address", record: []string{"10", address}, address: true},
+		{name: "address invalid weight", record: []string{address, "ten"}, err: "invalid weight ten"},
+		{name: "address negative weight", record: []string{address, "-1"}, err: "invalid weight -1"},
+		{name: "fid", record: []string{"3", ""}, fid: 3, weight: 1},
+		{name: "fid and weight", record: []string{" 3 ", " 5 "}, fid: 3, weight: 5},
+		{name: "username", record: []string{"@Alice", "2"}, username: "alice", weight: 2},
+		{name: "zero weight", record: []string{"@alice", "0"}, username: "alice", weight: 0},
+		{name: "empty username", record: []string{"@", ""}, err: "empty username"},
+		{name: "zero fid", record: []string{"0", ""}, err: "invalid address, fid or username"},
+		{name: "username without at", record: []string{"alice", ""}, err: "invalid address, fid or username"},
+		{name: "short address", record: []string{"0x71C7", ""}, err: "invalid address, fid or username"},
+		{name: "identity invalid weight", record: []string{"3", "1.5"}, err: "invalid weight 1.5"},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			addressRecord, identityRecord, err := parseCensusRecord(7, test.record)
+			if test.err != "" {
+				c.Assert(err, qt.ErrorMatches, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			if test.address {
+				c.Assert(addressRecord, qt.DeepEquals, test.record)
+				c.Assert(identityRecord, qt.IsNil)
+				return
+			}
+			c.Assert(addressRecord, qt.IsNil)
+			c.Assert(identityRecord.row, qt.Equals, 7)
+			c.Assert(identityRecord.fid, qt.Equals, test.fid)
+			c.Assert(identityRecord.username, qt.Equals, test.username)
+			c.Assert(identityRecord.weight.Int64(), qt.Equals, test.weight)
+		})
+	}
+}
+
+func TestCensusRecordFailures(t *testing.T) {
+	c := qt.New(t)
+	csv := "# census\n" +
+		"0x71C7656EC7ab88b098defB751B7401B5f6d8976F,10\n" +
+		"@alice,ten\n" +
+		"3,2\n" +
+		"bob\n" +
+		"4,5,6\n" +
+		"@Carol\n"
+	addresses := 0
+	identities := []*censusIdentityRecord{}
+	failures, err := streamCSV(strings.NewReader(csv), 10, func(line int, record []string) error {
+		addressRecord, identityRecord, err := parseCensusRecord(line, record)
+		if err != nil {
+			return err
+		}
+		if addressRecord != nil {
+			addresses++
+		} else {
+			identities = append(identities, identityRecord)
+		}
+		return nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(addresses, qt.Equals, 1)
+	c.Assert(identities, qt.HasLen, 2)
+	// the rows are the lines of the csv, including the comments
+	c.Assert(failures, qt.DeepEquals, []*CensusRecordFailure{
+		{Row: 3, Record: "@alice,ten", Error: "invalid weight ten"},
+		{Row: 5, Record: "bob,", Error: "invalid address, fid or username"},
+		{Row: 6, Record: "4,5,6", Error: "invalid number of fields"},
+	})
+
+	// the records that cannot be resolved are reported normalized
+	errNotFound := fmt.Errorf("user not found")
+	c.Assert(identities[0].failure(errNotFound), qt.DeepEquals,
+		&CensusRecordFailure{Row: 4, Record: "3,2", Error: "user not found"})
+	c.Assert(identities[1].failure(errNotFound), qt.DeepEquals,
+		&CensusRecordFailure{Row: 7, Record: "@carol,1", Error: "user not found"})
+}
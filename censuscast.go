@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+)
+
+// castReactionReply is the key of the replies weight in the cast census
+// request. Likes and recasts use the farcasterapi reaction types as keys.
+const castReactionReply = "reply"
+
+// censusCast creates a new census that includes the users who reacted to a
+// specific cast, liking, recasting and/or replying it. The weight of every
+// user is the sum of the weights of its reactions. It builds the census async
+// and returns the census ID.
+func (v *vocdoniHandler) censusCast(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	castFID, err := strconv.ParseUint(ctx.URLParam("fid"), 10, 64)
+	if err != nil {
+		return ctx.Send([]byte("invalid fid"), http.StatusBadRequest)
+	}
+	castHash := ctx.URLParam("hash")
+	if castHash == "" {
+		return ctx.Send([]byte("hash is required"), http.StatusBadRequest)
+	}
+	req := &CastCensusRequest{}
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, req); err != nil {
+			return ctx.Send([]byte("invalid request body"), http.StatusBadRequest)
+		}
+	}
+	for reaction := range req.Weights {
+		switch reaction {
+		case farcasterapi.ReactionTypeLike, farcasterapi.ReactionTypeRecast, castReactionReply:
+		default:
+			return ctx.Send([]byte(fmt.Sprintf("invalid reaction %s", reaction)), http.StatusBadRequest)
+		}
+	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	// create a censusID for the queue and store into it
+	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	if err != nil {
+		return err
+	}
+	v.startCensusJob(censusID, userFID)
+	if err := v.db.AddCensus(censusID, userFID); err != nil {
+		return fmt.Errorf("cannot add census to database: %w", err)
+	}
+	go func() {
+		startTime := time.Now()
+		var weights map[uint64]*big.Int
+		var err error
+		v.trackStepProgress(censusID, 1, 3, func(progress chan int) {
+			weights, err = v.castReactionsWeights(castFID, castHash, req, progress)
+		})
+		if err != nil {
+			log.Warnw("failed to get cast reactions", "fid", castFID, "hash", castHash, "error", err)
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(weights) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no reactions found for the cast"})
+			return
+		}
+		fids := make([]uint64, 0, len(weights))
+		for fid := range weights {
+			fids = append(fids, fid)
+		}
+		// create the participants from the database users using the fids and
+		// set their weights
+		var participants []*FarcasterParticipant
+		v.trackStepProgress(censusID, 2, 3, func(progress chan int) {
+			participants = v.farcasterCensusFromFids(fids, progress)
+		})
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		for _, p := range participants {
+			p.Weight = weights[p.FID]
+		}
+		applyCensusWeightStrategy(participants, weightStrategy)
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participant signers found for the cast", Filtered: filtered})
+			return
+		}
+		// create the census from the participants
+		var censusInfo *CensusInfo
+		v.trackStepProgress(censusID, 3, 3, func(progress chan int) {
+			censusInfo, err = CreateCensus(v.cli, participants, FrameCensusTypeCastReactions, progress)
+		})
+		if err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		censusInfo.FromTotalAddresses = uint32(len(fids))
+		censusInfo.Filtered = filtered
+		censusInfo.WeightStrategy = weightStrategy
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, censusInfo, participants) {
+			return
+		}
+		log.Infow("census created from cast reactions",
+			"fid", castFID,
+			"hash", castHash,
+			"participants", len(censusInfo.Usernames),
+			"duration", time.Since(startTime))
+	}()
+	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// reactions returns the reactions selected in the request, in the order in
+// which they are fetched. If no reaction is selected, all of them are.
+func (req *CastCensusRequest) reactions() []string {
+	if !req.Likes && !req.Recasts && !req.Replies {
+		return []string{farcasterapi.ReactionTypeLike, farcasterapi.ReactionTypeRecast, castReactionReply}
+	}
+	reactions := []string{}
+	if req.Likes {
+		reactions = append(reactions, farcasterapi.ReactionTypeLike)
+	}
+	if req.Recasts {
+		reactions = append(reactions, farcasterapi.ReactionTypeRecast)
+	}
+	if req.Replies {
+		reactions = append(reactions, castReactionReply)
+	}
+	return reactions
+}
+
+// castReactionsWeights returns the weight of every user that reacted to the
+// cast with the reactions selected in the request, indexed by FID. The weight
+// of a user is the sum of the weights of its reactions.
+func (v *vocdoniHandler) castReactionsWeights(fid uint64, hash string, req *CastCensusRequest,
+	progress chan int,
+) (map[uint64]*big.Int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reactions := req.reactions()
+	weights := make(map[uint64]*big.Int)
+	for i, reaction := range reactions {
+		var fids []uint64
+		var err error
+		if reaction == castReactionReply {
+			fids, err = v.fcapi.CastRepliers(ctx, fid, hash)
+		} else {
+			fids, err = v.fcapi.CastReactions(ctx, fid, hash, reaction)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot get %s reactions: %w", reaction, err)
+		}
+		weight := uint64(1)
+		if w, ok := req.Weights[reaction]; ok {
+			weight = w
+		}
+		for _, reactionFID := range fids {
+			if _, ok := weights[reactionFID]; !ok {
+				weights[reactionFID] = new(big.Int)
+			}
+			weights[reactionFID].Add(weights[reactionFID], new(big.Int).SetUint64(weight))
+		}
+		if progress != nil {
+			progress <- 100 * (i + 1) / len(reactions)
+		}
+	}
+	// users whose reactions have no weight are not eligible
+	for reactionFID, weight := range weights {
+		if weight.Sign() == 0 {
+			delete(weights, reactionFID)
+		}
+	}
+	return weights, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+func TestCastCensusRequestReactions(t *testing.T) {
+	c := qt.New(t)
+	all := []string{farcasterapi.ReactionTypeLike, farcasterapi.ReactionTypeRecast, castReactionReply}
+	tests := []struct {
+		name string
+		req  *CastCensusRequest
+		want []string
+	}{
+		{name: "none", req: &CastCensusRequest{}, want: all},
+		{name: "all", req: &CastCensusRequest{Likes: true, Recasts: true, Replies: true}, want: all},
+		{name: "likes", req: &CastCensusRequest{Likes: true}, want: []string{farcasterapi.ReactionTypeLike}},
+		{
+			name: "recasts and replies",
+			req:  &CastCensusRequest{Recasts: true, Replies: true},
+			want: []string{farcasterapi.ReactionTypeRecast, castReactionReply},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(test.req.reactions(), qt.DeepEquals, test.want)
+		})
+	}
+}
+
+func TestCastReactionsWeights(t *testing.T) {
+	c := qt.New(t)
+	api := mock.NewMockAPI()
+	api.AddFixtures(&mock.Fixtures{Casts: []*mock.CastFixture{
+		{Author: 1, Hash: "0xcast", Likes: []uint64{2, 3, 4}, Recasts: []uint64{3, 5}},
+		{Author: 4, Hash: "0xreply1", Parent: &mock.ParentFixture{FID: 1, Hash: "0xcast"}},
+		{Author: 4, Hash: "0xreply2", Parent: &mock.ParentFixture{FID: 1, Hash: "0xcast"}},
+		{Author: 6, Hash: "0xreply3", Parent: &mock.ParentFixture{FID: 1, Hash: "0xcast"}},
+	}})
+	handler := &vocdoniHandler{fcapi: api}
+
+	tests := []struct {
+		name string
+		req  *CastCensusRequest
+		want map[uint64]int64
+	}{
+		{
+			name: "all reactions",
+			req:  &CastCensusRequest{},
+			want: map[uint64]int64{2: 1, 3: 2, 4: 2, 5: 1, 6: 1},
+		},
+		{
+			name: "likes only",
+			req:  &CastCensusRequest{Likes: true},
+			want: map[uint64]int64{2: 1, 3: 1, 4: 1},
+		},
+		{
+			name: "weighted reactions",
+			req: &CastCensusRequest{Weights: map[string]uint64{
+				farcasterapi.ReactionTypeLike:   1,
+				farcasterapi.ReactionTypeRecast: 3,
+				castReactionReply:               2,
+			}},
+			want: map[uint64]int64{2: 1, 3: 4, 4: 3, 5: 3, 6: 2},
+		},
+		{
+			name: "reactions without weight",
+			req: &CastCensusRequest{Likes: true, Recasts: true, Weights: map[string]uint64{
+				farcasterapi.ReactionTypeLike: 0,
+			}},
+			want: map[uint64]int64{3: 1, 5: 1},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			weights, err := handler.castReactionsWeights(1, "0xcast", test.req, nil)
+			c.Assert(err, qt.IsNil)
+			got := map[uint64]int64{}
+			for fid, weight := range weights {
+				got[fid] = weight.Int64()
+			}
+			c.Assert(got, qt.DeepEquals, test.want)
+		})
+	}
+
+	api.SetError("CastRepliers", errors.New("hub unavailable"))
+	_, err := handler.castReactionsWeights(1, "0xcast", &CastCensusRequest{Replies: true}, nil)
+	c.Assert(err, qt.ErrorMatches, "cannot get reply reactions: hub unavailable")
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// maxCompositeCensusSources is the maximum number of leaf sources of a
+	// composite census.
+	maxCompositeCensusSources = 8
+	// maxCompositeCensusDepth is the maximum depth of the expression tree of
+	// a composite census.
+	maxCompositeCensusDepth = 4
+
+	// CensusOperationUnion includes the participants of any of the sources.
+	CensusOperationUnion = "union"
+	// CensusOperationIntersection includes the participants of all the sources.
+	CensusOperationIntersection = "intersection"
+	// CensusOperationDifference includes the participants of the first source
+	// that are not in any of the other sources.
+	CensusOperationDifference = "difference"
+
+	// CensusSourceCSV is a source built from a CSV of addresses, FIDs or
+	// usernames and weights.
+	CensusSourceCSV = "csv"
+	// CensusSourceChannel is a source built from the followers of a channel.
+	CensusSourceChannel = "channel"
+	// CensusSourceFollowers is a source built from the followers of a user.
+	CensusSourceFollowers = "followers"
+	// CensusSourceNFT is a source built from the holders of NFT tokens.
+	CensusSourceNFT = "nft"
+	// CensusSourceERC20 is a source built from the holders of an ERC20 token.
+	CensusSourceERC20 = "erc20"
+	// CensusSourceElectionVoters is a source built from the voters of a
+	// previous election.
+	CensusSourceElectionVoters = "electionVoters"
+	// CensusSourceElectionCensus is a source built from the census of a
+	// previous election.
+	CensusSourceElectionCensus = "electionCensus"
+
+	// CensusWeightRuleSum sums the weights of the participant in every source.
+	CensusWeightRuleSum = "sum"
+	// CensusWeightRuleMax takes the maximum weight of the participant.
+	CensusWeightRuleMax = "max"
+	// CensusWeightRuleFirst takes the weight of the participant in the first
+	// source that includes it.
+	CensusWeightRuleFirst = "first"
+)
+
+// compositeCensusMember is a Farcaster user included in a composite census
+// source, with all its signers.
+type compositeCensusMember struct {
+	username string
+	weight   *big.Int
+	pubKeys  [][]byte
+}
+
+// compositeCensusSet is the set of members of a composite census source
+// indexed by FID.
+type compositeCensusSet map[uint64]*compositeCensusMember
+
+// censusComposite creates a new census from an expression tree of census
+// sources combined with set operations. Every leaf source is resolved to a set
+// of FIDs through the existing census builders, then the operations are
+// applied and the census is created once from the resulting participants. It
+// builds the census async and returns the census ID.
+func (v *vocdoniHandler) censusComposite(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	req := &CompositeCensusRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ctx.Send([]byte("invalid request body"), http.StatusBadRequest)
+	}
+	switch req.WeightRule {
+	case "":
+		req.WeightRule = CensusWeightRuleSum
+	case CensusWeightRuleSum, CensusWeightRuleMax, CensusWeightRuleFirst:
+	default:
+		return ctx.Send([]byte(fmt.Sprintf("invalid weight rule %s", req.WeightRule)), http.StatusBadRequest)
+	}
+	leaves, err := v.checkCensusSourceNode(ctx.Request.Context(), req.Source, 1)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	if len(leaves) > maxCompositeCensusSources {
+		return ctx.Send([]byte(fmt.Sprintf("too many census sources, maximum allowed is %d", maxCompositeCensusSources)),
+			http.StatusBadRequest)
+	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+
+	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	if err != nil {
+		return err
+	}
+	v.startCensusJob(censusID, userFID)
+	if err := v.db.AddCensus(censusID, userFID); err != nil {
+		return fmt.Errorf("cannot add census to database: %w", err)
+	}
+	go func() {
+		startTime := time.Now()
+		log.Debugw("building composite census", "censusID", censusID, "sources", len(leaves))
+		// resolve every leaf source in its own step, the last step creates
+		// the census
+		totalSteps := len(leaves) + 1
+		resolved := make(map[*CensusSourceNode]compositeCensusSet, len(leaves))
+		for i, leaf := range leaves {
+			var set compositeCensusSet
+			var err error
+			v.trackStepProgress(censusID, i+1, totalSteps, func(progress chan int) {
+				set, err = v.resolveCensusSource(censusID, leaf, progress)
+			})
+			if err != nil {
+				log.Warnw("failed to resolve composite census source", "type", leaf.Type, "err", err.Error())
+				v.storeCensusJob(censusID, CensusInfo{
+					Error: fmt.Sprintf("cannot resolve %s source: %v", leaf.Type, err),
+				})
+				return
+			}
+			resolved[leaf] = set
+		}
+		members := evalCensusSourceNode(req.Source, resolved, req.WeightRule)
+		participants := []*FarcasterParticipant{}
+		for fid, member := range members {
+			if member.weight.Sign() <= 0 {
+				continue
+			}
+			for _, pubKey := range member.pubKeys {
+				participants = append(participants, &FarcasterParticipant{
+					PubKey:   pubKey,
+					Weight:   member.weight,
+					Username: member.username,
+					FID:      fid,
+				})
+			}
+		}
+		var err error
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants", Filtered: filtered})
+			return
+		}
+		applyCensusWeightStrategy(participants, weightStrategy)
+		var ci *CensusInfo
+		v.trackStepProgress(censusID, totalSteps, totalSteps, func(progress chan int) {
+			ci, err = CreateCensus(v.cli, participants, FrameCensusTypeComposite, progress)
+		})
+		if err != nil {
+			log.Errorw(err, "failed to create census")
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		ci.FromTotalAddresses = uint32(len(members))
+		ci.Filtered = filtered
+		ci.WeightStrategy = weightStrategy
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, ci, participants) {
+			return
+		}
+		log.Infow("composite census created",
+			"censusID", censusID.String(),
+			"size", len(ci.Usernames),
+			"duration", time.Since(startTime),
+			"sources", len(leaves))
+	}()
+	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// checkCensusSourceNode validates the expression tree of a composite census
+// from the node provided and returns its leaf sources in evaluation order.
+func (v *vocdoniHandler) checkCensusSourceNode(ctx context.Context, node *CensusSourceNode, depth int) ([]*CensusSourceNode, error) {
+	if node == nil {
+		return nil, fmt.Errorf("census source is required")
+	}
+	if depth > maxCompositeCensusDepth {
+		return nil, fmt.Errorf("census sources too nested, maximum depth is %d", maxCompositeCensusDepth)
+	}
+	if node.Operation != "" {
+		switch node.Operation {
+		case CensusOperationUnion, CensusOperationIntersection, CensusOperationDifference:
+		default:
+			return nil, fmt.Errorf("invalid census operation %s", node.Operation)
+		}
+		if len(node.Sources) < 2 {
+			return nil, fmt.Errorf("census operation %s requires at least two sources", node.Operation)
+		}
+		leaves := []*CensusSourceNode{}
+		for _, source := range node.Sources {
+			sourceLeaves, err := v.checkCensusSourceNode(ctx, source, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			leaves = append(leaves, sourceLeaves...)
+		}
+		return leaves, nil
+	}
+	switch node.Type {
+	case CensusSourceCSV:
+		if len(node.CSV) == 0 {
+			return nil, fmt.Errorf("csv source requires the csv content")
+		}
+	case CensusSourceChannel:
+		if node.Channel == "" {
+			return nil, fmt.Errorf("channel source requires the channel")
+		}
+		exists, err := v.fcapi.ChannelExists(ctx, node.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check channel %s: %w", node.Channel, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("channel %s not found", node.Channel)
+		}
+	case CensusSourceFollowers:
+		if node.UserFID == 0 {
+			return nil, fmt.Errorf("followers source requires the user fid")
+		}
+	case CensusSourceNFT, CensusSourceERC20:
+		if v.tokenHolders == nil {
+			return nil, fmt.Errorf("token holder provider not available")
+		}
+		if node.Type == CensusSourceNFT && (len(node.Tokens) == 0 || len(node.Tokens) > MAXNFTTokens) {
+			return nil, fmt.Errorf("invalid number of NFT tokens, bounds between 1 and %d", MAXNFTTokens)
+		}
+		if node.Type == CensusSourceERC20 && len(node.Tokens) != MAXERC20Tokens {
+			return nil, fmt.Errorf("invalid number of ERC20 tokens, must be %d", MAXERC20Tokens)
+		}
+		if err := v.checkTokens(node.Tokens); err != nil {
+			return nil, err
+		}
+	case CensusSourceElectionVoters:
+		if len(node.ElectionID) == 0 {
+			return nil, fmt.Errorf("%s source requires the election id", node.Type)
+		}
+		if _, err := v.db.Election(node.ElectionID); err != nil {
+			return nil, fmt.Errorf("election %x not found", node.ElectionID)
+		}
+	case CensusSourceElectionCensus:
+		if len(node.ElectionID) == 0 {
+			return nil, fmt.Errorf("%s source requires the election id", node.Type)
+		}
+		if _, err := v.db.CensusFromElection(node.ElectionID); err != nil {
+			return nil, fmt.Errorf("census of election %x not found", node.ElectionID)
+		}
+	default:
+		return nil, fmt.Errorf("invalid census source type %s", node.Type)
+	}
+	return []*CensusSourceNode{node}, nil
+}
+
+// resolveCensusSource resolves a leaf source of a composite census to the set
+// of its members, using the existing census builders.
+func (v *vocdoniHandler) resolveCensusSource(censusID types.HexBytes, node *CensusSourceNode,
+	progress chan int,
+) (compositeCensusSet, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var participants []*FarcasterParticipant
+	switch node.Type {
+	case CensusSourceCSV:
+		var err error
+		participants, _, _, err = v.farcasterCensusFromCSV(strings.NewReader(node.CSV), maxNumOfCsvRecords, progress)
+		if err != nil {
+			return nil, err
+		}
+	case CensusSourceChannel:
+		fids, err := v.fcapi.ChannelFIDs(ctx, node.Channel, nil)
+		if err != nil {
+			return nil, err
+		}
+		participants = v.farcasterCensusFromFids(fids, progress)
+	case CensusSourceFollowers:
+		fids, err := v.userFollowers(node.UserFID)
+		if err != nil {
+			return nil, err
+		}
+		participants = v.farcasterCensusFromFids(fids, progress)
+	case CensusSourceNFT, CensusSourceERC20:
+		holders, err := v.getTokenHolders(node.Tokens, censusID, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		participants, _, err = v.processCensusRecords(holders, progress)
+		if err != nil {
+			return nil, err
+		}
+	case CensusSourceElectionVoters:
+		var err error
+		if participants, err = v.electionVotersParticipants(node.ElectionID); err != nil {
+			return nil, err
+		}
+	case CensusSourceElectionCensus:
+		census, err := v.db.CensusFromElection(node.ElectionID)
+		if err != nil {
+			return nil, err
+		}
+		if participants, err = v.electionCensusParticipants(census); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid census source type %s", node.Type)
+	}
+	return censusSetFromParticipants(participants), nil
+}
+
+// censusSetFromParticipants groups the participants provided by FID. The
+// weight of a user is the maximum weight of its participants: every signer of
+// a user is a participant with the weight of the user, so the weights are not
+// summed, and a user included many times in the source with different weights,
+// e.g. by many addresses of a CSV, gets the same weight whatever the order of
+// the participants is.
+func censusSetFromParticipants(participants []*FarcasterParticipant) compositeCensusSet {
+	set := make(compositeCensusSet)
+	for _, p := range participants {
+		if p.FID == 0 {
+			continue
+		}
+		member, ok := set[p.FID]
+		if !ok {
+			member = &compositeCensusMember{
+				username: p.Username,
+				weight:   new(big.Int).Set(p.Weight),
+			}
+			set[p.FID] = member
+		} else if p.Weight.Cmp(member.weight) > 0 {
+			member.weight.Set(p.Weight)
+		}
+		duplicated := false
+		for _, pubKey := range member.pubKeys {
+			if bytes.Equal(pubKey, p.PubKey) {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			member.pubKeys = append(member.pubKeys, p.PubKey)
+		}
+	}
+	return set
+}
+
+// evalCensusSourceNode evaluates the expression tree from the node provided
+// using the resolved leaf sets, combining the weights of the members included
+// in more than one source with the weight rule.
+func evalCensusSourceNode(node *CensusSourceNode, resolved map[*CensusSourceNode]compositeCensusSet,
+	weightRule string,
+) compositeCensusSet {
+	if node.Operation == "" {
+		return resolved[node]
+	}
+	sets := make([]compositeCensusSet, 0, len(node.Sources))
+	for _, source := range node.Sources {
+		sets = append(sets, evalCensusSourceNode(source, resolved, weightRule))
+	}
+	result := make(compositeCensusSet)
+	switch node.Operation {
+	case CensusOperationUnion, CensusOperationIntersection:
+		// every member of an intersection is in the first set, so there is no
+		// need to iterate over the rest of them
+		candidates := sets
+		if node.Operation == CensusOperationIntersection {
+			candidates = sets[:1]
+		}
+		for _, set := range candidates {
+			for fid := range set {
+				if _, ok := result[fid]; ok {
+					continue
+				}
+				var member *compositeCensusMember
+				weights := []*big.Int{}
+				for _, other := range sets {
+					otherMember, ok := other[fid]
+					if !ok {
+						continue
+					}
+					if member == nil {
+						member = otherMember
+					}
+					weights = append(weights, otherMember.weight)
+				}
+				if node.Operation == CensusOperationIntersection && len(weights) != len(sets) {
+					continue
+				}
+				result[fid] = &compositeCensusMember{
+					username: member.username,
+					weight:   combineCensusWeights(weights, weightRule),
+					pubKeys:  member.pubKeys,
+				}
+			}
+		}
+	case CensusOperationDifference:
+		for fid, member := range sets[0] {
+			excluded := false
+			for _, other := range sets[1:] {
+				if _, ok := other[fid]; ok {
+					excluded = true
+					break
+				}
+			}
+			if !excluded {
+				result[fid] = member
+			}
+		}
+	}
+	return result
+}
+
+// combineCensusWeights combines the weights of a member in different sources,
+// sorted by source order, following the weight rule provided.
+func combineCensusWeights(weights []*big.Int, weightRule string) *big.Int {
+	result := new(big.Int)
+	for i, weight := range weights {
+		switch weightRule {
+		case CensusWeightRuleMax:
+			if i == 0 || weight.Cmp(result) > 0 {
+				result.Set(weight)
+			}
+		case CensusWeightRuleFirst:
+			if i == 0 {
+				result.Set(weight)
+			}
+		default:
+			result.Add(result, weight)
+		}
+	}
+	return result
+}
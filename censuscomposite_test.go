@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// testCensusSet returns a composite census set with the members provided as
+// pairs of FID and weight.
+func testCensusSet(members ...int64) compositeCensusSet {
+	set := make(compositeCensusSet)
+	for i := 0; i < len(members); i += 2 {
+		fid := uint64(members[i])
+		set[fid] = &compositeCensusMember{
+			username: "user" + big.NewInt(members[i]).String(),
+			weight:   big.NewInt(members[i+1]),
+			pubKeys:  [][]byte{{byte(fid)}},
+		}
+	}
+	return set
+}
+
+// censusSetWeights returns the weights of the members of the set provided by
+// FID.
+func censusSetWeights(set compositeCensusSet) map[uint64]string {
+	weights := map[uint64]string{}
+	for fid, member := range set {
+		weights[fid] = member.weight.String()
+	}
+	return weights
+}
+
+func TestCombineCensusWeights(t *testing.T) {
+	c := qt.New(t)
+	weights := []*big.Int{big.NewInt(2), big.NewInt(5), big.NewInt(3)}
+	tests := []struct {
+		rule string
+		want int64
+	}{
+		{rule: CensusWeightRuleSum, want: 10},
+		{rule: "", want: 10},
+		{rule: CensusWeightRuleMax, want: 5},
+		{rule: CensusWeightRuleFirst, want: 2},
+	}
+	for _, test := range tests {
+		c.Run(test.rule, func(c *qt.C) {
+			c.Assert(combineCensusWeights(weights, test.rule).Int64(), qt.Equals, test.want)
+		})
+	}
+	// the weights provided are not modified
+	c.Assert(weights[0].Int64(), qt.Equals, int64(2))
+	c.Assert(combineCensusWeights(nil, CensusWeightRuleMax).Int64(), qt.Equals, int64(0))
+}
+
+func TestCensusSetFromParticipants(t *testing.T) {
+	c := qt.New(t)
+	set := censusSetFromParticipants([]*FarcasterParticipant{
+		{FID: 1, Username: "alice", PubKey: []byte{1}, Weight: big.NewInt(2)},
+		{FID: 1, Username: "alice", PubKey: []byte{2}, Weight: big.NewInt(5)},
+		{FID: 1, Username: "alice", PubKey: []byte{1}, Weight: big.NewInt(3)},
+		{FID: 2, Username: "bob", PubKey: []byte{3}, Weight: big.NewInt(1)},
+		{FID: 0, Username: "unknown", PubKey: []byte{4}, Weight: big.NewInt(1)},
+	})
+	c.Assert(censusSetWeights(set), qt.DeepEquals, map[uint64]string{1: "5", 2: "1"})
+	c.Assert(set[1].pubKeys, qt.DeepEquals, [][]byte{{1}, {2}})
+}
+
+func TestEvalCensusSourceNode(t *testing.T) {
+	c := qt.New(t)
+	a := &CensusSourceNode{Type: CensusSourceCSV}
+	b := &CensusSourceNode{Type: CensusSourceChannel}
+	d := &CensusSourceNode{Type: CensusSourceFollowers}
+	resolved := map[*CensusSourceNode]compositeCensusSet{
+		a: testCensusSet(1, 2, 2, 3, 3, 4),
+		b: testCensusSet(2, 5, 3, 1, 4, 7),
+		d: testCensusSet(3, 10),
+	}
+	tests := []struct {
+		name string
+		node *CensusSourceNode
+		rule string
+		want map[uint64]string
+	}{
+		{
+			name: "leaf",
+			node: a,
+			want: map[uint64]string{1: "2", 2: "3", 3: "4"},
+		},
+		{
+			name: "union sum",
+			node: &CensusSourceNode{Operation: CensusOperationUnion, Sources: []*CensusSourceNode{a, b}},
+			rule: CensusWeightRuleSum,
+			want: map[uint64]string{1: "2", 2: "8", 3: "5", 4: "7"},
+		},
+		{
+			name: "union max",
+			node: &CensusSourceNode{Operation: CensusOperationUnion, Sources: []*CensusSourceNode{a, b}},
+			rule: CensusWeightRuleMax,
+			want: map[uint64]string{1: "2", 2: "5", 3: "4", 4: "7"},
+		},
+		{
+			name: "intersection first",
+			node: &CensusSourceNode{Operation: CensusOperationIntersection, Sources: []*CensusSourceNode{b, a}},
+			rule: CensusWeightRuleFirst,
+			want: map[uint64]string{2: "5", 3: "1"},
+		},
+		{
+			name: "difference",
+			node: &CensusSourceNode{Operation: CensusOperationDifference, Sources: []*CensusSourceNode{a, b}},
+			want: map[uint64]string{1: "2"},
+		},
+		{
+			name: "difference of many",
+			node: &CensusSourceNode{Operation: CensusOperationDifference, Sources: []*CensusSourceNode{b, a, d}},
+			want: map[uint64]string{4: "7"},
+		},
+		{
+			name: "nested",
+			node: &CensusSourceNode{
+				Operation: CensusOperationIntersection,
+				Sources: []*CensusSourceNode{
+					{Operation: CensusOperationUnion, Sources: []*CensusSourceNode{a, b}},
+					d,
+				},
+			},
+			rule: CensusWeightRuleSum,
+			want: map[uint64]string{3: "15"},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(censusSetWeights(evalCensusSourceNode(test.node, resolved, test.rule)),
+				qt.DeepEquals, test.want)
+		})
+	}
+	// the resolved sets are not modified by the evaluation
+	c.Assert(censusSetWeights(resolved[a]), qt.DeepEquals, map[uint64]string{1: "2", 2: "3", 3: "4"})
+}
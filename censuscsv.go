@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// csvAddressesBatchSize is the number of addresses of a CSV census
+	// resolved to Farcaster users in a single database query.
+	csvAddressesBatchSize = 1000
+	// maxCSVFailures is the maximum number of invalid records reported for a
+	// CSV census, the parsing is aborted if there are more.
+	maxCSVFailures = 1000
+	// maxCSVUploadSize is the maximum total size in bytes of a CSV census
+	// uploaded in chunks.
+	maxCSVUploadSize = 64 << 20
+	// maxCSVUploadChunkSize is the maximum size in bytes of every chunk of a
+	// CSV census upload. Every chunk is stored as a single document, so it
+	// must be well below the 16MB limit of the MongoDB documents.
+	maxCSVUploadChunkSize = 8 << 20
+	// csvUploadTTL is the time after which an upload that is not updated is
+	// removed from the database.
+	csvUploadTTL = time.Hour
+	// csvUploadFormField is the name of the form field that contains the CSV
+	// file in multipart requests.
+	csvUploadFormField = "file"
+)
+
+// csvCensusLimit is the maximum number of records of a CSV census for the
+// users with at least the given reputation.
+type csvCensusLimit struct {
+	Reputation uint32
+	MaxRecords int
+}
+
+// csvCensusLimits are the limits of the number of records of a CSV census by
+// reputation tier, sorted by reputation.
+var csvCensusLimits = []csvCensusLimit{{Reputation: 0, MaxRecords: maxNumOfCsvRecords}}
+
+// parseCSVCensusLimits parses the limits of the number of records of a CSV
+// census by reputation tier from a comma separated list of
+// <reputation>:<maxRecords> pairs, for example "0:10000,15:50000".
+func parseCSVCensusLimits(value string) ([]csvCensusLimit, error) {
+	limits := []csvCensusLimit{}
+	for _, tier := range strings.Split(value, ",") {
+		tier = strings.TrimSpace(tier)
+		if tier == "" {
+			continue
+		}
+		reputation, maxRecords, ok := strings.Cut(tier, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid csv census limit %s", tier)
+		}
+		rep, err := strconv.ParseUint(reputation, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reputation in csv census limit %s", tier)
+		}
+		max, err := strconv.Atoi(maxRecords)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid number of records in csv census limit %s", tier)
+		}
+		limits = append(limits, csvCensusLimit{Reputation: uint32(rep), MaxRecords: max})
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("no csv census limits provided")
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Reputation < limits[j].Reputation
+	})
+	return limits, nil
+}
+
+// csvRecordsLimit returns the maximum number of records of a CSV census for
+// a user with the reputation provided. It is the limit of the highest tier
+// reached by the reputation or the default limit if no tier is reached.
+func csvRecordsLimit(reputation uint32) int {
+	limit := maxNumOfCsvRecords
+	for _, tier := range csvCensusLimits {
+		if reputation >= tier.Reputation {
+			limit = tier.MaxRecords
+		}
+	}
+	return limit
+}
+
+// userCSVRecordsLimit returns the maximum number of records of a CSV census
+// created by the user provided, based on its reputation. The users without
+// access profile have no reputation.
+func (v *vocdoniHandler) userCSVRecordsLimit(userFID uint64) int {
+	reputation := uint32(0)
+	if profile, err := v.db.UserAccessProfile(userFID); err == nil {
+		reputation = profile.Reputation
+	}
+	return csvRecordsLimit(reputation)
+}
+
+// streamCSV parses a CSV census from the reader provided record by record,
+// without loading it in memory. It supports the weight-balance format, with an
+// address, FID or username and an optional weight in every record, and the
+// POAP export format. The function provided is called for every valid record,
+// normalized to the identity and the weight, with its line in the CSV. The
+// records that cannot be parsed, or that the function rejects returning an
+// error, are returned as failures. It fails if the CSV is empty, it has more
+// records than the maximum provided or too many invalid records.
+func streamCSV(reader io.Reader, maxRecords int, fn func(line int, record []string) error) ([]*CensusRecordFailure, error) {
+	br := bufio.NewReader(reader)
+	head, _ := br.Peek(len(POAP_CSV_HEADER) + 1024)
+	if len(bytes.TrimSpace(head)) == 0 {
+		return nil, fmt.Errorf("empty csv")
+	}
+	firstLine, _, _ := strings.Cut(string(head), "\n")
+	poap := strings.Contains(firstLine, POAP_CSV_HEADER)
+	csvType := "Weight-Balance"
+	if poap {
+		csvType = "POAP"
+	}
+	log.Infow("parsing csv", "type", csvType, "maxRecords", maxRecords)
+
+	r := csv.NewReader(br)
+	r.Comment = '#'
+	r.TrimLeadingSpace = true // trim leading space of each field
+	r.FieldsPerRecord = -1    // the number of fields is checked for each format
+	r.ReuseRecord = true
+	failures := []*CensusRecordFailure{}
+	addFailure := func(line int, record []string, err error) error {
+		failures = append(failures, &CensusRecordFailure{
+			Row:    line,
+			Record: strings.Join(record, ","),
+			Error:  err.Error(),
+		})
+		if len(failures) > maxCSVFailures {
+			return fmt.Errorf("too many invalid records, maximum allowed is %d", maxCSVFailures)
+		}
+		return nil
+	}
+	count := 0
+	skipHeader := poap
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return failures, err
+			}
+			if err := addFailure(parseErr.StartLine, nil, parseErr.Err); err != nil {
+				return failures, err
+			}
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		if skipHeader {
+			skipHeader = false
+			continue
+		}
+		if poap {
+			if len(record) != 6 {
+				if err := addFailure(line, record, fmt.Errorf("invalid number of fields")); err != nil {
+					return failures, err
+				}
+				continue
+			}
+			record = []string{record[1], "1"}
+		} else {
+			switch len(record) {
+			case 1:
+				record = []string{record[0], ""}
+			case 2:
+				record = []string{record[0], record[1]}
+			default:
+				if err := addFailure(line, record, fmt.Errorf("invalid number of fields")); err != nil {
+					return failures, err
+				}
+				continue
+			}
+		}
+		count++
+		if count > maxRecords {
+			return failures, fmt.Errorf("max number of records exceeded, maximum allowed is %d", maxRecords)
+		}
+		if err := fn(line, record); err != nil {
+			if err := addFailure(line, record, err); err != nil {
+				return failures, err
+			}
+		}
+	}
+	return failures, nil
+}
+
+// csvFromRequest returns a reader of the CSV of the request. If the request is
+// a multipart form, the CSV is read straight from its file field, otherwise it
+// is the request body.
+func csvFromRequest(msg *apirest.APIdata, ctx *httprouter.HTTPContext) (io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return bytes.NewReader(msg.Data), nil
+	}
+	mr := multipart.NewReader(bytes.NewReader(msg.Data), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing %s field in multipart form", csvUploadFormField)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		if part.FormName() == csvUploadFormField {
+			return part, nil
+		}
+	}
+}
+
+// censusCSVUpload stores a chunk of a CSV census in the database, so the
+// upload survives a restart of the service and can be continued in any
+// replica. Every chunk can have up to maxCSVUploadChunkSize bytes and the
+// whole upload up to maxCSVUploadSize bytes. The first chunk creates the
+// upload and the next ones must include its ID in the uploadId query
+// parameter. The chunks can be sent as the request body or as the file field
+// of a multipart form. It returns the upload ID and the size uploaded so far.
+// Once all the chunks are uploaded, the census is built with
+// censusCSVFromUpload.
+func (v *vocdoniHandler) censusCSVUpload(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	reader, err := csvFromRequest(msg, ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	chunk, err := io.ReadAll(io.LimitReader(reader, maxCSVUploadChunkSize+1))
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	if len(chunk) > maxCSVUploadChunkSize {
+		return ctx.Send([]byte(fmt.Sprintf("csv chunk too large, maximum allowed is %d bytes", maxCSVUploadChunkSize)),
+			http.StatusRequestEntityTooLarge)
+	}
+
+	uploadID := ctx.Request.URL.Query().Get("uploadId")
+	if uploadID == "" {
+		uploadID = uuid.New().String()
+		if err := v.db.CreateCSVUpload(uploadID, userFID, csvUploadTTL); err != nil {
+			return err
+		}
+	}
+	size, err := v.db.AppendCSVUploadChunk(uploadID, userFID, chunk, maxCSVUploadSize, csvUploadTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrCSVUploadUnknown):
+			return ctx.Send([]byte("upload not found"), http.StatusNotFound)
+		case errors.Is(err, mongo.ErrCSVUploadTooLarge):
+			return ctx.Send([]byte(fmt.Sprintf("csv too large, maximum allowed is %d bytes", maxCSVUploadSize)),
+				http.StatusRequestEntityTooLarge)
+		}
+		return err
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"uploadId": uploadID,
+		"size":     size,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// censusCSVFromUpload creates a new census from a CSV uploaded in chunks with
+// censusCSVUpload. The chunks are streamed from the database to the CSV parser
+// and the upload is removed once the census is built. It accepts the same
+// query parameters as censusCSV and returns the census ID.
+func (v *vocdoniHandler) censusCSVFromUpload(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	reader, size, err := v.db.TakeCSVUpload(ctx.URLParam("uploadId"), userFID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrCSVUploadUnknown) {
+			return ctx.Send([]byte("upload not found"), http.StatusNotFound)
+		}
+		return err
+	}
+	if size == 0 {
+		if err := reader.Close(); err != nil {
+			log.Warnw("cannot remove csv upload", "error", err)
+		}
+		return ctx.Send([]byte("empty csv"), http.StatusBadRequest)
+	}
+	return v.censusFromCSVReader(msg, ctx, reader)
+}
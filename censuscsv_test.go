@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+)
+
+// csvRecord is a record of a CSV census as provided by streamCSV.
+type csvRecord struct {
+	Line   int
+	Record string
+}
+
+// parseTestCSV parses the CSV provided with streamCSV, rejecting the records
+// whose identity is "reject", and returns the records accepted.
+func parseTestCSV(csv string, maxRecords int) ([]csvRecord, []*CensusRecordFailure, error) {
+	records := []csvRecord{}
+	failures, err := streamCSV(strings.NewReader(csv), maxRecords, func(line int, record []string) error {
+		if record[0] == "reject" {
+			return fmt.Errorf("rejected")
+		}
+		records = append(records, csvRecord{Line: line, Record: strings.Join(record, ",")})
+		return nil
+	})
+	return records, failures, err
+}
+
+func TestStreamCSV(t *testing.T) {
+	c := qt.New(t)
+
+	// weight-balance records, with an optional weight, and comments
+	records, failures, err := parseTestCSV("# comment\n0xa11ce,10\nbob\n  3,2\n", 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(failures, qt.HasLen, 0)
+	c.Assert(records, qt.DeepEquals, []csvRecord{
+		{Line: 2, Record: "0xa11ce,10"},
+		{Line: 3, Record: "bob,"},
+		{Line: 4, Record: "3,2"},
+	})
+
+	// POAP export, the header is skipped and every record has weight 1
+	poap := POAP_CSV_HEADER + "\n" +
+		"1,0xa11ce,alice.eth,2024-01-01,3,10\n" +
+		"2,0xb0b\n"
+	records, failures, err = parseTestCSV(poap, 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(records, qt.DeepEquals, []csvRecord{{Line: 2, Record: "0xa11ce,1"}})
+	c.Assert(failures, qt.HasLen, 1)
+	c.Assert(failures[0].Row, qt.Equals, 3)
+	c.Assert(failures[0].Error, qt.Equals, "invalid number of fields")
+
+	// invalid and rejected records are reported as failures
+	records, failures, err = parseTestCSV("alice,1\na,b,c\nreject,2\n", 10)
+	c.Assert(err, qt.IsNil)
+	c.Assert(records, qt.HasLen, 1)
+	c.Assert(failures, qt.DeepEquals, []*CensusRecordFailure{
+		{Row: 2, Record: "a,b,c", Error: "invalid number of fields"},
+		{Row: 3, Record: "reject,2", Error: "rejected"},
+	})
+
+	// empty csv
+	_, _, err = parseTestCSV(" \n\n", 10)
+	c.Assert(err, qt.ErrorMatches, "empty csv")
+
+	// too many records
+	_, _, err = parseTestCSV("a\nb\nc\n", 2)
+	c.Assert(err, qt.ErrorMatches, "max number of records exceeded.*")
+
+	// too many invalid records
+	_, failures, err = parseTestCSV(strings.Repeat("a,b,c\n", maxCSVFailures+1), maxCSVFailures+10)
+	c.Assert(err, qt.ErrorMatches, "too many invalid records.*")
+	c.Assert(failures, qt.HasLen, maxCSVFailures+1)
+}
+
+func TestParseCSVCensusLimits(t *testing.T) {
+	c := qt.New(t)
+
+	limits, err := parseCSVCensusLimits(" 15:50000, 0:10000,,50:100000")
+	c.Assert(err, qt.IsNil)
+	c.Assert(limits, qt.DeepEquals, []csvCensusLimit{
+		{Reputation: 0, MaxRecords: 10000},
+		{Reputation: 15, MaxRecords: 50000},
+		{Reputation: 50, MaxRecords: 100000},
+	})
+
+	for _, value := range []string{"", "10", "a:10", "10:a", "10:0", "-1:10"} {
+		c.Run(value, func(c *qt.C) {
+			_, err := parseCSVCensusLimits(value)
+			c.Assert(err, qt.IsNotNil)
+		})
+	}
+}
+
+func TestCSVRecordsLimit(t *testing.T) {
+	c := qt.New(t)
+
+	defaultLimits := csvCensusLimits
+	defer func() { csvCensusLimits = defaultLimits }()
+	csvCensusLimits = []csvCensusLimit{
+		{Reputation: 10, MaxRecords: 100},
+		{Reputation: 20, MaxRecords: 200},
+	}
+	c.Assert(csvRecordsLimit(0), qt.Equals, maxNumOfCsvRecords)
+	c.Assert(csvRecordsLimit(10), qt.Equals, 100)
+	c.Assert(csvRecordsLimit(19), qt.Equals, 100)
+	c.Assert(csvRecordsLimit(30), qt.Equals, 200)
+}
+
+func TestCSVFromRequest(t *testing.T) {
+	c := qt.New(t)
+
+	// the request body is the csv
+	req := httptest.NewRequest("POST", "/census/csv", nil)
+	reader, err := csvFromRequest(&apirest.APIdata{Data: []byte("alice,1\n")}, &httprouter.HTTPContext{Request: req})
+	c.Assert(err, qt.IsNil)
+	data, err := io.ReadAll(reader)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "alice,1\n")
+
+	// the csv is the file field of the multipart form
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	c.Assert(mw.WriteField("other", "value"), qt.IsNil)
+	fw, err := mw.CreateFormFile(csvUploadFormField, "census.csv")
+	c.Assert(err, qt.IsNil)
+	_, err = fw.Write([]byte("bob,2\n"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(mw.Close(), qt.IsNil)
+	req = httptest.NewRequest("POST", "/census/csv", nil)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	reader, err = csvFromRequest(&apirest.APIdata{Data: body.Bytes()}, &httprouter.HTTPContext{Request: req})
+	c.Assert(err, qt.IsNil)
+	identities := []string{}
+	failures, err := streamCSV(reader, 10, func(_ int, record []string) error {
+		identities = append(identities, record[0])
+		return nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(failures, qt.HasLen, 0)
+	c.Assert(identities, qt.DeepEquals, []string{"bob"})
+
+	// the multipart form must include the file field
+	body.Reset()
+	mw = multipart.NewWriter(body)
+	c.Assert(mw.WriteField("other", "value"), qt.IsNil)
+	c.Assert(mw.Close(), qt.IsNil)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	_, err = csvFromRequest(&apirest.APIdata{Data: body.Bytes()}, &httprouter.HTTPContext{Request: req})
+	c.Assert(err, qt.ErrorMatches, "missing file field in multipart form")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/state"
+)
+
+const (
+	// censusProofsWindow is the time window of the limits of the census
+	// proofs requested.
+	censusProofsWindow = time.Minute
+	// censusProofsPerClient is the maximum number of census proofs requested
+	// by a client in every window.
+	censusProofsPerClient = 10
+	// censusProofsTotal is the maximum number of census proofs requested by
+	// all the clients in every window.
+	censusProofsTotal = 120
+)
+
+// CensusExportParticipant is a participant of an exported census.
+type CensusExportParticipant struct {
+	Username string `json:"username"`
+	FID      uint64 `json:"fid"`
+	Weight   string `json:"weight"`
+}
+
+// CensusEligibility is the response of the eligibility lookup of a user in the
+// census of an election. The proof is only included if it is requested and
+// the user is eligible.
+type CensusEligibility struct {
+	FID      uint64                  `json:"fid"`
+	Username string                  `json:"username,omitempty"`
+	Eligible bool                    `json:"eligible"`
+	Weight   string                  `json:"weight,omitempty"`
+	Root     string                  `json:"root,omitempty"`
+	Proof    *CensusEligibilityProof `json:"proof,omitempty"`
+}
+
+// CensusEligibilityProof is the Merkle proof of a signer of a user in the
+// census tree, which allows to verify the eligibility independently.
+type CensusEligibilityProof struct {
+	Signer    types.HexBytes `json:"signer"`
+	Key       types.HexBytes `json:"key"`
+	Proof     types.HexBytes `json:"proof"`
+	LeafValue types.HexBytes `json:"leafValue"`
+	Siblings  []string       `json:"siblings,omitempty"`
+	Weight    string         `json:"weight"`
+}
+
+// censusExport returns the participants of the census of an election with
+// their username, FID and weight, sorted by username. Only the creator of the
+// election or the admins of its community can export the census. The format
+// is JSON by default, or CSV if the format query parameter is csv.
+func (v *vocdoniHandler) censusExport(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid electionID"), http.StatusBadRequest)
+	}
+	format := ctx.Request.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		return ctx.Send([]byte(fmt.Sprintf("invalid format %s", format)), http.StatusBadRequest)
+	}
+	election, err := v.db.Election(electionID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrElectionUnknown) {
+			return ctx.Send([]byte("election not found"), http.StatusNotFound)
+		}
+		return fmt.Errorf("failed to get election: %w", err)
+	}
+	isCommunityAdmin := election.Community != nil && election.Community.ID != 0 &&
+		v.db.IsCommunityAdmin(userFID, election.Community.ID)
+	if election.UserID != userFID && !isCommunityAdmin {
+		return ctx.Send([]byte("only the creator of the poll or the community admins can export the census"),
+			http.StatusForbidden)
+	}
+	census, err := v.db.CensusFromElection(electionID)
+	if err != nil {
+		return ctx.Send([]byte("census not found"), http.StatusNotFound)
+	}
+
+	usernames := make([]string, 0, len(census.Participants))
+	for username := range census.Participants {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	users, err := v.db.UsersByUsernames(usernames)
+	if err != nil {
+		return fmt.Errorf("cannot get census users: %w", err)
+	}
+	participants := make([]*CensusExportParticipant, 0, len(usernames))
+	for _, username := range usernames {
+		participant := &CensusExportParticipant{
+			Username: username,
+			Weight:   census.Participants[username],
+		}
+		if user, ok := users[username]; ok {
+			participant.FID = user.UserID
+		}
+		participants = append(participants, participant)
+	}
+
+	if format == "csv" {
+		buf := bytes.Buffer{}
+		w := csv.NewWriter(&buf)
+		if err := w.Write([]string{"username", "fid", "weight"}); err != nil {
+			return err
+		}
+		for _, p := range participants {
+			if err := w.Write([]string{p.Username, strconv.FormatUint(p.FID, 10), p.Weight}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		ctx.SetResponseContentType("text/csv; charset=utf-8")
+		return ctx.Send(buf.Bytes(), http.StatusOK)
+	}
+	data, err := json.Marshal(map[string]any{
+		"censusId":     census.CensusID,
+		"root":         census.Root,
+		"totalWeight":  census.TotalWeight,
+		"participants": participants,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// censusEligibility returns if the user with the given FID is included in the
+// census of an election and its weight. The eligibility is answered from the
+// participants of the census stored in the database, by FID, so it does not
+// depend on the username of the user, which can change after the census is
+// created. The censuses created before the participants were stored by FID
+// are looked up by the current username of the user. If the proof query
+// parameter is true, it also includes the Merkle proof of the first signer of
+// the user found in the census tree, so the eligibility can be verified
+// independently. The proofs are requested to the Vocdoni API, so they are
+// rate limited by client.
+func (v *vocdoniHandler) censusEligibility(_ *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid electionID"), http.StatusBadRequest)
+	}
+	fid, err := strconv.ParseUint(ctx.URLParam("fid"), 10, 64)
+	if err != nil {
+		return ctx.Send([]byte("invalid fid"), http.StatusBadRequest)
+	}
+	withProof := false
+	if value := ctx.Request.URL.Query().Get("proof"); value != "" {
+		if withProof, err = strconv.ParseBool(value); err != nil {
+			return ctx.Send([]byte("invalid proof parameter"), http.StatusBadRequest)
+		}
+	}
+	census, err := v.db.CensusFromElection(electionID)
+	if err != nil {
+		return ctx.Send([]byte("census not found"), http.StatusNotFound)
+	}
+
+	eligibility := &CensusEligibility{FID: fid, Root: census.Root}
+	user, err := v.db.User(fid)
+	if err == nil {
+		eligibility.Username = user.Username
+	}
+	eligibility.Weight, eligibility.Eligible = censusParticipantWeight(census, fid, eligibility.Username)
+	if withProof && eligibility.Eligible && user != nil {
+		if !v.censusProofs.allow(clientIP(ctx.Request)) {
+			return ctx.Send([]byte("too many proof requests, try again later"), http.StatusTooManyRequests)
+		}
+		proof, err := v.censusEligibilityProof(census.Root, user)
+		if err != nil {
+			log.Warnw("cannot generate census proof", "electionID", fmt.Sprintf("%x", electionID),
+				"fid", fid, "error", err)
+		} else {
+			eligibility.Proof = proof
+		}
+	}
+	data, err := json.Marshal(eligibility)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// censusParticipantWeight returns the weight of the user with the given FID
+// and username in the census provided and if the user is included in it. The
+// user is looked up by FID, or by username if the census does not have the
+// participants by FID.
+func censusParticipantWeight(census *mongo.Census, fid uint64, username string) (string, bool) {
+	if census.ParticipantFIDs != nil {
+		weight, ok := census.ParticipantFIDs[strconv.FormatUint(fid, 10)]
+		return weight, ok
+	}
+	if username == "" {
+		return "", false
+	}
+	weight, ok := census.Participants[username]
+	return weight, ok
+}
+
+// censusEligibilityProof returns the Merkle proof of the first signer of the
+// user provided found in the census tree with the given root.
+func (v *vocdoniHandler) censusEligibilityProof(root string, user *mongo.User) (*CensusEligibilityProof, error) {
+	rootBytes, err := hex.DecodeString(strings.TrimPrefix(root, "0x"))
+	if err != nil || len(rootBytes) == 0 {
+		return nil, fmt.Errorf("invalid census root %s", root)
+	}
+	for _, signer := range user.ActiveSigners() {
+		pubKey, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
+		if err != nil {
+			continue
+		}
+		voterID := state.NewVoterID(state.VoterIDTypeEd25519, pubKey)
+		proof, err := v.cli.CensusGenProof(rootBytes, voterID.Address())
+		if err != nil {
+			continue
+		}
+		return &CensusEligibilityProof{
+			Signer:    pubKey,
+			Key:       voterID.Address(),
+			Proof:     proof.Proof,
+			LeafValue: proof.LeafValue,
+			Siblings:  proof.Siblings,
+			Weight:    proof.LeafWeight.String(),
+		}, nil
+	}
+	return nil, fmt.Errorf("no signer of the user found in the census")
+}
+
+// censusProofLimiter limits the census proofs requested to the Vocdoni API by
+// every client, and by all of them, to a maximum number of proofs in every
+// time window.
+type censusProofLimiter struct {
+	mtx         sync.Mutex
+	window      time.Duration
+	perClient   int
+	total       int
+	windowStart time.Time
+	requests    map[string]int
+	count       int
+}
+
+func newCensusProofLimiter(window time.Duration, perClient, total int) *censusProofLimiter {
+	return &censusProofLimiter{
+		window:    window,
+		perClient: perClient,
+		total:     total,
+		requests:  make(map[string]int),
+	}
+}
+
+// allow registers a new proof request of the given client. It returns false
+// if the client, or all the clients together, reached the limit of the
+// current window.
+func (l *censusProofLimiter) allow(client string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if now := time.Now(); now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.requests = make(map[string]int)
+		l.count = 0
+	}
+	if l.count >= l.total || l.requests[client] >= l.perClient {
+		return false
+	}
+	l.count++
+	l.requests[client]++
+	return true
+}
+
+// clientIP returns the IP address of the client of the request provided. The
+// address of the X-Real-IP header, set by the reverse proxy, is preferred over
+// the remote address of the connection.
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/mongo"
+)
+
+func TestCensusParticipantWeight(t *testing.T) {
+	c := qt.New(t)
+	census := &mongo.Census{
+		Participants:    map[string]string{"alice": "3", "bob": "1"},
+		ParticipantFIDs: map[string]string{"1": "3", "2": "1"},
+	}
+	legacy := &mongo.Census{Participants: map[string]string{"alice": "3", "bob": "1"}}
+	tests := []struct {
+		name     string
+		census   *mongo.Census
+		fid      uint64
+		username string
+		weight   string
+		eligible bool
+	}{
+		{name: "by fid", census: census, fid: 1, username: "alice", weight: "3", eligible: true},
+		{name: "renamed user", census: census, fid: 2, username: "bob2", weight: "1", eligible: true},
+		{name: "username of other user", census: census, fid: 3, username: "alice"},
+		{name: "unknown user", census: census, fid: 3},
+		{name: "legacy by username", census: legacy, fid: 1, username: "alice", weight: "3", eligible: true},
+		{name: "legacy renamed user", census: legacy, fid: 2, username: "bob2"},
+		{name: "legacy without username", census: legacy, fid: 2},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			weight, eligible := censusParticipantWeight(test.census, test.fid, test.username)
+			c.Assert(eligible, qt.Equals, test.eligible)
+			c.Assert(weight, qt.Equals, test.weight)
+		})
+	}
+}
+
+func TestCensusProofLimiter(t *testing.T) {
+	c := qt.New(t)
+	limiter := newCensusProofLimiter(time.Hour, 2, 3)
+	c.Assert(limiter.allow("a"), qt.IsTrue)
+	c.Assert(limiter.allow("a"), qt.IsTrue)
+	// the client limit is reached
+	c.Assert(limiter.allow("a"), qt.IsFalse)
+	c.Assert(limiter.allow("b"), qt.IsTrue)
+	// the total limit is reached
+	c.Assert(limiter.allow("c"), qt.IsFalse)
+
+	// the limits are restored in the next window
+	limiter.windowStart = time.Now().Add(-time.Hour)
+	c.Assert(limiter.allow("a"), qt.IsTrue)
+	c.Assert(limiter.allow("c"), qt.IsTrue)
+}
+
+func TestClientIP(t *testing.T) {
+	c := qt.New(t)
+	r := httptest.NewRequest("GET", "/census/00/eligible/1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	c.Assert(clientIP(r), qt.Equals, "10.0.0.1")
+	r.Header.Set("X-Real-IP", "192.0.2.1")
+	c.Assert(clientIP(r), qt.Equals, "192.0.2.1")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// CensusFilterReasonDenylist is the reason of the participants dropped
+	// because they are in the denylist.
+	CensusFilterReasonDenylist = "denylist"
+	// CensusFilterReasonAccountAge is the reason of the participants dropped
+	// because their account is too new or its registration is unknown.
+	CensusFilterReasonAccountAge = "accountAge"
+	// CensusFilterReasonFollowers is the reason of the participants dropped
+	// because they do not have enough followers.
+	CensusFilterReasonFollowers = "followers"
+	// CensusFilterReasonReputation is the reason of the participants dropped
+	// because they do not have enough reputation.
+	CensusFilterReasonReputation = "reputation"
+	// CensusFilterReasonVerifiedAddress is the reason of the participants
+	// dropped because they have no verified address.
+	CensusFilterReasonVerifiedAddress = "verifiedAddress"
+
+	// maxCensusDenylistSize is the maximum number of entries of the denylist
+	// of the census filters.
+	maxCensusDenylistSize = 1000
+	// maxCensusMinAccountAgeDays is the maximum account age, in days, that
+	// can be required by the census filters.
+	maxCensusMinAccountAgeDays = 10 * 365
+)
+
+// CensusFilters defines the sybil-resistance filters applied to the
+// participants of a census. The zero value does not filter any participant.
+type CensusFilters struct {
+	// MinAccountAge drops the accounts registered in the Farcaster
+	// IdRegistry more recently. The accounts whose registration time has not
+	// been synced yet are dropped too.
+	MinAccountAge time.Duration
+	// MinFollowers drops the accounts with less followers.
+	MinFollowers uint64
+	// MinReputation drops the accounts with less reputation. The accounts
+	// without access profile have no reputation.
+	MinReputation uint32
+	// VerifiedAddress drops the accounts without any verified address.
+	VerifiedAddress bool
+	// DenyFIDs and DenyUsernames drop the accounts listed.
+	DenyFIDs      map[uint64]bool
+	DenyUsernames map[string]bool
+}
+
+// CensusFilterReport contains the number of unique users dropped by the census
+// filters, in total and by reason.
+type CensusFilterReport struct {
+	Total   int            `json:"total"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// Empty returns true if the filters do not drop any participant.
+func (f *CensusFilters) Empty() bool {
+	return f == nil || (f.MinAccountAge == 0 && f.MinFollowers == 0 && f.MinReputation == 0 &&
+		!f.VerifiedAddress && len(f.DenyFIDs) == 0 && len(f.DenyUsernames) == 0)
+}
+
+// censusFiltersFromQuery parses the census filters from the query parameters
+// of the request, so every census builder accepts them in the same way:
+//
+//	?minAccountAge=<days>&minFollowers=<n>&minReputation=<n>&verifiedAddress=true&denylist=<fid>,@<username>
+//
+// It returns nil if no filter is set.
+func censusFiltersFromQuery(ctx *httprouter.HTTPContext) (*CensusFilters, error) {
+	return parseCensusFilters(ctx.Request.URL.Query())
+}
+
+// parseCensusFilters parses the census filters from the values provided.
+func parseCensusFilters(query url.Values) (*CensusFilters, error) {
+	filters := &CensusFilters{
+		DenyFIDs:      make(map[uint64]bool),
+		DenyUsernames: make(map[string]bool),
+	}
+	var err error
+	if value := query.Get("minAccountAge"); value != "" {
+		days, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || days > maxCensusMinAccountAgeDays {
+			return nil, fmt.Errorf("invalid minAccountAge %s", value)
+		}
+		filters.MinAccountAge = time.Duration(days) * 24 * time.Hour
+	}
+	if value := query.Get("minFollowers"); value != "" {
+		if filters.MinFollowers, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid minFollowers %s", value)
+		}
+	}
+	if value := query.Get("minReputation"); value != "" {
+		reputation, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minReputation %s", value)
+		}
+		filters.MinReputation = uint32(reputation)
+	}
+	if value := query.Get("verifiedAddress"); value != "" {
+		if filters.VerifiedAddress, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid verifiedAddress %s", value)
+		}
+	}
+	if value := query.Get("denylist"); value != "" {
+		entries := strings.Split(value, ",")
+		if len(entries) > maxCensusDenylistSize {
+			return nil, fmt.Errorf("denylist too large, maximum allowed is %d", maxCensusDenylistSize)
+		}
+		for _, entry := range entries {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if username, ok := strings.CutPrefix(entry, "@"); ok {
+				filters.DenyUsernames[strings.ToLower(username)] = true
+				continue
+			}
+			fid, err := strconv.ParseUint(entry, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid denylist entry %s", entry)
+			}
+			filters.DenyFIDs[fid] = true
+		}
+	}
+	if filters.Empty() {
+		return nil, nil
+	}
+	return filters, nil
+}
+
+// filterCensusParticipants drops the participants that do not pass the census
+// filters and returns the remaining ones with the report of the users dropped.
+// Every user is counted once, with the first reason that drops it, even if it
+// has many signers. If the filters are empty, the participants are returned
+// as they are and the report is nil.
+func (v *vocdoniHandler) filterCensusParticipants(participants []*FarcasterParticipant,
+	filters *CensusFilters,
+) ([]*FarcasterParticipant, *CensusFilterReport, error) {
+	if filters.Empty() {
+		return participants, nil, nil
+	}
+	seen := make(map[uint64]bool)
+	fids := []uint64{}
+	for _, p := range participants {
+		if !seen[p.FID] {
+			seen[p.FID] = true
+			fids = append(fids, p.FID)
+		}
+	}
+	// get the data required by the filters from the database in bulk
+	var err error
+	var users map[uint64]*mongo.User
+	if filters.MinAccountAge > 0 || filters.MinFollowers > 0 || filters.VerifiedAddress {
+		if users, err = v.db.UsersByFIDs(fids); err != nil {
+			return nil, nil, fmt.Errorf("cannot get users to filter the census: %w", err)
+		}
+	}
+	var reputations map[uint64]uint32
+	if filters.MinReputation > 0 {
+		if reputations, err = v.db.ReputationsByFIDs(fids); err != nil {
+			return nil, nil, fmt.Errorf("cannot get reputations to filter the census: %w", err)
+		}
+	}
+	// decide which users are dropped and why
+	now := time.Now()
+	dropped := make(map[uint64]string)
+	for _, p := range participants {
+		if _, ok := dropped[p.FID]; ok {
+			continue
+		}
+		if reason := filters.reason(p, users[p.FID], reputations[p.FID], now); reason != "" {
+			dropped[p.FID] = reason
+		}
+	}
+	report := &CensusFilterReport{Reasons: make(map[string]int)}
+	for _, reason := range dropped {
+		report.Total++
+		report.Reasons[reason]++
+	}
+	result := make([]*FarcasterParticipant, 0, len(participants))
+	for _, p := range participants {
+		if _, ok := dropped[p.FID]; !ok {
+			result = append(result, p)
+		}
+	}
+	log.Debugw("census participants filtered",
+		"users", len(fids),
+		"dropped", report.Total,
+		"reasons", report.Reasons)
+	return result, report, nil
+}
+
+// reason returns the reason why the participant is dropped by the filters or
+// an empty string if it passes all of them. The user is the participant data
+// in the database, which can be nil if it is not required by the filters or
+// the user is unknown. The account age is computed at the time provided.
+func (f *CensusFilters) reason(p *FarcasterParticipant, user *mongo.User, reputation uint32, now time.Time) string {
+	switch {
+	case f.DenyFIDs[p.FID] || f.DenyUsernames[strings.ToLower(p.Username)]:
+		return CensusFilterReasonDenylist
+	case f.MinAccountAge > 0 && (user == nil || user.RegisteredAt.IsZero() || now.Sub(user.RegisteredAt) < f.MinAccountAge):
+		return CensusFilterReasonAccountAge
+	case f.MinFollowers > 0 && (user == nil || user.Followers < f.MinFollowers):
+		return CensusFilterReasonFollowers
+	case f.MinReputation > 0 && reputation < f.MinReputation:
+		return CensusFilterReasonReputation
+	case f.VerifiedAddress && (user == nil || len(user.Addresses) == 0):
+		return CensusFilterReasonVerifiedAddress
+	}
+	return ""
+}
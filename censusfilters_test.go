@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/mongo"
+)
+
+func TestParseCensusFilters(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name  string
+		query string
+		want  *CensusFilters
+		err   bool
+	}{
+		{name: "empty", query: ""},
+		{name: "unknown params", query: "foo=bar"},
+		{name: "zero values", query: "minAccountAge=0&minFollowers=0&verifiedAddress=false"},
+		{
+			name:  "all filters",
+			query: "minAccountAge=30&minFollowers=10&minReputation=5&verifiedAddress=true&denylist=1,@Alice,%202%20",
+			want: &CensusFilters{
+				MinAccountAge:   30 * 24 * time.Hour,
+				MinFollowers:    10,
+				MinReputation:   5,
+				VerifiedAddress: true,
+				DenyFIDs:        map[uint64]bool{1: true, 2: true},
+				DenyUsernames:   map[string]bool{"alice": true},
+			},
+		},
+		{
+			name:  "denylist empty entries",
+			query: "denylist=,3,,",
+			want: &CensusFilters{
+				DenyFIDs:      map[uint64]bool{3: true},
+				DenyUsernames: map[string]bool{},
+			},
+		},
+		{name: "invalid account age", query: "minAccountAge=-1", err: true},
+		{name: "account age too large", query: "minAccountAge=100000", err: true},
+		{name: "invalid followers", query: "minFollowers=many", err: true},
+		{name: "invalid reputation", query: "minReputation=5000000000", err: true},
+		{name: "invalid verified address", query: "verifiedAddress=maybe", err: true},
+		{name: "invalid denylist entry", query: "denylist=1,alice", err: true},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			query, err := url.ParseQuery(test.query)
+			c.Assert(err, qt.IsNil)
+			filters, err := parseCensusFilters(query)
+			if test.err {
+				c.Assert(err, qt.IsNotNil)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(filters, qt.DeepEquals, test.want)
+		})
+	}
+
+	c.Run("denylist too large", func(c *qt.C) {
+		entries := make([]string, maxCensusDenylistSize+1)
+		for i := range entries {
+			entries[i] = "1"
+		}
+		_, err := parseCensusFilters(url.Values{"denylist": {strings.Join(entries, ",")}})
+		c.Assert(err, qt.IsNotNil)
+	})
+}
+
+func TestCensusFiltersReason(t *testing.T) {
+	c := qt.New(t)
+	now := time.Now()
+	participant := &FarcasterParticipant{FID: 10, Username: "Alice"}
+	oldUser := &mongo.User{
+		UserID:       10,
+		Followers:    100,
+		Addresses:    []string{"0x01"},
+		RegisteredAt: now.Add(-365 * 24 * time.Hour),
+	}
+	tests := []struct {
+		name       string
+		filters    *CensusFilters
+		user       *mongo.User
+		reputation uint32
+		want       string
+	}{
+		{name: "no filters", filters: &CensusFilters{}, want: ""},
+		{name: "denied fid", filters: &CensusFilters{DenyFIDs: map[uint64]bool{10: true}}, want: CensusFilterReasonDenylist},
+		{
+			name:    "denied username",
+			filters: &CensusFilters{DenyUsernames: map[string]bool{"alice": true}},
+			want:    CensusFilterReasonDenylist,
+		},
+		{
+			name:    "denylist before other reasons",
+			filters: &CensusFilters{DenyFIDs: map[uint64]bool{10: true}, MinFollowers: 1000},
+			user:    oldUser,
+			want:    CensusFilterReasonDenylist,
+		},
+		{name: "old account", filters: &CensusFilters{MinAccountAge: 30 * 24 * time.Hour}, user: oldUser, want: ""},
+		{
+			name:    "new account",
+			filters: &CensusFilters{MinAccountAge: 30 * 24 * time.Hour},
+			user:    &mongo.User{UserID: 10, RegisteredAt: now.Add(-24 * time.Hour)},
+			want:    CensusFilterReasonAccountAge,
+		},
+		{
+			name:    "unknown registration",
+			filters: &CensusFilters{MinAccountAge: 30 * 24 * time.Hour},
+			user:    &mongo.User{UserID: 10},
+			want:    CensusFilterReasonAccountAge,
+		},
+		{name: "unknown user age", filters: &CensusFilters{MinAccountAge: time.Hour}, want: CensusFilterReasonAccountAge},
+		{name: "enough followers", filters: &CensusFilters{MinFollowers: 100}, user: oldUser, want: ""},
+		{name: "few followers", filters: &CensusFilters{MinFollowers: 101}, user: oldUser, want: CensusFilterReasonFollowers},
+		{name: "unknown user followers", filters: &CensusFilters{MinFollowers: 1}, want: CensusFilterReasonFollowers},
+		{name: "enough reputation", filters: &CensusFilters{MinReputation: 5}, reputation: 5, want: ""},
+		{name: "low reputation", filters: &CensusFilters{MinReputation: 5}, reputation: 4, want: CensusFilterReasonReputation},
+		{name: "verified address", filters: &CensusFilters{VerifiedAddress: true}, user: oldUser, want: ""},
+		{
+			name:    "no verified address",
+			filters: &CensusFilters{VerifiedAddress: true},
+			user:    &mongo.User{UserID: 10},
+			want:    CensusFilterReasonVerifiedAddress,
+		},
+		{name: "unknown user address", filters: &CensusFilters{VerifiedAddress: true}, want: CensusFilterReasonVerifiedAddress},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(test.filters.reason(participant, test.user, test.reputation, now), qt.Equals, test.want)
+		})
+	}
+}
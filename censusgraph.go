@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// GraphCensusModeMutuals includes the users that follow the account and
+	// are followed by it.
+	GraphCensusModeMutuals = "mutuals"
+	// GraphCensusModeFollowing includes the users followed by the account.
+	GraphCensusModeFollowing = "following"
+	// GraphCensusModeFollowers includes the users that follow any of the
+	// accounts.
+	GraphCensusModeFollowers = "followers"
+
+	// maxGraphCensusAccounts is the maximum number of accounts of a followers
+	// graph census.
+	maxGraphCensusAccounts = 10
+	// followGraphCacheSize is the maximum number of follow lists cached.
+	followGraphCacheSize = 256
+	// followGraphCacheTTL is the time a follow list is cached, so large
+	// accounts are not crawled again for every census.
+	followGraphCacheTTL = 6 * time.Hour
+)
+
+// censusGraph creates a new census from the follow graph of the accounts
+// provided: the mutual follows or the users followed by an account, or the
+// followers of any of the accounts. The poll author is always included. It
+// builds the census async and returns the census ID.
+func (v *vocdoniHandler) censusGraph(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	req := &GraphCensusRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ctx.Send([]byte("invalid request body"), http.StatusBadRequest)
+	}
+	switch req.Mode {
+	case GraphCensusModeMutuals, GraphCensusModeFollowing:
+		if len(req.FIDs) != 1 {
+			return ctx.Send([]byte(fmt.Sprintf("%s census requires exactly one fid", req.Mode)), http.StatusBadRequest)
+		}
+	case GraphCensusModeFollowers:
+		if len(req.FIDs) == 0 || len(req.FIDs) > maxGraphCensusAccounts {
+			return ctx.Send([]byte(fmt.Sprintf("invalid number of fids, bounds between 1 and %d", maxGraphCensusAccounts)),
+				http.StatusBadRequest)
+		}
+	default:
+		return ctx.Send([]byte(fmt.Sprintf("invalid census mode %s", req.Mode)), http.StatusBadRequest)
+	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	// create a censusID for the queue and store into it
+	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	if err != nil {
+		return err
+	}
+	v.startCensusJob(censusID, userFID)
+	if err := v.db.AddCensus(censusID, userFID); err != nil {
+		return fmt.Errorf("cannot add census to database: %w", err)
+	}
+	go func() {
+		startTime := time.Now()
+		var users []uint64
+		var err error
+		v.trackStepProgress(censusID, 1, 3, func(progress chan int) {
+			users, err = v.followGraphFIDs(req.Mode, req.FIDs, progress)
+		})
+		if err != nil {
+			log.Warnw("failed to get follow graph", "mode", req.Mode, "fids", req.FIDs, "error", err)
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		totalUsers := len(users)
+		if req.MinFollowers > 0 {
+			if users, err = v.db.UsersWithMinFollowers(users, req.MinFollowers); err != nil {
+				v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+				return
+			}
+		}
+		// include poll author in the census
+		users = append(users, userFID)
+		// create the participants from the database users using the fids
+		var participants []*FarcasterParticipant
+		v.trackStepProgress(censusID, 2, 3, func(progress chan int) {
+			participants = v.farcasterCensusFromFids(users, progress)
+		})
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants", Filtered: filtered})
+			return
+		}
+		// create the census from the participants
+		var censusInfo *CensusInfo
+		v.trackStepProgress(censusID, 3, 3, func(progress chan int) {
+			censusInfo, err = CreateCensus(v.cli, participants, FrameCensusTypeFollowGraph, progress)
+		})
+		if err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		censusInfo.FromTotalAddresses = uint32(totalUsers)
+		censusInfo.Filtered = filtered
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, censusInfo, participants) {
+			return
+		}
+		log.Infow("census created from follow graph",
+			"mode", req.Mode,
+			"fids", req.FIDs,
+			"minFollowers", req.MinFollowers,
+			"participants", len(censusInfo.Usernames),
+			"duration", time.Since(startTime))
+	}()
+	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// followGraphFIDs returns the FIDs of the users that have the relation defined
+// by the mode with the accounts provided, without duplicates.
+func (v *vocdoniHandler) followGraphFIDs(mode string, fids []uint64, progress chan int) ([]uint64, error) {
+	switch mode {
+	case GraphCensusModeFollowing:
+		following, err := v.userFollowing(fids[0])
+		if err != nil {
+			return nil, err
+		}
+		return unionFIDs(following), nil
+	case GraphCensusModeMutuals:
+		followers, err := v.userFollowers(fids[0])
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress <- 50
+		}
+		following, err := v.userFollowing(fids[0])
+		if err != nil {
+			return nil, err
+		}
+		return mutualFIDs(followers, following), nil
+	case GraphCensusModeFollowers:
+		lists := make([][]uint64, 0, len(fids))
+		for i, fid := range fids {
+			followers, err := v.userFollowers(fid)
+			if err != nil {
+				return nil, err
+			}
+			lists = append(lists, followers)
+			if progress != nil {
+				progress <- 100 * (i + 1) / len(fids)
+			}
+		}
+		return unionFIDs(lists...), nil
+	default:
+		return nil, fmt.Errorf("invalid census mode %s", mode)
+	}
+}
+
+// mutualFIDs returns the FIDs included in both lists provided, without
+// duplicates, in the order of the following list.
+func mutualFIDs(followers, following []uint64) []uint64 {
+	isFollower := make(map[uint64]bool, len(followers))
+	for _, fid := range followers {
+		isFollower[fid] = true
+	}
+	mutuals := []uint64{}
+	for _, fid := range following {
+		if isFollower[fid] {
+			mutuals = append(mutuals, fid)
+			// avoid duplicates if the list has repeated fids
+			delete(isFollower, fid)
+		}
+	}
+	return mutuals
+}
+
+// unionFIDs returns the FIDs included in any of the lists provided, without
+// duplicates, in the order in which they appear first.
+func unionFIDs(lists ...[]uint64) []uint64 {
+	seen := make(map[uint64]bool)
+	result := []uint64{}
+	for _, list := range lists {
+		for _, fid := range list {
+			if !seen[fid] {
+				seen[fid] = true
+				result = append(result, fid)
+			}
+		}
+	}
+	return result
+}
+
+// userFollowers returns the followers of the user with the given FID, using
+// the follow graph cache if they have been fetched recently.
+func (v *vocdoniHandler) userFollowers(fid uint64) ([]uint64, error) {
+	return v.cachedFollowGraph(fmt.Sprintf("followers-%d", fid), func(ctx context.Context) ([]uint64, error) {
+		return v.fcapi.UserFollowers(ctx, fid)
+	})
+}
+
+// userFollowing returns the users followed by the user with the given FID,
+// using the follow graph cache if they have been fetched recently.
+func (v *vocdoniHandler) userFollowing(fid uint64) ([]uint64, error) {
+	return v.cachedFollowGraph(fmt.Sprintf("following-%d", fid), func(ctx context.Context) ([]uint64, error) {
+		return v.fcapi.UserFollowing(ctx, fid)
+	})
+}
+
+// cachedFollowGraph returns the follow list cached with the given key or
+// fetches it and stores it in the cache. It returns a copy of the list, so
+// the callers can modify it.
+func (v *vocdoniHandler) cachedFollowGraph(key string, fetch func(context.Context) ([]uint64, error)) ([]uint64, error) {
+	if fids, ok := v.followGraph.Get(key); ok {
+		log.Debugw("follow graph cache hit", "key", key, "count", len(fids))
+		return slices.Clone(fids), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fids, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.followGraph.Add(key, fids)
+	return slices.Clone(fids), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+func TestMutualFIDs(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name      string
+		followers []uint64
+		following []uint64
+		want      []uint64
+	}{
+		{name: "no follows", want: []uint64{}},
+		{name: "no mutuals", followers: []uint64{1, 2}, following: []uint64{3, 4}, want: []uint64{}},
+		{name: "mutuals", followers: []uint64{1, 2, 3}, following: []uint64{4, 3, 1}, want: []uint64{3, 1}},
+		{name: "duplicates", followers: []uint64{1, 1, 2}, following: []uint64{1, 2, 1, 2}, want: []uint64{1, 2}},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(mutualFIDs(test.followers, test.following), qt.DeepEquals, test.want)
+		})
+	}
+}
+
+func TestUnionFIDs(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name  string
+		lists [][]uint64
+		want  []uint64
+	}{
+		{name: "no lists", want: []uint64{}},
+		{name: "one list", lists: [][]uint64{{3, 1, 3}}, want: []uint64{3, 1}},
+		{name: "many lists", lists: [][]uint64{{1, 2}, {2, 3}, {}, {4, 1}}, want: []uint64{1, 2, 3, 4}},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(unionFIDs(test.lists...), qt.DeepEquals, test.want)
+		})
+	}
+}
+
+func TestFollowGraphFIDs(t *testing.T) {
+	c := qt.New(t)
+	api := mock.NewMockAPI()
+	api.AddFixtures(&mock.Fixtures{Users: []*mock.UserFixture{
+		{FID: 1, Followers: []uint64{2, 3, 4}, Following: []uint64{3, 4, 5, 5}},
+		{FID: 2, Followers: []uint64{4, 6}},
+	}})
+	handler := &vocdoniHandler{
+		fcapi:       api,
+		followGraph: expirable.NewLRU[string, []uint64](followGraphCacheSize, nil, followGraphCacheTTL),
+	}
+	tests := []struct {
+		mode string
+		fids []uint64
+		want []uint64
+	}{
+		{mode: GraphCensusModeFollowing, fids: []uint64{1}, want: []uint64{3, 4, 5}},
+		{mode: GraphCensusModeMutuals, fids: []uint64{1}, want: []uint64{3, 4}},
+		{mode: GraphCensusModeFollowers, fids: []uint64{1, 2}, want: []uint64{2, 3, 4, 6}},
+	}
+	for _, test := range tests {
+		c.Run(test.mode, func(c *qt.C) {
+			fids, err := handler.followGraphFIDs(test.mode, test.fids, nil)
+			c.Assert(err, qt.IsNil)
+			c.Assert(fids, qt.DeepEquals, test.want)
+		})
+	}
+
+	// the follow lists are cached, so they are not fetched again
+	api.SetError("UserFollowers", farcasterapi.ErrNoDataFound)
+	fids, err := handler.followGraphFIDs(GraphCensusModeFollowers, []uint64{2}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fids, qt.DeepEquals, []uint64{4, 6})
+	_, err = handler.followGraphFIDs(GraphCensusModeFollowers, []uint64{3}, nil)
+	c.Assert(err, qt.ErrorIs, farcasterapi.ErrNoDataFound)
+	_, err = handler.followGraphFIDs("unknown", []uint64{1}, nil)
+	c.Assert(err, qt.ErrorMatches, "invalid census mode unknown")
+}
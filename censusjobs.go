@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// censusJobsGCInterval is the interval between the removals of the old
+	// finished census jobs.
+	censusJobsGCInterval = time.Hour
+	// censusJobsTTL is the time that a finished census job is kept, so the
+	// client can still check its result.
+	censusJobsTTL = 24 * time.Hour
+	// censusJobLeaseTTL is the time that a running census job is owned by the
+	// instance that builds it without renewing its lease. The jobs whose lease
+	// expires are considered interrupted.
+	censusJobLeaseTTL = 5 * time.Minute
+	// censusJobLeaseInterval is the interval between the renewals of the
+	// leases of the running census jobs.
+	censusJobLeaseInterval = time.Minute
+	// censusJobInterrupted is the error of the census jobs interrupted by a
+	// restart of the service.
+	censusJobInterrupted = "census creation interrupted by a restart of the service, please try again"
+)
+
+// startCensusJob stores a new running census job created by the user with the
+// given FID, both in the background queue and in the database.
+func (v *vocdoniHandler) startCensusJob(censusID types.HexBytes, createdBy uint64) {
+	v.censusJobsLock.Lock()
+	defer v.censusJobsLock.Unlock()
+	v.backgroundQueue.Store(censusID.String(), CensusInfo{})
+	if err := v.db.UpdateCensusJob(&mongo.CensusJob{
+		CensusID:   censusID.String(),
+		State:      mongo.CensusJobStateRunning,
+		CreatedBy:  createdBy,
+		LeaseUntil: time.Now().Add(censusJobLeaseTTL),
+	}); err != nil {
+		log.Warnw("cannot store census job", "censusID", censusID.String(), "error", err)
+	}
+}
+
+// storeCensusJob stores the census info provided as the current status of the
+// census job, both in the background queue and in the database, so the status
+// survives a restart of the service. The progress updates are only written to
+// the database when the progress changes. The updates of a job that is not
+// running anymore, because it was canceled, it already finished or its lease
+// expired, are discarded and it returns false, so the builder of the job can
+// discard the census too.
+func (v *vocdoniHandler) storeCensusJob(censusID types.HexBytes, info CensusInfo) bool {
+	v.censusJobsLock.Lock()
+	defer v.censusJobsLock.Unlock()
+
+	var previous *CensusInfo
+	if iPrevious, ok := v.backgroundQueue.Load(censusID.String()); ok {
+		if ci, ok := iPrevious.(CensusInfo); ok {
+			previous = &ci
+		}
+	}
+	if previous != nil && previous.Finished() {
+		return false
+	}
+	job := &mongo.CensusJob{
+		CensusID:   censusID.String(),
+		State:      mongo.CensusJobStateRunning,
+		Progress:   info.Progress,
+		LeaseUntil: time.Now().Add(censusJobLeaseTTL),
+	}
+	switch {
+	case info.Error != "":
+		job.State = mongo.CensusJobStateFailed
+		job.Error = info.Error
+	case info.Root != nil:
+		job.State = mongo.CensusJobStateDone
+		job.Progress = 100
+		// the census ID allows to assign the census to the election that
+		// uses it
+		info.CensusID = censusID
+	}
+	if job.Finished() {
+		info.FinishedAt = time.Now()
+		result := info
+		if len(result.Usernames) > maxUsersNamesToReturn {
+			result.Usernames = nil
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Warnw("cannot encode census job result", "censusID", censusID.String(), "error", err)
+		}
+		job.Result = string(data)
+	}
+	v.backgroundQueue.Store(censusID.String(), info)
+	if !job.Finished() && previous != nil && previous.Progress == info.Progress {
+		return true
+	}
+	if err := v.db.UpdateCensusJob(job); err != nil {
+		if errors.Is(err, mongo.ErrCensusJobDone) {
+			// the job was finished by other instance, e.g. its lease
+			// expired, so the database has the current status
+			v.backgroundQueue.Delete(censusID.String())
+			return false
+		}
+		log.Warnw("cannot store census job", "censusID", censusID.String(), "error", err)
+	}
+	return true
+}
+
+// censusJob returns the current status of the census job. It looks for it in
+// the background queue first and, if it is not there, in the database, since
+// the job could be created before a restart of the service.
+func (v *vocdoniHandler) censusJob(censusID types.HexBytes) (*CensusInfo, error) {
+	if iCensusInfo, ok := v.backgroundQueue.Load(censusID.String()); ok {
+		if ci, ok := iCensusInfo.(CensusInfo); ok {
+			return &ci, nil
+		}
+	}
+	job, err := v.db.CensusJob(censusID)
+	if err != nil {
+		return nil, err
+	}
+	ci := &CensusInfo{}
+	if job.Result != "" {
+		if err := json.Unmarshal([]byte(job.Result), ci); err != nil {
+			return nil, fmt.Errorf("cannot decode census job result: %w", err)
+		}
+	}
+	ci.Progress = job.Progress
+	ci.Error = job.Error
+	ci.Canceled = job.State == mongo.CensusJobStateCanceled
+	ci.FinishedAt = job.FinishedAt
+	return ci, nil
+}
+
+// cancelCensusJob cancels the creation of a census. Only the creator of the
+// census job can cancel it and only while it is being built. The steps of the
+// job that are already running are not interrupted, but the job does not
+// report progress anymore and its result is discarded, so the census is not
+// stored.
+func (v *vocdoniHandler) cancelCensusJob(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	var censusID types.HexBytes
+	censusID, err := hex.DecodeString(ctx.URLParam("censusID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid censusID"), http.StatusBadRequest)
+	}
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	job, err := v.db.CensusJob(censusID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrCensusJobUnknown) {
+			return ctx.Send(nil, http.StatusNotFound)
+		}
+		return err
+	}
+	if job.CreatedBy != userFID {
+		return ctx.Send([]byte("only the creator of the census can cancel it"), http.StatusForbidden)
+	}
+
+	v.censusJobsLock.Lock()
+	defer v.censusJobsLock.Unlock()
+	ci, err := v.censusJob(censusID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrCensusJobUnknown) {
+			return ctx.Send(nil, http.StatusNotFound)
+		}
+		return err
+	}
+	if ci.Finished() {
+		return ctx.Send([]byte("census creation already finished"), http.StatusConflict)
+	}
+	ci.Canceled = true
+	ci.FinishedAt = time.Now()
+	v.backgroundQueue.Store(censusID.String(), *ci)
+	if err := v.db.UpdateCensusJob(&mongo.CensusJob{
+		CensusID: censusID.String(),
+		State:    mongo.CensusJobStateCanceled,
+		Progress: ci.Progress,
+	}); err != nil {
+		if errors.Is(err, mongo.ErrCensusJobDone) {
+			v.backgroundQueue.Delete(censusID.String())
+			return ctx.Send([]byte("census creation already finished"), http.StatusConflict)
+		}
+		return fmt.Errorf("cannot cancel census job: %w", err)
+	}
+	log.Infow("census creation canceled", "censusID", censusID.String(), "user", userFID)
+	return ctx.Send(nil, http.StatusOK)
+}
+
+// failInterruptedCensusJobs sets as failed the census jobs whose lease has
+// expired, since the instance that was building them is gone and the client
+// would wait for them forever. The jobs of other running instances keep their
+// leases renewed, so they are not affected.
+func (v *vocdoniHandler) failInterruptedCensusJobs() {
+	failed, err := v.db.FailExpiredCensusJobs(censusJobInterrupted)
+	if err != nil {
+		log.Warnw("cannot fail interrupted census jobs", "error", err)
+		return
+	}
+	if failed > 0 {
+		log.Infow("interrupted census jobs failed", "jobs", failed)
+	}
+}
+
+// renewCensusJobLeasesAtBackground periodically renews the leases of the
+// census jobs running in this instance and fails the jobs interrupted in other
+// instances. It must run in the background.
+func (v *vocdoniHandler) renewCensusJobLeasesAtBackground(ctx context.Context) {
+	ticker := time.NewTicker(censusJobLeaseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			running := []string{}
+			v.backgroundQueue.Range(func(key, value any) bool {
+				if ci, ok := value.(CensusInfo); ok && !ci.Finished() {
+					running = append(running, key.(string))
+				}
+				return true
+			})
+			if _, err := v.db.RenewCensusJobLeases(running, time.Now().Add(censusJobLeaseTTL)); err != nil {
+				log.Warnw("cannot renew census job leases", "error", err)
+			}
+			v.failInterruptedCensusJobs()
+		}
+	}
+}
+
+// gcCensusJobsAtBackground periodically removes the census jobs that finished
+// before the census jobs TTL, both from the background queue and from the
+// database. It must run in the background.
+func (v *vocdoniHandler) gcCensusJobsAtBackground(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(censusJobsGCInterval):
+			before := time.Now().Add(-censusJobsTTL)
+			v.backgroundQueue.Range(func(key, value any) bool {
+				if ci, ok := value.(CensusInfo); ok && ci.Finished() && ci.FinishedAt.Before(before) {
+					v.backgroundQueue.Delete(key)
+				}
+				return true
+			})
+			removed, err := v.db.DeleteFinishedCensusJobs(before)
+			if err != nil {
+				log.Warnw("cannot remove finished census jobs", "error", err)
+				continue
+			}
+			if removed > 0 {
+				log.Debugw("finished census jobs removed", "jobs", removed)
+			}
+		}
+	}
+}
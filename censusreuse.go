@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+)
+
+// maxCensusNameLength is the maximum length of the name of a saved census.
+const maxCensusNameLength = 64
+
+// SavedCensusRequest is the request to save a census under a name.
+type SavedCensusRequest struct {
+	CensusID types.HexBytes `json:"censusId"`
+	Name     string         `json:"name"`
+}
+
+// SavedCensus is a census saved under a name by its creator.
+type SavedCensus struct {
+	CensusID           string `json:"censusId"`
+	Name               string `json:"name"`
+	Root               string `json:"root"`
+	URL                string `json:"url"`
+	FromTotalAddresses uint32 `json:"fromTotalAddresses"`
+	TotalWeight        string `json:"totalWeight"`
+}
+
+// participantsFromUsers creates the census participants of the users
+// provided, one for every signer. If the weights are provided, they are
+// indexed by username and the users without weight are skipped, otherwise
+// every user has weight 1.
+func participantsFromUsers(users []*mongo.User, weights map[string]*big.Int) []*FarcasterParticipant {
+	participants := []*FarcasterParticipant{}
+	for _, user := range users {
+		weight := big.NewInt(1)
+		if weights != nil {
+			var ok bool
+			if weight, ok = weights[user.Username]; !ok {
+				continue
+			}
+		}
+		for _, signer := range user.ActiveSigners() {
+			signerBytes, err := hex.DecodeString(strings.TrimPrefix(signer, "0x"))
+			if err != nil {
+				log.Warnw("error decoding signer", "signer", signer, "err", err)
+				continue
+			}
+			participants = append(participants, &FarcasterParticipant{
+				PubKey:   signerBytes,
+				Weight:   weight,
+				Username: user.Username,
+				FID:      user.UserID,
+			})
+		}
+	}
+	return participants
+}
+
+// electionVotersParticipants returns the census participants of the users
+// that voted in the election provided, all of them with weight 1.
+func (v *vocdoniHandler) electionVotersParticipants(electionID types.HexBytes) ([]*FarcasterParticipant, error) {
+	voters, err := v.db.VotersOfElection(electionID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get voters of election: %w", err)
+	}
+	return participantsFromUsers(voters, nil), nil
+}
+
+// censusParticipantsWeights parses the weights of the participants of a
+// census stored in the database, indexed by username. The participants with
+// an invalid weight are skipped.
+func censusParticipantsWeights(participants map[string]string) map[string]*big.Int {
+	weights := make(map[string]*big.Int, len(participants))
+	for username, weight := range participants {
+		w, ok := new(big.Int).SetString(weight, 10)
+		if !ok {
+			log.Warnw("invalid census participant weight", "username", username, "weight", weight)
+			continue
+		}
+		weights[username] = w
+	}
+	return weights
+}
+
+// electionCensusParticipants returns the census participants of the census
+// provided, with their weights in it.
+func (v *vocdoniHandler) electionCensusParticipants(census *mongo.Census) ([]*FarcasterParticipant, error) {
+	weights := censusParticipantsWeights(census.Participants)
+	usernames := make([]string, 0, len(weights))
+	for username := range weights {
+		usernames = append(usernames, username)
+	}
+	users, err := v.db.UsersByUsernames(usernames)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get census users: %w", err)
+	}
+	list := make([]*mongo.User, 0, len(users))
+	for _, user := range users {
+		list = append(list, user)
+	}
+	return participantsFromUsers(list, weights), nil
+}
+
+// censusInfoFromDatabase returns the census information of a census already
+// published and stored in the database, to reuse it without building it again.
+func (v *vocdoniHandler) censusInfoFromDatabase(census *mongo.Census) (*CensusInfo, error) {
+	root, err := hex.DecodeString(strings.TrimPrefix(census.Root, "0x"))
+	if err != nil || len(root) == 0 {
+		return nil, fmt.Errorf("census %s has no valid root", census.CensusID)
+	}
+	// the published censuses are identified by their root
+	size, err := v.cli.CensusSize(root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get census size: %w", err)
+	}
+	ci := &CensusInfo{
+		Root:               root,
+		Url:                census.URL,
+		Size:               size,
+		FromTotalAddresses: census.FromTotalAddresses,
+		Snapshot:           census.Snapshot,
+		WeightStrategy:     census.WeightStrategy,
+	}
+	for username := range census.Participants {
+		ci.Usernames = append(ci.Usernames, username)
+	}
+	return ci, nil
+}
+
+// censusElectionVoters creates a new census from the users that voted in a
+// previous election, all of them with weight 1. It accepts the census filters
+// as query parameters. It builds the census async and returns the census ID.
+func (v *vocdoniHandler) censusElectionVoters(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid electionID"), http.StatusBadRequest)
+	}
+	if _, err := v.db.Election(electionID); err != nil {
+		return ctx.Send([]byte("election not found"), http.StatusNotFound)
+	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	return v.censusFromPreviousElection(msg, ctx, FrameCensusTypeElectionVoters, filters, nil,
+		func() ([]*FarcasterParticipant, error) {
+			return v.electionVotersParticipants(electionID)
+		})
+}
+
+// censusElectionCensus creates a new census from the census of a previous
+// election. If no census filter nor weight strategy is provided, the census
+// tree of the election is reused without building it again. Otherwise, it is
+// built from the participants of the census of the election with their
+// weights. It returns the census ID.
+func (v *vocdoniHandler) censusElectionCensus(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid electionID"), http.StatusBadRequest)
+	}
+	census, err := v.db.CensusFromElection(electionID)
+	if err != nil {
+		return ctx.Send([]byte("census not found"), http.StatusNotFound)
+	}
+	filters, err := censusFiltersFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	weightStrategy, err := censusWeightStrategyFromQuery(ctx)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	if filters.Empty() && weightStrategy == nil {
+		ci, err := v.censusInfoFromDatabase(census)
+		if err == nil {
+			return v.reuseElectionCensus(msg, ctx, census, ci)
+		}
+		log.Warnw("cannot reuse census of election, building it again",
+			"electionID", fmt.Sprintf("%x", electionID), "error", err)
+	}
+	return v.censusFromPreviousElection(msg, ctx, FrameCensusTypeElectionCensus, filters, weightStrategy,
+		func() ([]*FarcasterParticipant, error) {
+			return v.electionCensusParticipants(census)
+		})
+}
+
+// reuseElectionCensus creates a new census that reuses the census tree of
+// the census of an election provided, with its census information. The census
+// is copied to a new one, so it can be assigned to another election, and its
+// job is stored as done. It returns the census ID.
+func (v *vocdoniHandler) reuseElectionCensus(msg *apirest.APIdata, ctx *httprouter.HTTPContext,
+	census *mongo.Census, ci *CensusInfo,
+) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	censusID := types.HexBytes(util.RandomBytes(32))
+	if err := v.db.CopyCensus(census, censusID, userFID, nil); err != nil {
+		return fmt.Errorf("cannot add census to database: %w", err)
+	}
+	ci.Type = FrameCensusTypeElectionCensus
+	v.storeCensusJob(censusID, *ci)
+	log.Infow("census reused from election",
+		"censusID", censusID.String(),
+		"electionID", census.ElectionID,
+		"root", ci.Root.String())
+	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// censusFromPreviousElection builds a new census from the participants
+// returned by the function provided, applying the census filters and the
+// weight strategy. It builds the census async and returns the census ID.
+func (v *vocdoniHandler) censusFromPreviousElection(msg *apirest.APIdata, ctx *httprouter.HTTPContext,
+	censusType FrameCensusType, filters *CensusFilters, weightStrategy *mongo.CensusWeightStrategy,
+	getParticipants func() ([]*FarcasterParticipant, error),
+) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	censusID, err := v.cli.NewCensus(api.CensusTypeWeighted)
+	if err != nil {
+		return err
+	}
+	v.startCensusJob(censusID, userFID)
+	if err := v.db.AddCensus(censusID, userFID); err != nil {
+		return fmt.Errorf("cannot add census to database: %w", err)
+	}
+	go func() {
+		startTime := time.Now()
+		participants, err := getParticipants()
+		if err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		// the users of the previous election, before filtering them
+		previousUsers, _ := uniqueCensusParticipants(participants)
+		fromTotalUsers := uint32(len(previousUsers))
+		var filtered *CensusFilterReport
+		if participants, filtered, err = v.filterCensusParticipants(participants, filters); err != nil {
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		if len(participants) == 0 {
+			v.storeCensusJob(censusID, CensusInfo{Error: "no valid participants", Filtered: filtered})
+			return
+		}
+		applyCensusWeightStrategy(participants, weightStrategy)
+		var ci *CensusInfo
+		v.trackStepProgress(censusID, 1, 1, func(progress chan int) {
+			ci, err = CreateCensus(v.cli, participants, censusType, progress)
+		})
+		if err != nil {
+			log.Errorw(err, "failed to create census")
+			v.storeCensusJob(censusID, CensusInfo{Error: err.Error()})
+			return
+		}
+		ci.FromTotalAddresses = fromTotalUsers
+		ci.Filtered = filtered
+		ci.WeightStrategy = weightStrategy
+		// store the census and its participants, unless the job was
+		// canceled, which discards the census
+		if !v.storeCensusParticipants(censusID, ci, participants) {
+			return
+		}
+		log.Infow("census created from previous election",
+			"censusID", censusID.String(),
+			"size", len(ci.Usernames),
+			"duration", time.Since(startTime))
+	}()
+	data, err := json.Marshal(map[string]string{"censusId": censusID.String()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// saveCensus saves a census under a name, so its creator can select it later
+// to create new elections. An empty name removes it from the saved censuses.
+// Only the creator of the census can save it and it must be already built.
+func (v *vocdoniHandler) saveCensus(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	req := &SavedCensusRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ctx.Send([]byte("invalid request body"), http.StatusBadRequest)
+	}
+	if req.Name, err = normalizeCensusName(req.Name); err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
+	census, err := v.savedCensusOfUser(req.CensusID, userFID)
+	if err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusNotFound)
+	}
+	if census.Root == "" {
+		return ctx.Send([]byte("census not built yet"), http.StatusBadRequest)
+	}
+	if err := v.db.SetNameForCensus(req.CensusID, req.Name); err != nil {
+		return err
+	}
+	return ctx.Send(nil, http.StatusOK)
+}
+
+// normalizeCensusName returns the name provided for a saved census without
+// leading and trailing spaces. It fails if the name is too long.
+func normalizeCensusName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > maxCensusNameLength {
+		return "", fmt.Errorf("census name too long, maximum allowed is %d characters", maxCensusNameLength)
+	}
+	return name, nil
+}
+
+// savedCensuses returns the censuses saved by the authenticated user.
+func (v *vocdoniHandler) savedCensuses(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	userFID, err := v.db.UserFromAuthToken(msg.AuthToken)
+	if err != nil {
+		return fmt.Errorf("cannot get user from auth token: %w", err)
+	}
+	censuses, err := v.db.SavedCensusesByUser(userFID)
+	if err != nil {
+		return err
+	}
+	saved := make([]*SavedCensus, 0, len(censuses))
+	for _, census := range censuses {
+		saved = append(saved, &SavedCensus{
+			CensusID:           census.CensusID,
+			Name:               census.Name,
+			Root:               census.Root,
+			URL:                census.URL,
+			FromTotalAddresses: census.FromTotalAddresses,
+			TotalWeight:        census.TotalWeight,
+		})
+	}
+	data, err := json.Marshal(map[string]any{"censuses": saved})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, http.StatusOK)
+}
+
+// savedCensusOfUser returns the census with the given ID if it was created by
+// the user provided.
+func (v *vocdoniHandler) savedCensusOfUser(censusID types.HexBytes, userFID uint64) (*mongo.Census, error) {
+	if len(censusID) == 0 {
+		return nil, fmt.Errorf("census not found")
+	}
+	census, err := v.db.Census(censusID)
+	if err != nil || census.CreatedBy != userFID {
+		return nil, fmt.Errorf("census not found")
+	}
+	return &census, nil
+}
+
+// savedCensusForElection returns the census information of a census saved by
+// the user provided to create a new election with it, and the saved census.
+// The census tree is reused, but the saved census must be copied to a new one
+// with assignSavedCensus once the election is created, so the saved one is
+// kept.
+func (v *vocdoniHandler) savedCensusForElection(censusID types.HexBytes, userFID uint64) (*CensusInfo, *mongo.Census, error) {
+	census, err := v.savedCensusOfUser(censusID, userFID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if census.Name == "" {
+		return nil, nil, errors.New("census not saved")
+	}
+	ci, err := v.censusInfoFromDatabase(census)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ci, census, nil
+}
+
+// assignSavedCensus copies the saved census provided to a new census assigned
+// to the election with the given ID, which uses the saved census tree.
+func (v *vocdoniHandler) assignSavedCensus(census *mongo.Census, userFID uint64, electionID types.HexBytes) error {
+	return v.db.CopyCensus(census, util.RandomBytes(32), userFID, electionID)
+}
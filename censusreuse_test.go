@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/mongo"
+)
+
+func TestParticipantsFromUsers(t *testing.T) {
+	c := qt.New(t)
+	users := []*mongo.User{
+		{UserID: 1, Username: "alice", Signers: []string{"0x01", "0x02"}, RevokedSigners: []string{"0x02"}},
+		{UserID: 2, Username: "bob", Signers: []string{"03", "invalid"}},
+		{UserID: 3, Username: "carol", Signers: []string{"0x04"}},
+	}
+	type participant struct {
+		FID    uint64
+		PubKey string
+		Weight int64
+	}
+	toParticipants := func(participants []*FarcasterParticipant) []participant {
+		result := []participant{}
+		for _, p := range participants {
+			result = append(result, participant{FID: p.FID, PubKey: string(p.PubKey), Weight: p.Weight.Int64()})
+		}
+		return result
+	}
+
+	// every active and valid signer is a participant with weight 1
+	c.Assert(toParticipants(participantsFromUsers(users, nil)), qt.DeepEquals, []participant{
+		{FID: 1, PubKey: "\x01", Weight: 1},
+		{FID: 2, PubKey: "\x03", Weight: 1},
+		{FID: 3, PubKey: "\x04", Weight: 1},
+	})
+	// the users without weight are skipped
+	weights := map[string]*big.Int{"alice": big.NewInt(5), "carol": big.NewInt(2)}
+	c.Assert(toParticipants(participantsFromUsers(users, weights)), qt.DeepEquals, []participant{
+		{FID: 1, PubKey: "\x01", Weight: 5},
+		{FID: 3, PubKey: "\x04", Weight: 2},
+	})
+	c.Assert(participantsFromUsers(nil, nil), qt.HasLen, 0)
+}
+
+func TestCensusParticipantsWeights(t *testing.T) {
+	c := qt.New(t)
+	weights := censusParticipantsWeights(map[string]string{
+		"alice": "10",
+		"bob":   "1000000000000000000000",
+		"carol": "invalid",
+		"dave":  "",
+	})
+	c.Assert(weights, qt.HasLen, 2)
+	c.Assert(weights["alice"].String(), qt.Equals, "10")
+	c.Assert(weights["bob"].String(), qt.Equals, "1000000000000000000000")
+	c.Assert(censusParticipantsWeights(nil), qt.HasLen, 0)
+}
+
+func TestNormalizeCensusName(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{name: "", want: ""},
+		{name: "  my census ", want: "my census"},
+		{name: strings.Repeat("a", maxCensusNameLength), want: strings.Repeat("a", maxCensusNameLength)},
+		{name: " " + strings.Repeat("a", maxCensusNameLength) + " ", want: strings.Repeat("a", maxCensusNameLength)},
+		{name: strings.Repeat("a", maxCensusNameLength+1), err: true},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			name, err := normalizeCensusName(test.name)
+			if test.err {
+				c.Assert(err, qt.ErrorMatches, "census name too long.*")
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(name, qt.Equals, test.want)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/httprouter"
+)
+
+const (
+	// WeightStrategyLinear keeps the weights as they are.
+	WeightStrategyLinear = "linear"
+	// WeightStrategyQuadratic replaces every weight by its integer square
+	// root.
+	WeightStrategyQuadratic = "quadratic"
+	// WeightStrategyLog replaces every weight w by 1 + floor(log2(w)).
+	WeightStrategyLog = "log"
+	// WeightStrategyCapped limits every weight to the cap of the strategy.
+	WeightStrategyCapped = "capped"
+	// WeightStrategyOnePersonOneVote sets every weight to 1.
+	WeightStrategyOnePersonOneVote = "1p1v"
+)
+
+// censusWeightStrategyFromQuery parses the weight strategy of a census from
+// the query parameters of the request, so every weighted census builder
+// accepts it in the same way:
+//
+//	?weightStrategy=<linear|quadratic|log|capped|1p1v>&weightCap=<n>
+//
+// It returns nil if no strategy is set or it is the linear one, since the
+// weights are not transformed.
+func censusWeightStrategyFromQuery(ctx *httprouter.HTTPContext) (*mongo.CensusWeightStrategy, error) {
+	query := ctx.Request.URL.Query()
+	strategy := &mongo.CensusWeightStrategy{Name: query.Get("weightStrategy")}
+	switch strategy.Name {
+	case "", WeightStrategyLinear:
+		return nil, nil
+	case WeightStrategyQuadratic, WeightStrategyLog, WeightStrategyOnePersonOneVote:
+	case WeightStrategyCapped:
+		weightCap, err := strconv.ParseUint(query.Get("weightCap"), 10, 64)
+		if err != nil || weightCap == 0 {
+			return nil, fmt.Errorf("capped weight strategy requires a weightCap greater than 0")
+		}
+		strategy.Cap = weightCap
+	default:
+		return nil, fmt.Errorf("invalid weight strategy %s", strategy.Name)
+	}
+	return strategy, nil
+}
+
+// applyCensusWeightStrategy replaces the weight of every participant by the
+// result of the weight strategy provided. If the strategy is nil, the weights
+// are not modified.
+func applyCensusWeightStrategy(participants []*FarcasterParticipant, strategy *mongo.CensusWeightStrategy) {
+	if strategy == nil {
+		return
+	}
+	for _, p := range participants {
+		p.Weight = censusWeight(p.Weight, strategy)
+	}
+}
+
+// censusWeight returns the weight provided transformed by the strategy. It
+// always returns a new value, since the weights can be shared by the signers
+// of the same user.
+func censusWeight(weight *big.Int, strategy *mongo.CensusWeightStrategy) *big.Int {
+	if strategy.Name == WeightStrategyOnePersonOneVote {
+		return big.NewInt(1)
+	}
+	if weight == nil || weight.Sign() <= 0 {
+		return new(big.Int)
+	}
+	switch strategy.Name {
+	case WeightStrategyQuadratic:
+		return new(big.Int).Sqrt(weight)
+	case WeightStrategyLog:
+		return new(big.Int).SetInt64(int64(weight.BitLen()))
+	case WeightStrategyCapped:
+		weightCap := new(big.Int).SetUint64(strategy.Cap)
+		if weight.Cmp(weightCap) > 0 {
+			return weightCap
+		}
+	}
+	return new(big.Int).Set(weight)
+}
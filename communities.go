@@ -5,18 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/vocdoni/vote-frame/communityhub"
 	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/imageframe"
 	"github.com/vocdoni/vote-frame/mongo"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
 )
 
+// themeColorRgx matches the valid colors of a community theme (hex format).
+var themeColorRgx = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
 // censusChannelOrAddresses gets the census channel or addresses based on the
 // type of the census provided from the database. If the census provided is
 // based on a channel, it gets the channel information from the farcaster API,
@@ -179,6 +186,7 @@ func (v *vocdoniHandler) listCommunitiesHandler(msg *apirest.APIdata, ctx *httpr
 			CensusChannel:   cChannel,
 			Channels:        c.Channels,
 			Disabled:        c.Disabled,
+			Theme:           c.Theme,
 		})
 	}
 	res, err := json.Marshal(communities)
@@ -245,6 +253,7 @@ func (v *vocdoniHandler) communityHandler(msg *apirest.APIdata, ctx *httprouter.
 		CensusChannel:   cChannel,
 		Channels:        dbCommunity.Channels,
 		Disabled:        dbCommunity.Disabled,
+		Theme:           dbCommunity.Theme,
 	})
 	if err != nil {
 		return ctx.Send([]byte("error encoding community"), http.StatusInternalServerError)
@@ -323,8 +332,20 @@ func (v *vocdoniHandler) communitySettingsHandler(msg *apirest.APIdata, ctx *htt
 	if typedCommunity.CensusChannel != nil {
 		censusChannel = typedCommunity.CensusChannel.ID
 	}
+	// check the theme if it is provided
+	_, updateTheme := mapCommunity["theme"]
+	if updateTheme && typedCommunity.Theme != nil {
+		if err := validateCommunityTheme(typedCommunity.Theme); err != nil {
+			return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+		}
+	}
 	// update the community image
 	if typedCommunity.LogoURL != "" && typedCommunity.LogoURL != dbCommunity.ImageURL {
+		// the logo is part of the theme, so the theme version must be updated
+		if !updateTheme && dbCommunity.Theme != nil {
+			updateTheme = true
+			typedCommunity.Theme = dbCommunity.Theme
+		}
 		// check if the current avatar is an internal image
 		avatarID, isInternalAvatar := avatarIDfromURL(dbCommunity.ImageURL)
 		// if is internal delete the current avatar from the database after
@@ -370,5 +391,76 @@ func (v *vocdoniHandler) communitySettingsHandler(msg *apirest.APIdata, ctx *htt
 	if err := v.db.UpdateCommunity(newDBCommuniy); err != nil {
 		return fmt.Errorf("error updating community: %w", err)
 	}
+	// update the theme in the database, it is not stored in the community hub
+	if updateTheme {
+		theme := typedCommunity.Theme
+		if theme != nil {
+			// upload the background image if it is base64 encoded
+			if isBase64Image(theme.BackgroundURL) {
+				backgroundURL, err := v.uploadAvatar("", userFID, uint64(id), theme.BackgroundURL)
+				if err != nil {
+					return fmt.Errorf("cannot upload theme background: %w", err)
+				}
+				theme.BackgroundURL = backgroundURL
+			}
+			theme.Version = uint64(time.Now().UnixMilli())
+		}
+		if err := v.db.SetCommunityTheme(uint64(id), theme); err != nil {
+			return fmt.Errorf("error updating community theme: %w", err)
+		}
+	}
 	return ctx.Send([]byte("ok"), http.StatusOK)
 }
+
+// validateCommunityTheme checks that the colors of the theme provided are
+// valid hex colors.
+func validateCommunityTheme(theme *mongo.CommunityTheme) error {
+	for name, color := range map[string]string{
+		"primaryColor":    theme.PrimaryColor,
+		"secondaryColor":  theme.SecondaryColor,
+		"textColor":       theme.TextColor,
+		"backgroundColor": theme.BackgroundColor,
+	} {
+		if color != "" && !themeColorRgx.MatchString(color) {
+			return fmt.Errorf("invalid theme %s: %s", name, color)
+		}
+	}
+	return nil
+}
+
+// electionTheme returns the image theme of the community of the election with
+// the given ID. If the election does not belong to a community or the
+// community has no theme, it returns nil.
+func (v *vocdoniHandler) electionTheme(electionID types.HexBytes) *imageframe.ImageTheme {
+	dbElection, err := v.db.Election(electionID)
+	if err != nil {
+		return nil
+	}
+	return v.communityTheme(dbElection)
+}
+
+// communityTheme returns the image theme of the community of the election
+// provided. If the election does not belong to a community or the community
+// has no theme, it returns nil.
+func (v *vocdoniHandler) communityTheme(dbElection *mongo.Election) *imageframe.ImageTheme {
+	if dbElection == nil || dbElection.Community == nil {
+		return nil
+	}
+	community, err := v.db.Community(dbElection.Community.ID)
+	if err != nil {
+		log.Warnw("error getting community theme", "err", err, "communityID", dbElection.Community.ID)
+		return nil
+	}
+	if community == nil || community.Theme == nil {
+		return nil
+	}
+	return &imageframe.ImageTheme{
+		Version:         community.Theme.Version,
+		Logo:            community.ImageURL,
+		Background:      community.Theme.BackgroundURL,
+		PrimaryColor:    community.Theme.PrimaryColor,
+		SecondaryColor:  community.Theme.SecondaryColor,
+		TextColor:       community.Theme.TextColor,
+		BackgroundColor: community.Theme.BackgroundColor,
+	}
+}
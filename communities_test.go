@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/mongo"
+)
+
+func TestValidateCommunityTheme(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name  string
+		theme *mongo.CommunityTheme
+		err   string
+	}{
+		{name: "empty", theme: &mongo.CommunityTheme{}},
+		{
+			name: "valid colors",
+			theme: &mongo.CommunityTheme{
+				PrimaryColor:    "#FF0000",
+				SecondaryColor:  "#0f0",
+				TextColor:       "#abcdef",
+				BackgroundColor: "#000",
+				BackgroundURL:   "https://example.com/background.png",
+			},
+		},
+		{name: "no hash", theme: &mongo.CommunityTheme{PrimaryColor: "ff0000"}, err: "invalid theme primaryColor: ff0000"},
+		{name: "named color", theme: &mongo.CommunityTheme{SecondaryColor: "red"}, err: "invalid theme secondaryColor: red"},
+		{name: "bad length", theme: &mongo.CommunityTheme{TextColor: "#abcd"}, err: "invalid theme textColor: #abcd"},
+		{name: "bad digit", theme: &mongo.CommunityTheme{BackgroundColor: "#00000g"}, err: "invalid theme backgroundColor: #00000g"},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			err := validateCommunityTheme(test.theme)
+			if test.err != "" {
+				c.Assert(err, qt.ErrorMatches, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	warpcastAllChannels = "https://api.warpcast.com/v2/all-channels"
+	// channelsIndexInterval is the time between two refreshes of the channels
+	// index metadata.
+	channelsIndexInterval = 6 * time.Hour
+	// channelsStreamRetryDelay is the time to wait before resuming the hub
+	// channel casts stream after an error.
+	channelsStreamRetryDelay = 10 * time.Second
+	// channelsStreamCursor is the name of the stored cursor of the hub
+	// channel casts stream.
+	channelsStreamCursor = "channelCasts"
+	// maxChannelsSearchResults is the maximum number of channels returned when
+	// searching the index.
+	maxChannelsSearchResults = 20
+)
+
+// channelURLRgx matches the parent url of the casts of the channels created
+// in Warpcast, and captures the channel ID.
+var channelURLRgx = regexp.MustCompile(`^https://warpcast\.com/~/channel/([a-z0-9-]+)$`)
+
+// warpcastChannelsResponse is the response from the Warpcast API for the all
+// channels endpoint.
+type warpcastChannelsResponse struct {
+	Result struct {
+		Channels []struct {
+			ID            string `json:"id"`
+			URL           string `json:"url"`
+			Name          string `json:"name"`
+			Description   string `json:"description"`
+			ImageURL      string `json:"imageUrl"`
+			FollowerCount int    `json:"followerCount"`
+		} `json:"channels"`
+	} `json:"result"`
+}
+
+// ChannelCastsStreamer is implemented by the APIs that can stream the authors
+// of the casts published in channels, i.e. the Hub API.
+type ChannelCastsStreamer interface {
+	StreamChannelCasts(ctx context.Context, cursor uint64, events chan<- *farcasterapi.ChannelCastsEvent) error
+}
+
+// ChannelIndex is a local index of the Farcaster channels stored in the
+// database, built from the hub data: the members of a channel are the users
+// that have casted in it, and the channels created in Warpcast are indexed
+// from the parent url of their casts. Optionally, the channels metadata
+// (names, descriptions, images and followers count) is enriched with the
+// public Warpcast API.
+type ChannelIndex struct {
+	db               *mongo.MongoStorage
+	cli              *http.Client
+	warpcastMetadata bool
+	// knownURLs contains the channel parent urls already in the index, only
+	// accessed by the channel casts stream
+	knownURLs map[string]bool
+}
+
+// NewChannelIndex returns a new ChannelIndex instance backed by the database
+// provided. If warpcastMetadata is true, the channels metadata is refreshed
+// from the Warpcast API.
+func NewChannelIndex(db *mongo.MongoStorage, warpcastMetadata bool) *ChannelIndex {
+	return &ChannelIndex{
+		db:               db,
+		cli:              &http.Client{Timeout: 30 * time.Second},
+		warpcastMetadata: warpcastMetadata,
+		knownURLs:        make(map[string]bool),
+	}
+}
+
+// Channel returns the channel with the given ID. If the channel is not in
+// the index, it returns farcasterapi.ErrChannelNotFound. If the followers of
+// the channel are unknown, the number of known members is returned instead.
+func (c *ChannelIndex) Channel(channelID string) (*farcasterapi.Channel, error) {
+	channel, err := c.channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if channel.Followers == 0 {
+		members, err := c.db.CountChannelMembers(channel.URL)
+		if err != nil {
+			log.Warnw("failed to count channel members", "channel", channelID, "error", err)
+		}
+		channel.Followers = int(members)
+	}
+	return channelFromDB(channel), nil
+}
+
+// SearchChannels returns the channels of the index whose ID or name contains
+// the query provided.
+func (c *ChannelIndex) SearchChannels(query string) ([]*farcasterapi.Channel, error) {
+	channels, err := c.db.SearchChannels(query, maxChannelsSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	result := []*farcasterapi.Channel{}
+	for _, channel := range channels {
+		result = append(result, channelFromDB(channel))
+	}
+	return result, nil
+}
+
+// ChannelMembers returns the FIDs of the known members of the channel with
+// the given ID, which are the users that have casted in the channel since the
+// index was started.
+func (c *ChannelIndex) ChannelMembers(channelID string) ([]uint64, error) {
+	channel, err := c.channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return c.db.ChannelMembers(channel.URL)
+}
+
+// Run starts the channels index processes in the background: the stream of
+// the channel casts of the hub provided, which adds the channels and members
+// found, resuming it from the stored cursor, and the refresh of the channels
+// metadata from the Warpcast API, if it is enabled.
+func (c *ChannelIndex) Run(ctx context.Context, hub ChannelCastsStreamer) {
+	go c.streamChannelCasts(ctx, hub)
+	if !c.warpcastMetadata {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(channelsIndexInterval)
+		defer ticker.Stop()
+		for {
+			if err := c.refreshChannels(); err != nil {
+				log.Warnw("failed to refresh channels index", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// streamChannelCasts indexes the channel casts streamed by the hub until the
+// context is done. The stream is resumed from the stored cursor, which is
+// updated once the casts of every event are indexed.
+func (c *ChannelIndex) streamChannelCasts(ctx context.Context, hub ChannelCastsStreamer) {
+	for {
+		cursor, err := c.db.StreamCursor(channelsStreamCursor)
+		if err != nil {
+			log.Warnw("failed to get channel casts cursor", "error", err)
+		}
+		events := make(chan *farcasterapi.ChannelCastsEvent)
+		streamCtx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- hub.StreamChannelCasts(streamCtx, cursor, events)
+		}()
+	stream:
+		for {
+			select {
+			case event := <-events:
+				if err := c.indexChannelCasts(event); err != nil {
+					// stop the stream to resume it from the last event indexed
+					log.Warnw("failed to index channel casts", "error", err)
+					break stream
+				}
+			case err := <-errCh:
+				if err != nil {
+					log.Warnw("channel casts stream stopped", "error", err)
+				}
+				break stream
+			}
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(channelsStreamRetryDelay):
+		}
+	}
+}
+
+// indexChannelCasts adds the authors of the event provided to the members of
+// the channels, by parent url, and the channels created in Warpcast that are
+// not in the index yet. Then it stores the cursor of the event.
+func (c *ChannelIndex) indexChannelCasts(event *farcasterapi.ChannelCastsEvent) error {
+	for url, fids := range event.Authors {
+		if err := c.addChannelURL(url); err != nil {
+			return err
+		}
+		if err := c.db.AddChannelMembers(url, fids); err != nil {
+			return err
+		}
+	}
+	return c.db.SetStreamCursor(channelsStreamCursor, event.Cursor)
+}
+
+// addChannelURL adds the channel of the parent url provided to the index if
+// it is a Warpcast channel url and the channel is not in the index yet. The
+// channels of other urls are added by the Warpcast metadata refresh, if it
+// is enabled.
+func (c *ChannelIndex) addChannelURL(url string) error {
+	if c.knownURLs[url] {
+		return nil
+	}
+	match := channelURLRgx.FindStringSubmatch(url)
+	if match == nil {
+		return nil
+	}
+	if _, err := c.db.ChannelByURL(url); err != nil {
+		if !errors.Is(err, mongo.ErrChannelUnknown) {
+			return err
+		}
+		if err := c.db.UpsertChannel(&mongo.Channel{ID: match[1], Name: match[1], URL: url}); err != nil {
+			return err
+		}
+	}
+	c.knownURLs[url] = true
+	return nil
+}
+
+// channel returns the channel with the given ID from the index. If it is not
+// in the index, it returns farcasterapi.ErrChannelNotFound.
+func (c *ChannelIndex) channel(channelID string) (*mongo.Channel, error) {
+	channel, err := c.db.Channel(channelID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrChannelUnknown) {
+			return nil, farcasterapi.ErrChannelNotFound
+		}
+		return nil, err
+	}
+	return channel, nil
+}
+
+// refreshChannels downloads the list of channels from the Warpcast API and
+// updates their metadata in the index.
+func (c *ChannelIndex) refreshChannels() error {
+	data, err := c.get(warpcastAllChannels)
+	if err != nil {
+		return fmt.Errorf("failed to get channels: %w", err)
+	}
+	var channels warpcastChannelsResponse
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return fmt.Errorf("failed to unmarshal channels: %w", err)
+	}
+	for _, ch := range channels.Result.Channels {
+		if err := c.db.UpsertChannel(&mongo.Channel{
+			ID:          ch.ID,
+			Name:        ch.Name,
+			Description: ch.Description,
+			URL:         ch.URL,
+			Image:       ch.ImageURL,
+			Followers:   ch.FollowerCount,
+		}); err != nil {
+			log.Warnw("failed to update channel", "channel", ch.ID, "error", err)
+		}
+	}
+	log.Infow("channels index refreshed", "channels", len(channels.Result.Channels))
+	return nil
+}
+
+func (c *ChannelIndex) get(uri string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func channelFromDB(channel *mongo.Channel) *farcasterapi.Channel {
+	return &farcasterapi.Channel{
+		ID:          channel.ID,
+		Name:        channel.Name,
+		Description: channel.Description,
+		Followers:   channel.Followers,
+		Image:       channel.Image,
+		URL:         channel.URL,
+	}
+}
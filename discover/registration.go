@@ -0,0 +1,81 @@
+package discover
+
+import (
+	"context"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// registrationSyncBatchSize is the number of users synced by iteration.
+	registrationSyncBatchSize = 100
+	// registrationRetryDelay is the delay before retrying the sync of the
+	// registration of a user after a failure.
+	registrationRetryDelay = time.Hour
+)
+
+// RegistrationProvider returns the time when a FID was registered in the
+// Farcaster IdRegistry.
+type RegistrationProvider interface {
+	RegistrationTime(ctx context.Context, fid uint64) (time.Time, error)
+}
+
+// RegistrationSync is a service that stores the registration time of the users
+// in the database, so the censuses can be filtered by account age. The
+// registration time of a FID never changes, so every user is synced once.
+type RegistrationSync struct {
+	db       *mongo.MongoStorage
+	provider RegistrationProvider
+}
+
+// NewRegistrationSync returns a new RegistrationSync instance that uses the
+// provider given to get the registration time of the users in the database.
+func NewRegistrationSync(db *mongo.MongoStorage, provider RegistrationProvider) *RegistrationSync {
+	return &RegistrationSync{
+		db:       db,
+		provider: provider,
+	}
+}
+
+// Run starts the registration sync process in the background. If the sync
+// of a user fails, it is retried after registrationRetryDelay.
+func (s *RegistrationSync) Run(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				users, err := s.db.UsersWithoutRegistration(registrationSyncBatchSize)
+				if err != nil {
+					log.Warnw("failed to get users without registration", "error", err)
+					time.Sleep(Throttle * 10)
+					continue
+				}
+				if len(users) == 0 {
+					// no pending users, wait a bit more
+					time.Sleep(Throttle * 50)
+					continue
+				}
+				for _, fid := range users {
+					if ctx.Err() != nil {
+						return
+					}
+					time.Sleep(Throttle)
+					registeredAt, err := s.provider.RegistrationTime(ctx, fid)
+					if err == nil {
+						err = s.db.SetUserRegistration(fid, registeredAt)
+					}
+					if err != nil {
+						log.Warnw("failed to sync user registration", "fid", fid, "retryIn", registrationRetryDelay, "error", err)
+						if err := s.db.DelayUserRegistrationSync(fid, time.Now().Add(registrationRetryDelay)); err != nil {
+							log.Warnw("failed to delay user registration sync", "fid", fid, "error", err)
+						}
+					}
+				}
+			}
+		}
+	}()
+}
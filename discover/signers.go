@@ -0,0 +1,185 @@
+package discover
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi/web3"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// signersSyncInterval is the minimum time between two syncs of the
+	// signers of the same user.
+	signersSyncInterval = 24 * time.Hour
+	// signersSyncBatchSize is the number of users synced by iteration.
+	signersSyncBatchSize = 100
+	// signersRetryBaseDelay is the delay before retrying the sync of the
+	// signers of a user after the first failure, it doubles after every
+	// consecutive failure up to signersSyncInterval.
+	signersRetryBaseDelay = 10 * time.Minute
+)
+
+// SignerSync is a service that reconciles the signers (app keys) of the users
+// stored in the database with the Farcaster KeyRegistry contract. The keys
+// added to the registry are stored as the user signers and the removed ones
+// as revoked signers, so they can be excluded from the censuses.
+type SignerSync struct {
+	db          *mongo.MongoStorage
+	keyRegistry *web3.FarcasterProvider
+}
+
+// NewSignerSync returns a new SignerSync instance that uses the KeyRegistry
+// provider given to sync the signers of the users in the database.
+func NewSignerSync(db *mongo.MongoStorage, keyRegistry *web3.FarcasterProvider) *SignerSync {
+	return &SignerSync{
+		db:          db,
+		keyRegistry: keyRegistry,
+	}
+}
+
+// SignersFromFID returns the signers of the given FID that are currently added
+// to the KeyRegistry. If the user is in the database, its signers are also
+// reconciled with the registry.
+func (s *SignerSync) SignersFromFID(fid uint64) ([]string, error) {
+	signers, revoked, err := s.registrySigners(fid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.UpdateUserSigners(fid, signers, revoked); err != nil && !errors.Is(err, mongo.ErrUserUnknown) {
+		log.Warnw("failed to update user signers", "fid", fid, "error", err)
+	}
+	return signers, nil
+}
+
+// SyncUser reconciles the signers of the user with the given FID with the
+// KeyRegistry. The signers stored that are no longer added to the registry are
+// marked as revoked.
+func (s *SignerSync) SyncUser(fid uint64) error {
+	user, err := s.db.User(fid)
+	if err != nil {
+		return fmt.Errorf("failed to get user from database: %w", err)
+	}
+	signers, revoked, err := s.registrySigners(fid)
+	if err != nil {
+		return err
+	}
+	added := make(map[string]bool, len(signers))
+	for _, signer := range signers {
+		added[signer] = true
+	}
+	revokedMap := make(map[string]bool, len(revoked))
+	for _, signer := range revoked {
+		revokedMap[signer] = true
+	}
+	newSigners, removedSigners := 0, 0
+	for _, signer := range signers {
+		if !containsSigner(user.Signers, signer) {
+			newSigners++
+		}
+	}
+	for _, signer := range user.Signers {
+		signer = mongo.NormalizeSigner(signer)
+		if added[signer] {
+			continue
+		}
+		removedSigners++
+		if !revokedMap[signer] {
+			revokedMap[signer] = true
+			revoked = append(revoked, signer)
+		}
+	}
+	if newSigners > 0 || removedSigners > 0 {
+		log.Debugw("user signers changed", "fid", fid, "added", newSigners, "removed", removedSigners)
+	}
+	return s.db.UpdateUserSigners(fid, signers, revoked)
+}
+
+// Run starts the signers sync process in the background. Users whose signers
+// have not been synced during the last signersSyncInterval are synced first.
+// If the sync of a user fails, it is retried with an exponential backoff.
+func (s *SignerSync) Run(ctx context.Context) {
+	go func() {
+		// consecutive sync failures by FID
+		failures := make(map[uint64]int)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				users, err := s.db.UsersWithOutdatedSigners(time.Now().Add(-signersSyncInterval), signersSyncBatchSize)
+				if err != nil {
+					log.Warnw("failed to get users with outdated signers", "error", err)
+					time.Sleep(Throttle * 10)
+					continue
+				}
+				if len(users) == 0 {
+					// no outdated users, wait a bit more
+					time.Sleep(Throttle * 50)
+					continue
+				}
+				for _, fid := range users {
+					time.Sleep(Throttle)
+					if err := s.SyncUser(fid); err != nil {
+						// delay the next sync of the user, so the failing
+						// users do not block the rest of the batch
+						failures[fid]++
+						delay := signersRetryDelay(failures[fid])
+						log.Warnw("failed to sync user signers", "fid", fid, "retryIn", delay, "error", err)
+						if err := s.db.DelayUserSignersSync(fid, time.Now().Add(delay)); err != nil {
+							log.Warnw("failed to delay user signers sync", "fid", fid, "error", err)
+						}
+						continue
+					}
+					delete(failures, fid)
+				}
+			}
+		}
+	}()
+}
+
+// registrySigners returns the added and removed keys of the given FID in the
+// KeyRegistry, hex encoded.
+func (s *SignerSync) registrySigners(fid uint64) ([]string, []string, error) {
+	added, err := s.keyRegistry.GetAppKeysByFid(new(big.Int).SetUint64(fid))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting signers: %w", err)
+	}
+	removed, err := s.keyRegistry.GetRemovedAppKeysByFid(new(big.Int).SetUint64(fid))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting revoked signers: %w", err)
+	}
+	return encodeSigners(added), encodeSigners(removed), nil
+}
+
+// signersRetryDelay returns the delay before retrying the sync of the signers
+// of a user after the given number of consecutive failures.
+func signersRetryDelay(failures int) time.Duration {
+	delay := signersRetryBaseDelay
+	for i := 1; i < failures && delay < signersSyncInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, signersSyncInterval)
+}
+
+func encodeSigners(keys [][]byte) []string {
+	signers := []string{}
+	for _, key := range keys {
+		signers = append(signers, hex.EncodeToString(key))
+	}
+	return signers
+}
+
+func containsSigner(signers []string, signer string) bool {
+	for _, s := range signers {
+		if mongo.NormalizeSigner(s) == signer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package discover
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSignersRetryDelay(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(signersRetryDelay(0), qt.Equals, signersRetryBaseDelay)
+	c.Assert(signersRetryDelay(1), qt.Equals, signersRetryBaseDelay)
+	c.Assert(signersRetryDelay(2), qt.Equals, 2*signersRetryBaseDelay)
+	c.Assert(signersRetryDelay(3), qt.Equals, 4*signersRetryBaseDelay)
+	// the delay is capped to the sync interval
+	c.Assert(signersRetryDelay(100), qt.Equals, signersSyncInterval)
+	for failures := 1; failures < 20; failures++ {
+		c.Assert(signersRetryDelay(failures) <= signersSyncInterval, qt.IsTrue)
+		c.Assert(signersRetryDelay(failures+1) >= signersRetryDelay(failures), qt.IsTrue)
+	}
+}
+
+func TestSigners(t *testing.T) {
+	c := qt.New(t)
+	signers := encodeSigners([][]byte{{0xab, 0x01}, {0xcd}})
+	c.Assert(signers, qt.DeepEquals, []string{"ab01", "cd"})
+	c.Assert(encodeSigners(nil), qt.DeepEquals, []string{})
+
+	// the stored signers can be prefixed and uppercase
+	stored := []string{"0xAB01", "ef"}
+	c.Assert(containsSigner(stored, "ab01"), qt.IsTrue)
+	c.Assert(containsSigner(stored, "ef"), qt.IsTrue)
+	c.Assert(containsSigner(stored, "cd"), qt.IsFalse)
+	c.Assert(containsSigner(nil, "cd"), qt.IsFalse)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/helpers"
+	"github.com/vocdoni/vote-frame/vocdonimock"
+	"go.vocdoni.io/dvote/apiclient"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	farcasterpb "go.vocdoni.io/dvote/vochain/transaction/proofs/farcasterproof/proto"
+	"google.golang.org/protobuf/proto"
+	"lukechampine.com/blake3"
+)
+
+// testVoter is a Farcaster user with the signer key used to sign the frame
+// actions.
+type testVoter struct {
+	fid    uint64
+	weight int64
+	key    ed25519.PrivateKey
+}
+
+func newTestVoter(c *qt.C, fid uint64, weight int64) *testVoter {
+	_, key, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.IsNil)
+	return &testVoter{fid: fid, weight: weight, key: key}
+}
+
+func (v *testVoter) participant() *FarcasterParticipant {
+	return &FarcasterParticipant{
+		PubKey:   v.key.Public().(ed25519.PublicKey),
+		Weight:   big.NewInt(v.weight),
+		Username: fmt.Sprintf("voter%d", v.fid),
+		FID:      v.fid,
+	}
+}
+
+// framePacket returns the frame signature packet of the voter pressing the
+// given button of the poll frame of the election provided.
+func (v *testVoter) framePacket(c *qt.C, electionID types.HexBytes, button uint32) *FrameSignaturePacket {
+	data := &farcasterpb.MessageData{
+		Type:      farcasterpb.MessageType_MESSAGE_TYPE_FRAME_ACTION,
+		Fid:       v.fid,
+		Timestamp: uint32(time.Now().Unix()),
+		Network:   farcasterpb.FarcasterNetwork_FARCASTER_NETWORK_MAINNET,
+		Body: &farcasterpb.MessageData_FrameActionBody{
+			FrameActionBody: &farcasterpb.FrameActionBody{
+				Url:         []byte(fmt.Sprintf("%s/vote/%x", serverURL, electionID)),
+				ButtonIndex: button,
+				CastId:      &farcasterpb.CastId{Fid: 1, Hash: util.RandomBytes(20)},
+			},
+		},
+	}
+	dataBytes, err := proto.Marshal(data)
+	c.Assert(err, qt.IsNil)
+	h := blake3.New(160, nil)
+	_, err = h.Write(dataBytes)
+	c.Assert(err, qt.IsNil)
+	hash := h.Sum(nil)[:frameHashSize]
+	messageBytes, err := proto.Marshal(&farcasterpb.Message{
+		Data:            data,
+		Hash:            hash,
+		HashScheme:      farcasterpb.HashScheme_HASH_SCHEME_BLAKE3,
+		Signature:       ed25519.Sign(v.key, hash),
+		SignatureScheme: farcasterpb.SignatureScheme_SIGNATURE_SCHEME_ED25519,
+		Signer:          v.key.Public().(ed25519.PublicKey),
+	})
+	c.Assert(err, qt.IsNil)
+
+	packet := &FrameSignaturePacket{}
+	packet.UntrustedData.FID = int64(v.fid)
+	packet.UntrustedData.ButtonIndex = int(button)
+	packet.TrustedData.MessageBytes = hex.EncodeToString(messageBytes)
+	return packet
+}
+
+func TestEndToEndPoll(t *testing.T) {
+	c := qt.New(t)
+
+	// start the fake Vocdoni API and connect to it
+	vocdoniAPI, err := vocdonimock.New(t.TempDir())
+	c.Assert(err, qt.IsNil)
+	defer vocdoniAPI.Close()
+	server := httptest.NewServer(vocdoniAPI)
+	defer server.Close()
+	cli, err := apiclient.New(server.URL + vocdonimock.APIPath)
+	c.Assert(err, qt.IsNil)
+	c.Assert(cli.SetAccount(util.RandomHex(32)), qt.IsNil)
+	c.Assert(ensureAccountExist(cli), qt.IsNil)
+
+	// build the census with the voters, the last one votes after the end
+	voters := []*testVoter{
+		newTestVoter(c, 100, 1),
+		newTestVoter(c, 101, 2),
+		newTestVoter(c, 102, 3),
+		newTestVoter(c, 103, 4),
+	}
+	participants := []*FarcasterParticipant{}
+	for _, voter := range voters {
+		participants = append(participants, voter.participant())
+	}
+	census, err := CreateCensus(cli, participants, FrameCensusTypeCSV, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(census.Size, qt.Equals, uint64(len(voters)))
+	c.Assert(census.Type, qt.Equals, FrameCensusTypeCSV)
+
+	// create the poll
+	electionID, err := createElection(cli, &ElectionDescription{
+		Question: "What is your favourite colour?",
+		Options:  []string{"Red", "Blue", "Green"},
+		Duration: time.Hour,
+	}, census)
+	c.Assert(err, qt.IsNil)
+	election, err := waitForElection(cli, electionID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(election.Metadata.Title["default"], qt.Equals, "What is your favourite colour?")
+	c.Assert(election.Census.CensusRoot, qt.DeepEquals, census.Root)
+	c.Assert(election.FinalResults, qt.IsFalse)
+
+	// cast the votes: Red (1) and Blue (2 + 3)
+	for i, button := range []int{1, 2, 2} {
+		packet := voters[i].framePacket(c, electionID, uint32(button))
+		nullifier, _, fid, weight, err := vote(packet, electionID, election.Census.CensusRoot, cli)
+		c.Assert(err, qt.IsNil)
+		c.Assert(nullifier, qt.Not(qt.HasLen), 0)
+		c.Assert(fid, qt.Equals, voters[i].fid)
+		c.Assert(weight.Int64(), qt.Equals, voters[i].weight)
+	}
+	count, err := cli.ElectionVoteCount(electionID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(count, qt.Equals, uint32(3))
+
+	// a voter can not vote twice
+	packet := voters[0].framePacket(c, electionID, 3)
+	_, _, _, _, err = vote(packet, electionID, election.Census.CensusRoot, cli)
+	c.Assert(err, qt.ErrorIs, ErrAlreadyVoted)
+
+	// a user out of the census can not vote
+	packet = newTestVoter(c, 200, 1).framePacket(c, electionID, 1)
+	_, _, _, _, err = vote(packet, electionID, election.Census.CensusRoot, cli)
+	c.Assert(err, qt.ErrorIs, ErrNotInCensus)
+
+	// a frame action with an invalid signature is rejected
+	packet = voters[3].framePacket(c, electionID, 1)
+	messageBytes, err := hex.DecodeString(packet.TrustedData.MessageBytes)
+	c.Assert(err, qt.IsNil)
+	messageBytes[len(messageBytes)-1] ^= 0xff
+	packet.TrustedData.MessageBytes = hex.EncodeToString(messageBytes)
+	_, _, _, _, err = vote(packet, electionID, election.Census.CensusRoot, cli)
+	c.Assert(err, qt.ErrorIs, ErrFrameSignature)
+
+	// end the poll and check the final results
+	vocdoniAPI.Advance(time.Hour)
+	election, err = cli.Election(electionID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(election.FinalResults, qt.IsTrue)
+	c.Assert(election.VoteCount, qt.Equals, uint64(3))
+	choices, results := helpers.ExtractResults(election, 0)
+	c.Assert(choices, qt.DeepEquals, []string{"Red", "Blue", "Green"})
+	c.Assert(helpers.BigIntsToStrings(results), qt.DeepEquals, []string{"1", "5", "0"})
+
+	// the votes are not accepted once the poll has ended
+	packet = voters[3].framePacket(c, electionID, 1)
+	_, _, _, _, err = vote(packet, electionID, election.Census.CensusRoot, cli)
+	c.Assert(err, qt.ErrorMatches, ".*not accepting votes.*")
+}
@@ -24,6 +24,7 @@ import (
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
 )
 
 const (
@@ -99,6 +100,14 @@ func (v *vocdoniHandler) createElection(msg *apirest.APIdata, ctx *httprouter.HT
 			return fmt.Errorf("community does not allow notifications")
 		}
 	}
+	// use the saved census selected by the user, if any
+	var savedCensus *mongo.Census
+	if req.Census == nil && len(req.SavedCensusID) > 0 {
+		req.Census, savedCensus, err = v.savedCensusForElection(req.SavedCensusID, fid)
+		if err != nil {
+			return ctx.Send([]byte(fmt.Sprintf("cannot use saved census: %v", err)), http.StatusBadRequest)
+		}
+	}
 	// use the request census or use the one hardcoded for all farcaster users
 	census := req.Census
 	if census == nil {
@@ -114,6 +123,10 @@ func (v *vocdoniHandler) createElection(msg *apirest.APIdata, ctx *httprouter.HT
 			return fmt.Errorf("election duration too long")
 		}
 	}
+	// check the results image settings if provided
+	if err := imageframe.ValidateResultsImageSettings(req.ResultsImage); err != nil {
+		return ctx.Send([]byte(err.Error()), http.StatusBadRequest)
+	}
 	// create the election description
 	req.ElectionDescription.UsersCount = uint32(len(census.Usernames))
 	req.ElectionDescription.UsersCountInitial = uint32(census.FromTotalAddresses)
@@ -124,17 +137,41 @@ func (v *vocdoniHandler) createElection(msg *apirest.APIdata, ctx *httprouter.HT
 	if err != nil {
 		return fmt.Errorf("failed to create election: %v", err)
 	}
-	// set the electionID for the census root previously stored on the database (if any).
-	if req.Census != nil && req.Census.Root != nil {
-		if err := v.db.SetElectionIdForCensusRoot(req.Census.Root, electionID); err != nil {
-			log.Errorw(err, fmt.Sprintf("failed to set electionID for census root %s", req.Census.Root))
+	// set the electionID for the census previously stored on the database (if
+	// any), by its ID or, if the request does not include it, by its root
+	if savedCensus != nil {
+		if err := v.assignSavedCensus(savedCensus, fid, electionID); err != nil {
+			log.Errorw(err, fmt.Sprintf("failed to copy saved census %s", savedCensus.CensusID))
 		}
+	} else if req.Census != nil && req.Census.Root != nil {
+		v.assignCensus(req.Census, electionID)
 	}
 	// return the electionID
 	ctx.SetResponseContentType("application/json")
 	return ctx.Send([]byte(electionID.String()), http.StatusOK)
 }
 
+// assignCensus assigns the election with the given ID to the census stored in
+// the database that it uses, by the census ID or, if the census information
+// does not include it, by the census root.
+func (v *vocdoniHandler) assignCensus(census *CensusInfo, electionID types.HexBytes) {
+	var err error
+	if len(census.CensusID) > 0 {
+		err = v.db.SetElectionIdForCensus(census.CensusID, census.Root, electionID)
+	} else {
+		err = v.db.SetElectionIdForCensusRoot(census.Root, electionID)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrCensusUnknown) {
+			log.Debugw("no census to assign to the election", "censusID", census.CensusID.String(),
+				"root", census.Root.String(), "electionID", electionID.String())
+			return
+		}
+		log.Warnw("failed to set electionID for census", "censusID", census.CensusID.String(),
+			"root", census.Root.String(), "electionID", electionID.String(), "error", err)
+	}
+}
+
 func (v *vocdoniHandler) showElection(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
 	if err != nil {
@@ -156,7 +193,7 @@ func (v *vocdoniHandler) showElection(msg *apirest.APIdata, ctx *httprouter.HTTP
 	if err != nil {
 		return fmt.Errorf("failed to fetch election: %w", err)
 	}
-	png, err := imageframe.QuestionImage(election)
+	png, err := imageframe.QuestionImage(election, v.electionTheme(election.ElectionID))
 	if err != nil {
 		return fmt.Errorf("failed to generate image: %v", err)
 	}
@@ -355,6 +392,9 @@ func (v *vocdoniHandler) electionFullInfo(msg *apirest.APIdata, ctx *httprouter.
 		Votes:                   results.Votes,
 		Finalized:               results.Finalized,
 		Community:               dbElection.Community,
+		ResultsImage:            dbElection.ResultsImage,
+		CensusSnapshot:          census.Snapshot,
+		CensusWeightStrategy:    census.WeightStrategy,
 	}
 
 	jresponse, err := json.Marshal(map[string]any{
@@ -382,9 +422,14 @@ func newElectionDescription(description *ElectionDescription, census *CensusInfo
 		size = uint64(maxElectionSize)
 	}
 
+	electionDescription := "this is a farcaster frame poll"
+	if description.Description != "" {
+		electionDescription = description.Description
+	}
+
 	return &api.ElectionDescription{
 		Title:       map[string]string{"default": description.Question},
-		Description: map[string]string{"default": "this is a farcaster frame poll"},
+		Description: map[string]string{"default": electionDescription},
 		EndDate:     time.Now().Add(description.Duration),
 
 		Questions: []api.Question{
@@ -397,6 +442,8 @@ func newElectionDescription(description *ElectionDescription, census *CensusInfo
 
 		ElectionType: api.ElectionType{
 			Autostart: true,
+			// the polls can be closed early by their creators through the bot
+			Interruptible: true,
 		},
 		VoteType: api.VoteType{
 			MaxVoteOverwrites: func() int {
@@ -514,6 +561,11 @@ func (v *vocdoniHandler) createAndSaveElectionAndProfile(desc *ElectionDescripti
 			desc.UsersCountInitial, communityID); err != nil {
 			return fmt.Errorf("failed to save election and profile: %w", err)
 		}
+		if desc.ResultsImage != nil {
+			if err := v.db.SetElectionResultsImage(electionID, desc.ResultsImage); err != nil {
+				return fmt.Errorf("failed to save results image settings: %w", err)
+			}
+		}
 		if notify {
 			if len(census.Usernames) > MaxUsersToNotify {
 				return fmt.Errorf("census too large to notify users but election has been created successfully")
@@ -651,6 +703,23 @@ func (v *vocdoniHandler) finalizeElectionsAtBackround(ctx context.Context) {
 	}
 }
 
+// closeElection ends the election with the given ID before its end date. It
+// updates the end time stored and the partial results of the election, so the
+// results are finalized in background by finalizeElectionsAtBackround once
+// the final results are available.
+func (v *vocdoniHandler) closeElection(electionID types.HexBytes) error {
+	if _, err := v.cli.SetElectionStatus(electionID, models.ProcessStatus_ENDED.String()); err != nil {
+		return fmt.Errorf("error closing election %x: %w", electionID, err)
+	}
+	if err := v.db.SetElectionEndTime(electionID, time.Now()); err != nil {
+		return fmt.Errorf("error updating end time of election %x: %w", electionID, err)
+	}
+	if _, err := v.updateAndFetchResultsFromDatabase(electionID, nil); err != nil {
+		return fmt.Errorf("error updating results of election %x: %w", electionID, err)
+	}
+	return nil
+}
+
 // remindersHandler returns the remindable voters and the number of already
 // reminded voters of an election. It requires the user to be the owner of the
 // election.
@@ -743,21 +812,6 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 	if err != nil {
 		return ctx.Send([]byte(err.Error()), apirest.HTTPstatusNotFound)
 	}
-	// get access profile to use the warpcast api key of the current user
-	accessProfile, err := v.db.UserAccessProfile(auth.UserID)
-	if err != nil {
-		return ctx.Send([]byte(err.Error()), http.StatusInternalServerError)
-	}
-	// check if the user has a configured warpcast api key
-	if accessProfile == nil || accessProfile.WarpcastAPIKey == "" {
-		return ctx.Send([]byte("no warpcast api key configured"), http.StatusBadRequest)
-	}
-	// init warpcast client to send the reminders with the user warpcast api key
-	warpcastClient := warpcast.NewWarpcastAPI()
-	if err := warpcastClient.SetFarcasterUser(auth.UserID, accessProfile.WarpcastAPIKey); err != nil {
-		log.Warnw("failed to initialize warpcast client", "error", err)
-		return ctx.Send([]byte("failed to initialize warpcast client: "+err.Error()), http.StatusInternalServerError)
-	}
 	// get the election id from the url params
 	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
 	if err != nil {
@@ -785,6 +839,56 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 	if err := json.Unmarshal(msg.Data, req); err != nil {
 		return fmt.Errorf("failed to unmarshal reminders request: %w", err)
 	}
+	taskID, err := v.queueReminders(auth.UserID, election, req)
+	if err != nil {
+		var reqErr remindersRequestError
+		if errors.As(err, &reqErr) {
+			return ctx.Send([]byte(reqErr), http.StatusBadRequest)
+		}
+		return err
+	}
+	res, err := json.Marshal(&ReminderResponse{
+		QueueID: taskID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal reminders response: %w", err)
+	}
+	return ctx.Send(res, http.StatusOK)
+}
+
+// remindersRequestError is the error returned by queueReminders when the
+// reminders request can not be sent, i.e. it is invalid or it exceeds the
+// limits of the user.
+type remindersRequestError string
+
+func (e remindersRequestError) Error() string {
+	return string(e)
+}
+
+// queueReminders sends the reminders of the request provided to the voters of
+// the election in background, using the warpcast api key of the user with the
+// given FID. It returns the ID of the background task that sends them. The
+// caller must check that the user is allowed to send the reminders.
+func (v *vocdoniHandler) queueReminders(userID uint64, election *mongo.Election, req *ReminderRequest) (string, error) {
+	// get access profile to use the warpcast api key of the current user
+	accessProfile, err := v.db.UserAccessProfile(userID)
+	if err != nil {
+		return "", err
+	}
+	// check if the user has a configured warpcast api key
+	if accessProfile == nil || accessProfile.WarpcastAPIKey == "" {
+		return "", remindersRequestError("no warpcast api key configured")
+	}
+	// init warpcast client to send the reminders with the user warpcast api key
+	warpcastClient := warpcast.NewWarpcastAPI()
+	if err := warpcastClient.SetFarcasterUser(userID, accessProfile.WarpcastAPIKey); err != nil {
+		log.Warnw("failed to initialize warpcast client", "error", err)
+		return "", fmt.Errorf("failed to initialize warpcast client: %w", err)
+	}
+	electionID, err := hex.DecodeString(election.ElectionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode electionID: %w", err)
+	}
 	usersToRemind := map[uint64]string{}
 	switch req.Type {
 	case RankedRemindersType:
@@ -792,16 +896,16 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 		// to remind from the request, and get the list of users to remind by weight
 		// from the database limited to that number
 		if req.NumberOfUsers == 0 {
-			return ctx.Send([]byte("missing number of users to remind"), http.StatusBadRequest)
+			return "", remindersRequestError("missing number of users to remind")
 		}
 		participants, err := v.db.ParticipantsByWeight(electionID, req.NumberOfUsers)
 		if err != nil {
-			return fmt.Errorf("failed to get participants by weight: %w", err)
+			return "", fmt.Errorf("failed to get participants by weight: %w", err)
 		}
 		for username := range participants {
 			user, err := v.db.UserByUsername(username)
 			if err != nil {
-				return fmt.Errorf("failed to get user by username: %w", err)
+				return "", fmt.Errorf("failed to get user by username: %w", err)
 			}
 			usersToRemind[user.UserID] = username
 		}
@@ -809,25 +913,23 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 		// if the reminder is for a individual users, get the list of users fids to
 		// remind from the request
 		if len(req.Users) == 0 {
-			return ctx.Send([]byte("no users to remind"), http.StatusBadRequest)
+			return "", remindersRequestError("no users to remind")
 		}
 		usersToRemind = req.Users
 	default:
-		return ctx.Send([]byte("invalid reminder type"), http.StatusBadRequest)
+		return "", remindersRequestError("invalid reminder type")
 	}
 	// get the remindable users to check if the users to remind are remindable
 	remindableUsers, alreadySent, err := v.db.RemindersOfElection(electionID)
 	if err != nil {
-		return fmt.Errorf("failed to get voters of election: %w", err)
+		return "", fmt.Errorf("failed to get voters of election: %w", err)
 	}
-	maxDMs := v.db.MaxDirectMessages(auth.UserID, maxDirectMessages)
+	maxDMs := v.db.MaxDirectMessages(userID, maxDirectMessages)
 	if uint32(len(remindableUsers)) > maxDMs {
-		msg := fmt.Sprintf("too many users to remind, by your reputation you only can sent %d reminds", maxDMs)
-		return ctx.Send([]byte(msg), http.StatusBadRequest)
+		return "", remindersRequestError(fmt.Sprintf("too many users to remind, by your reputation you only can sent %d reminds", maxDMs))
 	}
 	if alreadySent >= maxDMs {
-		msg := fmt.Sprintf("you have already sent the maximum number of reminders (%d)", maxDMs)
-		return ctx.Send([]byte(msg), http.StatusBadRequest)
+		return "", remindersRequestError(fmt.Sprintf("you have already sent the maximum number of reminders (%d)", maxDMs))
 	}
 	// send the reminders to the users in background
 	taskID := util.RandomHex(16)
@@ -853,7 +955,7 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 			log.Debugw("sending direct message reminder",
 				"content", string(req.Content),
 				"to", fid,
-				"from", auth.UserID)
+				"from", userID)
 			if err := warpcastClient.DirectMessage(ctx, req.Content, fid); err != nil {
 				log.Warnw("failed to send direct notification", "error", err, "fid", fid, "username", username)
 				currentStatus.Fails[username] = err.Error()
@@ -873,13 +975,7 @@ func (v *vocdoniHandler) sendRemindersHandler(msg *apirest.APIdata, ctx *httprou
 		currentStatus.Completed = true
 		v.backgroundQueue.Store(taskID, currentStatus)
 	}()
-	res, err := json.Marshal(&ReminderResponse{
-		QueueID: taskID,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal reminders response: %w", err)
-	}
-	return ctx.Send(res, http.StatusOK)
+	return taskID, nil
 }
 
 func (v *vocdoniHandler) remindersQueueHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -8,6 +8,13 @@ import (
 // MaxCastBytes is the maximum number of bytes that a cast can have.
 const MaxCastBytes = 350
 
+const (
+	// ReactionTypeLike is the reaction type of the likes to a cast.
+	ReactionTypeLike = "like"
+	// ReactionTypeRecast is the reaction type of the recasts of a cast.
+	ReactionTypeRecast = "recast"
+)
+
 var (
 	// ErrNoDataFound is returned when there is no data found.
 	ErrNoDataFound = fmt.Errorf("no data found")
@@ -15,6 +22,9 @@ var (
 	ErrNoNewCasts = fmt.Errorf("no new casts")
 	// ErrChannelNotFound is returned when the requested channel is not found.
 	ErrChannelNotFound = fmt.Errorf("channel not found")
+	// ErrRateLimited is returned when the requests are being rejected by the
+	// API provider due to its rate limits.
+	ErrRateLimited = fmt.Errorf("rate limited")
 )
 
 type API interface {
@@ -45,6 +55,9 @@ type API interface {
 	// UserDataByFID retrieves the Userdata of the user with the given fid, if
 	// something goes wrong, it returns an error
 	UserDataByFID(ctx context.Context, fid uint64) (*Userdata, error)
+	// UserDataByUsername retrieves the Userdata of the user with the given
+	// username, if something goes wrong, it returns an error
+	UserDataByUsername(ctx context.Context, username string) (*Userdata, error)
 	// UserDataByVerificationAddress retrieves the Userdata of the user with the
 	// given verification address, if something goes wrong, it returns an error
 	UserDataByVerificationAddress(ctx context.Context, address []string) ([]*Userdata, error)
@@ -55,6 +68,9 @@ type API interface {
 	// UserFollowers method returns the FIDs of the followers of the user with
 	// the given id. If something goes wrong, it returns an error.
 	UserFollowers(ctx context.Context, fid uint64) ([]uint64, error)
+	// UserFollowing method returns the FIDs of the users followed by the user
+	// with the given id. If something goes wrong, it returns an error.
+	UserFollowing(ctx context.Context, fid uint64) ([]uint64, error)
 	// Channel method returns the channel with the given id. If something goes
 	// wrong, it returns an error.
 	Channel(ctx context.Context, channelID string) (*Channel, error)
@@ -70,11 +86,46 @@ type API interface {
 	// FindChannel method returns the channels that matches with the handle (or
 	// the part of it) provided. If something goes wrong, it returns an error.
 	FindChannel(ctx context.Context, query string) ([]*Channel, error)
+	// CastReactions method returns the FIDs of the users that reacted to the
+	// cast with the given fid and hash with the given reaction type (like or
+	// recast). If something goes wrong, it returns an error.
+	CastReactions(ctx context.Context, fid uint64, hash, reactionType string) ([]uint64, error)
+	// CastRepliers method returns the FIDs of the users that replied to the
+	// cast with the given fid and hash. If something goes wrong, it returns an
+	// error.
+	CastRepliers(ctx context.Context, fid uint64, hash string) ([]uint64, error)
 	// DirectMessage method sends a direct message to the user with the given
 	// fid. If something goes wrong, it returns an error.
 	DirectMessage(ctx context.Context, content string, to uint64) error
 }
 
+// MentionStreamer is implemented by the APIs that can stream the mentions of
+// the farcaster user set instead of polling them with LastMentions.
+type MentionStreamer interface {
+	// StreamMentions sends the mentions of the farcaster user set, from the
+	// given cursor, to the events channel until the context is done or
+	// something goes wrong. A zero cursor starts the stream from now. It
+	// returns the error that stopped the stream, if any.
+	StreamMentions(ctx context.Context, cursor uint64, events chan<- *MentionEvent) error
+}
+
+// MentionEvent is an event of a mentions stream. The cursor is the position
+// of the stream to resume it after the event. The message is nil when the
+// event only advances the cursor.
+type MentionEvent struct {
+	Message *APIMessage
+	Cursor  uint64
+}
+
+// ChannelCastsEvent is an event of a channel casts stream. It contains the
+// authors of the casts published in channels, by channel parent url, since
+// the previous event. The cursor is the position of the stream to resume it
+// after the event.
+type ChannelCastsEvent struct {
+	Authors map[string][]uint64
+	Cursor  uint64
+}
+
 // ParentAPIMessage is a struct that represents the parent message of an
 // APIMessage that does not includes the parent message itself, but only the
 // fid of the author and hash as reference of the parent message.
@@ -0,0 +1,471 @@
+// Package cache implements a farcasterapi.API decorator that caches the
+// responses of the read methods (in memory and, optionally, in a persistent
+// store), coalesces the concurrent identical requests and limits the rate of
+// requests sent to the decorated provider, backing off when the provider
+// rejects them due to its rate limits.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// DefaultTTL is the default time that a response is cached.
+	DefaultTTL = 10 * time.Minute
+	// DefaultSize is the default number of responses cached in memory.
+	DefaultSize = 10000
+	// DefaultMaxRetries is the default number of retries of a rate limited
+	// request.
+	DefaultMaxRetries = 3
+	// DefaultBaseBackoff and DefaultMaxBackoff are the default limits of the
+	// exponential backoff of the rate limited requests.
+	DefaultBaseBackoff = time.Second
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+// Store is a persistent store for the cached responses, shared between
+// restarts and instances. The values are JSON encoded.
+type Store interface {
+	APICacheValue(key string) ([]byte, error)
+	SetAPICacheValue(key string, value []byte, expiresAt time.Time) error
+}
+
+// Config is the configuration of the cached API. Name identifies the
+// decorated provider in the store keys and the metrics. Rate is the maximum
+// number of requests per second sent to the provider (zero means no limit)
+// and Burst the number of requests that can be sent at once.
+type Config struct {
+	Name        string
+	TTL         time.Duration
+	Size        int
+	Store       Store
+	Rate        float64
+	Burst       int
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Stats are the metrics of the cached API.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	StoreHits   uint64 `json:"storeHits"`
+	Misses      uint64 `json:"misses"`
+	Coalesced   uint64 `json:"coalesced"`
+	Throttled   uint64 `json:"throttled"`
+	RateLimited uint64 `json:"rateLimited"`
+}
+
+// HitRate returns the ratio of cached responses over the total of cacheable
+// requests.
+func (s *Stats) HitRate() float64 {
+	total := s.Hits + s.StoreHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.StoreHits) / float64(total)
+}
+
+// CachedAPI is a farcasterapi.API decorator that caches the responses of the
+// read methods and limits the rate of requests to the decorated API.
+type CachedAPI struct {
+	farcasterapi.API
+	name        string
+	ttl         time.Duration
+	memory      *expirable.LRU[string, any]
+	store       Store
+	bucket      *tokenBucket
+	coalescer   coalescer
+	maxRetries  int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+
+	hits        atomic.Uint64
+	storeHits   atomic.Uint64
+	misses      atomic.Uint64
+	coalesced   atomic.Uint64
+	throttled   atomic.Uint64
+	rateLimited atomic.Uint64
+}
+
+// New returns a new CachedAPI that decorates the API provided with the given
+// configuration. The zero values of the configuration are replaced by the
+// defaults.
+func New(api farcasterapi.API, config *Config) (*CachedAPI, error) {
+	if api == nil {
+		return nil, fmt.Errorf("no api provided")
+	}
+	if config == nil {
+		config = &Config{}
+	}
+	c := &CachedAPI{
+		API:         api,
+		name:        config.Name,
+		ttl:         config.TTL,
+		store:       config.Store,
+		bucket:      newTokenBucket(config.Rate, config.Burst),
+		maxRetries:  config.MaxRetries,
+		baseBackoff: config.BaseBackoff,
+		maxBackoff:  config.MaxBackoff,
+	}
+	if c.ttl <= 0 {
+		c.ttl = DefaultTTL
+	}
+	size := config.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if c.maxRetries <= 0 {
+		c.maxRetries = DefaultMaxRetries
+	}
+	if c.baseBackoff <= 0 {
+		c.baseBackoff = DefaultBaseBackoff
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = DefaultMaxBackoff
+	}
+	c.memory = expirable.NewLRU[string, any](size, nil, c.ttl)
+	return c, nil
+}
+
+// Stats returns the current metrics of the cached API.
+func (c *CachedAPI) Stats() *Stats {
+	return &Stats{
+		Hits:        c.hits.Load(),
+		StoreHits:   c.storeHits.Load(),
+		Misses:      c.misses.Load(),
+		Coalesced:   c.coalesced.Load(),
+		Throttled:   c.throttled.Load(),
+		RateLimited: c.rateLimited.Load(),
+	}
+}
+
+// Monitor logs the metrics of the cached API every interval until the context
+// is done. This is a non blocking function that runs in the background.
+func (c *CachedAPI) Monitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := c.Stats()
+				log.Monitor("farcaster api cache "+c.name, map[string]any{
+					"hitRate":     fmt.Sprintf("%.2f", stats.HitRate()),
+					"hits":        stats.Hits,
+					"storeHits":   stats.StoreHits,
+					"misses":      stats.Misses,
+					"coalesced":   stats.Coalesced,
+					"throttled":   stats.Throttled,
+					"rateLimited": stats.RateLimited,
+				})
+			}
+		}
+	}()
+}
+
+// UserDataByFID returns the cached user data of the given FID or gets it
+// from the decorated API.
+func (c *CachedAPI) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.Userdata, error) {
+	return cached(ctx, c, fmt.Sprintf("userdata:fid:%d", fid), func(ctx context.Context) (*farcasterapi.Userdata, error) {
+		return c.API.UserDataByFID(ctx, fid)
+	})
+}
+
+// UserDataByUsername returns the cached user data of the given username or
+// gets it from the decorated API.
+func (c *CachedAPI) UserDataByUsername(ctx context.Context, username string) (*farcasterapi.Userdata, error) {
+	return cached(ctx, c, "userdata:username:"+username, func(ctx context.Context) (*farcasterapi.Userdata, error) {
+		return c.API.UserDataByUsername(ctx, username)
+	})
+}
+
+// UserDataByVerificationAddress returns the user data of the given addresses.
+// The addresses already cached are not requested to the decorated API again,
+// and the users found are cached by every requested address they verified.
+func (c *CachedAPI) UserDataByVerificationAddress(ctx context.Context, addresses []string) ([]*farcasterapi.Userdata, error) {
+	users := []*farcasterapi.Userdata{}
+	seen := map[uint64]bool{}
+	addUser := func(user *farcasterapi.Userdata) {
+		if user != nil && !seen[user.FID] {
+			seen[user.FID] = true
+			users = append(users, user)
+		}
+	}
+	pending := []string{}
+	for _, address := range addresses {
+		if user, ok := c.memory.Get(addressKey(address)); ok {
+			c.hits.Add(1)
+			addUser(clone(user.(*farcasterapi.Userdata)))
+			continue
+		}
+		pending = append(pending, address)
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		c.misses.Add(uint64(len(pending)))
+		res, shared, err := c.coalescer.do(ctx, "userdata:addresses:"+strings.Join(pending, ","), func(ctx context.Context) (any, error) {
+			found, err := limited(ctx, c, func() ([]*farcasterapi.Userdata, error) {
+				return c.API.UserDataByVerificationAddress(ctx, pending)
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range found {
+				for _, address := range user.VerificationsAddresses {
+					c.memory.Add(addressKey(address), user)
+				}
+			}
+			return found, nil
+		})
+		if shared {
+			c.coalesced.Add(1)
+		}
+		if err != nil {
+			if len(users) == 0 {
+				return nil, err
+			}
+			log.Debugw("failed to get users by address, using the cached ones", "api", c.name, "error", err)
+		}
+		found, _ := res.([]*farcasterapi.Userdata)
+		for _, user := range found {
+			addUser(clone(user))
+		}
+	}
+	if len(users) == 0 {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return users, nil
+}
+
+// SignersFromFID returns the cached signers of the given FID or gets them
+// from the decorated API.
+func (c *CachedAPI) SignersFromFID(fid uint64) ([]string, error) {
+	return cached(context.Background(), c, fmt.Sprintf("signers:%d", fid), func(_ context.Context) ([]string, error) {
+		return c.API.SignersFromFID(fid)
+	})
+}
+
+// UserFollowers returns the cached followers of the given FID or gets them
+// from the decorated API.
+func (c *CachedAPI) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
+	return cached(ctx, c, fmt.Sprintf("followers:%d", fid), func(ctx context.Context) ([]uint64, error) {
+		return c.API.UserFollowers(ctx, fid)
+	})
+}
+
+// UserFollowing returns the cached users followed by the given FID or gets
+// them from the decorated API.
+func (c *CachedAPI) UserFollowing(ctx context.Context, fid uint64) ([]uint64, error) {
+	return cached(ctx, c, fmt.Sprintf("following:%d", fid), func(ctx context.Context) ([]uint64, error) {
+		return c.API.UserFollowing(ctx, fid)
+	})
+}
+
+// Channel returns the cached channel with the given ID or gets it from the
+// decorated API.
+func (c *CachedAPI) Channel(ctx context.Context, channelID string) (*farcasterapi.Channel, error) {
+	return cached(ctx, c, "channel:"+channelID, func(ctx context.Context) (*farcasterapi.Channel, error) {
+		return c.API.Channel(ctx, channelID)
+	})
+}
+
+// The rest of the methods are not cached, but they are rate limited.
+
+func (c *CachedAPI) LastMentions(ctx context.Context, timestamp uint64) ([]*farcasterapi.APIMessage, uint64, error) {
+	last := timestamp
+	messages, err := limited(ctx, c, func() ([]*farcasterapi.APIMessage, error) {
+		var messages []*farcasterapi.APIMessage
+		var err error
+		messages, last, err = c.API.LastMentions(ctx, timestamp)
+		return messages, err
+	})
+	return messages, last, err
+}
+
+func (c *CachedAPI) GetCast(ctx context.Context, fid uint64, hash string) (*farcasterapi.APIMessage, error) {
+	return limited(ctx, c, func() (*farcasterapi.APIMessage, error) {
+		return c.API.GetCast(ctx, fid, hash)
+	})
+}
+
+func (c *CachedAPI) Publish(ctx context.Context, content string, mentionFids []uint64, embedURLS ...string) error {
+	_, err := limited(ctx, c, func() (any, error) {
+		return nil, c.API.Publish(ctx, content, mentionFids, embedURLS...)
+	})
+	return err
+}
+
+func (c *CachedAPI) Reply(ctx context.Context, targetMsg *farcasterapi.APIMessage, content string, mentionFids []uint64, embedURLS ...string) error {
+	_, err := limited(ctx, c, func() (any, error) {
+		return nil, c.API.Reply(ctx, targetMsg, content, mentionFids, embedURLS...)
+	})
+	return err
+}
+
+func (c *CachedAPI) ChannelFIDs(ctx context.Context, channelID string, progress chan int) ([]uint64, error) {
+	return limited(ctx, c, func() ([]uint64, error) {
+		return c.API.ChannelFIDs(ctx, channelID, progress)
+	})
+}
+
+func (c *CachedAPI) ChannelExists(ctx context.Context, channelID string) (bool, error) {
+	return limited(ctx, c, func() (bool, error) {
+		return c.API.ChannelExists(ctx, channelID)
+	})
+}
+
+func (c *CachedAPI) FindChannel(ctx context.Context, query string) ([]*farcasterapi.Channel, error) {
+	return limited(ctx, c, func() ([]*farcasterapi.Channel, error) {
+		return c.API.FindChannel(ctx, query)
+	})
+}
+
+func (c *CachedAPI) CastReactions(ctx context.Context, fid uint64, hash, reactionType string) ([]uint64, error) {
+	return limited(ctx, c, func() ([]uint64, error) {
+		return c.API.CastReactions(ctx, fid, hash, reactionType)
+	})
+}
+
+func (c *CachedAPI) CastRepliers(ctx context.Context, fid uint64, hash string) ([]uint64, error) {
+	return limited(ctx, c, func() ([]uint64, error) {
+		return c.API.CastRepliers(ctx, fid, hash)
+	})
+}
+
+func (c *CachedAPI) DirectMessage(ctx context.Context, content string, to uint64) error {
+	_, err := limited(ctx, c, func() (any, error) {
+		return nil, c.API.DirectMessage(ctx, content, to)
+	})
+	return err
+}
+
+// cached returns the value of the given key from the memory cache or the
+// store. If it is not cached, it gets the value with the fetch function,
+// coalescing the concurrent requests of the same key, and caches it. The
+// values returned are copies of the cached ones, so the callers can modify
+// them.
+func cached[T any](ctx context.Context, c *CachedAPI, key string, fetch func(context.Context) (T, error)) (T, error) {
+	if value, ok := c.memory.Get(key); ok {
+		if typed, ok := value.(T); ok {
+			c.hits.Add(1)
+			return clone(typed), nil
+		}
+	}
+	if c.store != nil {
+		if data, err := c.store.APICacheValue(c.storeKey(key)); err == nil {
+			var typed T
+			if err := json.Unmarshal(data, &typed); err == nil {
+				c.memory.Add(key, typed)
+				c.storeHits.Add(1)
+				return clone(typed), nil
+			}
+		}
+	}
+	c.misses.Add(1)
+	value, shared, err := c.coalescer.do(ctx, key, func(ctx context.Context) (any, error) {
+		value, err := limited(ctx, c, func() (T, error) {
+			return fetch(ctx)
+		})
+		if err != nil {
+			return value, err
+		}
+		c.set(key, value)
+		return value, nil
+	})
+	if shared {
+		c.coalesced.Add(1)
+	}
+	typed, _ := value.(T)
+	return clone(typed), err
+}
+
+// limited runs the function provided once the rate limiter allows it. If the
+// function is rate limited by the provider, the limiter is paused and the
+// function retried with an exponential backoff.
+func limited[T any](ctx context.Context, c *CachedAPI, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		waited, err := c.bucket.wait(ctx)
+		if err != nil {
+			var empty T
+			return empty, err
+		}
+		if waited {
+			c.throttled.Add(1)
+		}
+		res, err := fn()
+		if !isRateLimited(err) || attempt >= c.maxRetries {
+			return res, err
+		}
+		c.rateLimited.Add(1)
+		delay := backoff(attempt, c.baseBackoff, c.maxBackoff)
+		log.Debugw("farcaster api rate limited, backing off", "api", c.name, "attempt", attempt+1, "delay", delay)
+		c.bucket.pause(delay)
+	}
+}
+
+// set caches the value of the given key in memory and in the store.
+func (c *CachedAPI) set(key string, value any) {
+	c.memory.Add(key, value)
+	if c.store == nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Warnw("failed to encode cached value", "key", key, "error", err)
+		return
+	}
+	if err := c.store.SetAPICacheValue(c.storeKey(key), data, time.Now().Add(c.ttl)); err != nil {
+		log.Warnw("failed to store cached value", "key", key, "error", err)
+	}
+}
+
+func (c *CachedAPI) storeKey(key string) string {
+	return c.name + ":" + key
+}
+
+func addressKey(address string) string {
+	return "userdata:address:" + strings.ToLower(address)
+}
+
+// clone returns a copy of the cached value provided, including its slices, so
+// the callers can not modify the cached one.
+func clone[T any](value T) T {
+	var copied any
+	switch v := any(value).(type) {
+	case *farcasterapi.Userdata:
+		if v == nil {
+			return value
+		}
+		user := *v
+		user.VerificationsAddresses = slices.Clone(v.VerificationsAddresses)
+		user.Signers = slices.Clone(v.Signers)
+		copied = &user
+	case *farcasterapi.Channel:
+		if v == nil {
+			return value
+		}
+		channel := *v
+		copied = &channel
+	case []uint64:
+		copied = slices.Clone(v)
+	case []string:
+		copied = slices.Clone(v)
+	default:
+		return value
+	}
+	return copied.(T)
+}
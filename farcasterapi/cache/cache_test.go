@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+const testFID = 1
+
+// countingAPI counts the calls to UserFollowers and blocks them until the
+// release channel is closed, if it is set.
+type countingAPI struct {
+	*mock.MockAPI
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (a *countingAPI) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
+	a.calls.Add(1)
+	if a.release != nil {
+		select {
+		case <-a.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return a.MockAPI.UserFollowers(ctx, fid)
+}
+
+// memoryStore is a Store that keeps the values in memory.
+type memoryStore struct {
+	mtx    sync.Mutex
+	values map[string][]byte
+}
+
+func (s *memoryStore) APICacheValue(key string) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	return value, nil
+}
+
+func (s *memoryStore) SetAPICacheValue(key string, value []byte, _ time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.values == nil {
+		s.values = make(map[string][]byte)
+	}
+	s.values[key] = value
+	return nil
+}
+
+// waiters returns the number of callers waiting for the request in flight
+// of the given key.
+func waiters(c *CachedAPI, key string) int {
+	c.coalescer.mtx.Lock()
+	defer c.coalescer.mtx.Unlock()
+	if f, ok := c.coalescer.flights[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
+
+func newTestAPI() *countingAPI {
+	m := mock.NewMockAPI()
+	m.AddFixtures(&mock.Fixtures{Users: []*mock.UserFixture{{
+		FID:       testFID,
+		Username:  "alice",
+		Addresses: []string{"0xA11CE"},
+		Followers: []uint64{2, 3},
+	}}})
+	return &countingAPI{MockAPI: m}
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// the burst is available at once, the next token waits for the refill
+	b := newTokenBucket(20, 2)
+	for i := 0; i < 2; i++ {
+		waited, err := b.wait(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(waited, qt.IsFalse)
+	}
+	start := time.Now()
+	waited, err := b.wait(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(waited, qt.IsTrue)
+	c.Assert(time.Since(start) >= 40*time.Millisecond, qt.IsTrue)
+
+	// the waits stop when the context is done
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	b.pause(time.Second)
+	_, err = b.wait(cctx)
+	c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+
+	// without rate there is no limit, but the pauses still apply
+	b = newTokenBucket(0, 0)
+	for i := 0; i < 100; i++ {
+		waited, err := b.wait(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(waited, qt.IsFalse)
+	}
+	b.pause(30 * time.Millisecond)
+	b.pause(time.Millisecond)
+	start = time.Now()
+	waited, err = b.wait(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(waited, qt.IsTrue)
+	c.Assert(time.Since(start) >= 25*time.Millisecond, qt.IsTrue)
+}
+
+func TestBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: time.Second},
+		{attempt: 1, expected: 2 * time.Second},
+		{attempt: 3, expected: 8 * time.Second},
+		{attempt: 5, expected: 10 * time.Second},
+		{attempt: 100, expected: 10 * time.Second},
+	}
+	for _, test := range tests {
+		c.Run(fmt.Sprint(test.attempt), func(c *qt.C) {
+			for i := 0; i < 10; i++ {
+				delay := backoff(test.attempt, time.Second, 10*time.Second)
+				c.Assert(delay >= test.expected, qt.IsTrue)
+				c.Assert(delay <= test.expected+test.expected/4, qt.IsTrue)
+			}
+		})
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(isRateLimited(nil), qt.IsFalse)
+	c.Assert(isRateLimited(fmt.Errorf("error downloading json: %w", farcasterapi.ErrRateLimited)), qt.IsTrue)
+	// the messages that include 429 are not rate limits
+	c.Assert(isRateLimited(fmt.Errorf("user with fid 429 not found")), qt.IsFalse)
+	c.Assert(isRateLimited(fmt.Errorf("cast 0x429abc: 500 Internal Server Error")), qt.IsFalse)
+}
+
+func TestRateLimitedRetries(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	api := newTestAPI()
+	cached, err := New(api, &Config{MaxRetries: 2, BaseBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+
+	api.SetError("UserDataByFID", fmt.Errorf("error: %w", farcasterapi.ErrRateLimited))
+	_, err = cached.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.ErrorIs, farcasterapi.ErrRateLimited)
+	c.Assert(cached.Stats().RateLimited, qt.Equals, uint64(2))
+
+	// the rest of errors are not retried
+	api.SetError("UserDataByFID", fmt.Errorf("fid 429: 500 Internal Server Error"))
+	_, err = cached.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(cached.Stats().RateLimited, qt.Equals, uint64(2))
+}
+
+func TestTTL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	api := newTestAPI()
+	store := &memoryStore{}
+	cached, err := New(api, &Config{Name: "test", TTL: 50 * time.Millisecond, Store: store})
+	c.Assert(err, qt.IsNil)
+
+	for i := 0; i < 3; i++ {
+		followers, err := cached.UserFollowers(ctx, testFID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(followers, qt.DeepEquals, []uint64{2, 3})
+	}
+	c.Assert(api.calls.Load(), qt.Equals, int32(1))
+	c.Assert(cached.Stats().Hits, qt.Equals, uint64(2))
+	c.Assert(cached.Stats().Misses, qt.Equals, uint64(1))
+
+	// another instance with the same store gets the value from it
+	other, err := New(api, &Config{Name: "test", Store: store})
+	c.Assert(err, qt.IsNil)
+	_, err = other.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(other.Stats().StoreHits, qt.Equals, uint64(1))
+	c.Assert(api.calls.Load(), qt.Equals, int32(1))
+
+	// once the TTL expires, the value is requested again (the memory store
+	// does not expire its values, so the cache without store is used)
+	noStore, err := New(api, &Config{TTL: 50 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+	_, err = noStore.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(api.calls.Load(), qt.Equals, int32(2))
+	time.Sleep(70 * time.Millisecond)
+	_, err = noStore.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(api.calls.Load(), qt.Equals, int32(3))
+}
+
+func TestCoalescing(t *testing.T) {
+	c := qt.New(t)
+
+	api := newTestAPI()
+	api.release = make(chan struct{})
+	cached, err := New(api, nil)
+	c.Assert(err, qt.IsNil)
+
+	// the first caller gives up, but the rest get the result of the shared
+	// request
+	firstCtx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, err := cached.UserFollowers(firstCtx, testFID)
+		firstErr <- err
+	}()
+	for api.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	results := make(chan []uint64, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			followers, err := cached.UserFollowers(context.Background(), testFID)
+			if err == nil {
+				results <- followers
+			}
+		}()
+	}
+	for waiters(cached, fmt.Sprintf("followers:%d", testFID)) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	c.Assert(<-firstErr, qt.ErrorIs, context.Canceled)
+	close(api.release)
+	wg.Wait()
+	close(results)
+
+	c.Assert(api.calls.Load(), qt.Equals, int32(1))
+	c.Assert(cached.Stats().Coalesced, qt.Equals, uint64(3))
+	count := 0
+	for followers := range results {
+		c.Assert(followers, qt.DeepEquals, []uint64{2, 3})
+		count++
+	}
+	c.Assert(count, qt.Equals, 3)
+}
+
+func TestCoalescingCancelled(t *testing.T) {
+	c := qt.New(t)
+
+	api := newTestAPI()
+	api.release = make(chan struct{})
+	defer close(api.release)
+	cached, err := New(api, nil)
+	c.Assert(err, qt.IsNil)
+
+	// if every caller gives up, the shared request is cancelled and the next
+	// caller starts a new one
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = cached.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = cached.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+	c.Assert(api.calls.Load(), qt.Equals, int32(2))
+	c.Assert(cached.Stats().Coalesced, qt.Equals, uint64(0))
+}
+
+func TestCachedCopies(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	cached, err := New(newTestAPI(), nil)
+	c.Assert(err, qt.IsNil)
+
+	// the values returned can be modified without changing the cached ones
+	user, err := cached.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	user.Username = "mallory"
+	user.VerificationsAddresses[0] = "0xBAD"
+	user, err = cached.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "alice")
+	c.Assert(user.VerificationsAddresses, qt.DeepEquals, []string{"0xA11CE"})
+
+	users, err := cached.UserDataByVerificationAddress(ctx, []string{"0xA11CE"})
+	c.Assert(err, qt.IsNil)
+	users[0].Username = "mallory"
+	users, err = cached.UserDataByVerificationAddress(ctx, []string{"0xa11ce"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(users[0].Username, qt.Equals, "alice")
+
+	followers, err := cached.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	followers[0] = 100
+	followers, err = cached.UserFollowers(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(followers, qt.DeepEquals, []uint64{2, 3})
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+// tokenBucket is a token bucket rate limiter. The bucket is refilled at the
+// given rate (tokens per second) up to the burst size, and every request
+// takes a token. A rate of zero or less disables the limit. The bucket can be
+// paused to stop every request for a while, i.e. when the provider rejects the
+// requests due to its rate limits.
+type tokenBucket struct {
+	mtx         sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or the context is done. It returns
+// true if the request had to wait for a token.
+func (b *tokenBucket) wait(ctx context.Context) (bool, error) {
+	waited := false
+	for {
+		b.mtx.Lock()
+		now := time.Now()
+		var delay time.Duration
+		if now.Before(b.pausedUntil) {
+			delay = b.pausedUntil.Sub(now)
+		} else if b.rate <= 0 {
+			b.mtx.Unlock()
+			return waited, nil
+		} else {
+			b.tokens += now.Sub(b.last).Seconds() * b.rate
+			if b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+			b.last = now
+			if b.tokens >= 1 {
+				b.tokens--
+				b.mtx.Unlock()
+				return waited, nil
+			}
+			delay = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		}
+		b.mtx.Unlock()
+		waited = true
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// pause stops every request for the given duration, unless the bucket is
+// already paused for longer.
+func (b *tokenBucket) pause(d time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if until := time.Now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// backoff returns the delay before retrying a rate limited request for the
+// given attempt: exponential from the base delay, capped to the max delay,
+// with up to a 25% of jitter.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base << attempt
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/4+1))
+}
+
+// isRateLimited returns true if the error provided means that the provider is
+// rejecting the requests due to its rate limits. The providers wrap
+// farcasterapi.ErrRateLimited when they get a 429 status code.
+func isRateLimited(err error) bool {
+	return errors.Is(err, farcasterapi.ErrRateLimited)
+}
+
+// flight is an in-flight request whose result is shared by every caller. The
+// request runs with its own context, which is only cancelled when every
+// caller waiting for it is gone.
+type flight struct {
+	done    chan struct{}
+	val     any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalescer runs only one request at a time for every key, the concurrent
+// callers with the same key wait for it and get the same result.
+type coalescer struct {
+	mtx     sync.Mutex
+	flights map[string]*flight
+}
+
+// do runs fn for the given key if there is no request in flight for it, else
+// it waits for the one in flight. It returns true if the result was shared.
+// The function gets a context detached from the callers' ones, so a caller
+// that gives up does not cancel the request of the rest of callers. If the
+// context of the caller is done before the request finishes, it returns the
+// context error.
+func (c *coalescer) do(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, bool, error) {
+	c.mtx.Lock()
+	if c.flights == nil {
+		c.flights = make(map[string]*flight)
+	}
+	f, shared := c.flights[key]
+	if shared {
+		f.waiters++
+	} else {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		c.flights[key] = f
+		go func() {
+			f.val, f.err = fn(flightCtx)
+			c.mtx.Lock()
+			if c.flights[key] == f {
+				delete(c.flights, key)
+			}
+			c.mtx.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	c.mtx.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, shared, f.err
+	case <-ctx.Done():
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		// the last caller cancels the request, and it is not shared anymore
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+			if c.flights[key] == f {
+				delete(c.flights, key)
+			}
+		}
+		return nil, shared, ctx.Err()
+	}
+}
@@ -0,0 +1,574 @@
+// Package failover implements a farcasterapi.API that wraps several Farcaster
+// API backends. Every method is routed to an ordered list of backends and, if
+// a backend fails, the next one is tried. Backends that fail repeatedly are
+// skipped (circuit open) for a while, and then a single call is allowed to
+// try them again (circuit half-open). The methods that publish data are only
+// tried on the next backend if the failed one did not receive the request.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"go.vocdoni.io/dvote/log"
+)
+
+// Groups of methods of the farcasterapi.API interface that can be routed
+// together.
+const (
+	GroupMentions = "mentions"
+	GroupCasts    = "casts"
+	GroupUsers    = "users"
+	GroupChannels = "channels"
+	GroupMessages = "messages"
+)
+
+const (
+	// DefaultFailureThreshold is the default number of consecutive failures
+	// of a backend that opens its circuit.
+	DefaultFailureThreshold = 3
+	// DefaultOpenTimeout is the default time that the circuit of a backend
+	// stays open before trying it again.
+	DefaultOpenTimeout = 30 * time.Second
+)
+
+// ErrNoBackendAvailable is returned when every backend of a route has its
+// circuit open.
+var ErrNoBackendAvailable = fmt.Errorf("no farcaster api backend available")
+
+// groups is the list of groups of methods.
+var groups = []string{GroupMentions, GroupCasts, GroupUsers, GroupChannels, GroupMessages}
+
+// methodGroups maps every routable method to its group.
+var methodGroups = map[string]string{
+	"LastMentions":                  GroupMentions,
+	"WebhookHandler":                GroupMentions,
+	"GetCast":                       GroupCasts,
+	"Publish":                       GroupCasts,
+	"Reply":                         GroupCasts,
+	"CastReactions":                 GroupCasts,
+	"CastRepliers":                  GroupCasts,
+	"UserDataByFID":                 GroupUsers,
+	"UserDataByUsername":            GroupUsers,
+	"UserDataByVerificationAddress": GroupUsers,
+	"SignersFromFID":                GroupUsers,
+	"UserFollowers":                 GroupUsers,
+	"UserFollowing":                 GroupUsers,
+	"Channel":                       GroupChannels,
+	"ChannelFIDs":                   GroupChannels,
+	"ChannelExists":                 GroupChannels,
+	"FindChannel":                   GroupChannels,
+	"DirectMessage":                 GroupMessages,
+}
+
+// nonIdempotentMethods are the methods that publish data, so they can not be
+// retried on other backend if the failed one could have received the request.
+var nonIdempotentMethods = map[string]bool{
+	"Publish":       true,
+	"Reply":         true,
+	"DirectMessage": true,
+}
+
+// Backend is a named Farcaster API backend.
+type Backend struct {
+	Name string
+	API  farcasterapi.API
+}
+
+// Config is the configuration of the failover API. Routes maps a group of
+// methods or a method name to the ordered list of backend names to use. The
+// methods without route use every backend in the order they were provided.
+type Config struct {
+	Routes           map[string][]string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// BackendHealth is the health status of a backend for a group of methods.
+type BackendHealth struct {
+	Name                string    `json:"name"`
+	Group               string    `json:"group"`
+	Available           bool      `json:"available"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	OpenUntil           time.Time `json:"openUntil,omitempty"`
+	LastError           string    `json:"lastError,omitempty"`
+	Successes           uint64    `json:"successes"`
+	Failures            uint64    `json:"failures"`
+}
+
+// circuit tracks the health of a backend for a group of methods, so a
+// backend failing for some methods can still be used for the others. Once
+// the open timeout expires, the circuit is half-open and a single call probes
+// the backend, closing the circuit if it succeeds or opening it again if not.
+type circuit struct {
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	lastErr   error
+	successes uint64
+	errors    uint64
+}
+
+// backend wraps a Farcaster API backend with its circuits by group.
+type backend struct {
+	name     string
+	api      farcasterapi.API
+	circuits map[string]*circuit
+}
+
+// FailoverAPI is a farcasterapi.API that routes every method to an ordered
+// list of backends, falling back to the next one when a backend fails.
+type FailoverAPI struct {
+	backends         map[string]*backend
+	order            []string
+	routes           map[string][]string
+	failureThreshold int
+	openTimeout      time.Duration
+}
+
+// New creates a new FailoverAPI with the backends and the configuration
+// provided. The backends order is the default route for every method. It
+// returns an error if no backends are provided, a backend name is repeated or
+// a route includes an unknown backend or method.
+func New(backends []*Backend, config *Config) (*FailoverAPI, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends provided")
+	}
+	if config == nil {
+		config = &Config{}
+	}
+	f := &FailoverAPI{
+		backends:         make(map[string]*backend, len(backends)),
+		routes:           make(map[string][]string),
+		failureThreshold: config.FailureThreshold,
+		openTimeout:      config.OpenTimeout,
+	}
+	if f.failureThreshold <= 0 {
+		f.failureThreshold = DefaultFailureThreshold
+	}
+	if f.openTimeout <= 0 {
+		f.openTimeout = DefaultOpenTimeout
+	}
+	for _, b := range backends {
+		if b == nil || b.API == nil || b.Name == "" {
+			return nil, fmt.Errorf("invalid backend")
+		}
+		if _, ok := f.backends[b.Name]; ok {
+			return nil, fmt.Errorf("duplicated backend %s", b.Name)
+		}
+		circuits := map[string]*circuit{}
+		for _, group := range groups {
+			circuits[group] = &circuit{}
+		}
+		f.backends[b.Name] = &backend{name: b.Name, api: b.API, circuits: circuits}
+		f.order = append(f.order, b.Name)
+	}
+	for route, names := range config.Routes {
+		if !isGroup(route) {
+			if _, ok := methodGroups[route]; !ok {
+				return nil, fmt.Errorf("unknown route %s", route)
+			}
+		}
+		for _, name := range names {
+			if _, ok := f.backends[name]; !ok {
+				return nil, fmt.Errorf("unknown backend %s in route %s", name, route)
+			}
+		}
+		f.routes[route] = names
+	}
+	return f, nil
+}
+
+// ParseRoutes parses the routes provided in the format
+// "route=backend1,backend2;route2=backend2", where every route is a group of
+// methods or a method name.
+func ParseRoutes(value string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, rawRoute := range strings.Split(value, ";") {
+		rawRoute = strings.TrimSpace(rawRoute)
+		if rawRoute == "" {
+			continue
+		}
+		route, rawBackends, ok := strings.Cut(rawRoute, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route %s", rawRoute)
+		}
+		backends := []string{}
+		for _, name := range strings.Split(rawBackends, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				backends = append(backends, name)
+			}
+		}
+		if len(backends) == 0 {
+			return nil, fmt.Errorf("no backends for route %s", route)
+		}
+		routes[strings.TrimSpace(route)] = backends
+	}
+	return routes, nil
+}
+
+// Health returns the health status of every backend by group, in the default
+// order of the backends.
+func (f *FailoverAPI) Health() []*BackendHealth {
+	health := []*BackendHealth{}
+	for _, name := range f.order {
+		for _, group := range groups {
+			c := f.backends[name].circuits[group]
+			c.mtx.Lock()
+			h := &BackendHealth{
+				Name:                name,
+				Group:               group,
+				Available:           time.Now().After(c.openUntil),
+				ConsecutiveFailures: c.failures,
+				OpenUntil:           c.openUntil,
+				Successes:           c.successes,
+				Failures:            c.errors,
+			}
+			if c.lastErr != nil {
+				h.LastError = c.lastErr.Error()
+			}
+			c.mtx.Unlock()
+			health = append(health, h)
+		}
+	}
+	return health
+}
+
+// SetFarcasterUser sets the farcaster user in every backend. It only fails if
+// no backend accepts the user.
+func (f *FailoverAPI) SetFarcasterUser(fid uint64, signer string) error {
+	var errs []error
+	for _, name := range f.order {
+		if err := f.backends[name].api.SetFarcasterUser(fid, signer); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if len(errs) == len(f.order) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// FID returns the fid of the farcaster user set in the first backend that has
+// one.
+func (f *FailoverAPI) FID() uint64 {
+	for _, name := range f.order {
+		if fid := f.backends[name].api.FID(); fid != 0 {
+			return fid
+		}
+	}
+	return 0
+}
+
+// Stop stops every backend.
+func (f *FailoverAPI) Stop() error {
+	var errs []error
+	for _, name := range f.order {
+		if err := f.backends[name].api.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LastMentions returns the last mentions from the mentions backends.
+func (f *FailoverAPI) LastMentions(ctx context.Context, timestamp uint64) ([]*farcasterapi.APIMessage, uint64, error) {
+	type result struct {
+		messages  []*farcasterapi.APIMessage
+		timestamp uint64
+	}
+	res, err := call(ctx, f, "LastMentions", func(api farcasterapi.API) (*result, error) {
+		messages, last, err := api.LastMentions(ctx, timestamp)
+		return &result{messages, last}, err
+	})
+	if res == nil {
+		return nil, timestamp, err
+	}
+	return res.messages, res.timestamp, err
+}
+
+// GetCast returns the cast from the casts backends.
+func (f *FailoverAPI) GetCast(ctx context.Context, fid uint64, hash string) (*farcasterapi.APIMessage, error) {
+	return call(ctx, f, "GetCast", func(api farcasterapi.API) (*farcasterapi.APIMessage, error) {
+		return api.GetCast(ctx, fid, hash)
+	})
+}
+
+// Publish publishes the cast using the casts backends.
+func (f *FailoverAPI) Publish(ctx context.Context, content string, mentionFids []uint64, embedURLS ...string) error {
+	return exec(ctx, f, "Publish", func(api farcasterapi.API) error {
+		return api.Publish(ctx, content, mentionFids, embedURLS...)
+	})
+}
+
+// Reply publishes the reply using the casts backends.
+func (f *FailoverAPI) Reply(ctx context.Context, targetMsg *farcasterapi.APIMessage, content string, mentionFids []uint64, embedURLS ...string) error {
+	return exec(ctx, f, "Reply", func(api farcasterapi.API) error {
+		return api.Reply(ctx, targetMsg, content, mentionFids, embedURLS...)
+	})
+}
+
+// UserDataByFID returns the user data from the users backends.
+func (f *FailoverAPI) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.Userdata, error) {
+	return call(ctx, f, "UserDataByFID", func(api farcasterapi.API) (*farcasterapi.Userdata, error) {
+		return api.UserDataByFID(ctx, fid)
+	})
+}
+
+// UserDataByUsername returns the user data from the users backends.
+func (f *FailoverAPI) UserDataByUsername(ctx context.Context, username string) (*farcasterapi.Userdata, error) {
+	return call(ctx, f, "UserDataByUsername", func(api farcasterapi.API) (*farcasterapi.Userdata, error) {
+		return api.UserDataByUsername(ctx, username)
+	})
+}
+
+// UserDataByVerificationAddress returns the users data from the users
+// backends.
+func (f *FailoverAPI) UserDataByVerificationAddress(ctx context.Context, address []string) ([]*farcasterapi.Userdata, error) {
+	return call(ctx, f, "UserDataByVerificationAddress", func(api farcasterapi.API) ([]*farcasterapi.Userdata, error) {
+		return api.UserDataByVerificationAddress(ctx, address)
+	})
+}
+
+// WebhookHandler handles the webhook with the mentions backends.
+func (f *FailoverAPI) WebhookHandler(body []byte) error {
+	return exec(context.Background(), f, "WebhookHandler", func(api farcasterapi.API) error {
+		return api.WebhookHandler(body)
+	})
+}
+
+// SignersFromFID returns the user signers from the users backends.
+func (f *FailoverAPI) SignersFromFID(fid uint64) ([]string, error) {
+	return call(context.Background(), f, "SignersFromFID", func(api farcasterapi.API) ([]string, error) {
+		return api.SignersFromFID(fid)
+	})
+}
+
+// UserFollowers returns the user followers from the users backends.
+func (f *FailoverAPI) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
+	return call(ctx, f, "UserFollowers", func(api farcasterapi.API) ([]uint64, error) {
+		return api.UserFollowers(ctx, fid)
+	})
+}
+
+// UserFollowing returns the users followed from the users backends.
+func (f *FailoverAPI) UserFollowing(ctx context.Context, fid uint64) ([]uint64, error) {
+	return call(ctx, f, "UserFollowing", func(api farcasterapi.API) ([]uint64, error) {
+		return api.UserFollowing(ctx, fid)
+	})
+}
+
+// Channel returns the channel from the channels backends.
+func (f *FailoverAPI) Channel(ctx context.Context, channelID string) (*farcasterapi.Channel, error) {
+	return call(ctx, f, "Channel", func(api farcasterapi.API) (*farcasterapi.Channel, error) {
+		return api.Channel(ctx, channelID)
+	})
+}
+
+// ChannelFIDs returns the channel followers from the channels backends.
+func (f *FailoverAPI) ChannelFIDs(ctx context.Context, channelID string, progress chan int) ([]uint64, error) {
+	return call(ctx, f, "ChannelFIDs", func(api farcasterapi.API) ([]uint64, error) {
+		return api.ChannelFIDs(ctx, channelID, progress)
+	})
+}
+
+// ChannelExists checks the channel existence with the channels backends.
+func (f *FailoverAPI) ChannelExists(ctx context.Context, channelID string) (bool, error) {
+	return call(ctx, f, "ChannelExists", func(api farcasterapi.API) (bool, error) {
+		return api.ChannelExists(ctx, channelID)
+	})
+}
+
+// FindChannel searches the channels with the channels backends.
+func (f *FailoverAPI) FindChannel(ctx context.Context, query string) ([]*farcasterapi.Channel, error) {
+	return call(ctx, f, "FindChannel", func(api farcasterapi.API) ([]*farcasterapi.Channel, error) {
+		return api.FindChannel(ctx, query)
+	})
+}
+
+// CastReactions returns the cast reactions from the casts backends.
+func (f *FailoverAPI) CastReactions(ctx context.Context, fid uint64, hash, reactionType string) ([]uint64, error) {
+	return call(ctx, f, "CastReactions", func(api farcasterapi.API) ([]uint64, error) {
+		return api.CastReactions(ctx, fid, hash, reactionType)
+	})
+}
+
+// CastRepliers returns the cast repliers from the casts backends.
+func (f *FailoverAPI) CastRepliers(ctx context.Context, fid uint64, hash string) ([]uint64, error) {
+	return call(ctx, f, "CastRepliers", func(api farcasterapi.API) ([]uint64, error) {
+		return api.CastRepliers(ctx, fid, hash)
+	})
+}
+
+// DirectMessage sends the direct message using the messages backends.
+func (f *FailoverAPI) DirectMessage(ctx context.Context, content string, to uint64) error {
+	return exec(ctx, f, "DirectMessage", func(api farcasterapi.API) error {
+		return api.DirectMessage(ctx, content, to)
+	})
+}
+
+// route returns the backends to use for the given method. The method route
+// has precedence over the route of its group.
+func (f *FailoverAPI) route(method string) []*backend {
+	names, ok := f.routes[method]
+	if !ok {
+		if names, ok = f.routes[methodGroups[method]]; !ok {
+			names = f.order
+		}
+	}
+	backends := make([]*backend, 0, len(names))
+	for _, name := range names {
+		backends = append(backends, f.backends[name])
+	}
+	return backends
+}
+
+// call runs the function provided with the backends of the method route, in
+// order, until one of them succeeds. The backends with the circuit of the
+// method group open are skipped. The errors that are not caused by the backend
+// (i.e. no data found) are returned without trying the next one, as well as
+// the errors of the non idempotent methods that could have been received by
+// the backend. Once the context is done, no more backends are tried and the
+// result of the call is not recorded, neither as a failure nor as a success,
+// since it does not depend on the backend.
+func call[T any](ctx context.Context, f *FailoverAPI, method string, fn func(farcasterapi.API) (T, error)) (T, error) {
+	var res T
+	var lastErr error
+	group := methodGroups[method]
+	for _, b := range f.route(method) {
+		if ctx.Err() != nil {
+			break
+		}
+		c := b.circuits[group]
+		if !c.acquire() {
+			continue
+		}
+		r, err := fn(b.api)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// the call was canceled or timed out by the caller, so it says
+			// nothing about the backend, not even if it was the probe
+			c.release()
+			return res, fmt.Errorf("%s: %w", b.name, err)
+		case err == nil || !isBackendError(err):
+			c.success(b.name, group)
+			return r, err
+		}
+		c.failure(b.name, method, err, f.failureThreshold, f.openTimeout)
+		lastErr = fmt.Errorf("%s: %w", b.name, err)
+		if nonIdempotentMethods[method] && !isUnsentError(err) {
+			return res, lastErr
+		}
+	}
+	switch {
+	case lastErr != nil:
+		return res, lastErr
+	case ctx.Err() != nil:
+		return res, ctx.Err()
+	default:
+		return res, ErrNoBackendAvailable
+	}
+}
+
+// exec is the same as call for the functions that only return an error.
+func exec(ctx context.Context, f *FailoverAPI, method string, fn func(farcasterapi.API) error) error {
+	_, err := call(ctx, f, method, func(api farcasterapi.API) (struct{}, error) {
+		return struct{}{}, fn(api)
+	})
+	return err
+}
+
+// acquire returns true if the backend can be called, that is, if the circuit
+// is closed, or if its open timeout has expired and no other call is probing
+// the backend. In that case, the call is the probe until its result is
+// recorded or the circuit is released.
+func (c *circuit) acquire() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.openUntil.IsZero() {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// release ends the probe of the backend without recording its result.
+func (c *circuit) release() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.probing = false
+}
+
+// success records a successful call, closing the circuit.
+func (c *circuit) success(name, group string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.openUntil.IsZero() {
+		log.Infow("farcaster api backend circuit closed", "backend", name, "group", group)
+	}
+	c.failures = 0
+	c.openUntil = time.Time{}
+	c.probing = false
+	c.successes++
+}
+
+// failure records a failed call, opening the circuit for the given timeout if
+// the failures threshold is reached. A failed probe opens the circuit again.
+func (c *circuit) failure(name, method string, err error, threshold int, timeout time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.probing = false
+	c.failures++
+	c.errors++
+	c.lastErr = err
+	log.Debugw("farcaster api backend failed", "backend", name, "method", method, "error", err)
+	if c.failures >= threshold {
+		c.openUntil = time.Now().Add(timeout)
+		log.Warnw("farcaster api backend circuit opened", "backend", name, "group", methodGroups[method],
+			"failures", c.failures, "until", c.openUntil, "error", err)
+	}
+}
+
+// isBackendError returns false for the errors that do not mean that the
+// backend is failing, so the next backend should not be tried.
+func isBackendError(err error) bool {
+	return !errors.Is(err, farcasterapi.ErrNoDataFound) &&
+		!errors.Is(err, farcasterapi.ErrNoNewCasts) &&
+		!errors.Is(err, farcasterapi.ErrChannelNotFound)
+}
+
+// isUnsentError returns true for the errors that happen before the request
+// reaches the backend, like the connection errors, or when the backend rejects
+// it without processing it, like the rate limit errors. Only these errors allow
+// retrying the non idempotent methods on the next backend.
+func isUnsentError(err error) bool {
+	if errors.Is(err, farcasterapi.ErrRateLimited) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isGroup(route string) bool {
+	for _, group := range groups {
+		if route == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,306 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+const testFID = 1
+
+var errBackend = fmt.Errorf("500 internal server error")
+
+// newTestBackends returns mock backends with the given names, each one with
+// the test user named as the backend, so the backend used can be identified.
+func newTestBackends(names ...string) ([]*Backend, map[string]*mock.MockAPI) {
+	backends := []*Backend{}
+	mocks := map[string]*mock.MockAPI{}
+	for _, name := range names {
+		m := mock.NewMockAPI()
+		m.AddFixtures(&mock.Fixtures{Users: []*mock.UserFixture{{FID: testFID, Username: name}}})
+		if err := m.SetFarcasterUser(testFID, ""); err != nil {
+			panic(err)
+		}
+		backends = append(backends, &Backend{Name: name, API: m})
+		mocks[name] = m
+	}
+	return backends, mocks
+}
+
+// health returns the health of the backend for the group provided.
+func health(f *FailoverAPI, name, group string) *BackendHealth {
+	for _, h := range f.Health() {
+		if h.Name == name && h.Group == group {
+			return h
+		}
+	}
+	return nil
+}
+
+func TestParseRoutes(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		value    string
+		expected map[string][]string
+		err      bool
+	}{
+		{value: "", expected: map[string][]string{}},
+		{value: "users=hub", expected: map[string][]string{"users": {"hub"}}},
+		{
+			value: " users = hub, neynar ;; Publish=neynar; ",
+			expected: map[string][]string{
+				"users":   {"hub", "neynar"},
+				"Publish": {"neynar"},
+			},
+		},
+		{value: "users", err: true},
+		{value: "users=", err: true},
+		{value: "users= , ", err: true},
+	}
+	for _, test := range tests {
+		c.Run(test.value, func(c *qt.C) {
+			routes, err := ParseRoutes(test.value)
+			if test.err {
+				c.Assert(err, qt.IsNotNil)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(routes, qt.DeepEquals, test.expected)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	c := qt.New(t)
+
+	backends, _ := newTestBackends("a", "b")
+	_, err := New(nil, nil)
+	c.Assert(err, qt.IsNotNil)
+	_, err = New(append(backends, backends[0]), nil)
+	c.Assert(err, qt.ErrorMatches, "duplicated backend a")
+	_, err = New(backends, &Config{Routes: map[string][]string{"users": {"c"}}})
+	c.Assert(err, qt.ErrorMatches, "unknown backend c in route users")
+	_, err = New(backends, &Config{Routes: map[string][]string{"Unknown": {"a"}}})
+	c.Assert(err, qt.ErrorMatches, "unknown route Unknown")
+}
+
+func TestRoutingOrder(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	backends, _ := newTestBackends("a", "b", "c")
+	f, err := New(backends, &Config{Routes: map[string][]string{
+		GroupUsers:           {"b", "a"},
+		"UserDataByUsername": {"c"},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	// the group route has precedence over the default order
+	user, err := f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "b")
+	// the method route has precedence over the group route
+	_, err = f.UserDataByUsername(ctx, "c")
+	c.Assert(err, qt.IsNil)
+	c.Assert(health(f, "c", GroupUsers).Successes, qt.Equals, uint64(1))
+	// the methods without route use the default order
+	_, err = f.GetCast(ctx, testFID, "0x01")
+	c.Assert(err, qt.ErrorIs, farcasterapi.ErrNoDataFound)
+	c.Assert(health(f, "a", GroupCasts).Successes, qt.Equals, uint64(1))
+	c.Assert(health(f, "b", GroupCasts).Successes, qt.Equals, uint64(0))
+}
+
+func TestCircuit(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	backends, mocks := newTestBackends("a", "b")
+	f, err := New(backends, &Config{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+
+	// the errors of the data requested do not fail over
+	_, err = f.UserFollowers(ctx, 2)
+	c.Assert(err, qt.ErrorIs, farcasterapi.ErrNoDataFound)
+	c.Assert(health(f, "b", GroupUsers).Successes, qt.Equals, uint64(0))
+
+	// the backend errors fail over to the next backend until the threshold
+	// opens the circuit
+	mocks["a"].SetError("UserDataByFID", errBackend)
+	for i := 0; i < 2; i++ {
+		user, err := f.UserDataByFID(ctx, testFID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(user.Username, qt.Equals, "b")
+	}
+	h := health(f, "a", GroupUsers)
+	c.Assert(h.Available, qt.IsFalse)
+	c.Assert(h.Failures, qt.Equals, uint64(2))
+	c.Assert(h.LastError, qt.Equals, errBackend.Error())
+	// the circuit of the group is open, so the backend is skipped
+	_, err = f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(health(f, "a", GroupUsers).Failures, qt.Equals, uint64(2))
+	// the circuits of the other groups are still closed
+	c.Assert(health(f, "a", GroupCasts).Available, qt.IsTrue)
+
+	// once the timeout expires, the circuit is half-open and a failed probe
+	// opens it again
+	time.Sleep(60 * time.Millisecond)
+	c.Assert(health(f, "a", GroupUsers).Available, qt.IsTrue)
+	_, err = f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	h = health(f, "a", GroupUsers)
+	c.Assert(h.Available, qt.IsFalse)
+	c.Assert(h.Failures, qt.Equals, uint64(3))
+
+	// only one call probes the half-open circuit
+	time.Sleep(60 * time.Millisecond)
+	circuit := f.backends["a"].circuits[GroupUsers]
+	c.Assert(circuit.acquire(), qt.IsTrue)
+	c.Assert(circuit.acquire(), qt.IsFalse)
+	circuit.release()
+
+	// a successful probe closes the circuit
+	mocks["a"].SetError("UserDataByFID", nil)
+	user, err := f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "a")
+	h = health(f, "a", GroupUsers)
+	c.Assert(h.Available, qt.IsTrue)
+	c.Assert(h.ConsecutiveFailures, qt.Equals, 0)
+
+	// if every circuit is open, no backend is available
+	mocks["a"].SetError("Channel", errBackend)
+	mocks["b"].SetError("Channel", errBackend)
+	for i := 0; i < 2; i++ {
+		_, err = f.Channel(ctx, "vocdoni")
+		c.Assert(err, qt.ErrorIs, errBackend)
+	}
+	_, err = f.Channel(ctx, "vocdoni")
+	c.Assert(err, qt.ErrorIs, ErrNoBackendAvailable)
+}
+
+func TestNonIdempotentMethods(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	backends, mocks := newTestBackends("a", "b")
+	f, err := New(backends, nil)
+	c.Assert(err, qt.IsNil)
+
+	// an unclear error could mean that the cast was published, so the next
+	// backend is not tried
+	mocks["a"].SetError("Publish", errBackend)
+	c.Assert(f.Publish(ctx, "hello", nil), qt.ErrorIs, errBackend)
+	c.Assert(mocks["b"].PublishedCasts(), qt.HasLen, 0)
+	c.Assert(health(f, "a", GroupCasts).Failures, qt.Equals, uint64(1))
+
+	// the errors that happen before sending the request fail over
+	unsent := []error{
+		fmt.Errorf("error publishing: %w", farcasterapi.ErrRateLimited),
+		&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")},
+		&net.DNSError{Err: "no such host", Name: "hub"},
+	}
+	for i, err := range unsent {
+		mocks["a"].SetError("DirectMessage", err)
+		c.Assert(f.DirectMessage(ctx, "hello", 2), qt.IsNil)
+		c.Assert(mocks["b"].DirectMessages(2), qt.HasLen, i+1)
+	}
+}
+
+func TestContextDone(t *testing.T) {
+	c := qt.New(t)
+
+	backends, mocks := newTestBackends("a", "b")
+	f, err := New(backends, &Config{FailureThreshold: 1})
+	c.Assert(err, qt.IsNil)
+
+	// the calls with an expired context do not count as backend failures and
+	// are not tried on the next backends
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+	for _, name := range []string{"a", "b"} {
+		h := health(f, name, GroupUsers)
+		c.Assert(h.Available, qt.IsTrue)
+		c.Assert(h.Failures, qt.Equals, uint64(0))
+	}
+	c.Assert(health(f, "b", GroupUsers).Successes, qt.Equals, uint64(0))
+
+	// the timeouts of a backend with a valid context are backend failures
+	mocks["a"].SetError("UserDataByFID", context.DeadlineExceeded)
+	user, err := f.UserDataByFID(context.Background(), testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "b")
+	c.Assert(health(f, "a", GroupUsers).Available, qt.IsFalse)
+}
+
+// cancelingAPI is a mock backend that cancels the context of the caller when
+// the user data is requested, if its cancel function is set.
+type cancelingAPI struct {
+	*mock.MockAPI
+	cancel context.CancelFunc
+}
+
+func (api *cancelingAPI) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.Userdata, error) {
+	if api.cancel == nil {
+		return api.MockAPI.UserDataByFID(ctx, fid)
+	}
+	api.cancel()
+	return nil, fmt.Errorf("error downloading user data: %w", ctx.Err())
+}
+
+func TestCanceledProbe(t *testing.T) {
+	c := qt.New(t)
+
+	backends, mocks := newTestBackends("a", "b")
+	api := &cancelingAPI{MockAPI: mocks["a"]}
+	backends[0].API = api
+	f, err := New(backends, &Config{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+
+	// open the circuit of the backend
+	mocks["a"].SetError("UserDataByFID", errBackend)
+	_, err = f.UserDataByFID(context.Background(), testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(health(f, "a", GroupUsers).Available, qt.IsFalse)
+
+	// a probe canceled by the caller neither closes nor opens the circuit
+	// again, and the next call probes the backend
+	time.Sleep(60 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api.cancel = cancel
+	_, err = f.UserDataByFID(ctx, testFID)
+	c.Assert(err, qt.ErrorIs, context.Canceled)
+	h := health(f, "a", GroupUsers)
+	c.Assert(h.Available, qt.IsTrue)
+	c.Assert(h.ConsecutiveFailures, qt.Equals, 1)
+	c.Assert(h.Failures, qt.Equals, uint64(1))
+	c.Assert(h.Successes, qt.Equals, uint64(0))
+	c.Assert(health(f, "b", GroupUsers).Successes, qt.Equals, uint64(1))
+
+	// the context canceled errors of a backend with a valid context are
+	// backend failures
+	api.cancel = nil
+	mocks["a"].SetError("UserDataByFID", context.Canceled)
+	user, err := f.UserDataByFID(context.Background(), testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "b")
+	c.Assert(health(f, "a", GroupUsers).Available, qt.IsFalse)
+
+	// the next probe closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	mocks["a"].SetError("UserDataByFID", nil)
+	user, err = f.UserDataByFID(context.Background(), testFID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, "a")
+	c.Assert(health(f, "a", GroupUsers).ConsecutiveFailures, qt.Equals, 0)
+}
@@ -0,0 +1,218 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// eventsPollInterval is the time to wait for new events once the stream
+	// has reached the last event of the hub.
+	eventsPollInterval = 2 * time.Second
+	// eventSequenceBits is the number of bits of the hub event ids used for
+	// the sequence number of the events of the same millisecond.
+	eventSequenceBits = 12
+)
+
+// StreamMentions method subscribes to the events of the hub from the given
+// cursor, which is the hub event id to start from, and sends the mentions of
+// the farcaster user set to the events channel. A zero cursor starts the
+// stream from the current time. The cursor of every event points to the next
+// hub event, and an event without message is sent after every page of hub
+// events to advance the cursor. It returns when the context is done or if
+// something goes wrong reading the events.
+func (h *Hub) StreamMentions(ctx context.Context, cursor uint64, events chan<- *farcasterapi.MentionEvent) error {
+	if h.fid == 0 {
+		return fmt.Errorf("no farcaster user set")
+	}
+	log.Infow("streaming hub mentions", "fid", h.fid, "cursor", cursor)
+	send := func(event *farcasterapi.MentionEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return h.streamEvents(ctx, cursor, func(page []*hubEvent, next uint64) bool {
+		for _, event := range page {
+			if message, ok := h.mentionFromEvent(event); ok {
+				if !send(&farcasterapi.MentionEvent{Message: message, Cursor: event.ID + 1}) {
+					return false
+				}
+			}
+		}
+		return send(&farcasterapi.MentionEvent{Cursor: next})
+	})
+}
+
+// StreamChannelCasts method subscribes to the events of the hub from the
+// given cursor, which is the hub event id to start from, and sends the
+// authors of the new casts published in channels (casts with a parent url)
+// to the events channel, grouped by page of hub events. A zero cursor starts
+// the stream from the current time. The cursor of every event points to the
+// next page of hub events. It returns when the context is done or if
+// something goes wrong reading the events.
+func (h *Hub) StreamChannelCasts(ctx context.Context, cursor uint64, events chan<- *farcasterapi.ChannelCastsEvent) error {
+	log.Infow("streaming hub channel casts", "cursor", cursor)
+	return h.streamEvents(ctx, cursor, func(page []*hubEvent, next uint64) bool {
+		event := &farcasterapi.ChannelCastsEvent{
+			Authors: make(map[string][]uint64),
+			Cursor:  next,
+		}
+		for _, e := range page {
+			if parentURL, author, ok := channelCastFromEvent(e); ok && !slices.Contains(event.Authors[parentURL], author) {
+				event.Authors[parentURL] = append(event.Authors[parentURL], author)
+			}
+		}
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// streamEvents method reads the pages of hub events from the given cursor,
+// or from the current time if it is zero, and calls the handler with every
+// page and the cursor of the next one, waiting for new events once the
+// stream is up to date. The pages that do not advance the cursor are not
+// handled. It returns when the context is done, when the handler returns
+// false or if something goes wrong reading the events.
+func (h *Hub) streamEvents(ctx context.Context, cursor uint64, handler func(page []*hubEvent, next uint64) bool) error {
+	if cursor == 0 {
+		cursor = eventIDFromTime(time.Now())
+	}
+	for {
+		page, err := h.events(ctx, cursor)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		// advance the cursor to the next page, if the hub does not provide it
+		// use the next event of the last one received
+		next := page.NextPageEventID
+		if len(page.Events) > 0 && next <= cursor {
+			next = page.Events[len(page.Events)-1].ID + 1
+		}
+		if next > cursor {
+			if !handler(page.Events, next) {
+				return nil
+			}
+			cursor = next
+		}
+		// wait for new events if the stream is up to date
+		if len(page.Events) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(eventsPollInterval):
+			}
+		}
+	}
+}
+
+// events method returns the page of hub events that starts at the given
+// event id.
+func (h *Hub) events(ctx context.Context, fromEventID uint64) (*hubEventsResponse, error) {
+	internalCtx, cancel := context.WithTimeout(ctx, eventsTimeout)
+	defer cancel()
+	req, err := h.newRequest(internalCtx, http.MethodGet, fmt.Sprintf(ENDPOINT_EVENTS, fromEventID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading events: %w", err)
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Error("error closing response body")
+		}
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError("error downloading events", res)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	page := &hubEventsResponse{}
+	if err := json.Unmarshal(body, page); err != nil {
+		return nil, fmt.Errorf("error unmarshalling events: %w", err)
+	}
+	return page, nil
+}
+
+// mentionFromEvent method returns the cast of the hub event provided if it is
+// a new cast that mentions the farcaster user set.
+func (h *Hub) mentionFromEvent(event *hubEvent) (*farcasterapi.APIMessage, bool) {
+	if event.Type != EVENT_TYPE_MERGE_MESSAGE || event.MergeMessageBody == nil {
+		return nil, false
+	}
+	m := event.MergeMessageBody.Message
+	if m == nil || m.Data == nil || m.Data.Type != MESSAGE_TYPE_CAST_ADD || m.Data.CastAddBody == nil {
+		return nil, false
+	}
+	if m.Data.CastAddBody.Text == "" || !slices.Contains(m.Data.CastAddBody.Mentions, h.fid) {
+		return nil, false
+	}
+	content, err := h.composeCastContent(m.Data.CastAddBody)
+	if err != nil {
+		log.Error(err)
+	}
+	embeds := []string{}
+	for _, e := range m.Data.CastAddBody.Embeds {
+		embeds = append(embeds, e.Url)
+	}
+	var parent *farcasterapi.ParentAPIMessage
+	if m.Data.CastAddBody.ParentCast != nil {
+		parent = &farcasterapi.ParentAPIMessage{
+			FID:  m.Data.CastAddBody.ParentCast.FID,
+			Hash: m.Data.CastAddBody.ParentCast.Hash,
+		}
+	}
+	return &farcasterapi.APIMessage{
+		IsMention: true,
+		Content:   content,
+		Author:    m.Data.From,
+		Hash:      m.HexHash,
+		Parent:    parent,
+		Embeds:    embeds,
+	}, true
+}
+
+// channelCastFromEvent returns the parent url and the author of the cast of
+// the hub event provided if it is a new cast published in a channel.
+func channelCastFromEvent(event *hubEvent) (string, uint64, bool) {
+	if event.Type != EVENT_TYPE_MERGE_MESSAGE || event.MergeMessageBody == nil {
+		return "", 0, false
+	}
+	m := event.MergeMessageBody.Message
+	if m == nil || m.Data == nil || m.Data.Type != MESSAGE_TYPE_CAST_ADD || m.Data.CastAddBody == nil {
+		return "", 0, false
+	}
+	if m.Data.CastAddBody.ParentURL == "" {
+		return "", 0, false
+	}
+	return m.Data.CastAddBody.ParentURL, m.Data.From, true
+}
+
+// eventIDFromTime returns the first hub event id of the given time. The hub
+// event ids are the milliseconds since the farcaster epoch followed by a
+// sequence number.
+func eventIDFromTime(t time.Time) uint64 {
+	ms := uint64(t.UnixMilli()) - farcasterEpoch*1000
+	return ms << eventSequenceBits
+}
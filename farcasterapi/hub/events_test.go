@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+const channelURL = "https://warpcast.com/~/channel/vocdoni"
+
+// castEvent returns a hub event that merges a new cast of the given author
+// with the parent url and mentions provided.
+func castEvent(id, author uint64, parentURL string, mentions ...uint64) *hubEvent {
+	return &hubEvent{
+		Type: EVENT_TYPE_MERGE_MESSAGE,
+		ID:   id,
+		MergeMessageBody: &hubMergeMessageBody{Message: &hubMessage{
+			HexHash: "0x" + strconv.FormatUint(id, 16),
+			Data: &hubMessageData{
+				Type: MESSAGE_TYPE_CAST_ADD,
+				From: author,
+				CastAddBody: &hubCastAddBody{
+					Text:      "hello",
+					ParentURL: parentURL,
+					Mentions:  mentions,
+					// the mentions are at the start of the text
+					MentionsPositions: make([]uint64, len(mentions)),
+				},
+			},
+		}},
+	}
+}
+
+// testHub returns a Hub that gets the events from a test server that serves
+// the pages provided, by the event id they start from.
+func testHub(c *qt.C, pages map[uint64]*hubEventsResponse) *Hub {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, err := strconv.ParseUint(r.URL.Query().Get("from_event_id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, ok := pages[from]
+		if !ok {
+			page = &hubEventsResponse{}
+		}
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	c.Cleanup(srv.Close)
+	h, err := NewHubAPI(srv.URL, nil)
+	c.Assert(err, qt.IsNil)
+	return h
+}
+
+func TestEventIDFromTime(t *testing.T) {
+	c := qt.New(t)
+
+	epoch := time.Unix(int64(farcasterEpoch), 0)
+	c.Assert(eventIDFromTime(epoch), qt.Equals, uint64(0))
+	c.Assert(eventIDFromTime(epoch.Add(time.Millisecond)), qt.Equals, uint64(1)<<eventSequenceBits)
+	c.Assert(eventIDFromTime(epoch.Add(time.Second)), qt.Equals, uint64(1000)<<eventSequenceBits)
+	// the ids are sorted by time
+	now := time.Now()
+	c.Assert(eventIDFromTime(now) < eventIDFromTime(now.Add(time.Millisecond)), qt.IsTrue)
+}
+
+func TestMentionFromEvent(t *testing.T) {
+	c := qt.New(t)
+
+	h := &Hub{fid: 100}
+	event := castEvent(1, 10, "", 100)
+	event.MergeMessageBody.Message.Data.CastAddBody.ParentCast = &hubParentCast{FID: 20, Hash: "0xaa"}
+	event.MergeMessageBody.Message.Data.CastAddBody.Embeds = []*hubCastEmbeds{{Url: "https://example.com"}}
+	msg, ok := h.mentionFromEvent(event)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(msg, qt.DeepEquals, &farcasterapi.APIMessage{
+		IsMention: true,
+		Content:   "hello",
+		Author:    10,
+		Hash:      "0x1",
+		Parent:    &farcasterapi.ParentAPIMessage{FID: 20, Hash: "0xaa"},
+		Embeds:    []string{"https://example.com"},
+	})
+
+	// casts that do not mention the user, casts without text, other messages
+	// and other events are ignored
+	_, ok = h.mentionFromEvent(castEvent(2, 10, ""))
+	c.Assert(ok, qt.IsFalse)
+	empty := castEvent(3, 10, "", 100)
+	empty.MergeMessageBody.Message.Data.CastAddBody.Text = ""
+	_, ok = h.mentionFromEvent(empty)
+	c.Assert(ok, qt.IsFalse)
+	reaction := castEvent(4, 10, "", 100)
+	reaction.MergeMessageBody.Message.Data.Type = "MESSAGE_TYPE_REACTION_ADD"
+	_, ok = h.mentionFromEvent(reaction)
+	c.Assert(ok, qt.IsFalse)
+	_, ok = h.mentionFromEvent(&hubEvent{Type: "HUB_EVENT_TYPE_PRUNE_MESSAGE", ID: 5})
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestChannelCastFromEvent(t *testing.T) {
+	c := qt.New(t)
+
+	url, author, ok := channelCastFromEvent(castEvent(1, 10, channelURL))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(url, qt.Equals, channelURL)
+	c.Assert(author, qt.Equals, uint64(10))
+
+	// casts without parent url, other messages and other events are ignored
+	_, _, ok = channelCastFromEvent(castEvent(2, 10, ""))
+	c.Assert(ok, qt.IsFalse)
+	reaction := castEvent(3, 10, channelURL)
+	reaction.MergeMessageBody.Message.Data.Type = "MESSAGE_TYPE_REACTION_ADD"
+	_, _, ok = channelCastFromEvent(reaction)
+	c.Assert(ok, qt.IsFalse)
+	_, _, ok = channelCastFromEvent(&hubEvent{Type: "HUB_EVENT_TYPE_PRUNE_MESSAGE", ID: 4})
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestStreamChannelCasts(t *testing.T) {
+	c := qt.New(t)
+
+	otherURL := "chain://eip155:1/erc721:0x0000000000000000000000000000000000000001"
+	h := testHub(c, map[uint64]*hubEventsResponse{
+		100: {
+			Events: []*hubEvent{
+				castEvent(100, 10, channelURL),
+				castEvent(101, 11, channelURL),
+				castEvent(102, 10, channelURL),
+				castEvent(103, 12, ""),
+			},
+			NextPageEventID: 104,
+		},
+		// a page without next page id advances after its last event
+		104: {Events: []*hubEvent{castEvent(110, 13, otherURL)}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan *farcasterapi.ChannelCastsEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.StreamChannelCasts(ctx, 100, events)
+	}()
+
+	event := <-events
+	c.Assert(event.Cursor, qt.Equals, uint64(104))
+	c.Assert(event.Authors, qt.DeepEquals, map[string][]uint64{channelURL: {10, 11}})
+	event = <-events
+	c.Assert(event.Cursor, qt.Equals, uint64(111))
+	c.Assert(event.Authors, qt.DeepEquals, map[string][]uint64{otherURL: {13}})
+
+	cancel()
+	c.Assert(<-errCh, qt.IsNil)
+}
@@ -184,3 +184,23 @@ func (h *Hub) newRequest(ctx context.Context, method string, uri string, body io
 	}
 	return req, nil
 }
+
+// registrationTime returns the time of the first register event of the given
+// FID from the IdRegistry events provided. The transfers and recovery changes
+// of the FID are ignored. It returns false if there is no register event.
+func registrationTime(fid uint64, events []*hubOnChainEvent) (time.Time, bool) {
+	var first *hubOnChainEvent
+	for _, event := range events {
+		if event == nil || event.FID != fid || event.Type != ONCHAIN_EVENT_TYPE_ID_REGISTER ||
+			event.IDRegisterEventBody == nil || event.IDRegisterEventBody.EventType != ID_REGISTER_EVENT_TYPE_REGISTER {
+			continue
+		}
+		if first == nil || event.BlockNumber < first.BlockNumber {
+			first = event
+		}
+	}
+	if first == nil || first.BlockTimestamp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(first.BlockTimestamp, 0), true
+}
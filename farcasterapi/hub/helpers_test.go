@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func idRegistryEvent(fid, block uint64, timestamp int64, eventType string) *hubOnChainEvent {
+	return &hubOnChainEvent{
+		Type:                ONCHAIN_EVENT_TYPE_ID_REGISTER,
+		FID:                 fid,
+		BlockNumber:         block,
+		BlockTimestamp:      timestamp,
+		IDRegisterEventBody: &hubIDRegisterEventBody{EventType: eventType},
+	}
+}
+
+func TestRegistrationTime(t *testing.T) {
+	c := qt.New(t)
+	const transfer = "ID_REGISTER_EVENT_TYPE_TRANSFER"
+	tests := []struct {
+		name   string
+		events []*hubOnChainEvent
+		want   int64
+		ok     bool
+	}{
+		{name: "no events"},
+		{
+			name:   "register",
+			events: []*hubOnChainEvent{idRegistryEvent(3, 100, 1695949583, ID_REGISTER_EVENT_TYPE_REGISTER)},
+			want:   1695949583,
+			ok:     true,
+		},
+		{
+			name: "transfers ignored",
+			events: []*hubOnChainEvent{
+				idRegistryEvent(3, 300, 1700000000, transfer),
+				idRegistryEvent(3, 100, 1695949583, ID_REGISTER_EVENT_TYPE_REGISTER),
+			},
+			want: 1695949583,
+			ok:   true,
+		},
+		{
+			name:   "only transfers",
+			events: []*hubOnChainEvent{idRegistryEvent(3, 300, 1700000000, transfer)},
+		},
+		{
+			name: "first register",
+			events: []*hubOnChainEvent{
+				idRegistryEvent(3, 200, 1696000000, ID_REGISTER_EVENT_TYPE_REGISTER),
+				idRegistryEvent(3, 100, 1695949583, ID_REGISTER_EVENT_TYPE_REGISTER),
+			},
+			want: 1695949583,
+			ok:   true,
+		},
+		{
+			name:   "other fid",
+			events: []*hubOnChainEvent{idRegistryEvent(4, 100, 1695949583, ID_REGISTER_EVENT_TYPE_REGISTER)},
+		},
+		{
+			name:   "no timestamp",
+			events: []*hubOnChainEvent{idRegistryEvent(3, 100, 0, ID_REGISTER_EVENT_TYPE_REGISTER)},
+		},
+		{
+			name:   "nil body",
+			events: []*hubOnChainEvent{{Type: ONCHAIN_EVENT_TYPE_ID_REGISTER, FID: 3, BlockNumber: 100, BlockTimestamp: 1695949583}},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			registeredAt, ok := registrationTime(3, test.events)
+			c.Assert(ok, qt.Equals, test.ok)
+			if test.ok {
+				c.Assert(registeredAt.Equal(time.Unix(test.want, 0)), qt.IsTrue)
+			}
+		})
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -23,25 +25,44 @@ const (
 	ENDPOINT_SUBMIT_MESSAGE        = "submitMessage"
 	ENDPOINT_USERDATA              = "userDataByFid?fid=%d"
 	ENDPOINT_CUSTODY_ADDRESS       = "userNameProofsByFid?fid=%d"
+	ENDPOINT_USERNAME_PROOF        = "userNameProofByName?name=%s"
 	ENDPOINT_USER_FOLLOWERs        = "linksByTargetFid?target_fid=%d"
+	ENDPOINT_USER_FOLLOWING        = "linksByFid?fid=%d&link_type=follow&pageSize=%d&pageToken=%s"
 	ENDPOINT_VERIFICATIONS         = "verificationsByFid?fid=%d"
 	ENDPOINT_IDREGISTRY_BY_ADDRESS = "onChainIdRegistryEventByAddress?address=%s"
+	ENDPOINT_ONCHAIN_EVENTS        = "onChainEventsByFid?fid=%d&event_type=%s"
+	ENDPOINT_REACTIONS_BY_CAST     = "reactionsByCast?target_fid=%d&target_hash=%s&reaction_type=%s&pageSize=%d&pageToken=%s"
+	ENDPOINT_CASTS_BY_PARENT       = "castsByParent?fid=%d&hash=%s&pageSize=%d&pageToken=%s"
+	ENDPOINT_EVENTS                = "events?from_event_id=%d"
 	// timeouts
 	getCastTimeout          = 10 * time.Second
 	getCastByMentionTimeout = 15 * time.Second
 	submitMessageTimeout    = 5 * time.Minute
 	userdataTimeout         = 15 * time.Second
 	userFollowersTimeout    = 15 * time.Second
+	castReactionsTimeout    = 15 * time.Second
+	eventsTimeout           = 15 * time.Second
+	onChainEventsTimeout    = 15 * time.Second
 	// message types
 	MESSAGE_TYPE_CAST_ADD     = "MESSAGE_TYPE_CAST_ADD"
 	MESSAGE_TYPE_USERPROOF    = "USERNAME_TYPE_FNAME"
 	MESSAGE_TYPE_VERIFICATION = "MESSAGE_TYPE_VERIFICATION_ADD_ETH_ADDRESS"
 	MESSAGE_TYPE_LINK         = "MESSAGE_TYPE_LINK_ADD"
 	MESSAGE_TYPE_USERDATA_ADD = "MESSAGE_TYPE_USER_DATA_ADD"
+	MESSAGE_TYPE_REACTION_ADD = "MESSAGE_TYPE_REACTION_ADD"
+	// event types
+	EVENT_TYPE_MERGE_MESSAGE = "HUB_EVENT_TYPE_MERGE_MESSAGE"
+	// onchain event types
+	ONCHAIN_EVENT_TYPE_ID_REGISTER  = "EVENT_TYPE_ID_REGISTER"
+	ID_REGISTER_EVENT_TYPE_REGISTER = "ID_REGISTER_EVENT_TYPE_REGISTER"
+	// reaction types
+	REACTION_TYPE_LIKE   = "REACTION_TYPE_LIKE"
+	REACTION_TYPE_RECAST = "REACTION_TYPE_RECAST"
 	// user data types
 	USERDATA_TYPE_USERNAME = "USER_DATA_TYPE_USERNAME"
 	// other constants
 	farcasterEpoch uint64 = 1609459200 // January 1, 2021 UTC
+	pageSize              = 1000
 )
 
 // Hub struct implements the farcasterapi.API interface and represents the
@@ -51,6 +72,24 @@ type Hub struct {
 	privKey  []byte
 	endpoint string
 	auth     map[string]string
+	signers  SignersProvider
+	channels ChannelIndex
+}
+
+// SignersProvider is the interface that the Hub uses to get the signers of a
+// FID, since the Hub HTTP API does not expose the onchain app keys.
+type SignersProvider interface {
+	SignersFromFID(fid uint64) ([]string, error)
+}
+
+// ChannelIndex is the interface that the Hub uses to get the channels details
+// and members, since the Hub API does not store channels metadata. The index
+// is built in the background from the channel casts streamed by the hub (see
+// StreamChannelCasts).
+type ChannelIndex interface {
+	Channel(channelID string) (*farcasterapi.Channel, error)
+	SearchChannels(query string) ([]*farcasterapi.Channel, error)
+	ChannelMembers(channelID string) ([]uint64, error)
 }
 
 // Init initializes the API Hub with the given arguments.
@@ -118,7 +157,7 @@ func (h *Hub) LastMentions(ctx context.Context, timestamp uint64) ([]*farcastera
 		}
 	}()
 	if res.StatusCode != http.StatusOK {
-		return nil, 0, fmt.Errorf("error downloading json: %s", res.Status)
+		return nil, 0, statusError("error downloading json", res)
 	}
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -196,7 +235,7 @@ func (h *Hub) GetCast(ctx context.Context, fid uint64, hash string) (*farcastera
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error downloading cast: %s", res.Status)
+		return nil, statusError("error downloading cast", res)
 	}
 	// read the response body
 	body, err := io.ReadAll(res.Body)
@@ -273,6 +312,9 @@ func (h *Hub) Publish(ctx context.Context, content string, mentionFIDs []uint64,
 		return fmt.Errorf("error submitting the message: %s", err)
 	}
 	if res.StatusCode != http.StatusOK {
+		if res.StatusCode == http.StatusTooManyRequests {
+			return statusError("error submitting the message", res)
+		}
 		// read the response body
 		body, err := io.ReadAll(res.Body)
 		if err != nil {
@@ -330,6 +372,9 @@ func (h *Hub) Reply(ctx context.Context, targetMsg *farcasterapi.APIMessage,
 		return fmt.Errorf("error submitting the message: %s", err)
 	}
 	if res.StatusCode != http.StatusOK {
+		if res.StatusCode == http.StatusTooManyRequests {
+			return statusError("error submitting the message", res)
+		}
 		// read the response body
 		body, err := io.ReadAll(res.Body)
 		if err != nil {
@@ -386,7 +431,7 @@ func (h *Hub) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.User
 		return nil, fmt.Errorf("error downloading custody address: %w", err)
 	}
 	if custodyAddressRes.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error downloading custody address: %s", custodyAddressRes.Status)
+		return nil, statusError("error downloading custody address", custodyAddressRes)
 	}
 	// read the response body
 	custodyAddressBody, err := io.ReadAll(custodyAddressRes.Body)
@@ -424,7 +469,7 @@ func (h *Hub) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.User
 		return nil, fmt.Errorf("error downloading verifications: %w", err)
 	}
 	if verificationsRes.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error downloading verifications: %s", verificationsRes.Status)
+		return nil, statusError("error downloading verifications", verificationsRes)
 	}
 	// read the response body
 	verificationsBody, err := io.ReadAll(verificationsRes.Body)
@@ -453,6 +498,16 @@ func (h *Hub) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.User
 	for signer := range signersMap {
 		signers = append(signers, signer)
 	}
+	// prefer the signers of the provider if it is set, since the verification
+	// signers could have been revoked, but fall back to the verification
+	// signers if the provider fails
+	if h.signers != nil {
+		if providerSigners, err := h.signers.SignersFromFID(fid); err != nil {
+			log.Warnw("error getting signers from provider, using the verification signers", "fid", fid, "error", err)
+		} else {
+			signers = providerSigners
+		}
+	}
 	return &farcasterapi.Userdata{
 		FID:                    fid,
 		Username:               username,
@@ -462,6 +517,79 @@ func (h *Hub) UserDataByFID(ctx context.Context, fid uint64) (*farcasterapi.User
 	}, nil
 }
 
+// RegistrationTime returns the time when the given FID was registered in the
+// Farcaster IdRegistry contract, that is the timestamp of the block of its
+// register event, as indexed by the hub. It returns an error if the hub has
+// no register event for the FID.
+func (h *Hub) RegistrationTime(ctx context.Context, fid uint64) (time.Time, error) {
+	// create a new context with a timeout
+	internalCtx, cancel := context.WithTimeout(ctx, onChainEventsTimeout)
+	defer cancel()
+	// prepare the request to get the IdRegistry events of the FID
+	uri := fmt.Sprintf(ENDPOINT_ONCHAIN_EVENTS, fid, ONCHAIN_EVENT_TYPE_ID_REGISTER)
+	req, err := h.newRequest(internalCtx, http.MethodGet, uri, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error creating request: %w", err)
+	}
+	// download the events from the API and check for errors
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error downloading onchain events: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return time.Time{}, statusError("error downloading onchain events", res)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading response body: %w", err)
+	}
+	events := &hubOnChainEventsResponse{}
+	if err := json.Unmarshal(body, events); err != nil {
+		return time.Time{}, fmt.Errorf("error unmarshalling onchain events: %w", err)
+	}
+	registeredAt, ok := registrationTime(fid, events.Events)
+	if !ok {
+		return time.Time{}, fmt.Errorf("no register event found for fid %d", fid)
+	}
+	return registeredAt, nil
+}
+
+// UserDataByUsername method returns the userdata of the user with the given
+// username. It gets the fid of the user from its username proof and then
+// returns its userdata. If something goes wrong, it returns an error.
+func (h *Hub) UserDataByUsername(ctx context.Context, username string) (*farcasterapi.Userdata, error) {
+	internalCtx, cancel := context.WithTimeout(ctx, userdataTimeout)
+	defer cancel()
+	req, err := h.newRequest(internalCtx, http.MethodGet, fmt.Sprintf(ENDPOINT_USERNAME_PROOF, url.QueryEscape(username)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating username proof request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading username proof: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError("error downloading username proof", res)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading username proof response body: %w", err)
+	}
+	proof := &usernameProofs{}
+	if err := json.Unmarshal(body, proof); err != nil {
+		return nil, fmt.Errorf("error unmarshalling username proof: %w", err)
+	}
+	if proof.FID == 0 {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return h.UserDataByFID(ctx, proof.FID)
+}
+
 // UserDataByVerificationAddress method returns the user data for the given
 // verification addresses. It returns a slice of user data and an error. Hub
 // does not implement this method.
@@ -488,7 +616,7 @@ func (h *Hub) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error downloading user followers: %s", res.Status)
+		return nil, statusError("error downloading user followers", res)
 	}
 	// read the response body
 	body, err := io.ReadAll(res.Body)
@@ -511,24 +639,182 @@ func (h *Hub) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
 	return followersFids, nil
 }
 
-// Channel method is not supported by the Hub API. It returns an error.
+// UserFollowing method returns the FIDs of the users followed by the user with
+// the given id. If something goes wrong, it returns an error.
+func (h *Hub) UserFollowing(ctx context.Context, fid uint64) ([]uint64, error) {
+	messages, err := h.paginatedMessages(ctx, func(pageToken string) string {
+		return fmt.Sprintf(ENDPOINT_USER_FOLLOWING, fid, pageSize, pageToken)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading user following: %w", err)
+	}
+	// filter the followed FIDs, ignoring duplicates, and return them
+	fids := []uint64{}
+	seen := map[uint64]bool{}
+	for _, msg := range messages {
+		if msg.Data == nil || msg.Data.Type != MESSAGE_TYPE_LINK || msg.Data.LinkBody == nil {
+			continue
+		}
+		if target := msg.Data.LinkBody.TargetFid; target != 0 && !seen[target] {
+			seen[target] = true
+			fids = append(fids, target)
+		}
+	}
+	return fids, nil
+}
+
+// CastReactions method returns the FIDs of the users that reacted to the cast
+// with the given fid and hash with the given reaction type (like or recast).
+// If something goes wrong, it returns an error.
+func (h *Hub) CastReactions(ctx context.Context, fid uint64, hash, reactionType string) ([]uint64, error) {
+	var hubReactionType string
+	switch reactionType {
+	case farcasterapi.ReactionTypeLike:
+		hubReactionType = REACTION_TYPE_LIKE
+	case farcasterapi.ReactionTypeRecast:
+		hubReactionType = REACTION_TYPE_RECAST
+	default:
+		return nil, fmt.Errorf("unsupported reaction type %s", reactionType)
+	}
+	messages, err := h.paginatedMessages(ctx, func(pageToken string) string {
+		return fmt.Sprintf(ENDPOINT_REACTIONS_BY_CAST, fid, hash, hubReactionType, pageSize, pageToken)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading cast reactions: %w", err)
+	}
+	// filter the reactions FIDs, ignoring duplicates, and return them
+	fids := []uint64{}
+	seen := map[uint64]bool{}
+	for _, msg := range messages {
+		if msg.Data == nil || msg.Data.Type != MESSAGE_TYPE_REACTION_ADD || seen[msg.Data.From] {
+			continue
+		}
+		if msg.Data.ReactionBody == nil || msg.Data.ReactionBody.Type != hubReactionType {
+			continue
+		}
+		seen[msg.Data.From] = true
+		fids = append(fids, msg.Data.From)
+	}
+	return fids, nil
+}
+
+// CastRepliers method returns the FIDs of the users that replied to the cast
+// with the given fid and hash. If something goes wrong, it returns an error.
+func (h *Hub) CastRepliers(ctx context.Context, fid uint64, hash string) ([]uint64, error) {
+	messages, err := h.paginatedMessages(ctx, func(pageToken string) string {
+		return fmt.Sprintf(ENDPOINT_CASTS_BY_PARENT, fid, hash, pageSize, pageToken)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading cast replies: %w", err)
+	}
+	// filter the repliers FIDs, ignoring duplicates, and return them
+	fids := []uint64{}
+	seen := map[uint64]bool{}
+	for _, msg := range messages {
+		if msg.Data == nil || msg.Data.Type != MESSAGE_TYPE_CAST_ADD || seen[msg.Data.From] {
+			continue
+		}
+		seen[msg.Data.From] = true
+		fids = append(fids, msg.Data.From)
+	}
+	return fids, nil
+}
+
+// paginatedMessages method downloads all the pages of messages of an endpoint
+// of the Hub API. The uri function must return the uri of the endpoint for
+// the given page token.
+func (h *Hub) paginatedMessages(ctx context.Context, uri func(string) string) ([]*hubMessage, error) {
+	messages := []*hubMessage{}
+	pageToken := ""
+	for {
+		internalCtx, cancel := context.WithTimeout(ctx, castReactionsTimeout)
+		req, err := h.newRequest(internalCtx, http.MethodGet, uri(pageToken), nil)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("error downloading messages: %w", err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		cancel()
+		if res.StatusCode == http.StatusTooManyRequests {
+			return nil, fmt.Errorf("error downloading messages: %w", farcasterapi.ErrRateLimited)
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, statusError("error downloading messages", res)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %w", err)
+		}
+		page := &hubMessageResponse{}
+		if err := json.Unmarshal(body, page); err != nil {
+			return nil, fmt.Errorf("error unmarshalling messages: %w", err)
+		}
+		messages = append(messages, page.Messages...)
+		if page.NextPageToken == "" || len(page.Messages) == 0 {
+			break
+		}
+		pageToken = url.QueryEscape(page.NextPageToken)
+	}
+	return messages, nil
+}
+
+// SetChannelIndex method sets the index used to get the channels details and
+// members.
+func (h *Hub) SetChannelIndex(index ChannelIndex) {
+	h.channels = index
+}
+
+// Channel method returns the channel with the given id from the channel index.
+// If the channel is not found, it returns an ErrChannelNotFound error.
 func (h *Hub) Channel(ctx context.Context, channelID string) (*farcasterapi.Channel, error) {
-	return nil, fmt.Errorf("hub api does not support channels yet")
+	if h.channels == nil {
+		return nil, fmt.Errorf("hub api does not have a channel index")
+	}
+	return h.channels.Channel(channelID)
 }
 
-// ChannelFIDs method is not supported by the Hub API. It returns an error.
-func (h *Hub) ChannelFIDs(ctx context.Context, channelID string, _ chan int) ([]uint64, error) {
-	return nil, fmt.Errorf("hub api does not support channels yet")
+// ChannelFIDs method returns the FIDs of the members of the channel with the
+// given id from the channel index, which are the users that have casted in
+// the channel since the index was started. If the channel does not exist, it
+// returns an ErrChannelNotFound error.
+func (h *Hub) ChannelFIDs(ctx context.Context, channelID string, progress chan int) ([]uint64, error) {
+	if h.channels == nil {
+		return nil, fmt.Errorf("hub api does not have a channel index")
+	}
+	fids, err := h.channels.ChannelMembers(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		progress <- 100
+	}
+	return fids, nil
 }
 
-// ChannelExists method is not supported by the Hub API. It returns an error.
+// ChannelExists method returns a boolean indicating if the channel with the
+// given id exists in the channel index.
 func (h *Hub) ChannelExists(ctx context.Context, channelID string) (bool, error) {
-	return false, fmt.Errorf("hub api does not support channels yet")
+	if _, err := h.Channel(ctx, channelID); err != nil {
+		if errors.Is(err, farcasterapi.ErrChannelNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking channel existence: %w", err)
+	}
+	return true, nil
 }
 
-// ListChannels method is not supported by the Hub API. It returns an error.
+// FindChannel method returns the channels of the channel index that matches
+// with the query provided.
 func (h *Hub) FindChannel(ctx context.Context, query string) ([]*farcasterapi.Channel, error) {
-	return nil, fmt.Errorf("hub api does not support channels yet")
+	if h.channels == nil {
+		return nil, fmt.Errorf("hub api does not have a channel index")
+	}
+	return h.channels.SearchChannels(query)
 }
 
 // DirectMessage method sends a direct message to the user with the given fid.
@@ -545,8 +831,27 @@ func (h *Hub) WebhookHandler(_ []byte) error {
 	return fmt.Errorf("not implemented")
 }
 
+// SetSignersProvider method sets the provider used to get the signers of the
+// users, usually backed by the Farcaster KeyRegistry contract.
+func (h *Hub) SetSignersProvider(provider SignersProvider) {
+	h.signers = provider
+}
+
 // SignersFromFID method returns the signers for the given FID. It returns a
-// slice of signers and an error. Hub does not implement this method.
+// slice of signers and an error. It requires a signers provider to be set.
 func (h *Hub) SignersFromFID(fid uint64) ([]string, error) {
-	return nil, fmt.Errorf("not implemented")
+	if h.signers == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return h.signers.SignersFromFID(fid)
+}
+
+// statusError returns the error of a hub response with an unexpected status
+// code, prefixed by the action provided. If the hub is rejecting the requests
+// due to its rate limits, the error wraps farcasterapi.ErrRateLimited.
+func statusError(action string, res *http.Response) error {
+	if res.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%s: %w", action, farcasterapi.ErrRateLimited)
+	}
+	return fmt.Errorf("%s: %s", action, res.Status)
 }
@@ -18,11 +18,23 @@ type hubCastAddBody struct {
 	ParentCast        *hubParentCast   `json:"parentCastId"`
 }
 
+type hubReactionBody struct {
+	Type       string         `json:"type"`
+	TargetCast *hubParentCast `json:"targetCastId"`
+}
+
+type hubLinkBody struct {
+	Type      string `json:"type"`
+	TargetFid uint64 `json:"targetFid"`
+}
+
 type hubMessageData struct {
-	Type        string          `json:"type"`
-	From        uint64          `json:"fid"`
-	Timestamp   uint64          `json:"timestamp"`
-	CastAddBody *hubCastAddBody `json:"castAddBody,omitempty"`
+	Type         string           `json:"type"`
+	From         uint64           `json:"fid"`
+	Timestamp    uint64           `json:"timestamp"`
+	CastAddBody  *hubCastAddBody  `json:"castAddBody,omitempty"`
+	ReactionBody *hubReactionBody `json:"reactionBody,omitempty"`
+	LinkBody     *hubLinkBody     `json:"linkBody,omitempty"`
 }
 
 type hubMessage struct {
@@ -31,7 +43,40 @@ type hubMessage struct {
 }
 
 type hubMessageResponse struct {
-	Messages []*hubMessage `json:"messages"`
+	Messages      []*hubMessage `json:"messages"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+type hubMergeMessageBody struct {
+	Message *hubMessage `json:"message"`
+}
+
+type hubEvent struct {
+	Type             string               `json:"type"`
+	ID               uint64               `json:"id"`
+	MergeMessageBody *hubMergeMessageBody `json:"mergeMessageBody,omitempty"`
+}
+
+type hubEventsResponse struct {
+	Events          []*hubEvent `json:"events"`
+	NextPageEventID uint64      `json:"nextPageEventId"`
+}
+
+type hubIDRegisterEventBody struct {
+	EventType string `json:"eventType"`
+}
+
+type hubOnChainEvent struct {
+	Type                string                  `json:"type"`
+	FID                 uint64                  `json:"fid"`
+	BlockNumber         uint64                  `json:"blockNumber"`
+	BlockTimestamp      int64                   `json:"blockTimestamp"`
+	IDRegisterEventBody *hubIDRegisterEventBody `json:"idRegisterEventBody,omitempty"`
+}
+
+type hubOnChainEventsResponse struct {
+	Events        []*hubOnChainEvent `json:"events"`
+	NextPageToken string             `json:"nextPageToken"`
 }
 
 type usernameProofs struct {
@@ -0,0 +1,79 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Fixtures is the data loaded into the MockAPI. It is usually decoded from a
+// JSON file, see LoadFixtures.
+type Fixtures struct {
+	Users    []*UserFixture    `json:"users"`
+	Channels []*ChannelFixture `json:"channels"`
+	Casts    []*CastFixture    `json:"casts"`
+}
+
+// UserFixture is a Farcaster user with its signers and social graph.
+type UserFixture struct {
+	FID            uint64   `json:"fid"`
+	Username       string   `json:"username"`
+	Displayname    string   `json:"displayname"`
+	CustodyAddress string   `json:"custodyAddress"`
+	Addresses      []string `json:"addresses"`
+	Signers        []string `json:"signers"`
+	Avatar         string   `json:"avatar"`
+	Bio            string   `json:"bio"`
+	Followers      []uint64 `json:"followers"`
+	Following      []uint64 `json:"following"`
+}
+
+// ChannelFixture is a Farcaster channel and the FIDs of its followers.
+type ChannelFixture struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Image       string   `json:"image"`
+	URL         string   `json:"url"`
+	Followers   []uint64 `json:"followers"`
+}
+
+// CastFixture is a Farcaster cast with its reactions and mentions. If the
+// cast mentions the user set in the MockAPI, it is delivered as a mention.
+type CastFixture struct {
+	Author    uint64         `json:"author"`
+	Hash      string         `json:"hash"`
+	Content   string         `json:"content"`
+	Timestamp uint64         `json:"timestamp"`
+	Parent    *ParentFixture `json:"parent,omitempty"`
+	Embeds    []string       `json:"embeds,omitempty"`
+	Mentions  []uint64       `json:"mentions,omitempty"`
+	Likes     []uint64       `json:"likes,omitempty"`
+	Recasts   []uint64       `json:"recasts,omitempty"`
+}
+
+// ParentFixture is the reference to the parent cast of a cast.
+type ParentFixture struct {
+	FID  uint64 `json:"fid"`
+	Hash string `json:"hash"`
+}
+
+// LoadFixtures decodes the fixtures from the JSON file with the given path.
+func LoadFixtures(path string) (*Fixtures, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fixtures file: %w", err)
+	}
+	defer fd.Close()
+	return DecodeFixtures(fd)
+}
+
+// DecodeFixtures decodes the fixtures from the JSON reader provided.
+func DecodeFixtures(r io.Reader) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	if err := json.NewDecoder(r).Decode(fixtures); err != nil {
+		return nil, fmt.Errorf("error decoding fixtures: %w", err)
+	}
+	return fixtures, nil
+}
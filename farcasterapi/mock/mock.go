@@ -0,0 +1,533 @@
+// Package mock implements an in-memory farcasterapi.API to be used in tests
+// and for local development without any Farcaster provider. Its data is
+// loaded from fixtures and every cast, reply and direct message sent through
+// it is recorded to be checked later.
+package mock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+)
+
+// PublishedCast is a cast published or replied through the MockAPI.
+type PublishedCast struct {
+	Author   uint64
+	Hash     string
+	Content  string
+	Mentions []uint64
+	Embeds   []string
+	// Parent is the cast replied, nil if it is not a reply
+	Parent *farcasterapi.ParentAPIMessage
+}
+
+// DirectMessage is a direct message sent through the MockAPI.
+type DirectMessage struct {
+	From    uint64
+	To      uint64
+	Content string
+}
+
+// MockAPI is an in-memory farcasterapi.API. It is safe for concurrent use.
+type MockAPI struct {
+	mtx      sync.Mutex
+	fid      uint64
+	users    map[uint64]*UserFixture
+	channels map[string]*ChannelFixture
+	casts    map[string]*CastFixture
+	mentions []*CastFixture
+	lastTime uint64
+	errors   map[string]error
+
+	published []*PublishedCast
+	messages  []*DirectMessage
+}
+
+// NewMockAPI returns an empty MockAPI, use AddFixtures to load its data.
+func NewMockAPI() *MockAPI {
+	return &MockAPI{
+		users:    make(map[uint64]*UserFixture),
+		channels: make(map[string]*ChannelFixture),
+		casts:    make(map[string]*CastFixture),
+		errors:   make(map[string]error),
+	}
+}
+
+// AddFixtures loads the fixtures provided. The users, channels and casts
+// that already exist are replaced. The casts that mention the current user
+// are queued as new mentions.
+func (m *MockAPI) AddFixtures(fixtures *Fixtures) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, user := range fixtures.Users {
+		m.users[user.FID] = user
+	}
+	for _, channel := range fixtures.Channels {
+		m.channels[channel.ID] = channel
+	}
+	for _, cast := range fixtures.Casts {
+		m.addCast(cast)
+	}
+}
+
+// AddMention adds a new cast from the author provided that mentions the
+// current user and queues it to be returned by LastMentions. It returns the
+// hash of the cast.
+func (m *MockAPI) AddMention(author uint64, content string, embeds ...string) string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	cast := &CastFixture{
+		Author:   author,
+		Content:  content,
+		Embeds:   embeds,
+		Mentions: []uint64{m.fid},
+	}
+	m.addCast(cast)
+	return cast.Hash
+}
+
+// SetError makes every call to the method with the given name fail with the
+// error provided. Use a nil error to restore the method.
+func (m *MockAPI) SetError(method string, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err == nil {
+		delete(m.errors, method)
+		return
+	}
+	m.errors[method] = err
+}
+
+// SetFarcasterUser sets the user that publishes the casts and receives the
+// mentions. The signer is ignored. The casts already loaded that mention the
+// user are queued as new mentions.
+func (m *MockAPI) SetFarcasterUser(fid uint64, _ string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.fid = fid
+	m.mentions = nil
+	for _, cast := range m.casts {
+		if m.mentionsUser(cast) {
+			m.mentions = append(m.mentions, cast)
+		}
+	}
+	sort.Slice(m.mentions, func(i, j int) bool {
+		return m.mentions[i].Timestamp < m.mentions[j].Timestamp
+	})
+	return nil
+}
+
+// FID returns the fid of the current user.
+func (m *MockAPI) FID() uint64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.fid
+}
+
+// Stop does nothing, the MockAPI has no background processes.
+func (m *MockAPI) Stop() error {
+	return nil
+}
+
+// LastMentions returns the queued mentions newer than the given timestamp and
+// clears the queue.
+func (m *MockAPI) LastMentions(_ context.Context, timestamp uint64) ([]*farcasterapi.APIMessage, uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["LastMentions"]; err != nil {
+		return nil, timestamp, err
+	}
+	if m.fid == 0 {
+		return nil, 0, fmt.Errorf("farcaster user not set")
+	}
+	messages := []*farcasterapi.APIMessage{}
+	last := timestamp
+	for _, cast := range m.mentions {
+		if cast.Timestamp <= timestamp {
+			continue
+		}
+		msg := castToMessage(cast)
+		msg.IsMention = true
+		messages = append(messages, msg)
+		if cast.Timestamp > last {
+			last = cast.Timestamp
+		}
+	}
+	m.mentions = nil
+	if len(messages) == 0 {
+		return nil, last, farcasterapi.ErrNoNewCasts
+	}
+	return messages, last, nil
+}
+
+// GetCast returns the cast with the given hash.
+func (m *MockAPI) GetCast(_ context.Context, _ uint64, hash string) (*farcasterapi.APIMessage, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["GetCast"]; err != nil {
+		return nil, err
+	}
+	cast, ok := m.casts[hash]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return castToMessage(cast), nil
+}
+
+// Publish records a new cast of the current user.
+func (m *MockAPI) Publish(_ context.Context, content string, mentionFids []uint64, embedURLS ...string) error {
+	return m.publish("Publish", nil, content, mentionFids, embedURLS)
+}
+
+// Reply records a new reply of the current user to the cast provided.
+func (m *MockAPI) Reply(_ context.Context, targetMsg *farcasterapi.APIMessage, content string, mentionFids []uint64, embedURLS ...string) error {
+	if targetMsg == nil {
+		return fmt.Errorf("no target message provided")
+	}
+	parent := &farcasterapi.ParentAPIMessage{FID: targetMsg.Author, Hash: targetMsg.Hash}
+	return m.publish("Reply", parent, content, mentionFids, embedURLS)
+}
+
+// UserDataByFID returns the user with the given fid.
+func (m *MockAPI) UserDataByFID(_ context.Context, fid uint64) (*farcasterapi.Userdata, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["UserDataByFID"]; err != nil {
+		return nil, err
+	}
+	user, ok := m.users[fid]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return userToUserdata(user), nil
+}
+
+// UserDataByUsername returns the user with the given username.
+func (m *MockAPI) UserDataByUsername(_ context.Context, username string) (*farcasterapi.Userdata, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["UserDataByUsername"]; err != nil {
+		return nil, err
+	}
+	for _, user := range m.users {
+		if user.Username == username {
+			return userToUserdata(user), nil
+		}
+	}
+	return nil, farcasterapi.ErrNoDataFound
+}
+
+// UserDataByVerificationAddress returns the users that have verified any of
+// the given addresses.
+func (m *MockAPI) UserDataByVerificationAddress(_ context.Context, addresses []string) ([]*farcasterapi.Userdata, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["UserDataByVerificationAddress"]; err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, address := range addresses {
+		wanted[strings.ToLower(address)] = true
+	}
+	users := []*farcasterapi.Userdata{}
+	for _, user := range m.users {
+		for _, address := range user.Addresses {
+			if wanted[strings.ToLower(address)] {
+				users = append(users, userToUserdata(user))
+				break
+			}
+		}
+	}
+	if len(users) == 0 {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return users, nil
+}
+
+// WebhookHandler decodes the body as a CastFixture and adds it as a new cast,
+// queuing it as a mention if it mentions the current user.
+func (m *MockAPI) WebhookHandler(body []byte) error {
+	cast := &CastFixture{}
+	if err := json.Unmarshal(body, cast); err != nil {
+		return fmt.Errorf("error decoding webhook cast: %w", err)
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["WebhookHandler"]; err != nil {
+		return err
+	}
+	m.addCast(cast)
+	return nil
+}
+
+// SignersFromFID returns the signers of the user with the given fid.
+func (m *MockAPI) SignersFromFID(fid uint64) ([]string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["SignersFromFID"]; err != nil {
+		return nil, err
+	}
+	user, ok := m.users[fid]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return append([]string{}, user.Signers...), nil
+}
+
+// UserFollowers returns the followers of the user with the given fid.
+func (m *MockAPI) UserFollowers(_ context.Context, fid uint64) ([]uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["UserFollowers"]; err != nil {
+		return nil, err
+	}
+	user, ok := m.users[fid]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return append([]uint64{}, user.Followers...), nil
+}
+
+// UserFollowing returns the users followed by the user with the given fid.
+func (m *MockAPI) UserFollowing(_ context.Context, fid uint64) ([]uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["UserFollowing"]; err != nil {
+		return nil, err
+	}
+	user, ok := m.users[fid]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return append([]uint64{}, user.Following...), nil
+}
+
+// Channel returns the channel with the given id.
+func (m *MockAPI) Channel(_ context.Context, channelID string) (*farcasterapi.Channel, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["Channel"]; err != nil {
+		return nil, err
+	}
+	channel, ok := m.channels[channelID]
+	if !ok {
+		return nil, farcasterapi.ErrChannelNotFound
+	}
+	return channelToChannel(channel), nil
+}
+
+// ChannelFIDs returns the followers of the channel with the given id.
+func (m *MockAPI) ChannelFIDs(_ context.Context, channelID string, progress chan int) ([]uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["ChannelFIDs"]; err != nil {
+		return nil, err
+	}
+	channel, ok := m.channels[channelID]
+	if !ok {
+		return nil, farcasterapi.ErrChannelNotFound
+	}
+	if progress != nil {
+		progress <- 100
+	}
+	return append([]uint64{}, channel.Followers...), nil
+}
+
+// ChannelExists returns true if the channel with the given id exists.
+func (m *MockAPI) ChannelExists(_ context.Context, channelID string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["ChannelExists"]; err != nil {
+		return false, err
+	}
+	_, ok := m.channels[channelID]
+	return ok, nil
+}
+
+// FindChannel returns the channels whose id or name contains the query.
+func (m *MockAPI) FindChannel(_ context.Context, query string) ([]*farcasterapi.Channel, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["FindChannel"]; err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	channels := []*farcasterapi.Channel{}
+	for _, channel := range m.channels {
+		if strings.Contains(strings.ToLower(channel.ID), query) ||
+			strings.Contains(strings.ToLower(channel.Name), query) {
+			channels = append(channels, channelToChannel(channel))
+		}
+	}
+	return channels, nil
+}
+
+// CastReactions returns the FIDs of the users that liked or recasted the cast
+// with the given hash.
+func (m *MockAPI) CastReactions(_ context.Context, _ uint64, hash, reactionType string) ([]uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["CastReactions"]; err != nil {
+		return nil, err
+	}
+	cast, ok := m.casts[hash]
+	if !ok {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	switch reactionType {
+	case farcasterapi.ReactionTypeLike:
+		return append([]uint64{}, cast.Likes...), nil
+	case farcasterapi.ReactionTypeRecast:
+		return append([]uint64{}, cast.Recasts...), nil
+	default:
+		return nil, fmt.Errorf("unsupported reaction type %s", reactionType)
+	}
+}
+
+// CastRepliers returns the FIDs of the users that replied the cast with the
+// given hash.
+func (m *MockAPI) CastRepliers(_ context.Context, _ uint64, hash string) ([]uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["CastRepliers"]; err != nil {
+		return nil, err
+	}
+	fids := []uint64{}
+	seen := map[uint64]bool{}
+	for _, cast := range m.casts {
+		if cast.Parent != nil && cast.Parent.Hash == hash && !seen[cast.Author] {
+			seen[cast.Author] = true
+			fids = append(fids, cast.Author)
+		}
+	}
+	return fids, nil
+}
+
+// DirectMessage records a direct message from the current user.
+func (m *MockAPI) DirectMessage(_ context.Context, content string, to uint64) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors["DirectMessage"]; err != nil {
+		return err
+	}
+	m.messages = append(m.messages, &DirectMessage{From: m.fid, To: to, Content: content})
+	return nil
+}
+
+// publish records a new cast of the current user, it must be called without
+// the lock held.
+func (m *MockAPI) publish(method string, parent *farcasterapi.ParentAPIMessage, content string,
+	mentions []uint64, embeds []string,
+) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err := m.errors[method]; err != nil {
+		return err
+	}
+	if m.fid == 0 {
+		return fmt.Errorf("farcaster user not set")
+	}
+	if len(content) > farcasterapi.MaxCastBytes {
+		return fmt.Errorf("cast content too long: %d bytes", len(content))
+	}
+	cast := &CastFixture{
+		Author:   m.fid,
+		Content:  content,
+		Mentions: mentions,
+		Embeds:   embeds,
+	}
+	if parent != nil {
+		cast.Parent = &ParentFixture{FID: parent.FID, Hash: parent.Hash}
+	}
+	m.addCast(cast)
+	m.published = append(m.published, &PublishedCast{
+		Author:   cast.Author,
+		Hash:     cast.Hash,
+		Content:  content,
+		Mentions: mentions,
+		Embeds:   embeds,
+		Parent:   parent,
+	})
+	return nil
+}
+
+// addCast stores the cast provided, setting its hash and timestamp if they
+// are empty, and queues it as a mention if it mentions the current user. It
+// must be called with the lock held.
+func (m *MockAPI) addCast(cast *CastFixture) {
+	if cast.Timestamp == 0 {
+		cast.Timestamp = uint64(time.Now().Unix())
+	}
+	// keep the timestamps strictly increasing, so the mentions are not missed
+	if cast.Timestamp <= m.lastTime {
+		cast.Timestamp = m.lastTime + 1
+	}
+	m.lastTime = cast.Timestamp
+	if cast.Hash == "" {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%d:%s", cast.Author, cast.Timestamp, cast.Content)))
+		cast.Hash = "0x" + hex.EncodeToString(hash[:20])
+	}
+	m.casts[cast.Hash] = cast
+	if m.mentionsUser(cast) {
+		m.mentions = append(m.mentions, cast)
+	}
+}
+
+// mentionsUser returns true if the cast provided mentions the current user and
+// it is not authored by it. It must be called with the lock held.
+func (m *MockAPI) mentionsUser(cast *CastFixture) bool {
+	if m.fid == 0 || cast.Author == m.fid {
+		return false
+	}
+	for _, fid := range cast.Mentions {
+		if fid == m.fid {
+			return true
+		}
+	}
+	return false
+}
+
+func castToMessage(cast *CastFixture) *farcasterapi.APIMessage {
+	msg := &farcasterapi.APIMessage{
+		Content: cast.Content,
+		Author:  cast.Author,
+		Hash:    cast.Hash,
+		Embeds:  append([]string{}, cast.Embeds...),
+	}
+	if cast.Parent != nil {
+		msg.Parent = &farcasterapi.ParentAPIMessage{FID: cast.Parent.FID, Hash: cast.Parent.Hash}
+	}
+	return msg
+}
+
+func userToUserdata(user *UserFixture) *farcasterapi.Userdata {
+	return &farcasterapi.Userdata{
+		FID:                    user.FID,
+		Username:               user.Username,
+		Displayname:            user.Displayname,
+		CustodyAddress:         user.CustodyAddress,
+		VerificationsAddresses: append([]string{}, user.Addresses...),
+		Signers:                append([]string{}, user.Signers...),
+		Avatar:                 user.Avatar,
+		Bio:                    user.Bio,
+	}
+}
+
+func channelToChannel(channel *ChannelFixture) *farcasterapi.Channel {
+	return &farcasterapi.Channel{
+		ID:          channel.ID,
+		Name:        channel.Name,
+		Description: channel.Description,
+		Followers:   len(channel.Followers),
+		Image:       channel.Image,
+		URL:         channel.URL,
+	}
+}
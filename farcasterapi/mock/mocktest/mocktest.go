@@ -0,0 +1,62 @@
+// Package mocktest provides the test assertions about the casts published and
+// the direct messages sent through a mock.MockAPI. It is kept apart from the
+// mock package, so the binaries that link the mock do not depend on the
+// testing package.
+package mocktest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+)
+
+// AssertPublished checks that a cast or a reply containing the given text has
+// been published through the MockAPI and returns it.
+func AssertPublished(t testing.TB, m *mock.MockAPI, contains string) *mock.PublishedCast {
+	t.Helper()
+	for _, cast := range m.PublishedCasts() {
+		if strings.Contains(cast.Content, contains) {
+			return cast
+		}
+	}
+	t.Errorf("no published cast contains %q", contains)
+	return nil
+}
+
+// AssertReplied checks that a reply to the cast with the given hash
+// containing the given text has been published through the MockAPI and
+// returns it.
+func AssertReplied(t testing.TB, m *mock.MockAPI, hash, contains string) *mock.PublishedCast {
+	t.Helper()
+	for _, cast := range m.Replies(hash) {
+		if strings.Contains(cast.Content, contains) {
+			return cast
+		}
+	}
+	t.Errorf("no reply to %s contains %q", hash, contains)
+	return nil
+}
+
+// AssertNotPublished checks that no cast or reply has been published through
+// the MockAPI.
+func AssertNotPublished(t testing.TB, m *mock.MockAPI) {
+	t.Helper()
+	if casts := m.PublishedCasts(); len(casts) > 0 {
+		t.Errorf("expected no published casts, got %d (first: %q)", len(casts), casts[0].Content)
+	}
+}
+
+// AssertDirectMessage checks that a direct message containing the given text
+// has been sent through the MockAPI to the user with the given fid and
+// returns it.
+func AssertDirectMessage(t testing.TB, m *mock.MockAPI, to uint64, contains string) *mock.DirectMessage {
+	t.Helper()
+	for _, msg := range m.DirectMessages(to) {
+		if strings.Contains(msg.Content, contains) {
+			return msg
+		}
+	}
+	t.Errorf("no direct message to %d contains %q", to, contains)
+	return nil
+}
@@ -0,0 +1,43 @@
+package mock
+
+// PublishedCasts returns the casts and replies published through the MockAPI,
+// in order.
+func (m *MockAPI) PublishedCasts() []*PublishedCast {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]*PublishedCast{}, m.published...)
+}
+
+// Replies returns the replies published through the MockAPI to the cast with
+// the given hash, in order.
+func (m *MockAPI) Replies(hash string) []*PublishedCast {
+	replies := []*PublishedCast{}
+	for _, cast := range m.PublishedCasts() {
+		if cast.Parent != nil && cast.Parent.Hash == hash {
+			replies = append(replies, cast)
+		}
+	}
+	return replies
+}
+
+// DirectMessages returns the direct messages sent through the MockAPI to the
+// user with the given fid, in order.
+func (m *MockAPI) DirectMessages(to uint64) []*DirectMessage {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	messages := []*DirectMessage{}
+	for _, msg := range m.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// Reset clears the published casts and the direct messages sent.
+func (m *MockAPI) Reset() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.published = nil
+	m.messages = nil
+}
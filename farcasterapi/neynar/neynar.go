@@ -34,12 +34,16 @@ const (
 	neynarGetCastsEndpoint    = NeynarAPIEndpoint + "/v1/farcaster/mentions-and-replies?fid=%d&limit=150&cursor=%s"
 	neynarGetCastEndpoint     = NeynarAPIEndpoint + "/v2/farcaster/cast?identifier=%s&type=hash"
 	neynarReplyEndpoint       = NeynarAPIEndpoint + "/v2/farcaster/cast"
+	neynarUserByUsername      = NeynarAPIEndpoint + "/v1/farcaster/user-by-username?username=%s"
 	neynarUserByEthAddresses  = NeynarAPIEndpoint + "/v2/farcaster/user/bulk-by-address?addresses=%s"
 	neynarUserFollowers       = NeynarAPIEndpoint + "/v1/farcaster/followers?fid=%d&limit=150&cursor=%s"
+	neynarUserFollowing       = NeynarAPIEndpoint + "/v1/farcaster/following?fid=%d&limit=150&cursor=%s"
 	neynarChannelDataByID     = NeynarAPIEndpoint + "/v2/farcaster/channel?id=%s"
 	neynarSuggestChannels     = NeynarAPIEndpoint + "/v2/farcaster/channel/search?q=%s"
 	neynarUsersByChannelID    = NeynarAPIEndpoint + "/v2/farcaster/channel/followers?id=%s&limit=1000&cursor=%s"
 	neynarVerificationsByFID  = NeynarHubEndpoint + "/verificationsByFid?fid=%d"
+	neynarCastReactions       = NeynarAPIEndpoint + "/v2/farcaster/reactions/cast?hash=%s&types=%s&limit=100&cursor=%s"
+	neynarCastConversation    = NeynarAPIEndpoint + "/v2/farcaster/cast/conversation?identifier=%s&type=hash&reply_depth=1&limit=50&cursor=%s"
 	warpcastChannelInfo       = WarpcastClientEndpoint + "/channel?key=%s"
 
 	MaxAddressesPerRequest = 200
@@ -264,6 +268,28 @@ func (n *NeynarAPI) SignersFromFID(fid uint64) ([]string, error) {
 	return signers, nil
 }
 
+// UserDataByUsername method returns the userdata of the user with the given
+// username. It gets the fid of the user by its username and then returns its
+// userdata. If something goes wrong, it returns an error.
+func (n *NeynarAPI) UserDataByUsername(ctx context.Context, username string) (*farcasterapi.Userdata, error) {
+	url := fmt.Sprintf(neynarUserByUsername, username)
+	body, err := n.neynarReq(ctx, url, http.MethodGet, nil, defaultRequestTimeout)
+	if err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return nil, farcasterapi.ErrNoDataFound
+		}
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	userResponse := &userdataV1Response{}
+	if err := json.Unmarshal(body, userResponse); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response body: %w", err)
+	}
+	if userResponse.Result == nil || userResponse.Result.User == nil || userResponse.Result.User.Fid == 0 {
+		return nil, farcasterapi.ErrNoDataFound
+	}
+	return n.UserDataByFID(ctx, userResponse.Result.User.Fid)
+}
+
 // UserDataByVerificationAddress method returns the userdata of the user with the given Ethereum address.
 func (n *NeynarAPI) UserDataByVerificationAddress(ctx context.Context, addresses []string) ([]*farcasterapi.Userdata, error) {
 	if len(addresses) > MaxAddressesPerRequest {
@@ -327,11 +353,23 @@ func (n *NeynarAPI) UserDataByVerificationAddress(ctx context.Context, addresses
 // UserFollowers method returns the FIDs of the followers of the user with the
 // given id. If something goes wrong, it returns an error.
 func (n *NeynarAPI) UserFollowers(ctx context.Context, fid uint64) ([]uint64, error) {
+	return n.userLinks(ctx, neynarUserFollowers, fid)
+}
+
+// UserFollowing method returns the FIDs of the users followed by the user with
+// the given id. If something goes wrong, it returns an error.
+func (n *NeynarAPI) UserFollowing(ctx context.Context, fid uint64) ([]uint64, error) {
+	return n.userLinks(ctx, neynarUserFollowing, fid)
+}
+
+// userLinks method returns the FIDs of the users listed by the given paginated
+// endpoint (followers or following) for the user with the given id.
+func (n *NeynarAPI) userLinks(ctx context.Context, endpoint string, fid uint64) ([]uint64, error) {
 	cursor := ""
 	userFIDs := []uint64{}
 	for {
-		// create request with the channel id provided
-		url := fmt.Sprintf(neynarUserFollowers, fid, cursor)
+		// create request with the user fid provided
+		url := fmt.Sprintf(endpoint, fid, cursor)
 		body, err := n.neynarReq(ctx, url, http.MethodGet, nil, defaultRequestTimeout)
 		if err != nil {
 			return nil, fmt.Errorf("error creating request: %w", err)
@@ -486,6 +524,84 @@ func (n *NeynarAPI) FindChannel(ctx context.Context, query string) ([]*farcaster
 	return channels, nil
 }
 
+// CastReactions method returns the FIDs of the users that reacted to the cast
+// with the given hash with the given reaction type (like or recast). The fid
+// of the cast author is not required by the Neynar API. If something goes
+// wrong, it returns an error.
+func (n *NeynarAPI) CastReactions(ctx context.Context, _ uint64, hash, reactionType string) ([]uint64, error) {
+	var neynarReactionType string
+	switch reactionType {
+	case farcasterapi.ReactionTypeLike:
+		neynarReactionType = "likes"
+	case farcasterapi.ReactionTypeRecast:
+		neynarReactionType = "recasts"
+	default:
+		return nil, fmt.Errorf("unsupported reaction type %s", reactionType)
+	}
+	cursor := ""
+	userFIDs := []uint64{}
+	seen := map[uint64]bool{}
+	for {
+		url := fmt.Sprintf(neynarCastReactions, hash, neynarReactionType, cursor)
+		body, err := n.neynarReq(ctx, url, http.MethodGet, nil, defaultRequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		reactionsResult := &castReactionsResult{}
+		if err := json.Unmarshal(body, reactionsResult); err != nil {
+			return nil, fmt.Errorf("error unmarshalling response body: %w", err)
+		}
+		for _, reaction := range reactionsResult.Reactions {
+			if reaction.User == nil || seen[reaction.User.Fid] {
+				continue
+			}
+			seen[reaction.User.Fid] = true
+			userFIDs = append(userFIDs, reaction.User.Fid)
+		}
+		if len(reactionsResult.Reactions) == 0 || reactionsResult.NextCursor == nil || reactionsResult.NextCursor.Cursor == "" {
+			break
+		}
+		cursor = reactionsResult.NextCursor.Cursor
+	}
+	return userFIDs, nil
+}
+
+// CastRepliers method returns the FIDs of the users that replied to the cast
+// with the given hash. The fid of the cast author is not required by the
+// Neynar API. If something goes wrong, it returns an error.
+func (n *NeynarAPI) CastRepliers(ctx context.Context, _ uint64, hash string) ([]uint64, error) {
+	cursor := ""
+	userFIDs := []uint64{}
+	seen := map[uint64]bool{}
+	for {
+		url := fmt.Sprintf(neynarCastConversation, hash, cursor)
+		body, err := n.neynarReq(ctx, url, http.MethodGet, nil, defaultRequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		conversationResult := &castConversationResult{}
+		if err := json.Unmarshal(body, conversationResult); err != nil {
+			return nil, fmt.Errorf("error unmarshalling response body: %w", err)
+		}
+		if conversationResult.Conversation == nil || conversationResult.Conversation.Cast == nil {
+			return nil, farcasterapi.ErrNoDataFound
+		}
+		replies := conversationResult.Conversation.Cast.DirectReplies
+		for _, reply := range replies {
+			if reply.Author == nil || seen[reply.Author.Fid] {
+				continue
+			}
+			seen[reply.Author.Fid] = true
+			userFIDs = append(userFIDs, reply.Author.Fid)
+		}
+		if len(replies) == 0 || conversationResult.NextCursor == nil || conversationResult.NextCursor.Cursor == "" {
+			break
+		}
+		cursor = conversationResult.NextCursor.Cursor
+	}
+	return userFIDs, nil
+}
+
 // DirectMessage method sends a direct message to the user with the given fid.
 // If something goes wrong, it returns an error.
 func (n *NeynarAPI) DirectMessage(ctx context.Context, content string, to uint64) error {
@@ -597,7 +713,7 @@ func (n *NeynarAPI) sendRequest(req *http.Request) ([]byte, error) {
 		}
 		log.Debugw("retrying request", "attempt", attempt+1, "url", req.URL.String(), "method", req.Method)
 	}
-	return nil, fmt.Errorf("error downloading json: exceeded retry limit")
+	return nil, fmt.Errorf("error downloading json: exceeded retry limit: %w", farcasterapi.ErrRateLimited)
 }
 
 // VerifyRequest method verifies the request signature and returns a boolean
@@ -89,6 +89,31 @@ type castResponseV2 struct {
 	Data *castWebhookData `json:"cast"`
 }
 
+type castReactionV2 struct {
+	ReactionType string      `json:"reaction_type"`
+	User         *userdataV2 `json:"user"`
+}
+
+type castReactionsResult struct {
+	Reactions  []*castReactionV2 `json:"reactions"`
+	NextCursor *cursor           `json:"next"`
+}
+
+type castConversationCast struct {
+	Hash          string             `json:"hash"`
+	Author        *userdataV2        `json:"author"`
+	DirectReplies []*castWebhookData `json:"direct_replies"`
+}
+
+type castConversation struct {
+	Cast *castConversationCast `json:"cast"`
+}
+
+type castConversationResult struct {
+	Conversation *castConversation `json:"conversation"`
+	NextCursor   *cursor           `json:"next"`
+}
+
 // ---
 
 type userProfile struct {
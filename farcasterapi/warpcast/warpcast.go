@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/vocdoni/vote-frame/farcasterapi"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/util"
 )
@@ -199,5 +200,5 @@ func (w *WarpcastAPI) sendRequest(req *http.Request) ([]byte, error) {
 			return respBody, nil // Success
 		}
 	}
-	return nil, fmt.Errorf("error downloading json: exceeded retry limit")
+	return nil, fmt.Errorf("error downloading json: exceeded retry limit: %w", farcasterapi.ErrRateLimited)
 }
@@ -15,6 +15,12 @@ const (
 	maxRetries                = 5
 )
 
+// Key states as defined by the KeyRegistry contract.
+const (
+	KeyStateAdded   uint8 = 1
+	KeyStateRemoved uint8 = 2
+)
+
 type ProviderInfo struct {
 	endpoint  string
 	available bool
@@ -47,8 +53,20 @@ func NewFarcasterProvider(w3p *web3.Web3Pool) (*FarcasterProvider, error) {
 	return fp, nil
 }
 
+// GetAppKeysByFid returns the app keys of the given fid that are currently
+// added to the KeyRegistry.
 func (p *FarcasterProvider) GetAppKeysByFid(fid *big.Int) ([][]byte, error) {
-	keys, err := p.contract.FarcasterKeyRegistryCaller.KeysOf(nil, fid, 1)
+	return p.keysByState(fid, KeyStateAdded)
+}
+
+// GetRemovedAppKeysByFid returns the app keys of the given fid that have been
+// removed (revoked) from the KeyRegistry.
+func (p *FarcasterProvider) GetRemovedAppKeysByFid(fid *big.Int) ([][]byte, error) {
+	return p.keysByState(fid, KeyStateRemoved)
+}
+
+func (p *FarcasterProvider) keysByState(fid *big.Int, state uint8) ([][]byte, error) {
+	keys, err := p.contract.FarcasterKeyRegistryCaller.KeysOf(nil, fid, state)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get keys: %w", err)
 	}
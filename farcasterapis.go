@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/cache"
+	"github.com/vocdoni/vote-frame/farcasterapi/failover"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	farcasterBackendNeynar = "neynar"
+	farcasterBackendHub    = "hub"
+	// farcasterAPIStatsInterval is the interval to log the cache metrics of
+	// the Farcaster API backends.
+	farcasterAPIStatsInterval = 5 * time.Minute
+)
+
+// newCachedFarcasterAPI wraps the Farcaster API backend provided with a cache
+// and a rate limiter, and starts logging its metrics until the context is
+// done.
+func newCachedFarcasterAPI(ctx context.Context, api farcasterapi.API, config *cache.Config) *cache.CachedAPI {
+	cachedAPI, err := cache.New(api, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cachedAPI.Monitor(ctx, farcasterAPIStatsInterval)
+	return cachedAPI
+}
+
+// newMockFarcasterAPI returns an in-memory mock of the Farcaster API with the
+// fixtures of the given file loaded, if any.
+func newMockFarcasterAPI(fixturesPath string) (*mock.MockAPI, error) {
+	mockAPI := mock.NewMockAPI()
+	if fixturesPath == "" {
+		return mockAPI, nil
+	}
+	fixtures, err := mock.LoadFixtures(fixturesPath)
+	if err != nil {
+		return nil, err
+	}
+	mockAPI.AddFixtures(fixtures)
+	log.Infow("farcaster api mock fixtures loaded",
+		"users", len(fixtures.Users),
+		"channels", len(fixtures.Channels),
+		"casts", len(fixtures.Casts))
+	return mockAPI, nil
+}
+
+// newFarcasterAPI returns the Farcaster API to use with the backends provided.
+// The order is the list of backend names by priority, the backends that are
+// not available are ignored, as well as the routes to them. If only one
+// backend is available, it is returned as is, otherwise they are wrapped in a
+// failover API.
+func newFarcasterAPI(order []string, backends map[string]farcasterapi.API, config *failover.Config) (farcasterapi.API, error) {
+	available := []*failover.Backend{}
+	for _, name := range order {
+		name = strings.TrimSpace(name)
+		if api, ok := backends[name]; ok {
+			available = append(available, &failover.Backend{Name: name, API: api})
+		}
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("no farcaster api backends available of %v", order)
+	}
+	if len(available) == 1 {
+		log.Infow("using farcaster api backend", "backend", available[0].Name)
+		return available[0].API, nil
+	}
+	names := []string{}
+	isAvailable := map[string]bool{}
+	for _, b := range available {
+		names = append(names, b.Name)
+		isAvailable[b.Name] = true
+	}
+	routes := map[string][]string{}
+	for route, routeNames := range config.Routes {
+		for _, name := range routeNames {
+			if isAvailable[name] {
+				routes[route] = append(routes[route], name)
+			}
+		}
+	}
+	log.Infow("using farcaster api backends with failover", "backends", names, "routes", routes)
+	return failover.New(available, &failover.Config{
+		Routes:           routes,
+		FailureThreshold: config.FailureThreshold,
+		OpenTimeout:      config.OpenTimeout,
+	})
+}
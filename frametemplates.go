@@ -99,7 +99,7 @@ var frameAfterVote = header + `
 
 var frameResults = header + `
     <meta property="fc:frame" content="vNext" />
-    <meta name="fc:frame:image:aspect_ratio" content="1:1" />
+    <meta name="fc:frame:image:aspect_ratio" content="{aspectRatio}" />
     <meta property="fc:frame:image" content="{image}" />
 
     <meta property="fc:frame:post_url" content="{server}/{processID}" />
@@ -116,7 +116,7 @@ var frameResults = header + `
 
 var frameFinalResults = header + `
     <meta property="fc:frame" content="vNext" />
-    <meta name="fc:frame:image:aspect_ratio" content="1:1" />
+    <meta name="fc:frame:image:aspect_ratio" content="{aspectRatio}" />
     <meta property="fc:frame:image" content="{image}" />
     <meta http-equiv="refresh" content="0;url={server}/app/#poll/{processID}" />
 ` + body
@@ -15,12 +15,14 @@ import (
 
 	"github.com/google/uuid"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/vocdoni/vote-frame/airstack"
 	"github.com/vocdoni/vote-frame/communityhub"
 	"github.com/vocdoni/vote-frame/farcasterapi"
 	"github.com/vocdoni/vote-frame/imageframe"
 	"github.com/vocdoni/vote-frame/mongo"
 	"github.com/vocdoni/vote-frame/shortener"
+	"github.com/vocdoni/vote-frame/tokenholders"
 	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/apiclient"
 	"go.vocdoni.io/dvote/httprouter"
@@ -39,11 +41,16 @@ type vocdoniHandler struct {
 	webappdir     string
 	db            *mongo.MongoStorage
 	electionLRU   *lru.Cache[string, *api.Election]
+	followGraph   *expirable.LRU[string, []uint64]
+	censusProofs  *censusProofLimiter
 	fcapi         farcasterapi.API
 	airstack      *airstack.Airstack
+	tokenHolders  tokenholders.TokenHolderProvider
+	tokenSnaps    tokenholders.SnapshotProvider
 	comhub        *communityhub.CommunityHub
 
 	backgroundQueue  sync.Map
+	censusJobsLock   sync.Mutex
 	addAuthTokenFunc func(uint64, string)
 }
 
@@ -57,6 +64,8 @@ func NewVocdoniHandler(
 	fcapi farcasterapi.API,
 	token *uuid.UUID,
 	airstack *airstack.Airstack,
+	tokenHolders tokenholders.TokenHolderProvider,
+	tokenSnaps tokenholders.SnapshotProvider,
 	comhub *communityhub.CommunityHub,
 ) (*vocdoniHandler, error) {
 	// Get the vocdoni account
@@ -94,6 +103,8 @@ func NewVocdoniHandler(
 		db:            db,
 		fcapi:         fcapi,
 		airstack:      airstack,
+		tokenHolders:  tokenHolders,
+		tokenSnaps:    tokenSnaps,
 		comhub:        comhub,
 		electionLRU: func() *lru.Cache[string, *api.Election] {
 			lru, err := lru.New[string, *api.Election](100)
@@ -102,11 +113,19 @@ func NewVocdoniHandler(
 			}
 			return lru
 		}(),
+		followGraph:  expirable.NewLRU[string, []uint64](followGraphCacheSize, nil, followGraphCacheTTL),
+		censusProofs: newCensusProofLimiter(censusProofsWindow, censusProofsPerClient, censusProofsTotal),
 	}
 
 	// Add the election callback to the mongo database to fetch the election information
 	db.AddElectionCallback(vh.election)
 	go vh.finalizeElectionsAtBackround(ctx)
+	go vh.recordTallySnapshotsAtBackground(ctx)
+	// Fail the census jobs interrupted by a restart, keep the leases of the
+	// running ones and remove the old ones
+	vh.failInterruptedCensusJobs()
+	go vh.renewCensusJobLeasesAtBackground(ctx)
+	go vh.gcCensusJobsAtBackground(ctx)
 	return vh, ensureAccountExist(cli)
 }
 
@@ -136,14 +155,14 @@ func (v *vocdoniHandler) landing(msg *apirest.APIdata, ctx *httprouter.HTTPConte
 
 	response := strings.ReplaceAll(frame(frameMain), "{processID}", election.ElectionID.String())
 	response = strings.ReplaceAll(response, "{title}", election.Metadata.Title["default"])
-	response = strings.ReplaceAll(response, "{image}", landingPNGfile(election))
+	response = strings.ReplaceAll(response, "{image}", landingPNGfile(election, v.electionTheme(election.ElectionID)))
 
 	ctx.SetResponseContentType("text/html; charset=utf-8")
 	return ctx.Send([]byte(response), http.StatusOK)
 }
 
-func landingPNGfile(election *api.Election) string {
-	pngFile, err := imageframe.QuestionImage(election)
+func landingPNGfile(election *api.Election, theme *imageframe.ImageTheme) string {
+	pngFile, err := imageframe.QuestionImage(election, theme)
 	if err != nil {
 		log.Warnw("failed to create landing image", "error", err)
 		return imageLink(imageframe.NotFoundImage())
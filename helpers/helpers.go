@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"math/big"
+	"sort"
 
 	"go.vocdoni.io/dvote/api"
 )
@@ -99,3 +100,45 @@ func TruncateDecimals(num *big.Int, numberOfDecimals uint32) *big.Int {
 
 	return result
 }
+
+// TopNResults returns the n choices with more votes sorted in descending order,
+// grouping the rest of them into a single choice with the othersLabel provided.
+// If there are n or less choices, they are returned sorted without grouping.
+func TopNResults(choices []string, results []*big.Int, n int, othersLabel string) ([]string, []*big.Int) {
+	if len(choices) != len(results) || n <= 0 {
+		return choices, results
+	}
+	// sort the indexes of the choices by their results, keeping the original
+	// order for the ties
+	idxs := make([]int, len(choices))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		a, b := results[idxs[i]], results[idxs[j]]
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.Cmp(b) > 0
+	})
+	// grouping a single choice into others makes no sense
+	group := len(choices) > n+1
+	topChoices := []string{}
+	topResults := []*big.Int{}
+	others := new(big.Int)
+	for i, idx := range idxs {
+		if i < n || !group {
+			topChoices = append(topChoices, choices[idx])
+			topResults = append(topResults, results[idx])
+			continue
+		}
+		if results[idx] != nil {
+			others.Add(others, results[idx])
+		}
+	}
+	if group {
+		topChoices = append(topChoices, othersLabel)
+		topResults = append(topResults, others)
+	}
+	return topChoices, topResults
+}
@@ -140,3 +140,55 @@ func TestExtractResults(t *testing.T) {
 		})
 	}
 }
+
+func TestTopNResults(t *testing.T) {
+	testCases := []struct {
+		name            string
+		choices         []string
+		results         []*big.Int
+		n               int
+		expectedChoices []string
+		expectedResults []*big.Int
+	}{
+		{
+			name:            "Group the rest of choices into others",
+			choices:         []string{"Choice 1", "Choice 2", "Choice 3", "Choice 4"},
+			results:         []*big.Int{big.NewInt(100), big.NewInt(400), big.NewInt(50), big.NewInt(300)},
+			n:               2,
+			expectedChoices: []string{"Choice 2", "Choice 4", "Others"},
+			expectedResults: []*big.Int{big.NewInt(400), big.NewInt(300), big.NewInt(150)},
+		},
+		{
+			name:            "Do not group a single remaining choice",
+			choices:         []string{"Choice 1", "Choice 2", "Choice 3"},
+			results:         []*big.Int{big.NewInt(100), big.NewInt(200), big.NewInt(300)},
+			n:               2,
+			expectedChoices: []string{"Choice 3", "Choice 2", "Choice 1"},
+			expectedResults: []*big.Int{big.NewInt(300), big.NewInt(200), big.NewInt(100)},
+		},
+		{
+			name:            "Ties keep the original order",
+			choices:         []string{"Choice 1", "Choice 2", "Choice 3", "Choice 4"},
+			results:         []*big.Int{big.NewInt(100), big.NewInt(100), big.NewInt(100), big.NewInt(0)},
+			n:               1,
+			expectedChoices: []string{"Choice 1", "Others"},
+			expectedResults: []*big.Int{big.NewInt(100), big.NewInt(200)},
+		},
+		{
+			name:            "Invalid n returns the input",
+			choices:         []string{"Choice 1", "Choice 2"},
+			results:         []*big.Int{big.NewInt(100), big.NewInt(200)},
+			n:               0,
+			expectedChoices: []string{"Choice 1", "Choice 2"},
+			expectedResults: []*big.Int{big.NewInt(100), big.NewInt(200)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			choices, results := TopNResults(tc.choices, tc.results, tc.n, "Others")
+			assert.Equal(t, tc.expectedChoices, choices)
+			assert.Equal(t, tc.expectedResults, results)
+		})
+	}
+}
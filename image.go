@@ -6,16 +6,29 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/vocdoni/vote-frame/imageframe"
 	"github.com/vocdoni/vote-frame/mongo"
+	"github.com/zeebo/blake3"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 )
 
+const (
+	// immutableImageCacheControl is the Cache-Control header value for images
+	// that never change for a given id.
+	immutableImageCacheControl = "public, max-age=86400, immutable"
+	// revalidateImageCacheControl is the Cache-Control header value for images
+	// that can change, clients must revalidate them using the ETag.
+	revalidateImageCacheControl = "no-cache"
+)
+
 var (
 	imageTypeRgx   = regexp.MustCompile(`^data:(image/[a-z]+);base64,`)
 	isAvatarURLRgx = regexp.MustCompile(`.+/images/avatar/(.+)\.jpg$`)
@@ -25,7 +38,8 @@ func (v *vocdoniHandler) imagesHandler(msg *apirest.APIdata, ctx *httprouter.HTT
 	id := ctx.URLParam("id")
 	data := imageframe.FromCache(id)
 	if data != nil {
-		return imageResponse(ctx, data)
+		// the images are immutable for a given id
+		return cachedImageResponse(ctx, data, immutableImageCacheControl)
 	}
 	idSplit := strings.Split(id, "_")
 	var electionID types.HexBytes
@@ -40,7 +54,7 @@ func (v *vocdoniHandler) imagesHandler(msg *apirest.APIdata, ctx *httprouter.HTT
 	if pngResults != nil {
 		// for future requests, add the image to the cache with the given id
 		imageframe.AddImageToCacheWithID(id, pngResults)
-		return imageResponse(ctx, pngResults)
+		return cachedImageResponse(ctx, pngResults, immutableImageCacheControl)
 	}
 
 	// as fallback, get the election and return the landing png
@@ -48,7 +62,7 @@ func (v *vocdoniHandler) imagesHandler(msg *apirest.APIdata, ctx *httprouter.HTT
 	if err != nil {
 		return errorImageResponse(ctx, fmt.Errorf("failed to get election: %w", err))
 	}
-	png, err := imageframe.QuestionImage(election)
+	png, err := imageframe.QuestionImage(election, v.electionTheme(electionID))
 	if err != nil {
 		return errorImageResponse(ctx, fmt.Errorf("failed to build landing: %w", err))
 	}
@@ -69,7 +83,38 @@ func (v *vocdoniHandler) preview(msg *apirest.APIdata, ctx *httprouter.HTTPConte
 		return errorImageResponse(ctx, fmt.Errorf("election has no questions"))
 	}
 
-	png, err := imageframe.QuestionImage(election)
+	// if any results image setting is provided in the query, preview the
+	// results image with them, otherwise preview the question image
+	query := ctx.Request.URL.Query()
+	if query.Has("visualization") || query.Has("aspectRatio") || query.Has("topN") {
+		settings := &mongo.ResultsImageSettings{
+			Visualization: query.Get("visualization"),
+			AspectRatio:   query.Get("aspectRatio"),
+		}
+		if strTopN := query.Get("topN"); strTopN != "" {
+			if settings.TopN, err = strconv.Atoi(strTopN); err != nil {
+				return errorImageResponse(ctx, fmt.Errorf("invalid topN: %w", err))
+			}
+		}
+		if err := imageframe.ValidateResultsImageSettings(settings); err != nil {
+			return errorImageResponse(ctx, err)
+		}
+		electiondb, err := v.db.Election(electionID)
+		if err != nil {
+			log.Warnw("failed to fetch election from database", "error", err)
+		}
+		totalWeightStr := ""
+		if census, err := v.db.CensusFromElection(electionID); err == nil {
+			totalWeightStr = census.TotalWeight
+		}
+		png, err := v.resultsImage(election, electiondb, totalWeightStr, settings)
+		if err != nil {
+			return errorImageResponse(ctx, err)
+		}
+		return imageResponse(ctx, imageframe.FromCache(png))
+	}
+
+	png, err := imageframe.QuestionImage(election, v.electionTheme(electionID))
 	if err != nil {
 		return errorImageResponse(ctx, err)
 	}
@@ -133,15 +178,43 @@ func (v *vocdoniHandler) updloadAvatarHandler(msg *apirest.APIdata, ctx *httprou
 }
 
 func imageResponse(ctx *httprouter.HTTPContext, png []byte) error {
+	return cachedImageResponse(ctx, png, revalidateImageCacheControl)
+}
+
+// cachedImageResponse sends the png image with the given Cache-Control header
+// value and an ETag based on the content hash. If the client already has the
+// same content (If-None-Match header), it replies with 304 Not Modified.
+func cachedImageResponse(ctx *httprouter.HTTPContext, png []byte, cacheControl string) error {
 	defer ctx.Request.Body.Close()
 	if ctx.Request.Context().Err() != nil {
 		// The connection was closed, so don't try to write to it.
 		return fmt.Errorf("connection is closed")
 	}
 	ctx.SetResponseContentType("image/png")
+	if len(png) == 0 {
+		return ctx.Send(png, 200)
+	}
+	etag := fmt.Sprintf(`"%x"`, blake3.Sum256(png))
+	ctx.SetHeader("ETag", etag)
+	ctx.SetHeader("Cache-Control", cacheControl)
+	if etagMatches(ctx.Request.Header.Get("If-None-Match"), etag) {
+		return ctx.Send(nil, http.StatusNotModified)
+	}
 	return ctx.Send(png, 200)
 }
 
+// etagMatches checks if the If-None-Match header value contains the given
+// etag, including weak validators and the wildcard.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func errorImageResponse(ctx *httprouter.HTTPContext, err error) error {
 	png, err := imageframe.ErrorImage(err.Error())
 	if err != nil {
@@ -2,8 +2,10 @@ package imageframe
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/vocdoni/vote-frame/mongo"
 	"github.com/zeebo/blake3"
 	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/log"
@@ -11,48 +13,61 @@ import (
 )
 
 // generateElectionCacheKey returns a unique identifier cache key, for the election.
-// The cache key is based on the electionID, voteCount and finalResults.
-func generateElectionCacheKey(election *api.Election, imageType int) string {
+// The cache key is based on the electionID, voteCount and finalResults. If a theme
+// is provided, its version is also included, as well as the results image settings
+// if they are not the default ones.
+func generateElectionCacheKey(election *api.Election, imageType int, theme *ImageTheme, settings *mongo.ResultsImageSettings) string {
 	if election == nil {
 		return ""
 	}
+	var key string
 	switch imageType {
 	case imageTypeResults:
-		return fmt.Sprintf("%s_%d-%d%d", election.ElectionID.String(), election.VoteCount, func() int {
+		key = fmt.Sprintf("%s_%d-%d%d", election.ElectionID.String(), election.VoteCount, func() int {
 			if election.FinalResults {
 				return 1
 			}
 			return 0
-		}(), imageType)
+		}(), imageType) + resultsSettingsCacheKeySuffix(settings)
 	case imageTypeQuestion:
-		return fmt.Sprintf("%s_%d", election.ElectionID.String(), imageType)
+		key = fmt.Sprintf("%s_%d", election.ElectionID.String(), imageType)
 	default:
 		log.Errorw(fmt.Errorf("unknown image type %d", imageType), "cacheElectionID")
 		// fallback
-		return fmt.Sprintf("%s_%d", election.ElectionID.String(), imageType)
+		key = fmt.Sprintf("%s_%d", election.ElectionID.String(), imageType)
 	}
+	if theme != nil {
+		key = fmt.Sprintf("%s-t%d", key, theme.Version)
+	}
+	return key
 }
 
-// cacheElectionImage adds an image to the LRU cache.
+// cacheElectionImage adds an image to the LRU cache and persists it in the
+// image store, since its key can be generated again for the same election.
 // Returns the cache key.
 // If electionID is nil, the image is not associated with any election.
-func cacheElectionImage(data []byte, election *api.Election, imageType int) string {
-	id := generateElectionCacheKey(election, imageType)
-	imagesLRU.Add(id, data)
+func cacheElectionImage(data []byte, election *api.Election, imageType int, theme *ImageTheme, settings *mongo.ResultsImageSettings) string {
+	id := generateElectionCacheKey(election, imageType, theme, settings)
+	addImage(id, data, true)
 	return id
 }
 
-// electionImageCacheKey checks if an election associated image exist in the LRU cache.
-// If so it returns the cache key identifier, otherwise it returns an empty string.
-func electionImageCacheKey(election *api.Election, imageType int) string {
-	id := generateElectionCacheKey(election, imageType)
-	_, ok := imagesLRU.Get(id)
-	if !ok {
-		missesCounter.Add(1)
-		return ""
+// electionImageCacheKey checks if an election associated image exist in the LRU cache
+// or in the image store. If so it returns the cache key identifier, otherwise it returns
+// an empty string.
+func electionImageCacheKey(election *api.Election, imageType int, theme *ImageTheme, settings *mongo.ResultsImageSettings) string {
+	id := generateElectionCacheKey(election, imageType, theme, settings)
+	if _, ok := imagesLRU.Get(id); ok {
+		hitsCounter.Add(1)
+		return id
 	}
-	hitsCounter.Add(1)
-	return id
+	if data := storedImage(id); data != nil {
+		hitsCounter.Add(1)
+		imagesLRU.Add(id, data)
+		return id
+	}
+	missesCounter.Add(1)
+	return ""
 }
 
 // genericImageCacheKey returns a unique identifier cache key, for the image data.
@@ -60,50 +75,120 @@ func oneTimeImageCacheKey() string {
 	return util.RandomHex(20)
 }
 
-// AddImageToCache adds an image to the LRU cache.
-// Returns the cache key.
+// AddImageToCache adds an image to the LRU cache and persists it in the image
+// store. Returns the cache key.
 // The key is the hash of the image data.
 func AddImageToCache(data []byte) string {
 	id := fmt.Sprintf("%x", blake3.Sum256(data))
-	imagesLRU.Add(id, data)
+	addImage(id, data, true)
 	return id
 }
 
 // AddImageToCacheWithID adds an image to the LRU cache with a specific ID.
+// These images are not persisted in the image store, since their IDs are
+// random one-time keys or they can be rebuilt from the database.
 func AddImageToCacheWithID(id string, data []byte) {
-	imagesLRU.Add(id, data)
+	addImage(id, data, false)
 }
 
 // FromCache tries to retrieve data from cache with a specified id.
 // Returns nil if the image is not in the cache.
-// If the data is not available, it retries until the timeout is reached.
+// If the data is not available yet, it waits until the image is added
+// or the timeout is reached.
 func FromCache(id string) []byte {
 	if id == "" {
 		return nil
 	}
-
-	// Using a ticker for retry interval
-	ticker := time.NewTicker(time.Millisecond * 200)
-	defer ticker.Stop()
-
-	// Setting up a timeout
-	timeoutChan := time.After(TimeoutImageGeneration)
-
-	for {
-		select {
-		case <-timeoutChan:
-			// Timeout reached, return nil
-			return nil
-		case <-ticker.C:
-			// Attempt to get data from cache
-			if data, ok := imagesLRU.Get(id); ok {
-				return data
-			}
-		}
+	if data, ok := imagesLRU.Get(id); ok {
+		return data
 	}
+	// the image could have been generated before a restart or by another
+	// replica, so check the image store
+	if data := storedImage(id); data != nil {
+		imagesLRU.Add(id, data)
+		return data
+	}
+	if data := waitForImage(id, TimeoutImageGeneration); data != nil {
+		return data
+	}
+	// last attempt, in case another replica generated it meanwhile
+	if data := storedImage(id); data != nil {
+		imagesLRU.Add(id, data)
+		return data
+	}
+	return nil
 }
 
 // IsInCache checks if an image is in the LRU cache.
 func IsInCache(id string) bool {
 	return imagesLRU.Contains(id)
 }
+
+// imageWaiter allows to wait for an image to be added to the cache. The done
+// channel is closed once the image is available in the data field.
+type imageWaiter struct {
+	done    chan struct{}
+	data    []byte
+	waiting int
+}
+
+var (
+	imageWaiters    = make(map[string]*imageWaiter)
+	imageWaitersMtx sync.Mutex
+)
+
+// addImage adds the image to the LRU cache, persists it in the image store if
+// persist is true and notifies the goroutines waiting for it.
+func addImage(id string, data []byte, persist bool) {
+	imagesLRU.Add(id, data)
+	imageWaitersMtx.Lock()
+	if w, ok := imageWaiters[id]; ok {
+		w.data = data
+		close(w.done)
+		delete(imageWaiters, id)
+	}
+	imageWaitersMtx.Unlock()
+	if persist {
+		go storeImage(id, data)
+	}
+}
+
+// waitForImage waits until the image with the given id is added to the cache
+// or the timeout is reached. It returns the image data or nil on timeout.
+func waitForImage(id string, timeout time.Duration) []byte {
+	imageWaitersMtx.Lock()
+	// check the cache while holding the lock, so the image cannot be added
+	// between the check and the waiter registration
+	if data, ok := imagesLRU.Peek(id); ok {
+		imageWaitersMtx.Unlock()
+		return data
+	}
+	w, ok := imageWaiters[id]
+	if !ok {
+		w = &imageWaiter{done: make(chan struct{})}
+		imageWaiters[id] = w
+	}
+	w.waiting++
+	imageWaitersMtx.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.done:
+		return w.data
+	case <-timer.C:
+		imageWaitersMtx.Lock()
+		defer imageWaitersMtx.Unlock()
+		// the image could have been added right after the timeout
+		select {
+		case <-w.done:
+			return w.data
+		default:
+		}
+		w.waiting--
+		if w.waiting == 0 {
+			delete(imageWaiters, id)
+		}
+		return nil
+	}
+}
@@ -29,6 +29,9 @@ const (
 	ImageGeneratorURL = "https://img.frame.vote"
 
 	TimeoutImageGeneration = 15 * time.Second
+	// waitImageGeneration is the maximum time to wait for an image to be
+	// generated before returning its key, so it is likely ready when requested
+	waitImageGeneration = 2 * time.Second
 )
 
 const (
@@ -71,7 +74,7 @@ func init() {
 
 	go func() {
 		for range time.Tick(60 * time.Second) {
-			log.Infow("image cache stats", "hits", hitsCounter.Load(), "misses", missesCounter.Load(), "size", imagesLRU.Len())
+			log.Infow("image cache stats", "hits", hitsCounter.Load(), "misses", missesCounter.Load(), "size", imagesLRU.Len(), "persistent", store != nil)
 		}
 	}()
 }
@@ -79,15 +82,41 @@ func init() {
 // ImageRequest is a general struct for making requests to the API.
 // It includes all possible fields that can be sent to the API.
 type ImageRequest struct {
-	Type          string   `json:"type"`
-	Error         string   `json:"error,omitempty"`
-	Info          []string `json:"info,omitempty"`
-	Question      string   `json:"question,omitempty"`
-	Choices       []string `json:"choices,omitempty"`
-	Results       []string `json:"results,omitempty"`
-	VoteCount     uint64   `json:"voteCount"`
-	Participation float32  `json:"participation"`
-	Turnout       float32  `json:"turnout"`
+	Type          string          `json:"type"`
+	Error         string          `json:"error,omitempty"`
+	Info          []string        `json:"info,omitempty"`
+	Question      string          `json:"question,omitempty"`
+	Choices       []string        `json:"choices,omitempty"`
+	Results       []string        `json:"results,omitempty"`
+	VoteCount     uint64          `json:"voteCount"`
+	Participation float32         `json:"participation"`
+	Turnout       float32         `json:"turnout"`
+	Theme         *ImageTheme     `json:"theme,omitempty"`
+	Visualization string          `json:"visualization,omitempty"`
+	AspectRatio   string          `json:"aspectRatio,omitempty"`
+	Headcount     []string        `json:"headcount,omitempty"`
+	Timeline      []TimelinePoint `json:"timeline,omitempty"`
+}
+
+// TimelinePoint is a point of the results timeline sent to the image
+// generator. The timestamp is expressed in Unix seconds.
+type TimelinePoint struct {
+	Timestamp int64    `json:"timestamp"`
+	VoteCount uint64   `json:"voteCount"`
+	Results   []string `json:"results"`
+}
+
+// ImageTheme defines the branding applied to the question and results images,
+// usually the theme of the community of the election. The version is not sent
+// to the image generator, it is only used to compose the cache keys.
+type ImageTheme struct {
+	Version         uint64 `json:"-"`
+	Logo            string `json:"logo,omitempty"`
+	Background      string `json:"background,omitempty"`
+	PrimaryColor    string `json:"primaryColor,omitempty"`
+	SecondaryColor  string `json:"secondaryColor,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
 }
 
 // ErrorImage creates an image representing an error message.
@@ -105,7 +134,7 @@ func ErrorImage(errorMessage string) (string, error) {
 		}
 		AddImageToCacheWithID(imgCacheKey, png)
 	}()
-	time.Sleep(2 * time.Second)
+	waitForImage(imgCacheKey, waitImageGeneration)
 	return imgCacheKey, nil
 }
 
@@ -125,17 +154,18 @@ func InfoImage(infoLines []string) (string, error) {
 		}
 		AddImageToCacheWithID(imgCacheKey, png)
 	}()
-	time.Sleep(2 * time.Second)
+	waitForImage(imgCacheKey, waitImageGeneration)
 	return imgCacheKey, nil
 }
 
 // QuestionImage creates an image representing a question with choices.
-func QuestionImage(election *api.Election) (string, error) {
+// The theme is optional, if provided the image is branded with it.
+func QuestionImage(election *api.Election, theme *ImageTheme) (string, error) {
 	if election == nil || election.Metadata == nil || len(election.Metadata.Questions) == 0 {
 		return "", fmt.Errorf("election has no questions")
 	}
 	// Check if the image is already in the cache
-	if id := electionImageCacheKey(election, imageTypeQuestion); id != "" {
+	if id := electionImageCacheKey(election, imageTypeQuestion, theme, nil); id != "" {
 		return id, nil
 	}
 
@@ -149,6 +179,7 @@ func QuestionImage(election *api.Election) (string, error) {
 		Type:     "question",
 		Question: title,
 		Choices:  choices,
+		Theme:    theme,
 	}
 	go func() {
 		png, err := makeRequest(requestData)
@@ -156,23 +187,32 @@ func QuestionImage(election *api.Election) (string, error) {
 			log.Warnw("failed to create image", "error", err)
 			return
 		}
-		cacheElectionImage(png, election, imageTypeQuestion)
+		cacheElectionImage(png, election, imageTypeQuestion, theme, nil)
 	}()
 	// Add some time to allow the image to be generated
-	time.Sleep(2 * time.Second)
-	return generateElectionCacheKey(election, imageTypeQuestion), nil
+	imgCacheKey := generateElectionCacheKey(election, imageTypeQuestion, theme, nil)
+	waitForImage(imgCacheKey, waitImageGeneration)
+	return imgCacheKey, nil
 }
 
 // ResultsImage creates an image showing the results of a poll.
 // It returns the image id that can be fetch using FromCache(id).
 // The totalWeightStr is the total weight of the census, if empty Turnout is not calculated.
 // The electiondb is the election data from the database, if nil the participation is not calculated.
-func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeightStr string) (string, error) {
+// The theme is optional, if provided the image is branded with it.
+// The settings define the visualization and aspect ratio of the image, if nil the settings
+// of the electiondb are used, and if those are not defined, the default ones.
+func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeightStr string,
+	theme *ImageTheme, settings *mongo.ResultsImageSettings,
+) (string, error) {
 	if election == nil || election.Metadata == nil || len(election.Metadata.Questions) == 0 {
 		return "", fmt.Errorf("election has no questions")
 	}
+	if settings == nil && electiondb != nil {
+		settings = electiondb.ResultsImage
+	}
 	// Check if the image is already in the cache
-	if id := electionImageCacheKey(election, imageTypeResults); id != "" {
+	if id := electionImageCacheKey(election, imageTypeResults, theme, settings); id != "" {
 		return id, nil
 	}
 
@@ -189,6 +229,24 @@ func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeigh
 	title := election.Metadata.Questions[0].Title["default"]
 	choices, results := helpers.ExtractResults(election, 0)
 
+	var headcount []string
+	if settings != nil {
+		switch settings.Visualization {
+		case VisualizationTopN:
+			choices, results = helpers.TopNResults(choices, results, settings.TopN, othersLabel)
+		case VisualizationWeightedHeadcount:
+			// the headcount is indexed by the choice value, which is its position
+			headcount = make([]string, len(choices))
+			for i := range headcount {
+				count := uint64(0)
+				if electiondb != nil && i < len(electiondb.ChoicesHeadcount) {
+					count = electiondb.ChoicesHeadcount[i]
+				}
+				headcount[i] = fmt.Sprintf("%d", count)
+			}
+		}
+	}
+
 	requestData := ImageRequest{
 		Type:          "results",
 		Question:      title,
@@ -197,6 +255,12 @@ func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeigh
 		VoteCount:     election.VoteCount,
 		Participation: participation,
 		Turnout:       weightTurnout,
+		Theme:         theme,
+		Headcount:     headcount,
+	}
+	if settings != nil {
+		requestData.Visualization = settings.Visualization
+		requestData.AspectRatio = settings.AspectRatio
 	}
 	log.Debugw("requesting results image",
 		"type", requestData.Type,
@@ -205,7 +269,75 @@ func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeigh
 		"results", requestData.Results,
 		"voteCount", requestData.VoteCount,
 		"participation", requestData.Participation,
-		"turnout", requestData.Turnout)
+		"turnout", requestData.Turnout,
+		"visualization", requestData.Visualization,
+		"aspectRatio", requestData.AspectRatio)
+
+	go func() {
+		png, err := makeRequest(requestData)
+		if err != nil {
+			log.Warnw("failed to create image", "error", err)
+			return
+		}
+		cacheElectionImage(png, election, imageTypeResults, theme, settings)
+	}()
+	imgCacheKey := generateElectionCacheKey(election, imageTypeResults, theme, settings)
+	waitForImage(imgCacheKey, waitImageGeneration)
+	return imgCacheKey, nil
+}
+
+// TimelineImage creates an image showing the evolution of the results of a
+// poll over time, using the tally snapshots provided (sorted by timestamp).
+// It returns the image id that can be fetch using FromCache(id).
+// The theme is optional, if provided the image is branded with it.
+// The settings are only used for the aspect ratio, the visualization is
+// always the timeline one.
+func TimelineImage(election *api.Election, snapshots []mongo.TallySnapshot,
+	theme *ImageTheme, settings *mongo.ResultsImageSettings,
+) (string, error) {
+	if election == nil || election.Metadata == nil || len(election.Metadata.Questions) == 0 {
+		return "", fmt.Errorf("election has no questions")
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf("election has no tally snapshots")
+	}
+	timelineSettings := &mongo.ResultsImageSettings{
+		Visualization: VisualizationTimeline,
+		AspectRatio:   AspectRatio(settings),
+	}
+	// Check if the image is already in the cache
+	if id := electionImageCacheKey(election, imageTypeResults, theme, timelineSettings); id != "" {
+		return id, nil
+	}
+
+	title := election.Metadata.Questions[0].Title["default"]
+	choices, results := helpers.ExtractResults(election, 0)
+	timeline := make([]TimelinePoint, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		timeline = append(timeline, TimelinePoint{
+			Timestamp: snapshot.Timestamp.Unix(),
+			VoteCount: snapshot.VoteCount,
+			Results:   snapshot.Votes,
+		})
+	}
+
+	requestData := ImageRequest{
+		Type:          "results",
+		Question:      title,
+		Choices:       choices,
+		Results:       helpers.BigIntsToStrings(results),
+		VoteCount:     election.VoteCount,
+		Theme:         theme,
+		Visualization: timelineSettings.Visualization,
+		AspectRatio:   timelineSettings.AspectRatio,
+		Timeline:      timeline,
+	}
+	log.Debugw("requesting timeline image",
+		"question", requestData.Question,
+		"choices", requestData.Choices,
+		"voteCount", requestData.VoteCount,
+		"points", len(requestData.Timeline),
+		"aspectRatio", requestData.AspectRatio)
 
 	go func() {
 		png, err := makeRequest(requestData)
@@ -213,10 +345,11 @@ func ResultsImage(election *api.Election, electiondb *mongo.Election, totalWeigh
 			log.Warnw("failed to create image", "error", err)
 			return
 		}
-		cacheElectionImage(png, election, imageTypeResults)
+		cacheElectionImage(png, election, imageTypeResults, theme, timelineSettings)
 	}()
-	time.Sleep(2 * time.Second)
-	return generateElectionCacheKey(election, imageTypeResults), nil
+	imgCacheKey := generateElectionCacheKey(election, imageTypeResults, theme, timelineSettings)
+	waitForImage(imgCacheKey, waitImageGeneration)
+	return imgCacheKey, nil
 }
 
 // AfterVoteImage creates a static image to be displayed after a vote has been cast.
@@ -279,7 +412,7 @@ func emptyBodyImage(t string) string {
 		}
 		AddImageToCacheWithID(imgCacheKey, png)
 	}()
-	time.Sleep(2 * time.Second)
+	waitForImage(imgCacheKey, waitImageGeneration)
 	return imgCacheKey
 }
 
@@ -0,0 +1,218 @@
+package imageframe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/log"
+)
+
+// ErrImageNotFound is returned by the image stores when the requested image
+// does not exist.
+var ErrImageNotFound = fmt.Errorf("image not found")
+
+// validImageIDRgx matches the image ids that can be safely used as file names.
+var validImageIDRgx = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ImageStore is a persistent storage for the generated images, shared between
+// restarts and replicas. The images are indexed by the same keys used by the
+// in-memory cache, and they are immutable for a given key. The store is used
+// as a second level cache behind the in-memory LRU.
+type ImageStore interface {
+	// Image returns the image data for the given id. If the image does not
+	// exist, it returns an error.
+	Image(id string) ([]byte, error)
+	// SetImage stores the image data with the given id.
+	SetImage(id string, data []byte) error
+	// DeleteExpiredImages removes the images stored for longer than the
+	// store TTL, and returns the number of images removed. The images
+	// removed are generated again on demand.
+	DeleteExpiredImages() (int64, error)
+}
+
+// DefaultImageTTL is the default time that an image is kept in the
+// filesystem image store.
+const DefaultImageTTL = 90 * 24 * time.Hour
+
+// imagesPruneInterval is the interval between the removals of the expired
+// images from the image store.
+const imagesPruneInterval = time.Hour
+
+// store is the persistent image store. If nil, images are only kept in memory.
+var store ImageStore
+
+// SetImageStore sets the persistent image store used as second level cache.
+// It must be called before serving any image.
+func SetImageStore(s ImageStore) {
+	store = s
+}
+
+// PruneImageStoreAtBackground periodically removes the expired images from
+// the image store, if any, until the context is done. It must run in the
+// background.
+func PruneImageStoreAtBackground(ctx context.Context) {
+	if store == nil {
+		return
+	}
+	ticker := time.NewTicker(imagesPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				removed, err := store.DeleteExpiredImages()
+				if err != nil {
+					log.Warnw("failed to remove expired images", "error", err)
+					break
+				}
+				if removed > 0 {
+					log.Debugw("expired images removed", "count", removed)
+				}
+				// the mongo store removes the images in batches, so keep
+				// going until there is nothing left to remove
+				if removed == 0 || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// FilesystemStore is an ImageStore that keeps the images as files in a
+// local directory, until the TTL since they were written expires.
+type FilesystemStore struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewFilesystemStore creates a new FilesystemStore using the given directory,
+// creating it if it does not exist. The images are removed by
+// DeleteExpiredImages once the given TTL expires.
+func NewFilesystemStore(dir string, ttl time.Duration) (*FilesystemStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid images TTL %s", ttl)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("cannot create images directory: %w", err)
+	}
+	return &FilesystemStore{dir: dir, ttl: ttl}, nil
+}
+
+// Image returns the image data for the given id from the filesystem.
+func (fs *FilesystemStore) Image(id string) ([]byte, error) {
+	if !validImageIDRgx.MatchString(id) {
+		return nil, fmt.Errorf("invalid image id %s", id)
+	}
+	data, err := os.ReadFile(fs.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrImageNotFound
+		}
+		return nil, fmt.Errorf("cannot read image %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// SetImage writes the image data to the filesystem. The file is written to a
+// temporary file first and then renamed, so concurrent readers never get a
+// partial image.
+func (fs *FilesystemStore) SetImage(id string, data []byte) error {
+	if !validImageIDRgx.MatchString(id) {
+		return fmt.Errorf("invalid image id %s", id)
+	}
+	tmp, err := os.CreateTemp(fs.dir, id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	defer func() {
+		// on success the file has been renamed, so this is a no-op
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write image %s: %w", id, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write image %s: %w", id, err)
+	}
+	if err := os.Rename(tmp.Name(), fs.path(id)); err != nil {
+		return fmt.Errorf("cannot store image %s: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteExpiredImages removes the images written before the TTL of the store,
+// and the temporary files left by the writes interrupted before the TTL. It
+// returns the number of images removed.
+func (fs *FilesystemStore) DeleteExpiredImages() (int64, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read images directory: %w", err)
+	}
+	removed := int64(0)
+	for _, entry := range entries {
+		name := entry.Name()
+		isImage := strings.HasSuffix(name, ".png")
+		if entry.IsDir() || (!isImage && !strings.HasSuffix(name, ".tmp")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// removed by a concurrent call
+			continue
+		}
+		if time.Since(info.ModTime()) < fs.ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(fs.dir, name)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return removed, fmt.Errorf("cannot remove image %s: %w", name, err)
+		}
+		if isImage {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (fs *FilesystemStore) path(id string) string {
+	return filepath.Join(fs.dir, id+".png")
+}
+
+// storeImage persists the image in the image store, if any. Errors are only
+// logged since the image is still available in the in-memory cache.
+func storeImage(id string, data []byte) {
+	if store == nil {
+		return
+	}
+	if err := store.SetImage(id, data); err != nil {
+		log.Warnw("failed to persist image", "id", id, "error", err)
+	}
+}
+
+// storedImage returns the image with the given id from the image store, if
+// any. If the image is not found or something fails, it returns nil.
+func storedImage(id string) []byte {
+	if store == nil {
+		return nil
+	}
+	data, err := store.Image(id)
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) && !errors.Is(err, mongo.ErrImageUnknown) {
+			log.Warnw("failed to get image from store", "id", id, "error", err)
+		}
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,98 @@
+package imageframe
+
+import (
+	"fmt"
+
+	"github.com/vocdoni/vote-frame/mongo"
+)
+
+const (
+	// VisualizationBars renders the results as horizontal bars (default).
+	VisualizationBars = "bars"
+	// VisualizationPie renders the results as a pie chart.
+	VisualizationPie = "pie"
+	// VisualizationDonut renders the results as a donut chart.
+	VisualizationDonut = "donut"
+	// VisualizationWeightedHeadcount renders the weighted results and the
+	// number of voters of each choice side by side.
+	VisualizationWeightedHeadcount = "weighted-headcount"
+	// VisualizationTopN renders the N choices with more votes, grouping the
+	// rest of them into "Others".
+	VisualizationTopN = "topn"
+	// VisualizationTimeline renders the evolution of the results over time
+	// as a sparkline of each choice.
+	VisualizationTimeline = "timeline"
+
+	// AspectRatioSquare is the 1:1 aspect ratio (default).
+	AspectRatioSquare = "1:1"
+	// AspectRatioWide is the 1.91:1 aspect ratio.
+	AspectRatioWide = "1.91:1"
+
+	// DefaultTopN is the number of choices shown by the top-N visualization
+	// if no other value is provided.
+	DefaultTopN = 3
+	// othersLabel is the label of the choice that groups the rest of choices
+	// in the top-N visualization.
+	othersLabel = "Others"
+)
+
+// ValidateResultsImageSettings checks the results image settings provided and
+// sets the default values of the empty fields. It returns an error if the
+// visualization or the aspect ratio are not supported.
+func ValidateResultsImageSettings(settings *mongo.ResultsImageSettings) error {
+	if settings == nil {
+		return nil
+	}
+	switch settings.Visualization {
+	case "":
+		settings.Visualization = VisualizationBars
+	case VisualizationBars, VisualizationPie, VisualizationDonut, VisualizationWeightedHeadcount, VisualizationTimeline:
+	case VisualizationTopN:
+		if settings.TopN < 0 {
+			return fmt.Errorf("invalid topN value %d", settings.TopN)
+		}
+		if settings.TopN == 0 {
+			settings.TopN = DefaultTopN
+		}
+	default:
+		return fmt.Errorf("unsupported visualization %s", settings.Visualization)
+	}
+	switch settings.AspectRatio {
+	case "":
+		settings.AspectRatio = AspectRatioSquare
+	case AspectRatioSquare, AspectRatioWide:
+	default:
+		return fmt.Errorf("unsupported aspect ratio %s", settings.AspectRatio)
+	}
+	return nil
+}
+
+// AspectRatio returns the aspect ratio of the results image settings provided
+// or the default one if they are nil or empty.
+func AspectRatio(settings *mongo.ResultsImageSettings) string {
+	if settings == nil || settings.AspectRatio == "" {
+		return AspectRatioSquare
+	}
+	return settings.AspectRatio
+}
+
+// resultsSettingsCacheKeySuffix returns the suffix of the results image cache
+// key for the settings provided. The default settings have no suffix, so the
+// keys of the images already generated are still valid.
+func resultsSettingsCacheKeySuffix(settings *mongo.ResultsImageSettings) string {
+	if settings == nil {
+		return ""
+	}
+	suffix := ""
+	switch settings.Visualization {
+	case "", VisualizationBars:
+	case VisualizationTopN:
+		suffix += fmt.Sprintf("-%s%d", settings.Visualization, settings.TopN)
+	default:
+		suffix += "-" + settings.Visualization
+	}
+	if settings.AspectRatio == AspectRatioWide {
+		suffix += "-wide"
+	}
+	return suffix
+}
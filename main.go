@@ -22,11 +22,17 @@ import (
 	"github.com/vocdoni/vote-frame/communityhub"
 	"github.com/vocdoni/vote-frame/discover"
 	"github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/cache"
+	"github.com/vocdoni/vote-frame/farcasterapi/failover"
 	"github.com/vocdoni/vote-frame/farcasterapi/hub"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
 	"github.com/vocdoni/vote-frame/farcasterapi/neynar"
+	fcweb3 "github.com/vocdoni/vote-frame/farcasterapi/web3"
 	"github.com/vocdoni/vote-frame/features"
+	"github.com/vocdoni/vote-frame/imageframe"
 	"github.com/vocdoni/vote-frame/mongo"
 	"github.com/vocdoni/vote-frame/notifications"
+	"github.com/vocdoni/vote-frame/tokenholders"
 	urlapi "go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
@@ -64,6 +70,8 @@ func main() {
 		"https://rpc.degen.tips,https://eth.llamarpc.com,https://rpc.ankr.com/eth,https://ethereum-rpc.publicnode.com,https://mainnet.optimism.io,https://optimism.llamarpc.com,https://optimism-mainnet.public.blastapi.io,https://rpc.ankr.com/optimism",
 		"Web3 RPCs")
 	flag.Bool("indexer", false, "Enable the indexer to autodiscover users and their profiles")
+	flag.String("imageStore", "memory", "The persistent store for the generated images (memory, filesystem or mongo)")
+	flag.String("imageStoreDir", "", "The directory to store the images when using the filesystem image store (default: <dataDir>/images)")
 	// community hub flags
 	flag.String("communityHubAddress", "", "The address of the CommunityHub contract")
 	flag.Uint64("communityHubChainID", 666666666, "The chain ID of the CommunityHub contract (default: DegenChain 666666666)")
@@ -76,10 +84,27 @@ func main() {
 	flag.Uint64("botFid", 0, "FID to be used for the bot")
 	flag.String("botPrivKey", "", "The bot private key to use for signing the vote (hex)")
 	flag.String("botHubEndpoint", "", "The hub endpoint to use")
+	flag.Bool("botStreamMentions", true, "stream the bot mentions from the hub events instead of polling them (requires botHubEndpoint)")
 	flag.String("neynarAPIKey", "", "neynar API key")
+	flag.String("hubEndpoint", "", "hub endpoint to use as Farcaster API if no neynar API key is provided")
 	flag.String("neynarSignerUUID", "", "neynar signer UUID")
 	flag.String("neynarWebhookSecret", "", "neynar Webhook shared secret")
 
+	// Farcaster API failover flags
+	flag.String("farcasterAPIs", "neynar,hub", "ordered list of the Farcaster API backends used by the API (neynar, hub)")
+	flag.String("botFarcasterAPIs", "hub,neynar", "ordered list of the Farcaster API backends used by the bot (hub, neynar)")
+	flag.String("farcasterAPIRoutes", "",
+		"Farcaster API backends by group of methods (mentions, casts, users, channels, messages) or method name, e.g. 'channels=neynar,hub;casts=hub,neynar'")
+	flag.Int("farcasterAPIFailures", failover.DefaultFailureThreshold, "consecutive failures of a Farcaster API backend before skipping it")
+	flag.Duration("farcasterAPICooldown", failover.DefaultOpenTimeout, "time to skip a failing Farcaster API backend before trying it again")
+	flag.Duration("farcasterAPICacheTTL", cache.DefaultTTL, "time to cache the Farcaster API responses")
+	flag.Bool("farcasterAPICacheDB", false, "also cache the Farcaster API responses in the database")
+	flag.Float64("neynarRateLimit", 10, "maximum number of requests per second to the Neynar API (0 means no limit)")
+	flag.Float64("hubRateLimit", 0, "maximum number of requests per second to the hub (0 means no limit)")
+	flag.Bool("channelsWarpcastMetadata", false, "enrich the local channels index (used by the hub backend) with the channels metadata of the Warpcast API")
+	flag.Bool("farcasterMock", false, "use an in-memory mock of the Farcaster API (for testing and local development)")
+	flag.String("farcasterMockFixtures", "", "JSON file with the users, channels and casts to load in the Farcaster API mock")
+
 	// Airstack flags
 	flag.String("airstackAPIEndpoint", "https://api.airstack.xyz/gql", "The Airstack API endpoint to use")
 	flag.String("airstackAPIKey", "", "The Airstack API key to use")
@@ -87,8 +112,13 @@ func main() {
 	flag.Int("airstackMaxHolders", 10000, "The maximum number of holders to be retrieved from the Airstack API")
 	flag.String("airstackSupportAPIEndpoint", "", "Airstack support API endpoint")
 	flag.String("airstackTokenWhitelist", "", "Airstack token whitelist")
+	flag.String("tokenHolderProvider", "airstack",
+		"The provider of the token holders for NFT and ERC20 censuses (airstack or onchain), token censuses are disabled if it is not available")
+	flag.Int("onchainMaxHolders", 10000, "The maximum number of holders of a token scanned by the onchain token holder provider")
 
 	// Limited features flags
+	flag.String("csvCensusLimits", "0:10000,15:50000,40:200000",
+		"The maximum number of records of a CSV census by reputation tier, as a comma separated list of reputation:maxRecords")
 	flag.Int32("featureNotificationReputation", 15, "Reputation threshold to enable the notification feature")
 	flag.Int32("maxDirectMessages", 10000, "The maximum number of direct messages that any user can send. It will be scaled based on the reputation of the user.")
 
@@ -125,7 +155,22 @@ func main() {
 	web3endpointStr := viper.GetString("web3")
 	web3endpoint := strings.Split(web3endpointStr, ",")
 	neynarAPIKey := viper.GetString("neynarAPIKey")
+	hubEndpoint := viper.GetString("hubEndpoint")
+	farcasterAPIs := viper.GetString("farcasterAPIs")
+	botFarcasterAPIs := viper.GetString("botFarcasterAPIs")
+	farcasterAPIRoutes := viper.GetString("farcasterAPIRoutes")
+	farcasterAPIFailures := viper.GetInt("farcasterAPIFailures")
+	farcasterAPICooldown := viper.GetDuration("farcasterAPICooldown")
+	farcasterAPICacheTTL := viper.GetDuration("farcasterAPICacheTTL")
+	farcasterAPICacheDB := viper.GetBool("farcasterAPICacheDB")
+	neynarRateLimit := viper.GetFloat64("neynarRateLimit")
+	hubRateLimit := viper.GetFloat64("hubRateLimit")
+	channelsWarpcastMetadata := viper.GetBool("channelsWarpcastMetadata")
+	farcasterMock := viper.GetBool("farcasterMock")
+	farcasterMockFixtures := viper.GetString("farcasterMockFixtures")
 	indexer := viper.GetBool("indexer")
+	imageStore := viper.GetString("imageStore")
+	imageStoreDir := viper.GetString("imageStoreDir")
 	// community hub vars
 	communityHubAddress := viper.GetString("communityHubAddress")
 	communityHubChainID := viper.GetUint64("communityHubChainID")
@@ -135,6 +180,7 @@ func main() {
 	botFid := viper.GetUint64("botFid")
 	botPrivKey := viper.GetString("botPrivKey")
 	botHubEndpoint := viper.GetString("botHubEndpoint")
+	botStreamMentions := viper.GetBool("botStreamMentions")
 	neynarSignerUUID := viper.GetString("neynarSignerUUID")
 	neynarWebhookSecret := viper.GetString("neynarWebhookSecret")
 
@@ -145,15 +191,25 @@ func main() {
 	airstackMaxHolders := uint32(viper.GetInt("airstackMaxHolders"))
 	airstackSupportAPIEndpoint := viper.GetString("airstackSupportAPIEndpoint")
 	airstackTokenWhitelist := viper.GetString("airstackTokenWhitelist")
+	tokenHolderProvider := viper.GetString("tokenHolderProvider")
+	onchainMaxHolders := uint32(viper.GetInt("onchainMaxHolders"))
 
 	// limited features vars
 	featureNotificationReputation := uint32(viper.GetInt32("featureNotificationReputation"))
 	maxDirectMessages = uint32(viper.GetInt32("maxDirectMessages"))
+	csvCensusLimitsStr := viper.GetString("csvCensusLimits")
 
 	// overwrite features thesholds
 	if featureNotificationReputation > 0 {
 		features.SetReputation(features.NOTIFY_USERS, featureNotificationReputation)
 	}
+	if csvCensusLimitsStr != "" {
+		limits, err := parseCSVCensusLimits(csvCensusLimitsStr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		csvCensusLimits = limits
+	}
 
 	if adminToken == "" {
 		adminToken = uuid.New().String()
@@ -189,9 +245,23 @@ func main() {
 		"communityHubAdmin", communityHubAdminPrivKey != "",
 		"botFid", botFid,
 		"botHubEndpoint", botHubEndpoint,
+		"botStreamMentions", botStreamMentions,
+		"hubEndpoint", hubEndpoint,
+		"farcasterAPIs", farcasterAPIs,
+		"botFarcasterAPIs", botFarcasterAPIs,
+		"farcasterAPIRoutes", farcasterAPIRoutes,
+		"farcasterAPICacheTTL", farcasterAPICacheTTL,
+		"farcasterAPICacheDB", farcasterAPICacheDB,
+		"neynarRateLimit", neynarRateLimit,
+		"hubRateLimit", hubRateLimit,
+		"channelsWarpcastMetadata", channelsWarpcastMetadata,
+		"farcasterMock", farcasterMock,
+		"farcasterMockFixtures", farcasterMockFixtures,
 		"neynarSignerUUID", neynarSignerUUID,
 		"web3endpoint", web3endpoint,
 		"indexer", indexer,
+		"imageStore", imageStore,
+		"imageStoreDir", imageStoreDir,
 		"apiToken", apiToken,
 		"airstackAPIEndpoint", airstackEndpoint,
 		"airstackAPIKey", airstackKey,
@@ -199,6 +269,8 @@ func main() {
 		"airstackMaxHolders", airstackMaxHolders,
 		"airstackSupportAPIEndpoint", airstackSupportAPIEndpoint,
 		"airstackTokenWhitelist", airstackTokenWhitelist,
+		"tokenHolderProvider", tokenHolderProvider,
+		"onchainMaxHolders", onchainMaxHolders,
 	)
 
 	// Start the pprof http endpoints
@@ -253,10 +325,31 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Set the persistent image store
+	switch imageStore {
+	case "memory":
+	case "filesystem":
+		if imageStoreDir == "" {
+			imageStoreDir = path.Join(dataDir, "images")
+		}
+		fsStore, err := imageframe.NewFilesystemStore(imageStoreDir, imageframe.DefaultImageTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imageframe.SetImageStore(fsStore)
+	case "mongo":
+		imageframe.SetImageStore(db)
+	default:
+		log.Fatalf("invalid image store %s (memory, filesystem or mongo expected)", imageStore)
+	}
+
 	// Start the discovery user profile background process
 	mainCtx, mainCtxCancel := context.WithCancel(context.Background())
 	defer mainCtxCancel()
 
+	// Remove the expired images from the persistent image store
+	go imageframe.PruneImageStoreAtBackground(mainCtx)
+
 	// Create a Web3 pool
 	web3pool, err := c3web3.NewWeb3Pool()
 	if err != nil {
@@ -269,14 +362,104 @@ func main() {
 	}
 	log.Infow("web3 pool initialized", "endpoints", web3pool.String())
 
-	// Create the Farcaster API client
-	neynarcli, err := neynar.NewNeynarAPI(neynarAPIKey, web3pool)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// Create the Farcaster API clients, or the in-memory mock of the Farcaster
+	// API if the mock mode is enabled
+	var (
+		fcapi        farcasterapi.API
+		fcMock       *mock.MockAPI
+		neynarcli    *neynar.NeynarAPI
+		cachedNeynar *cache.CachedAPI
+		fcCacheStore cache.Store
+		fcConfig     *failover.Config
+		signerSync   *discover.SignerSync
+		channelIndex *discover.ChannelIndex
+	)
+	if farcasterMock {
+		if fcMock, err = newMockFarcasterAPI(farcasterMockFixtures); err != nil {
+			log.Fatal(err)
+		}
+		fcapi = fcMock
+		log.Warnw("using the in-memory mock of the farcaster api", "fixtures", farcasterMockFixtures)
+	} else {
+		// Create the Farcaster API client
+		neynarcli, err = neynar.NewNeynarAPI(neynarAPIKey, web3pool)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	// Start the discovery user profile background process
-	discover.NewFarcasterDiscover(db, neynarcli).Run(mainCtx, indexer)
+		// Wrap the Farcaster API clients with a cache and a rate limiter
+		if farcasterAPICacheDB {
+			fcCacheStore = db
+		}
+		cachedNeynar = newCachedFarcasterAPI(mainCtx, neynarcli, &cache.Config{
+			Name:  farcasterBackendNeynar,
+			TTL:   farcasterAPICacheTTL,
+			Store: fcCacheStore,
+			Rate:  neynarRateLimit,
+		})
+
+		// Start the discovery user profile background process
+		discover.NewFarcasterDiscover(db, cachedNeynar).Run(mainCtx, indexer)
+
+		// Start the users signers sync against the Farcaster KeyRegistry
+		keyRegistry, err := fcweb3.NewFarcasterProvider(web3pool)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signerSync = discover.NewSignerSync(db, keyRegistry)
+		signerSync.Run(mainCtx)
+
+		// Create the local channels index, used by the Hub based Farcaster API,
+		// and build it from the channel casts streamed by the hub. The hub
+		// also provides the registration time of the users, required to
+		// filter the censuses by account age
+		channelIndex = discover.NewChannelIndex(db, channelsWarpcastMetadata)
+		if indexHubEndpoint := hubEndpoint; indexHubEndpoint != "" || botHubEndpoint != "" {
+			if indexHubEndpoint == "" {
+				indexHubEndpoint = botHubEndpoint
+			}
+			indexHub, err := hub.NewHubAPI(indexHubEndpoint, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			channelIndex.Run(mainCtx, indexHub)
+			discover.NewRegistrationSync(db, indexHub).Run(mainCtx)
+		}
+
+		// Create the Farcaster API from the backends available: neynar (always
+		// available unless only a hub endpoint is provided) and the hub
+		fcRoutes, err := failover.ParseRoutes(farcasterAPIRoutes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fcConfig = &failover.Config{
+			Routes:           fcRoutes,
+			FailureThreshold: farcasterAPIFailures,
+			OpenTimeout:      farcasterAPICooldown,
+		}
+		apiBackends := map[string]farcasterapi.API{}
+		if neynarAPIKey != "" || hubEndpoint == "" {
+			apiBackends[farcasterBackendNeynar] = cachedNeynar
+		}
+		if hubEndpoint != "" {
+			hubcli, err := hub.NewHubAPI(hubEndpoint, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hubcli.SetSignersProvider(signerSync)
+			hubcli.SetChannelIndex(channelIndex)
+			apiBackends[farcasterBackendHub] = newCachedFarcasterAPI(mainCtx, hubcli, &cache.Config{
+				Name:  farcasterBackendHub,
+				TTL:   farcasterAPICacheTTL,
+				Store: fcCacheStore,
+				Rate:  hubRateLimit,
+			})
+		}
+		fcapi, err = newFarcasterAPI(strings.Split(farcasterAPIs, ","), apiBackends, fcConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Create the community hub service
 	var comHub *communityhub.CommunityHub
@@ -316,10 +499,39 @@ func main() {
 		}
 	}
 
+	// Create the token holder provider, the on-chain one scans the transfer
+	// logs of the tokens using the web3 pool, and it is also used to resolve
+	// the token balances at past blocks. If the provider is not available,
+	// the token censuses are disabled.
+	var holdersProvider tokenholders.TokenHolderProvider
+	var snapshotsProvider tokenholders.SnapshotProvider
+	switch tokenHolderProvider {
+	case "airstack":
+		if as == nil {
+			log.Warnw("no Airstack API key provided, token censuses disabled")
+			break
+		}
+		holdersProvider = as
+	case "onchain":
+		onchainHolders, err := tokenholders.NewOnchainProvider(mainCtx, web3pool, db, onchainMaxHolders)
+		if err != nil {
+			log.Fatal(err)
+		}
+		holdersProvider = onchainHolders
+		snapshotsProvider = onchainHolders
+	default:
+		log.Fatalf("invalid token holder provider %s (airstack or onchain expected)", tokenHolderProvider)
+	}
+	if holdersProvider != nil {
+		log.Infow("token holder provider initialized", "provider", tokenHolderProvider,
+			"blockchains", holdersProvider.Blockchains(),
+			"maxHolders", holdersProvider.MaxHolders())
+	}
+
 	// Create the Vocdoni handler
 	apiTokenUUID := uuid.MustParse(apiToken)
 	handler, err := NewVocdoniHandler(apiEndpoint, vocdoniPrivKey, censusInfo,
-		webAppDir, db, mainCtx, neynarcli, &apiTokenUUID, as, comHub)
+		webAppDir, db, mainCtx, fcapi, &apiTokenUUID, as, holdersProvider, snapshotsProvider, comHub)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -498,6 +710,10 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/poll/{electionID}/timeline", http.MethodGet, "public", handler.resultsTimeline); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/poll/info/{electionID}", http.MethodGet, "public", handler.electionFullInfo); err != nil {
 		log.Fatal(err)
 	}
@@ -530,6 +746,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/census/{electionID}/export", http.MethodGet, "private", handler.censusExport); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/{electionID}/eligible/{fid}", http.MethodGet, "public", handler.censusEligibility); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/census/root/{root}", http.MethodGet, "public", handler.censusFromDatabaseByRoot); err != nil {
 		log.Fatal(err)
 	}
@@ -538,6 +762,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/census/csv/upload", http.MethodPost, "private", handler.censusCSVUpload); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/csv/upload/{uploadId}", http.MethodPost, "private", handler.censusCSVFromUpload); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/census/followers/{userFid}", http.MethodPost, "private", handler.censusFollowers); err != nil {
 		log.Fatal(err)
 	}
@@ -550,14 +782,46 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/census/cast/{fid}/{hash}", http.MethodPost, "private", handler.censusCast); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/graph", http.MethodPost, "private", handler.censusGraph); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/composite", http.MethodPost, "private", handler.censusComposite); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/census/community", http.MethodPost, "private", handler.censusCommunity); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/census/election/{electionID}/voters", http.MethodPost, "private", handler.censusElectionVoters); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/election/{electionID}/census", http.MethodPost, "private", handler.censusElectionCensus); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/saved", http.MethodPost, "private", handler.saveCensus); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := uAPI.Endpoint.RegisterMethod("/census/saved", http.MethodGet, "private", handler.savedCensuses); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/census/check/{censusID}", http.MethodGet, "private", handler.censusQueueInfo); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := uAPI.Endpoint.RegisterMethod("/census/check/{censusID}", http.MethodDelete, "private", handler.cancelCensusJob); err != nil {
+		log.Fatal(err)
+	}
+
 	if err := uAPI.Endpoint.RegisterMethod("/census/exists/nft", http.MethodPost, "public", handler.checkNFTContractHandler); err != nil {
 		log.Fatal(err)
 	}
@@ -566,7 +830,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if as != nil { // if airstack activated
+	if holdersProvider != nil { // if token holder provider activated
 		if err := uAPI.Endpoint.RegisterMethod("/census/airstack/nft", http.MethodPost, "private", handler.censusTokenNFTAirstack); err != nil {
 			log.Fatal(err)
 		}
@@ -674,31 +938,62 @@ func main() {
 	// if a bot FID is provided, start the bot background process
 	if botFid > 0 {
 		var botAPI farcasterapi.API
-		if botPrivKey != "" && botHubEndpoint != "" {
-			// Hub based bot
-			botAPI, err = hub.NewHubAPI(botHubEndpoint, nil)
-			if err != nil {
+		var botStream farcasterapi.MentionStreamer
+		if fcMock != nil {
+			// Mock based bot, the mentions can be sent to the webhook
+			if err := fcMock.SetFarcasterUser(botFid, ""); err != nil {
 				log.Fatal(err)
 			}
-			if err := botAPI.SetFarcasterUser(botFid, botPrivKey); err != nil {
+			botAPI = fcMock
+			if err := uAPI.Endpoint.RegisterMethod("/webhook/mock", http.MethodPost, "public", mockWebhook(fcMock)); err != nil {
 				log.Fatal(err)
 			}
-			log.Info("trying to init Hub based bot")
-		} else if neynarAPIKey != "" && neynarSignerUUID != "" && neynarWebhookSecret != "" {
-			// Neynar based bot
-			if err := neynarcli.SetFarcasterUser(botFid, neynarSignerUUID); err != nil {
-				log.Fatal(err)
+			log.Info("trying to init mock based bot")
+		} else {
+			botBackends := map[string]farcasterapi.API{}
+			if botPrivKey != "" && botHubEndpoint != "" {
+				// Hub based bot
+				hubAPI, err := hub.NewHubAPI(botHubEndpoint, nil)
+				if err != nil {
+					log.Fatal(err)
+				}
+				hubAPI.SetSignersProvider(signerSync)
+				hubAPI.SetChannelIndex(channelIndex)
+				if err := hubAPI.SetFarcasterUser(botFid, botPrivKey); err != nil {
+					log.Fatal(err)
+				}
+				botBackends[farcasterBackendHub] = newCachedFarcasterAPI(mainCtx, hubAPI, &cache.Config{
+					Name:  "bot" + farcasterBackendHub,
+					TTL:   farcasterAPICacheTTL,
+					Store: fcCacheStore,
+					Rate:  hubRateLimit,
+				})
+				if botStreamMentions {
+					botStream = hubAPI
+				}
+				log.Info("trying to init Hub based bot")
+			}
+			if neynarAPIKey != "" && neynarSignerUUID != "" && neynarWebhookSecret != "" {
+				// Neynar based bot
+				if err := neynarcli.SetFarcasterUser(botFid, neynarSignerUUID); err != nil {
+					log.Fatal(err)
+				}
+				botBackends[farcasterBackendNeynar] = cachedNeynar
+				// register neynar webhook handler
+				if err := uAPI.Endpoint.RegisterMethod("/webhook/neynar", http.MethodPost, "public", neynarWebhook(neynarcli, neynarWebhookSecret)); err != nil {
+					log.Fatal(err)
+				}
+				log.Info("trying to init Neynar based bot")
 			}
-			botAPI = neynarcli
-			// register neynar webhook handler
-			if err := uAPI.Endpoint.RegisterMethod("/webhook/neynar", http.MethodPost, "public", neynarWebhook(neynarcli, neynarWebhookSecret)); err != nil {
+			if len(botBackends) == 0 {
+				log.Fatalf("botFid is set but botPrivKey and botHubEndpoint or neynarAPIKey, neynarSignerUUID and neynarWebhookSecret are not")
+			}
+			botAPI, err = newFarcasterAPI(strings.Split(botFarcasterAPIs, ","), botBackends, fcConfig)
+			if err != nil {
 				log.Fatal(err)
 			}
-			log.Info("trying to init Neynar based bot")
-		} else {
-			log.Fatalf("botFid is set but botPrivKey and botHubEndpoint or neynarAPIKey, neynarSignerUUID and neynarWebhookSecret are not")
 		}
-		voteBot, err := initBot(mainCtx, handler, botAPI, censusInfo)
+		voteBot, err := initBot(mainCtx, handler, botAPI, botStream, censusInfo)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -27,6 +28,30 @@ func (ms *MongoStorage) UserAccessProfile(userID uint64) (*UserAccessProfile, er
 	return &profile, nil
 }
 
+// ReputationsByFIDs returns the reputation of the users of the list provided
+// that have an access profile, indexed by FID.
+func (ms *MongoStorage) ReputationsByFIDs(fids []uint64) (map[uint64]uint32, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	result := make(map[uint64]uint32, len(fids))
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "reputation": 1})
+	err := findIn(ms.userAccessProfiles, "_id", fids, bson.M{}, opts, func(cursor *mongo.Cursor) error {
+		var profile struct {
+			UserID     uint64 `bson:"_id"`
+			Reputation uint32 `bson:"reputation"`
+		}
+		if err := cursor.Decode(&profile); err != nil {
+			return err
+		}
+		result[profile.UserID] = profile.Reputation
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find access profiles: %w", err)
+	}
+	return result, nil
+}
+
 // SetReputationForUser updates the reputation for a given user ID.
 func (ms *MongoStorage) SetReputationForUser(userID uint64, reputation uint32) error {
 	return ms.updateUserAccessProfile(userID, bson.M{"$set": bson.M{"reputation": reputation}})
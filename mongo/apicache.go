@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APICacheValue returns the cached value of the given key. If the value is
+// not cached or it has expired, it returns ErrCacheMiss.
+func (ms *MongoStorage) APICacheValue(key string) ([]byte, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var entry struct {
+		Value     []byte    `bson:"value"`
+		ExpiresAt time.Time `bson:"expiresAt"`
+	}
+	if err := ms.apiCache.FindOne(ctx, bson.M{"_id": key}).Decode(&entry); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCacheMiss
+		}
+		return nil, fmt.Errorf("error retrieving cached value: %w", err)
+	}
+	// the expired documents are removed by the TTL index, but not immediately
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, ErrCacheMiss
+	}
+	return entry.Value, nil
+}
+
+// SetAPICacheValue caches the value provided for the given key until the
+// expiration time.
+func (ms *MongoStorage) SetAPICacheValue(key string, value []byte, expiresAt time.Time) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"value": value, "expiresAt": expiresAt}}
+	if _, err := ms.apiCache.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("cannot cache value: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// botCastsExpiration is the time to keep the hashes of the casts processed by
+// the bot. It must be longer than the time the bot can take to be restarted
+// and resume its mentions stream.
+const botCastsExpiration = 7 * 24 * time.Hour
+
+// botPollsExpiration is the time to keep the pending polls of the bot that
+// have not been resumed nor removed.
+const botPollsExpiration = 24 * time.Hour
+
+// BotCursor returns the stored cursor of the mentions stream of the bot with
+// the given fid. It returns 0 if the bot has no cursor stored.
+func (ms *MongoStorage) BotCursor(fid uint64) (uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var state struct {
+		Cursor uint64 `bson:"cursor"`
+	}
+	if err := ms.botState.FindOne(ctx, bson.M{"_id": fid}).Decode(&state); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error retrieving bot cursor: %w", err)
+	}
+	return state.Cursor, nil
+}
+
+// SetBotCursor stores the cursor of the mentions stream of the bot with the
+// given fid.
+func (ms *MongoStorage) SetBotCursor(fid, cursor uint64) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"cursor": cursor, "updatedAt": time.Now()}}
+	if _, err := ms.botState.UpdateOne(ctx, bson.M{"_id": fid}, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("cannot update bot cursor: %w", err)
+	}
+	return nil
+}
+
+// BotCastHandled returns true if the cast with the given hash has already been
+// processed by the bot with the given fid.
+func (ms *MongoStorage) BotCastHandled(fid uint64, hash string) (bool, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	count, err := ms.botCasts.CountDocuments(ctx, bson.M{"_id": hash, "botFid": fid})
+	if err != nil {
+		return false, fmt.Errorf("error retrieving bot cast: %w", err)
+	}
+	return count > 0, nil
+}
+
+// AddBotCast registers the cast with the given hash as processed by the bot
+// with the given fid. It returns false if the cast was already registered.
+func (ms *MongoStorage) AddBotCast(fid uint64, hash string) (bool, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cast := bson.M{
+		"_id":       hash,
+		"botFid":    fid,
+		"expiresAt": time.Now().Add(botCastsExpiration),
+	}
+	if _, err := ms.botCasts.InsertOne(ctx, cast); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot add bot cast: %w", err)
+	}
+	return true, nil
+}
+
+// AddBotPendingPoll stores a poll of the bot with the given fid that is
+// waiting for its census, so it can be resumed if the bot is restarted. The
+// poll is identified by the hash of the cast that created it, and its data is
+// opaque to the storage.
+func (ms *MongoStorage) AddBotPendingPoll(fid uint64, hash string, data []byte) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	poll := BotPendingPoll{
+		Hash:      hash,
+		BotFID:    fid,
+		Data:      data,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(botPollsExpiration),
+	}
+	opts := options.Replace().SetUpsert(true)
+	if _, err := ms.botPolls.ReplaceOne(ctx, bson.M{"_id": hash}, poll, opts); err != nil {
+		return fmt.Errorf("cannot add bot pending poll: %w", err)
+	}
+	return nil
+}
+
+// BotPendingPolls returns the pending polls of the bot with the given fid,
+// the oldest first.
+func (ms *MongoStorage) BotPendingPolls(fid uint64) ([]*BotPendingPoll, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := ms.botPolls.Find(ctx, bson.M{"botFid": fid}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving bot pending polls: %w", err)
+	}
+	polls := []*BotPendingPoll{}
+	if err := cursor.All(ctx, &polls); err != nil {
+		return nil, fmt.Errorf("error decoding bot pending polls: %w", err)
+	}
+	return polls, nil
+}
+
+// DelBotPendingPoll removes the pending poll created by the cast with the
+// given hash, once it has been created or it has failed.
+func (ms *MongoStorage) DelBotPendingPoll(hash string) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := ms.botPolls.DeleteOne(ctx, bson.M{"_id": hash}); err != nil {
+		return fmt.Errorf("cannot remove bot pending poll: %w", err)
+	}
+	return nil
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"math/big"
 	"sort"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.vocdoni.io/dvote/types"
 )
 
@@ -30,8 +32,10 @@ func (ms *MongoStorage) AddCensus(censusID types.HexBytes, userFID uint64) error
 }
 
 // AddParticipantsToCensus updates a census document with participants and their associated values.
+// The weights of the participants are also stored by FID, which do not change
+// if the participants change their usernames.
 func (ms *MongoStorage) AddParticipantsToCensus(censusID types.HexBytes, participants map[string]*big.Int,
-	fromTotalAddresses uint32, totalWeight *big.Int, censusURI string,
+	participantFIDs map[uint64]*big.Int, fromTotalAddresses uint32, totalWeight *big.Int, censusURI string,
 ) error {
 	ms.keysLock.Lock()
 	defer ms.keysLock.Unlock()
@@ -40,12 +44,17 @@ func (ms *MongoStorage) AddParticipantsToCensus(censusID types.HexBytes, partici
 	for k, v := range participants {
 		participantsString[k] = v.String()
 	}
+	participantFIDsString := map[string]string{}
+	for fid, v := range participantFIDs {
+		participantFIDsString[strconv.FormatUint(fid, 10)] = v.String()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	update := bson.M{
 		"$set": bson.M{
 			"participants":       participantsString,
+			"participantFids":    participantFIDsString,
 			"fromTotalAddresses": fromTotalAddresses,
 			"totalWeight":        totalWeight.String(),
 			"url":                censusURI,
@@ -60,6 +69,109 @@ func (ms *MongoStorage) AddParticipantsToCensus(censusID types.HexBytes, partici
 	return nil
 }
 
+// SetSnapshotForCensus updates a census document with the block at which the
+// token balances of its participants were resolved.
+func (ms *MongoStorage) SetSnapshotForCensus(censusID types.HexBytes, snapshot *CensusSnapshot) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{
+		"$set": bson.M{"snapshot": snapshot},
+	}
+	if _, err := ms.census.UpdateOne(ctx, bson.M{"_id": censusID.String()}, update); err != nil {
+		return fmt.Errorf("cannot update census: %w", err)
+	}
+	return nil
+}
+
+// SetWeightStrategyForCensus updates a census document with the strategy
+// used to compute the weights of its participants.
+func (ms *MongoStorage) SetWeightStrategyForCensus(censusID types.HexBytes, strategy *CensusWeightStrategy) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{
+		"$set": bson.M{"weightStrategy": strategy},
+	}
+	if _, err := ms.census.UpdateOne(ctx, bson.M{"_id": censusID.String()}, update); err != nil {
+		return fmt.Errorf("cannot update census: %w", err)
+	}
+	return nil
+}
+
+// SetNameForCensus saves a census under the name provided, so its creator can
+// select it later. An empty name removes the census from the saved ones.
+func (ms *MongoStorage) SetNameForCensus(censusID types.HexBytes, name string) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{"$set": bson.M{"name": name}}
+	if name == "" {
+		update = bson.M{"$unset": bson.M{"name": ""}}
+	}
+	if _, err := ms.census.UpdateOne(ctx, bson.M{"_id": censusID.String()}, update); err != nil {
+		return fmt.Errorf("cannot update census: %w", err)
+	}
+	return nil
+}
+
+// SavedCensusesByUser returns the censuses saved under a name by the user
+// provided, without their participants.
+func (ms *MongoStorage) SavedCensusesByUser(userFID uint64) ([]Census, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"createdBy": userFID, "name": bson.M{"$exists": true, "$ne": ""}}
+	opts := options.Find().SetProjection(bson.M{"participants": 0}).SetSort(bson.M{"name": 1})
+	cursor, err := ms.census.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find saved censuses: %w", err)
+	}
+	defer cursor.Close(ctx)
+	censuses := []Census{}
+	if err := cursor.All(ctx, &censuses); err != nil {
+		return nil, fmt.Errorf("cannot decode saved censuses: %w", err)
+	}
+	return censuses, nil
+}
+
+// CopyCensus creates a new census document with the given ID and creator
+// from the census provided, keeping its participants, root and settings. The
+// copy is assigned to the election provided, if any, and it is not saved under
+// a name, so the same census tree can be used by another election.
+func (ms *MongoStorage) CopyCensus(census *Census, censusID types.HexBytes, userFID uint64,
+	electionID types.HexBytes,
+) error {
+	if census == nil {
+		return fmt.Errorf("invalid census")
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	copied := *census
+	copied.CensusID = censusID.String()
+	copied.CreatedBy = userFID
+	copied.ElectionID = ""
+	if electionID != nil {
+		copied.ElectionID = electionID.String()
+	}
+	copied.Name = ""
+	if _, err := ms.census.InsertOne(ctx, copied); err != nil {
+		return fmt.Errorf("cannot insert census: %w", err)
+	}
+	return nil
+}
+
 // Census retrieves a census document based on its ID.
 func (ms *MongoStorage) Census(censusID types.HexBytes) (Census, error) {
 	ms.keysLock.RLock()
@@ -162,28 +274,56 @@ func (ms *MongoStorage) censusFromElection(electionID types.HexBytes) (*Census,
 	return &census, nil
 }
 
-// SetElectionIdForCensusRoot updates the ElectionID for a given census document by its root.
-// If the root is not found, it returns nil, indicating no error occurred.
-func (ms *MongoStorage) SetElectionIdForCensusRoot(root, electionID types.HexBytes) error {
+// SetElectionIdForCensus assigns the election provided to the census document
+// with the given ID and root, if it is not assigned to any election yet. It
+// returns ErrCensusUnknown if there is no such census document.
+func (ms *MongoStorage) SetElectionIdForCensus(censusID, root, electionID types.HexBytes) error {
 	ms.keysLock.Lock()
 	defer ms.keysLock.Unlock()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Prepare the update document to set the new ElectionID
+	filter := bson.M{
+		"_id":        censusID.String(),
+		"root":       root.String(),
+		"electionId": bson.M{"$in": []any{"", nil}},
+	}
 	update := bson.M{"$set": bson.M{"electionId": electionID.String()}}
-
-	// Execute the update operation
-	result, err := ms.census.UpdateOne(ctx, bson.M{"root": root.String()}, update)
+	result, err := ms.census.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return fmt.Errorf("cannot update ElectionID for Census with root %s: %w", root.String(), err)
+		return fmt.Errorf("cannot update ElectionID for Census %s: %w", censusID.String(), err)
 	}
-
-	// If the root is not found, MatchedCount will be 0. We treat this as a no-op success.
 	if result.MatchedCount == 0 {
-		return nil
+		return ErrCensusUnknown
 	}
+	return nil
+}
+
+// SetElectionIdForCensusRoot assigns the election provided to the census
+// document with the given root, for the requests that do not include the
+// census ID. Since a census tree can be reused by many elections, the election
+// is only assigned if a single census document with the root is not assigned
+// to any election yet, otherwise the census is ambiguous and it returns
+// ErrCensusUnknown.
+func (ms *MongoStorage) SetElectionIdForCensusRoot(root, electionID types.HexBytes) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unassigned := bson.M{"root": root.String(), "electionId": bson.M{"$in": []any{"", nil}}}
+	count, err := ms.census.CountDocuments(ctx, unassigned, options.Count().SetLimit(2))
+	if err != nil {
+		return fmt.Errorf("cannot count Census with root %s: %w", root.String(), err)
+	}
+	if count != 1 {
+		return ErrCensusUnknown
+	}
+	update := bson.M{"$set": bson.M{"electionId": electionID.String()}}
+	if _, err := ms.census.UpdateOne(ctx, unassigned, update); err != nil {
+		return fmt.Errorf("cannot update ElectionID for Census with root %s: %w", root.String(), err)
+	}
 	return nil
 }
@@ -0,0 +1,148 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.vocdoni.io/dvote/types"
+)
+
+// UpdateCensusJob creates or replaces the census job provided. The creation
+// time and the creator are kept if the job already exists and the finish time
+// is set when the job is not running anymore. The lease of the job is updated
+// if it is provided. The jobs that are not running anymore cannot be updated,
+// it returns ErrCensusJobDone for them.
+func (ms *MongoStorage) UpdateCensusJob(job *CensusJob) error {
+	if job == nil || job.CensusID == "" {
+		return fmt.Errorf("invalid census job")
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{
+		"state":     job.State,
+		"progress":  job.Progress,
+		"error":     job.Error,
+		"result":    job.Result,
+		"updatedAt": now,
+	}
+	if job.Finished() {
+		set["finishedAt"] = now
+	}
+	if !job.LeaseUntil.IsZero() {
+		set["leaseUntil"] = job.LeaseUntil
+	}
+	setOnInsert := bson.M{"createdAt": now}
+	if job.CreatedBy != 0 {
+		setOnInsert["createdBy"] = job.CreatedBy
+	}
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": setOnInsert,
+	}
+	// only the running jobs match the filter, so the upsert of a finished
+	// job fails because the job already exists
+	filter := bson.M{"_id": job.CensusID, "state": CensusJobStateRunning}
+	if _, err := ms.censusJobs.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrCensusJobDone
+		}
+		return fmt.Errorf("cannot update census job: %w", err)
+	}
+	return nil
+}
+
+// CensusJob returns the census job of the census with the given ID. If the
+// job is not found, it returns ErrCensusJobUnknown.
+func (ms *MongoStorage) CensusJob(censusID types.HexBytes) (*CensusJob, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job CensusJob
+	if err := ms.censusJobs.FindOne(ctx, bson.M{"_id": censusID.String()}).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCensusJobUnknown
+		}
+		return nil, fmt.Errorf("error retrieving census job: %w", err)
+	}
+	return &job, nil
+}
+
+// RenewCensusJobLeases extends until the given time the lease of the running
+// census jobs with the IDs provided. It returns the number of jobs updated.
+func (ms *MongoStorage) RenewCensusJobLeases(censusIDs []string, until time.Time) (int64, error) {
+	if len(censusIDs) == 0 {
+		return 0, nil
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":   bson.M{"$in": censusIDs},
+		"state": CensusJobStateRunning,
+	}
+	res, err := ms.censusJobs.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"leaseUntil": until}})
+	if err != nil {
+		return 0, fmt.Errorf("cannot renew census job leases: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// FailExpiredCensusJobs sets the state of the running census jobs whose lease
+// has expired to failed with the error provided. It is used to fail the jobs
+// interrupted by a restart or a crash of the instance that was building them,
+// without affecting the jobs of the instances still running. It returns the
+// number of jobs updated.
+func (ms *MongoStorage) FailExpiredCensusJobs(reason string) (int64, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"state":      CensusJobStateRunning,
+		"leaseUntil": bson.M{"$lt": now},
+	}
+	update := bson.M{"$set": bson.M{
+		"state":      CensusJobStateFailed,
+		"error":      reason,
+		"updatedAt":  now,
+		"finishedAt": now,
+	}}
+	res, err := ms.censusJobs.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("cannot fail expired census jobs: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// DeleteFinishedCensusJobs removes the census jobs that finished before the
+// given time. It returns the number of jobs removed.
+func (ms *MongoStorage) DeleteFinishedCensusJobs(before time.Time) (int64, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"state":      bson.M{"$ne": CensusJobStateRunning},
+		"finishedAt": bson.M{"$lt": before},
+	}
+	res, err := ms.censusJobs.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete finished census jobs: %w", err)
+	}
+	return res.DeletedCount, nil
+}
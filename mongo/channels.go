@@ -0,0 +1,161 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpsertChannel creates or updates the channel provided in the channels
+// index. Only the non-zero fields of the channel are updated.
+func (ms *MongoStorage) UpsertChannel(channel *Channel) error {
+	if channel == nil || channel.ID == "" {
+		return fmt.Errorf("invalid channel")
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel.UpdatedAt = time.Now()
+	updateDoc, err := dynamicUpdateDocument(channel, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create update document: %w", err)
+	}
+	opts := options.Update().SetUpsert(true)
+	if _, err := ms.channels.UpdateOne(ctx, bson.M{"_id": channel.ID}, updateDoc, opts); err != nil {
+		return fmt.Errorf("cannot update channel: %w", err)
+	}
+	return nil
+}
+
+// Channel returns the channel with the given ID from the channels index. If
+// the channel is not found, it returns ErrChannelUnknown.
+func (ms *MongoStorage) Channel(channelID string) (*Channel, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var channel Channel
+	if err := ms.channels.FindOne(ctx, bson.M{"_id": channelID}).Decode(&channel); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrChannelUnknown
+		}
+		return nil, fmt.Errorf("error retrieving channel: %w", err)
+	}
+	return &channel, nil
+}
+
+// ChannelByURL returns the channel with the given parent url from the
+// channels index. If the channel is not found, it returns ErrChannelUnknown.
+func (ms *MongoStorage) ChannelByURL(url string) (*Channel, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var channel Channel
+	if err := ms.channels.FindOne(ctx, bson.M{"url": url}).Decode(&channel); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrChannelUnknown
+		}
+		return nil, fmt.Errorf("error retrieving channel: %w", err)
+	}
+	return &channel, nil
+}
+
+// SearchChannels returns up to maxResults channels whose ID or name contains
+// the query provided (case insensitive), sorted by number of followers.
+func (ms *MongoStorage) SearchChannels(query string, maxResults int) ([]*Channel, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pattern := bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}
+	filter := bson.M{"$or": []bson.M{{"_id": pattern}, {"name": pattern}}}
+	opts := options.Find().SetSort(bson.M{"followers": -1}).SetLimit(int64(maxResults))
+	cur, err := ms.channels.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error searching channels: %w", err)
+	}
+	defer cur.Close(ctx)
+	channels := []*Channel{}
+	if err := cur.All(ctx, &channels); err != nil {
+		return nil, fmt.Errorf("error decoding channels: %w", err)
+	}
+	return channels, nil
+}
+
+// AddChannelMembers adds the FIDs provided to the members of the channel with
+// the given parent url. The FIDs that are already members are ignored. The
+// members are stored by parent url, so they can be added before the channel
+// metadata is known.
+func (ms *MongoStorage) AddChannelMembers(url string, fids []uint64) error {
+	if len(fids) == 0 {
+		return nil
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(fids))
+	for _, fid := range fids {
+		filter := bson.M{"url": url, "fid": fid}
+		update := bson.M{
+			"$set":         bson.M{"updatedAt": now},
+			"$setOnInsert": bson.M{"url": url, "fid": fid},
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	opts := options.BulkWrite().SetOrdered(false)
+	if _, err := ms.channelMembers.BulkWrite(ctx, models, opts); err != nil {
+		return fmt.Errorf("cannot add channel members: %w", err)
+	}
+	return nil
+}
+
+// ChannelMembers returns the FIDs of the known members of the channel with
+// the given parent url.
+func (ms *MongoStorage) ChannelMembers(url string) ([]uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"fid": 1, "_id": 0})
+	cur, err := ms.channelMembers.Find(ctx, bson.M{"url": url}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving channel members: %w", err)
+	}
+	defer cur.Close(ctx)
+	fids := []uint64{}
+	for cur.Next(ctx) {
+		var member struct {
+			FID uint64 `bson:"fid"`
+		}
+		if err := cur.Decode(&member); err != nil {
+			return nil, fmt.Errorf("error decoding channel member: %w", err)
+		}
+		fids = append(fids, member.FID)
+	}
+	return fids, cur.Err()
+}
+
+// CountChannelMembers returns the number of known members of the channel with
+// the given parent url.
+func (ms *MongoStorage) CountChannelMembers(url string) (int64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return ms.channelMembers.CountDocuments(ctx, bson.M{"url": url})
+}
@@ -241,3 +241,18 @@ func (ms *MongoStorage) SetCommunityNotifications(communityID uint64, enabled bo
 	_, err := ms.communities.UpdateOne(ctx, bson.M{"_id": communityID}, bson.M{"$set": bson.M{"notifications": enabled}})
 	return err
 }
+
+// SetCommunityTheme sets the theme of the community with the given ID. If the
+// theme is nil, the current theme is removed.
+func (ms *MongoStorage) SetCommunityTheme(communityID uint64, theme *CommunityTheme) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{"$set": bson.M{"theme": theme}}
+	if theme == nil {
+		update = bson.M{"$unset": bson.M{"theme": ""}}
+	}
+	_, err := ms.communities.UpdateOne(ctx, bson.M{"_id": communityID}, update)
+	return err
+}
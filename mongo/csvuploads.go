@@ -0,0 +1,203 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateCSVUpload creates a new empty CSV upload with the given ID, owned by
+// the user with the given FID. The upload and its chunks are removed by the
+// TTL index if they are not updated before the TTL provided.
+func (ms *MongoStorage) CreateCSVUpload(id string, owner uint64, ttl time.Duration) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upload := bson.M{
+		"_id":       id,
+		"owner":     owner,
+		"size":      int64(0),
+		"chunks":    0,
+		"expiresAt": time.Now().Add(ttl),
+	}
+	if _, err := ms.csvUploads.InsertOne(ctx, upload); err != nil {
+		return fmt.Errorf("cannot create csv upload: %w", err)
+	}
+	return nil
+}
+
+// AppendCSVUploadChunk appends the chunk provided to the CSV upload with the
+// given ID and owner, and extends the TTL of the upload. The size of the
+// upload is updated atomically, so the chunks sent concurrently keep the order
+// in which they are registered. If the chunk cannot be stored, the size of the
+// upload is restored, and any chunk left out of sequence is detected when the
+// upload is read. It returns the size of the upload, or ErrCSVUploadUnknown if
+// the upload does not exist and ErrCSVUploadTooLarge if the upload would
+// exceed the maximum size provided.
+func (ms *MongoStorage) AppendCSVUploadChunk(id string, owner uint64, chunk []byte,
+	maxSize int64, ttl time.Duration,
+) (int64, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expiresAt := time.Now().Add(ttl)
+	filter := bson.M{"_id": id, "owner": owner, "size": bson.M{"$lte": maxSize - int64(len(chunk))}}
+	update := bson.M{
+		"$inc": bson.M{"size": int64(len(chunk)), "chunks": 1},
+		"$set": bson.M{"expiresAt": expiresAt},
+	}
+	var upload struct {
+		Size   int64 `bson:"size"`
+		Chunks int   `bson:"chunks"`
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := ms.csvUploads.FindOneAndUpdate(ctx, filter, update, opts).Decode(&upload); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, fmt.Errorf("cannot update csv upload: %w", err)
+		}
+		count, err := ms.csvUploads.CountDocuments(ctx, bson.M{"_id": id, "owner": owner})
+		if err != nil {
+			return 0, fmt.Errorf("cannot check csv upload: %w", err)
+		}
+		if count == 0 {
+			return 0, ErrCSVUploadUnknown
+		}
+		return 0, ErrCSVUploadTooLarge
+	}
+	chunkDoc := bson.M{
+		"uploadId":  id,
+		"index":     upload.Chunks - 1,
+		"data":      chunk,
+		"expiresAt": expiresAt,
+	}
+	if _, err := ms.csvUploadChunks.InsertOne(ctx, chunkDoc); err != nil {
+		// restore the counters with a new context, since the chunk could have
+		// failed because of the timeout of the current one
+		rollbackCtx, rollbackCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer rollbackCancel()
+		if _, rbErr := ms.csvUploads.UpdateOne(rollbackCtx, bson.M{"_id": id},
+			bson.M{"$inc": bson.M{"size": -int64(len(chunk)), "chunks": -1}}); rbErr != nil {
+			return 0, fmt.Errorf("cannot store csv upload chunk: %w (cannot restore upload: %v)", err, rbErr)
+		}
+		return 0, fmt.Errorf("cannot store csv upload chunk: %w", err)
+	}
+	// extend the TTL of the previous chunks too
+	if _, err := ms.csvUploadChunks.UpdateMany(ctx, bson.M{"uploadId": id},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt}}); err != nil {
+		return 0, fmt.Errorf("cannot update csv upload chunks: %w", err)
+	}
+	return upload.Size, nil
+}
+
+// TakeCSVUpload removes the CSV upload with the given ID and owner, so no more
+// chunks can be appended, and returns a reader of its content that reads the
+// chunks one by one, in order. The chunks are removed when the reader is
+// closed. The reader fails with ErrCSVUploadCorrupt if the chunks are not
+// contiguous or their size does not match the size of the upload. It returns
+// ErrCSVUploadUnknown if the upload does not exist.
+func (ms *MongoStorage) TakeCSVUpload(id string, owner uint64) (io.ReadCloser, int64, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var upload struct {
+		Size   int64 `bson:"size"`
+		Chunks int   `bson:"chunks"`
+	}
+	if err := ms.csvUploads.FindOneAndDelete(ctx, bson.M{"_id": id, "owner": owner}).Decode(&upload); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, 0, ErrCSVUploadUnknown
+		}
+		return nil, 0, fmt.Errorf("cannot get csv upload: %w", err)
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: 1}}).SetBatchSize(1)
+	cursor, err := ms.csvUploadChunks.Find(ctx, bson.M{"uploadId": id}, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot get csv upload chunks: %w", err)
+	}
+	return &csvUploadReader{
+		ms:     ms,
+		id:     id,
+		cursor: cursor,
+		size:   upload.Size,
+		chunks: upload.Chunks,
+	}, upload.Size, nil
+}
+
+// csvUploadReader reads the chunks of a CSV upload from the database, keeping
+// only the current chunk in memory. It checks that the chunks read are the
+// ones registered in the upload, in sequence, and sum its size.
+type csvUploadReader struct {
+	ms     *MongoStorage
+	id     string
+	cursor *mongo.Cursor
+	chunk  []byte
+	// size and chunks are the expected size and number of chunks of the upload
+	size   int64
+	chunks int
+	// read and next are the bytes read and the index of the next chunk
+	read int64
+	next int
+}
+
+// Read implements io.Reader.
+func (r *csvUploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		next := r.cursor.Next(ctx)
+		cancel()
+		if !next {
+			if err := r.cursor.Err(); err != nil {
+				return 0, fmt.Errorf("cannot read csv upload chunk: %w", err)
+			}
+			if r.next != r.chunks || r.read != r.size {
+				return 0, fmt.Errorf("%w: read %d chunks and %d bytes, expected %d chunks and %d bytes",
+					ErrCSVUploadCorrupt, r.next, r.read, r.chunks, r.size)
+			}
+			return 0, io.EOF
+		}
+		var chunk struct {
+			Index int    `bson:"index"`
+			Data  []byte `bson:"data"`
+		}
+		if err := r.cursor.Decode(&chunk); err != nil {
+			return 0, fmt.Errorf("cannot decode csv upload chunk: %w", err)
+		}
+		if chunk.Index != r.next {
+			return 0, fmt.Errorf("%w: expected chunk %d, got %d", ErrCSVUploadCorrupt, r.next, chunk.Index)
+		}
+		r.next++
+		r.read += int64(len(chunk.Data))
+		if r.read > r.size {
+			return 0, fmt.Errorf("%w: read more than %d bytes", ErrCSVUploadCorrupt, r.size)
+		}
+		r.chunk = chunk.Data
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// Close closes the cursor of the chunks and removes them.
+func (r *csvUploadReader) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := r.cursor.Close(ctx)
+	r.ms.keysLock.Lock()
+	defer r.ms.keysLock.Unlock()
+	if _, rmErr := r.ms.csvUploadChunks.DeleteMany(ctx, bson.M{"uploadId": r.id}); rmErr != nil {
+		return fmt.Errorf("cannot remove csv upload chunks: %w", rmErr)
+	}
+	return err
+}
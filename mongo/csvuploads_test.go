@@ -0,0 +1,56 @@
+package mongo
+
+import (
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testCSVUploadReader returns a reader of the chunks provided, as stored in
+// the database, for an upload of the given size and number of chunks.
+func testCSVUploadReader(c *qt.C, size int64, chunks int, docs ...bson.M) *csvUploadReader {
+	documents := []any{}
+	for _, doc := range docs {
+		documents = append(documents, doc)
+	}
+	cursor, err := mongo.NewCursorFromDocuments(documents, nil, nil)
+	c.Assert(err, qt.IsNil)
+	return &csvUploadReader{cursor: cursor, size: size, chunks: chunks}
+}
+
+func TestCSVUploadReader(t *testing.T) {
+	c := qt.New(t)
+	chunk := func(index int, data string) bson.M {
+		return bson.M{"index": index, "data": []byte(data)}
+	}
+	tests := []struct {
+		name    string
+		size    int64
+		chunks  int
+		docs    []bson.M
+		want    string
+		corrupt bool
+	}{
+		{name: "complete", size: 8, chunks: 2, docs: []bson.M{chunk(0, "a,1\n"), chunk(1, "b,2\n")}, want: "a,1\nb,2\n"},
+		{name: "empty", size: 0, chunks: 0, want: ""},
+		{name: "missing last chunk", size: 8, chunks: 2, docs: []bson.M{chunk(0, "a,1\n")}, corrupt: true},
+		{name: "missing middle chunk", size: 8, chunks: 2, docs: []bson.M{chunk(0, "a,1\n"), chunk(2, "b,2\n")}, corrupt: true},
+		{name: "duplicated chunk", size: 8, chunks: 2, docs: []bson.M{chunk(0, "a,1\n"), chunk(0, "a,1\n")}, corrupt: true},
+		{name: "extra chunk", size: 4, chunks: 1, docs: []bson.M{chunk(0, "a,1\n"), chunk(1, "b,2\n")}, corrupt: true},
+		{name: "size mismatch", size: 10, chunks: 2, docs: []bson.M{chunk(0, "a,1\n"), chunk(1, "b,2\n")}, corrupt: true},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			data, err := io.ReadAll(testCSVUploadReader(c, test.size, test.chunks, test.docs...))
+			if test.corrupt {
+				c.Assert(err, qt.ErrorIs, ErrCSVUploadCorrupt)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(data), qt.Equals, test.want)
+		})
+	}
+}
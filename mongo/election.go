@@ -199,3 +199,25 @@ func (ms *MongoStorage) updateElection(election *Election) error {
 	}
 	return nil
 }
+
+// SetElectionEndTime updates the end time of the election with the given ID,
+// i.e. when the election is closed before its end date.
+func (ms *MongoStorage) SetElectionEndTime(electionID types.HexBytes, endTime time.Time) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := ms.elections.UpdateOne(ctx, bson.M{"_id": electionID.String()}, bson.M{"$set": bson.M{"endTime": endTime}})
+	return err
+}
+
+// SetElectionResultsImage sets the results image settings of the election
+// with the given ID.
+func (ms *MongoStorage) SetElectionResultsImage(electionID types.HexBytes, settings *ResultsImageSettings) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := ms.elections.UpdateOne(ctx, bson.M{"_id": electionID.String()}, bson.M{"$set": bson.M{"resultsImage": settings}})
+	return err
+}
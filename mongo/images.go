@@ -0,0 +1,136 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// imagesTTL is the time that a generated image is kept in the GridFS
+	// images bucket before it is removed by DeleteExpiredImages. The images
+	// removed are generated again on demand.
+	imagesTTL = 90 * 24 * time.Hour
+	// imagesOrphanChunksAge is the age of the chunks of an image without file
+	// document after which the upload is considered interrupted, so the
+	// chunks can be removed and the image uploaded again.
+	imagesOrphanChunksAge = time.Minute
+	// imagesDeleteBatchSize is the maximum number of expired images removed
+	// by every call to DeleteExpiredImages.
+	imagesDeleteBatchSize = 1000
+)
+
+// Image returns the image data stored in the GridFS images bucket with the
+// given id. If the image does not exist, it returns ErrImageUnknown.
+func (ms *MongoStorage) Image(id string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := ms.images.DownloadToStream(id, buf); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrImageUnknown
+		}
+		return nil, fmt.Errorf("error retrieving image %s: %w", id, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SetImage stores the image data in the GridFS images bucket with the given
+// id, until the images TTL expires. The id of the image is the id of its file,
+// so the images, which are immutable for a given id, are only uploaded once,
+// even by different replicas at the same time: the chunks of the second upload
+// are rejected by the unique index of the chunks. If the chunks belong to an
+// upload interrupted a while ago, they are removed and the image is uploaded
+// again.
+func (ms *MongoStorage) SetImage(id string, data []byte) error {
+	err := ms.uploadImage(id, data)
+	if err == nil || !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	orphan, err := ms.orphanImageChunks(id)
+	if err != nil || !orphan {
+		// the image has been uploaded already, or it is being uploaded
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := ms.images.GetChunksCollection().DeleteMany(ctx, bson.M{"files_id": id}); err != nil {
+		return fmt.Errorf("cannot remove chunks of image %s: %w", id, err)
+	}
+	if err := ms.uploadImage(id, data); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// uploadImage uploads the image data to the GridFS images bucket as the file
+// with the given id, with its expiration time as metadata.
+func (ms *MongoStorage) uploadImage(id string, data []byte) error {
+	opts := options.GridFSUpload().SetMetadata(bson.M{"expiresAt": time.Now().Add(imagesTTL)})
+	if err := ms.images.UploadFromStreamWithID(id, id, bytes.NewReader(data), opts); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		return fmt.Errorf("cannot upload image %s: %w", id, err)
+	}
+	return nil
+}
+
+// orphanImageChunks returns true if the image with the given id has no file
+// document and its first chunk is older than imagesOrphanChunksAge, so its
+// upload was interrupted.
+func (ms *MongoStorage) orphanImageChunks(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := ms.images.GetFilesCollection().CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, fmt.Errorf("cannot check image %s: %w", id, err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+	var chunk struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	if err := ms.images.GetChunksCollection().FindOne(ctx, bson.M{"files_id": id, "n": 0}, opts).Decode(&chunk); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot check chunks of image %s: %w", id, err)
+	}
+	return time.Since(chunk.ID.Timestamp()) > imagesOrphanChunksAge, nil
+}
+
+// DeleteExpiredImages removes up to imagesDeleteBatchSize images whose TTL
+// has expired from the GridFS images bucket, with their chunks. It returns
+// the number of images removed.
+func (ms *MongoStorage) DeleteExpiredImages() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(imagesDeleteBatchSize)
+	cursor, err := ms.images.GetFilesCollection().Find(ctx, bson.M{"metadata.expiresAt": bson.M{"$lt": time.Now()}}, opts)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get expired images: %w", err)
+	}
+	var files []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return 0, fmt.Errorf("cannot get expired images: %w", err)
+	}
+	removed := int64(0)
+	for _, file := range files {
+		if err := ms.images.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return removed, fmt.Errorf("cannot remove image %s: %w", file.ID, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.vocdoni.io/dvote/api"
@@ -39,6 +40,20 @@ type MongoStorage struct {
 	userAccessProfiles *mongo.Collection
 	communities        *mongo.Collection
 	avatars            *mongo.Collection
+	tallySnapshots     *mongo.Collection
+	tokenHolderScans   *mongo.Collection
+	tokenBalances      *mongo.Collection
+	censusJobs         *mongo.Collection
+	channels           *mongo.Collection
+	channelMembers     *mongo.Collection
+	apiCache           *mongo.Collection
+	botState           *mongo.Collection
+	streamCursors      *mongo.Collection
+	botCasts           *mongo.Collection
+	botPolls           *mongo.Collection
+	images             *gridfs.Bucket
+	csvUploads         *mongo.Collection
+	csvUploadChunks    *mongo.Collection
 }
 
 type Options struct {
@@ -107,6 +122,23 @@ func New(url, database string) (*MongoStorage, error) {
 	ms.userAccessProfiles = client.Database(database).Collection("userAccessProfiles")
 	ms.communities = client.Database(database).Collection("communities")
 	ms.avatars = client.Database(database).Collection("avatars")
+	ms.tallySnapshots = client.Database(database).Collection("tallySnapshots")
+	ms.tokenHolderScans = client.Database(database).Collection("tokenHolderScans")
+	ms.tokenBalances = client.Database(database).Collection("tokenBalances")
+	ms.censusJobs = client.Database(database).Collection("censusJobs")
+	ms.channels = client.Database(database).Collection("channels")
+	ms.channelMembers = client.Database(database).Collection("channelMembers")
+	ms.apiCache = client.Database(database).Collection("apiCache")
+	ms.botState = client.Database(database).Collection("botState")
+	ms.streamCursors = client.Database(database).Collection("streamCursors")
+	ms.botCasts = client.Database(database).Collection("botCasts")
+	ms.botPolls = client.Database(database).Collection("botPolls")
+	ms.images, err = gridfs.NewBucket(client.Database(database), options.GridFSBucket().SetName("images"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create images bucket: %w", err)
+	}
+	ms.csvUploads = client.Database(database).Collection("csvUploads")
+	ms.csvUploadChunks = client.Database(database).Collection("csvUploadChunks")
 
 	// If reset flag is enabled, Reset drops the database documents and recreates indexes
 	// else, just createIndexes
@@ -158,6 +190,15 @@ func (ms *MongoStorage) createIndexes() error {
 		return err
 	}
 
+	// Create an index model for the 'signersSyncedAt' field on users (signer sync)
+	userSignersSyncedIndexModel := mongo.IndexModel{
+		Keys:    bson.M{"signersSyncedAt": 1},
+		Options: options.Index().SetName("signersSyncedAtIndex"),
+	}
+	if _, err := ms.users.Indexes().CreateOne(ctx, userSignersSyncedIndexModel); err != nil {
+		return err
+	}
+
 	// Create index for authentication collection
 	authIndexModel := mongo.IndexModel{
 		Keys: bson.M{"authTokens": 1},
@@ -275,6 +316,126 @@ func (ms *MongoStorage) createIndexes() error {
 		return fmt.Errorf("failed to create index on community ids for avatars: %w", err)
 	}
 
+	// Create an index for the 'electionId' and 'timestamp' fields on tally
+	// snapshots to support the results timeline queries
+	tallySnapshotsIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "electionId", Value: 1},
+			{Key: "timestamp", Value: 1},
+		},
+	}
+	if _, err := ms.tallySnapshots.Indexes().CreateOne(ctx, tallySnapshotsIndex); err != nil {
+		return fmt.Errorf("failed to create index on election ids for tally snapshots: %w", err)
+	}
+
+	// Create an index for the 'scanId' field on token balances to get the
+	// holders of a token
+	tokenBalancesIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "scanId", Value: 1}},
+	}
+	if _, err := ms.tokenBalances.Indexes().CreateOne(ctx, tokenBalancesIndex); err != nil {
+		return fmt.Errorf("failed to create index on scan ids for token balances: %w", err)
+	}
+
+	// Create an index for the 'state' and 'finishedAt' fields on census jobs
+	// to find the interrupted jobs and the old finished ones
+	censusJobsIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "finishedAt", Value: 1}},
+	}
+	if _, err := ms.censusJobs.Indexes().CreateOne(ctx, censusJobsIndex); err != nil {
+		return fmt.Errorf("failed to create index on state for census jobs: %w", err)
+	}
+
+	// Create an index for the 'url' field on channels to find the channel of
+	// a cast parent url
+	channelsURLIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "url", Value: 1}},
+	}
+	if _, err := ms.channels.Indexes().CreateOne(ctx, channelsURLIndex); err != nil {
+		return fmt.Errorf("failed to create index on url for channels: %w", err)
+	}
+
+	// Create a unique index for the 'url' and 'fid' fields on channel members
+	// to list the members of a channel without duplicates
+	channelMembersIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "url", Value: 1}, {Key: "fid", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := ms.channelMembers.Indexes().CreateOne(ctx, channelMembersIndex); err != nil {
+		return fmt.Errorf("failed to create index on urls for channel members: %w", err)
+	}
+
+	// Create a TTL index for the 'expiresAt' field on the api cache to remove
+	// the expired values
+	apiCacheIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := ms.apiCache.Indexes().CreateOne(ctx, apiCacheIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for api cache: %w", err)
+	}
+
+	// Create a TTL index for the 'expiresAt' field on the bot casts to forget
+	// the old processed casts
+	botCastsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := ms.botCasts.Indexes().CreateOne(ctx, botCastsIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for bot casts: %w", err)
+	}
+
+	// Create a TTL index for the 'expiresAt' field on the pending bot polls
+	// to forget the polls that could not be resumed
+	botPollsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := ms.botPolls.Indexes().CreateOne(ctx, botPollsIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for bot polls: %w", err)
+	}
+
+	// Create an index for the expiration time of the images, which are
+	// removed with their chunks by DeleteExpiredImages, since a TTL index
+	// would only remove the files of the GridFS bucket
+	imagesIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "metadata.expiresAt", Value: 1}},
+	}
+	if _, err := ms.images.GetFilesCollection().Indexes().CreateOne(ctx, imagesIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for images: %w", err)
+	}
+	// The images are uploaded once by id thanks to the unique index of the
+	// chunks, which GridFS only creates on the first upload to the bucket
+	imageChunksIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "files_id", Value: 1}, {Key: "n", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := ms.images.GetChunksCollection().Indexes().CreateOne(ctx, imageChunksIndex); err != nil {
+		return fmt.Errorf("failed to create index on image chunks: %w", err)
+	}
+
+	// Create TTL indexes for the 'expiresAt' field on the csv uploads and their
+	// chunks to remove the abandoned uploads
+	csvUploadsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := ms.csvUploads.Indexes().CreateOne(ctx, csvUploadsIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for csv uploads: %w", err)
+	}
+	if _, err := ms.csvUploadChunks.Indexes().CreateOne(ctx, csvUploadsIndex); err != nil {
+		return fmt.Errorf("failed to create index on expiration for csv upload chunks: %w", err)
+	}
+
+	// Create an index on the upload ID and the index of the csv upload chunks
+	// to read them in order
+	csvUploadChunksIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "uploadId", Value: 1}, {Key: "index", Value: 1}},
+	}
+	if _, err := ms.csvUploadChunks.Indexes().CreateOne(ctx, csvUploadChunksIndex); err != nil {
+		return fmt.Errorf("failed to create index on csv upload chunks: %w", err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,46 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StreamCursor returns the stored cursor of the stream with the given name,
+// i.e. the hub events stream of the channels index. It returns 0 if the
+// stream has no cursor stored.
+func (ms *MongoStorage) StreamCursor(name string) (uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var state struct {
+		Cursor uint64 `bson:"cursor"`
+	}
+	if err := ms.streamCursors.FindOne(ctx, bson.M{"_id": name}).Decode(&state); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error retrieving stream cursor: %w", err)
+	}
+	return state.Cursor, nil
+}
+
+// SetStreamCursor stores the cursor of the stream with the given name.
+func (ms *MongoStorage) SetStreamCursor(name string, cursor uint64) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"cursor": cursor, "updatedAt": time.Now()}}
+	if _, err := ms.streamCursors.UpdateOne(ctx, bson.M{"_id": name}, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("cannot update stream cursor: %w", err)
+	}
+	return nil
+}
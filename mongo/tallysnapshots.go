@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.vocdoni.io/dvote/types"
+)
+
+// AddTallySnapshot stores a new snapshot of the results of an election. If
+// the timestamp of the snapshot is not set, the current time is used.
+func (ms *MongoStorage) AddTallySnapshot(snapshot *TallySnapshot) error {
+	if snapshot == nil || snapshot.ElectionID == "" {
+		return fmt.Errorf("invalid tally snapshot")
+	}
+	if snapshot.Timestamp.IsZero() {
+		snapshot.Timestamp = time.Now()
+	}
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ms.tallySnapshots.InsertOne(ctx, snapshot); err != nil {
+		return fmt.Errorf("cannot insert tally snapshot: %w", err)
+	}
+	return nil
+}
+
+// LastTallySnapshot returns the most recent snapshot of the results of an
+// election. If the election has no snapshots, it returns ErrNoTallySnapshots.
+func (ms *MongoStorage) LastTallySnapshot(electionID types.HexBytes) (*TallySnapshot, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	var snapshot TallySnapshot
+	if err := ms.tallySnapshots.FindOne(ctx, bson.M{"electionId": electionID.String()}, opts).Decode(&snapshot); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoTallySnapshots
+		}
+		return nil, fmt.Errorf("error retrieving last tally snapshot for electionID %s: %w", electionID.String(), err)
+	}
+	return &snapshot, nil
+}
+
+// TallySnapshots returns the snapshots of the results of an election sorted
+// by timestamp in ascending order.
+func (ms *MongoStorage) TallySnapshots(electionID types.HexBytes) ([]TallySnapshot, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	cursor, err := ms.tallySnapshots.Find(ctx, bson.M{"electionId": electionID.String()}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tally snapshots: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []TallySnapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to decode tally snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// ClaimTallySnapshotElections claims up to maxResults elections that have not
+// ended yet and whose tally snapshot is due, and returns their IDs. The
+// elections claimed are not returned again, by this or any other instance,
+// until the given interval elapses, so the active elections are snapshotted
+// once per interval no matter how many instances record the snapshots. The
+// elections claimed longer ago are returned first.
+func (ms *MongoStorage) ClaimTallySnapshotElections(maxResults int, interval time.Duration) ([]string, error) {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"endTime": bson.M{"$gt": now},
+		"$or": []bson.M{
+			{"tallySnapshotDueAt": bson.M{"$exists": false}},
+			{"tallySnapshotDueAt": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"tallySnapshotDueAt": now.Add(interval)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"tallySnapshotDueAt": 1}).
+		SetProjection(bson.M{"_id": 1})
+	electionIDs := []string{}
+	for len(electionIDs) < maxResults {
+		var election struct {
+			ElectionID string `bson:"_id"`
+		}
+		if err := ms.elections.FindOneAndUpdate(ctx, filter, update, opts).Decode(&election); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			return electionIDs, fmt.Errorf("cannot claim tally snapshot elections: %w", err)
+		}
+		electionIDs = append(electionIDs, election.ElectionID)
+	}
+	return electionIDs, nil
+}
@@ -0,0 +1,196 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tokenBalancesBatchSize is the maximum number of token balances written in
+// a single bulk operation.
+const tokenBalancesBatchSize = 1000
+
+// TokenHolderScanID returns the identifier of the scan of the token with the
+// given address in the given chain.
+func TokenHolderScanID(chainID uint64, address string) string {
+	return fmt.Sprintf("%d:%s", chainID, strings.ToLower(address))
+}
+
+// TokenHolderScan returns the checkpoint of the scan of the token with the
+// given address in the given chain. If the token has not been scanned yet, it
+// returns ErrTokenScanUnknown.
+func (ms *MongoStorage) TokenHolderScan(chainID uint64, address string) (*TokenHolderScan, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var scan TokenHolderScan
+	if err := ms.tokenHolderScans.FindOne(ctx, bson.M{"_id": TokenHolderScanID(chainID, address)}).Decode(&scan); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTokenScanUnknown
+		}
+		return nil, fmt.Errorf("error retrieving token scan: %w", err)
+	}
+	return &scan, nil
+}
+
+// TokenHolderBalances returns the balances of the holders of the token with
+// the given address in the given chain, indexed by holder address, without
+// the empty ones. It also returns the holders whose balance was updated after
+// the last block provided, which only happens if the last update of the scan
+// was interrupted.
+func (ms *MongoStorage) TokenHolderBalances(chainID uint64, address string, lastBlock uint64) (
+	map[string]*big.Int, map[string]bool, error,
+) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cursor, err := ms.tokenBalances.Find(ctx, bson.M{"scanId": TokenHolderScanID(chainID, address)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find token balances: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	balances := make(map[string]*big.Int)
+	updated := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var balance TokenHolderBalance
+		if err := cursor.Decode(&balance); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode token balance: %w", err)
+		}
+		value, ok := new(big.Int).SetString(balance.Balance, 10)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid balance %s for holder %s", balance.Balance, balance.Holder)
+		}
+		if balance.Block > lastBlock {
+			updated[balance.Holder] = true
+		}
+		if value.Sign() > 0 {
+			balances[balance.Holder] = value
+		}
+	}
+	return balances, updated, cursor.Err()
+}
+
+// UpdateTokenHolderScan stores the balances of the holders that changed since
+// the previous checkpoint and the checkpoint of the scan provided. Before
+// updating the balances, the last block of the scan is stored as pending, and
+// it is cleared once the balances are stored, so an interrupted update can be
+// detected and resumed. Every balance is stored with the block of the update,
+// so the ones already updated are known, and the holders with a zero balance
+// are removed once the update is completed.
+func (ms *MongoStorage) UpdateTokenHolderScan(scan *TokenHolderScan, changed map[string]*big.Int) error {
+	if scan == nil {
+		return fmt.Errorf("invalid token scan")
+	}
+	scan.ID = TokenHolderScanID(scan.ChainID, scan.Address)
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+
+	pending := bson.M{"$set": bson.M{
+		"chainId":      scan.ChainID,
+		"address":      scan.Address,
+		"startBlock":   scan.StartBlock,
+		"pendingBlock": scan.LastBlock,
+		"updatedAt":    time.Now(),
+	}}
+	pendingCtx, pendingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer pendingCancel()
+	if _, err := ms.tokenHolderScans.UpdateOne(pendingCtx, bson.M{"_id": scan.ID}, pending, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("cannot update token scan: %w", err)
+	}
+
+	models := []mongo.WriteModel{}
+	for holder, balance := range changed {
+		id := fmt.Sprintf("%s:%s", scan.ID, holder)
+		if balance.Sign() < 0 {
+			balance = new(big.Int)
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetReplacement(&TokenHolderBalance{
+				ID:      id,
+				ScanID:  scan.ID,
+				Holder:  holder,
+				Balance: balance.String(),
+				Block:   scan.LastBlock,
+			}).
+			SetUpsert(true))
+	}
+	for i := 0; i < len(models); i += tokenBalancesBatchSize {
+		to := i + tokenBalancesBatchSize
+		if to > len(models) {
+			to = len(models)
+		}
+		bulkCtx, bulkCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := ms.tokenBalances.BulkWrite(bulkCtx, models[i:to], options.BulkWrite().SetOrdered(false))
+		bulkCancel()
+		if err != nil {
+			return fmt.Errorf("cannot update token balances: %w", err)
+		}
+	}
+
+	scan.PendingBlock = 0
+	scan.UpdatedAt = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ms.tokenHolderScans.ReplaceOne(ctx, bson.M{"_id": scan.ID}, scan); err != nil {
+		return fmt.Errorf("cannot update token scan: %w", err)
+	}
+	if _, err := ms.tokenBalances.DeleteMany(ctx, bson.M{"scanId": scan.ID, "balance": "0"}); err != nil {
+		return fmt.Errorf("cannot delete empty token balances: %w", err)
+	}
+	return nil
+}
+
+// SetTokenHolderScanExceeded removes the balances of the scan provided and
+// stores its checkpoint with the maximum number of holders exceeded, so the
+// token is not scanned again unless the maximum is increased.
+func (ms *MongoStorage) SetTokenHolderScanExceeded(scan *TokenHolderScan, maxHolders uint32) error {
+	if scan == nil {
+		return fmt.Errorf("invalid token scan")
+	}
+	if err := ms.DeleteTokenHolderScan(scan.ChainID, scan.Address); err != nil {
+		return err
+	}
+	scan.ID = TokenHolderScanID(scan.ChainID, scan.Address)
+	scan.PendingBlock = 0
+	scan.HoldersExceeded = maxHolders
+	scan.UpdatedAt = time.Now()
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ms.tokenHolderScans.ReplaceOne(ctx, bson.M{"_id": scan.ID}, scan, options.Replace().SetUpsert(true)); err != nil {
+		return fmt.Errorf("cannot update token scan: %w", err)
+	}
+	return nil
+}
+
+// DeleteTokenHolderScan removes the checkpoint and the balances of the scan
+// of the token with the given address in the given chain, so it can be
+// scanned again from the beginning.
+func (ms *MongoStorage) DeleteTokenHolderScan(chainID uint64, address string) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	scanID := TokenHolderScanID(chainID, address)
+	if _, err := ms.tokenHolderScans.DeleteOne(ctx, bson.M{"_id": scanID}); err != nil {
+		return fmt.Errorf("cannot delete token scan: %w", err)
+	}
+	if _, err := ms.tokenBalances.DeleteMany(ctx, bson.M{"scanId": scanID}); err != nil {
+		return fmt.Errorf("cannot delete token balances: %w", err)
+	}
+	return nil
+}
@@ -13,9 +13,21 @@ import (
 )
 
 var (
-	ErrUserUnknown     = fmt.Errorf("user unknown")
-	ErrAvatarUnknown   = fmt.Errorf("avatar unknown")
-	ErrElectionUnknown = fmt.Errorf("electionID unknown")
+	ErrUserUnknown      = fmt.Errorf("user unknown")
+	ErrAvatarUnknown    = fmt.Errorf("avatar unknown")
+	ErrElectionUnknown  = fmt.Errorf("electionID unknown")
+	ErrImageUnknown     = fmt.Errorf("image unknown")
+	ErrTokenScanUnknown = fmt.Errorf("token scan unknown")
+	ErrCensusJobUnknown = fmt.Errorf("census job unknown")
+	ErrCensusJobDone    = fmt.Errorf("census job already finished")
+	ErrNoTallySnapshots = fmt.Errorf("no tally snapshots")
+	ErrCensusUnknown    = fmt.Errorf("census unknown")
+	ErrChannelUnknown   = fmt.Errorf("channel unknown")
+	ErrCacheMiss        = fmt.Errorf("cache miss")
+
+	ErrCSVUploadUnknown  = fmt.Errorf("csv upload unknown")
+	ErrCSVUploadTooLarge = fmt.Errorf("csv upload too large")
+	ErrCSVUploadCorrupt  = fmt.Errorf("csv upload corrupt")
 )
 
 // Users is the list of users.
@@ -25,17 +37,49 @@ type Users struct {
 
 // User represents a farcaster user.
 type User struct {
-	UserID         uint64    `json:"userID,omitempty" bson:"_id"`
-	ElectionCount  uint64    `json:"electionCount" bson:"electionCount"`
-	CastedVotes    uint64    `json:"castedVotes" bson:"castedVotes"`
-	Username       string    `json:"username" bson:"username"`
-	Displayname    string    `json:"displayname" bson:"displayname"`
-	CustodyAddress string    `json:"custodyAddress" bson:"custodyAddress"`
-	Addresses      []string  `json:"addresses" bson:"addresses"`
-	Signers        []string  `json:"signers" bson:"signers"`
-	Followers      uint64    `json:"followers" bson:"followers"`
-	LastUpdated    time.Time `json:"lastUpdated" bson:"lastUpdated"`
-	Avatar         string    `json:"avatar" bson:"avatar"`
+	UserID          uint64    `json:"userID,omitempty" bson:"_id"`
+	ElectionCount   uint64    `json:"electionCount" bson:"electionCount"`
+	CastedVotes     uint64    `json:"castedVotes" bson:"castedVotes"`
+	Username        string    `json:"username" bson:"username"`
+	Displayname     string    `json:"displayname" bson:"displayname"`
+	CustodyAddress  string    `json:"custodyAddress" bson:"custodyAddress"`
+	Addresses       []string  `json:"addresses" bson:"addresses"`
+	Signers         []string  `json:"signers" bson:"signers"`
+	RevokedSigners  []string  `json:"revokedSigners,omitempty" bson:"revokedSigners,omitempty"`
+	Followers       uint64    `json:"followers" bson:"followers"`
+	LastUpdated     time.Time `json:"lastUpdated" bson:"lastUpdated"`
+	SignersSyncedAt time.Time `json:"signersSyncedAt,omitempty" bson:"signersSyncedAt,omitempty"`
+	SignersRetryAt  time.Time `json:"signersRetryAt,omitempty" bson:"signersRetryAt,omitempty"`
+	Avatar          string    `json:"avatar" bson:"avatar"`
+	// RegisteredAt is the time when the FID was registered in the Farcaster
+	// IdRegistry, it is zero until the registration of the user is synced.
+	RegisteredAt        time.Time `json:"registeredAt,omitempty" bson:"registeredAt,omitempty"`
+	RegistrationRetryAt time.Time `json:"-" bson:"registrationRetryAt,omitempty"`
+}
+
+// ActiveSigners returns the signers of the user that have not been revoked.
+// Signers are compared in lowercase and without the 0x prefix.
+func (u *User) ActiveSigners() []string {
+	if len(u.RevokedSigners) == 0 {
+		return u.Signers
+	}
+	revoked := make(map[string]bool, len(u.RevokedSigners))
+	for _, signer := range u.RevokedSigners {
+		revoked[NormalizeSigner(signer)] = true
+	}
+	signers := []string{}
+	for _, signer := range u.Signers {
+		if !revoked[NormalizeSigner(signer)] {
+			signers = append(signers, signer)
+		}
+	}
+	return signers
+}
+
+// NormalizeSigner returns the signer provided in lowercase and without the 0x
+// prefix, the format used to store the signers synced from the KeyRegistry.
+func NormalizeSigner(signer string) string {
+	return strings.ToLower(strings.TrimPrefix(signer, "0x"))
 }
 
 // UserAccessProfile holds the user's access profile data, used by our backend to determine the user's access level.
@@ -59,30 +103,60 @@ type ElectionCommunity struct {
 
 // Election represents an election and its details owned by a user.
 type Election struct {
-	ElectionID            string             `json:"electionId" bson:"_id"`
-	UserID                uint64             `json:"userId" bson:"userId"`
-	CastedVotes           uint64             `json:"castedVotes" bson:"castedVotes"`
-	LastVoteTime          time.Time          `json:"lastVoteTime" bson:"lastVoteTime"`
-	CreatedTime           time.Time          `json:"createdTime" bson:"createdTime"`
-	EndTime               time.Time          `json:"endTime" bson:"endTime"`
-	Source                string             `json:"source" bson:"source"`
-	FarcasterUserCount    uint32             `json:"farcasterUserCount" bson:"farcasterUserCount"`
-	InitialAddressesCount uint32             `json:"initialAddressesCount" bson:"initialAddressesCount"`
-	Question              string             `json:"question" bson:"question"`
-	Community             *ElectionCommunity `json:"community" bson:"community"`
-	CastedWeight          string             `json:"castedWeight" bson:"castedWeight"`
+	ElectionID            string                `json:"electionId" bson:"_id"`
+	UserID                uint64                `json:"userId" bson:"userId"`
+	CastedVotes           uint64                `json:"castedVotes" bson:"castedVotes"`
+	LastVoteTime          time.Time             `json:"lastVoteTime" bson:"lastVoteTime"`
+	CreatedTime           time.Time             `json:"createdTime" bson:"createdTime"`
+	EndTime               time.Time             `json:"endTime" bson:"endTime"`
+	Source                string                `json:"source" bson:"source"`
+	FarcasterUserCount    uint32                `json:"farcasterUserCount" bson:"farcasterUserCount"`
+	InitialAddressesCount uint32                `json:"initialAddressesCount" bson:"initialAddressesCount"`
+	Question              string                `json:"question" bson:"question"`
+	Community             *ElectionCommunity    `json:"community" bson:"community"`
+	CastedWeight          string                `json:"castedWeight" bson:"castedWeight"`
+	ResultsImage          *ResultsImageSettings `json:"resultsImage,omitempty" bson:"resultsImage,omitempty"`
+	ChoicesHeadcount      []uint64              `json:"choicesHeadcount,omitempty" bson:"choicesHeadcount,omitempty"`
+}
+
+// ResultsImageSettings defines how the results image of an election is
+// rendered: the visualization, the aspect ratio of the image and, for the
+// top-N visualization, the number of choices to show.
+type ResultsImageSettings struct {
+	Visualization string `json:"visualization,omitempty" bson:"visualization"`
+	AspectRatio   string `json:"aspectRatio,omitempty" bson:"aspectRatio"`
+	TopN          int    `json:"topN,omitempty" bson:"topN"`
 }
 
 // Census stores the census of an election ready to be used for voting on farcaster.
 type Census struct {
-	CensusID           string            `json:"censusId" bson:"_id"`
-	Root               string            `json:"root" bson:"root"`
-	ElectionID         string            `json:"electionId" bson:"electionId"`
-	Participants       map[string]string `json:"participants" bson:"participants"`
-	FromTotalAddresses uint32            `json:"fromTotalAddresses" bson:"fromTotalAddresses"`
-	CreatedBy          uint64            `json:"createdBy" bson:"createdBy"`
-	TotalWeight        string            `json:"totalWeight" bson:"totalWeight"`
-	URL                string            `json:"url" bson:"url"`
+	CensusID           string                `json:"censusId" bson:"_id"`
+	Root               string                `json:"root" bson:"root"`
+	ElectionID         string                `json:"electionId" bson:"electionId"`
+	Participants       map[string]string     `json:"participants" bson:"participants"`
+	ParticipantFIDs    map[string]string     `json:"-" bson:"participantFids,omitempty"`
+	FromTotalAddresses uint32                `json:"fromTotalAddresses" bson:"fromTotalAddresses"`
+	CreatedBy          uint64                `json:"createdBy" bson:"createdBy"`
+	TotalWeight        string                `json:"totalWeight" bson:"totalWeight"`
+	URL                string                `json:"url" bson:"url"`
+	Snapshot           *CensusSnapshot       `json:"snapshot,omitempty" bson:"snapshot,omitempty"`
+	WeightStrategy     *CensusWeightStrategy `json:"weightStrategy,omitempty" bson:"weightStrategy,omitempty"`
+	Name               string                `json:"name,omitempty" bson:"name,omitempty"`
+}
+
+// CensusWeightStrategy defines the transformation applied to the weights of
+// the participants of a census, and its cap if the strategy is the capped one.
+type CensusWeightStrategy struct {
+	Name string `json:"name" bson:"name"`
+	Cap  uint64 `json:"cap,omitempty" bson:"cap,omitempty"`
+}
+
+// CensusSnapshot defines the block of a blockchain at which the token balances
+// of a token based census were resolved, and its timestamp.
+type CensusSnapshot struct {
+	Blockchain string `json:"blockchain" bson:"blockchain"`
+	Block      uint64 `json:"block" bson:"block"`
+	Timestamp  int64  `json:"timestamp" bson:"timestamp"`
 }
 
 // ElectionMeta stores non related election information that is useful
@@ -102,6 +176,99 @@ type Results struct {
 	Finalized  bool     `json:"finalized" bson:"finalized"`
 }
 
+// TallySnapshot represents the state of the results of an election at a given
+// time. The snapshots of an election compose the timeline of its results.
+type TallySnapshot struct {
+	ElectionID   string    `json:"electionId" bson:"electionId"`
+	Timestamp    time.Time `json:"timestamp" bson:"timestamp"`
+	VoteCount    uint64    `json:"voteCount" bson:"voteCount"`
+	CastedWeight string    `json:"castedWeight" bson:"castedWeight"`
+	Votes        []string  `json:"votes" bson:"votes"`
+}
+
+// TokenHolderScan is the checkpoint of the scan of the transfer logs of a
+// token, used to resume the reconstruction of its holders balances. If the
+// pending block is set, the balances were being updated up to that block when
+// the scan was interrupted, so only the balances updated at the pending block
+// are consistent with it. If the token exceeded the maximum number of holders
+// set when it was scanned, its balances are not stored.
+type TokenHolderScan struct {
+	ID              string    `json:"id" bson:"_id"`
+	ChainID         uint64    `json:"chainId" bson:"chainId"`
+	Address         string    `json:"address" bson:"address"`
+	StartBlock      uint64    `json:"startBlock" bson:"startBlock"`
+	LastBlock       uint64    `json:"lastBlock" bson:"lastBlock"`
+	PendingBlock    uint64    `json:"pendingBlock" bson:"pendingBlock"`
+	HoldersExceeded uint32    `json:"holdersExceeded,omitempty" bson:"holdersExceeded,omitempty"`
+	UpdatedAt       time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// TokenHolderBalance is the balance of a holder of a token at the given block
+// of the token scan.
+type TokenHolderBalance struct {
+	ID      string `json:"id" bson:"_id"`
+	ScanID  string `json:"scanId" bson:"scanId"`
+	Holder  string `json:"holder" bson:"holder"`
+	Balance string `json:"balance" bson:"balance"`
+	Block   uint64 `json:"block" bson:"block"`
+}
+
+// BotPendingPoll is a poll of the bot that is waiting for its census, stored
+// to resume it if the bot is restarted.
+type BotPendingPoll struct {
+	Hash      string    `bson:"_id"`
+	BotFID    uint64    `bson:"botFid"`
+	Data      []byte    `bson:"data"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+const (
+	// CensusJobStateRunning is the state of the census jobs being built.
+	CensusJobStateRunning = "running"
+	// CensusJobStateDone is the state of the census jobs built successfully.
+	CensusJobStateDone = "done"
+	// CensusJobStateFailed is the state of the census jobs that failed.
+	CensusJobStateFailed = "failed"
+	// CensusJobStateCanceled is the state of the census jobs canceled by
+	// their creator.
+	CensusJobStateCanceled = "canceled"
+)
+
+// CensusJob represents the process of building a census. The result is the
+// JSON encoded census information once the job is done. The running jobs have
+// a lease that the instance that builds them renews, so the jobs whose lease
+// expires were interrupted.
+type CensusJob struct {
+	CensusID   string    `json:"censusId" bson:"_id"`
+	State      string    `json:"state" bson:"state"`
+	Progress   uint32    `json:"progress" bson:"progress"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	Result     string    `json:"result,omitempty" bson:"result,omitempty"`
+	CreatedBy  uint64    `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" bson:"updatedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	LeaseUntil time.Time `json:"leaseUntil,omitempty" bson:"leaseUntil,omitempty"`
+}
+
+// Finished returns true if the job is not running anymore.
+func (j *CensusJob) Finished() bool {
+	return j.State != CensusJobStateRunning
+}
+
+// Channel represents a Farcaster channel of the local channels index. The URL
+// is the parent url of the casts published in the channel.
+type Channel struct {
+	ID          string    `json:"id" bson:"_id"`
+	Name        string    `json:"name" bson:"name"`
+	Description string    `json:"description" bson:"description"`
+	URL         string    `json:"url" bson:"url"`
+	Image       string    `json:"image" bson:"image"`
+	Followers   int       `json:"followers" bson:"followers"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
 // VotersOfElection represents the list of voters of an election. It includes
 // the list of voters, the list of users that have already been reminded and
 // the list of users that can be reminded about the election.
@@ -226,6 +393,20 @@ type Community struct {
 	Notifications bool            `json:"notifications" bson:"notifications"`
 	Disabled      bool            `json:"disabled" bson:"disabled"`
 	Featured      bool            `json:"featured" bson:"featured"`
+	Theme         *CommunityTheme `json:"theme,omitempty" bson:"theme,omitempty"`
+}
+
+// CommunityTheme represents the branding of a community that is applied to the
+// frame images of its polls. The logo is the community image. The version is
+// the timestamp (in milliseconds) of the last update, and it is included in
+// the image cache keys to invalidate them when the theme changes.
+type CommunityTheme struct {
+	Version         uint64 `json:"version" bson:"version"`
+	PrimaryColor    string `json:"primaryColor,omitempty" bson:"primaryColor"`
+	SecondaryColor  string `json:"secondaryColor,omitempty" bson:"secondaryColor"`
+	TextColor       string `json:"textColor,omitempty" bson:"textColor"`
+	BackgroundColor string `json:"backgroundColor,omitempty" bson:"backgroundColor"`
+	BackgroundURL   string `json:"backgroundURL,omitempty" bson:"backgroundURL"`
 }
 
 const (
@@ -295,7 +476,8 @@ func dynamicUpdateDocument(item interface{}, alwaysUpdateTags []string) (bson.M,
 			continue
 		}
 		fieldType := typ.Field(i)
-		tag := fieldType.Tag.Get("bson")
+		// the options of the tag, like omitempty, are not part of the name
+		tag, _, _ := strings.Cut(fieldType.Tag.Get("bson"), ",")
 		if tag == "" || tag == "-" || tag == "_id" {
 			continue
 		}
@@ -0,0 +1,30 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDynamicUpdateDocument(t *testing.T) {
+	c := qt.New(t)
+	syncedAt := time.Unix(1700000000, 0)
+	update, err := dynamicUpdateDocument(&User{
+		UserID:          1,
+		Username:        "alice",
+		RevokedSigners:  []string{"0x01"},
+		SignersSyncedAt: syncedAt,
+	}, []string{"followers"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(update, qt.DeepEquals, bson.M{"$set": bson.M{
+		"username":        "alice",
+		"revokedSigners":  []string{"0x01"},
+		"signersSyncedAt": syncedAt,
+		"followers":       uint64(0),
+	}})
+
+	_, err = dynamicUpdateDocument("not a struct", nil)
+	c.Assert(err, qt.IsNotNil)
+}
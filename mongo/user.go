@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.vocdoni.io/dvote/log"
 )
@@ -121,6 +122,176 @@ func (ms *MongoStorage) UserExists(userFID uint64) bool {
 	return err == nil
 }
 
+// UpdateUserSigners replaces the signers and the revoked signers of the user
+// with the given FID and sets the time of the last signers sync. It returns
+// ErrUserUnknown if the user is not in the database.
+func (ms *MongoStorage) UpdateUserSigners(userFID uint64, signers, revoked []string) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if signers == nil {
+		signers = []string{}
+	}
+	if revoked == nil {
+		revoked = []string{}
+	}
+	res, err := ms.users.UpdateOne(ctx, bson.M{"_id": userFID}, bson.M{
+		"$set": bson.M{
+			"signers":         signers,
+			"revokedSigners":  revoked,
+			"signersSyncedAt": time.Now(),
+		},
+		"$unset": bson.M{"signersRetryAt": ""},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot update user signers: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserUnknown
+	}
+	return nil
+}
+
+// DelayUserSignersSync sets the time before which the signers of the user
+// with the given FID are not synced again, i.e. after a failed sync, so the
+// user is not returned by UsersWithOutdatedSigners until then.
+func (ms *MongoStorage) DelayUserSignersSync(userFID uint64, retryAt time.Time) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := ms.users.UpdateOne(ctx, bson.M{"_id": userFID}, bson.M{"$set": bson.M{
+		"signersRetryAt": retryAt,
+	}})
+	if err != nil {
+		return fmt.Errorf("cannot delay user signers sync: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserUnknown
+	}
+	return nil
+}
+
+// SetUserRegistration stores the time when the user with the given FID was
+// registered in the Farcaster IdRegistry.
+func (ms *MongoStorage) SetUserRegistration(userFID uint64, registeredAt time.Time) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := ms.users.UpdateOne(ctx, bson.M{"_id": userFID}, bson.M{
+		"$set":   bson.M{"registeredAt": registeredAt},
+		"$unset": bson.M{"registrationRetryAt": ""},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot update user registration: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserUnknown
+	}
+	return nil
+}
+
+// DelayUserRegistrationSync sets the time before which the registration of
+// the user with the given FID is not synced again, i.e. after a failed sync,
+// so the user is not returned by UsersWithoutRegistration until then.
+func (ms *MongoStorage) DelayUserRegistrationSync(userFID uint64, retryAt time.Time) error {
+	ms.keysLock.Lock()
+	defer ms.keysLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := ms.users.UpdateOne(ctx, bson.M{"_id": userFID}, bson.M{"$set": bson.M{
+		"registrationRetryAt": retryAt,
+	}})
+	if err != nil {
+		return fmt.Errorf("cannot delay user registration sync: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserUnknown
+	}
+	return nil
+}
+
+// UsersWithoutRegistration returns up to maxResults FIDs of the users whose
+// registration time has not been synced yet. The users whose sync has been
+// delayed after a failure are skipped until their retry time.
+func (ms *MongoStorage) UsersWithoutRegistration(maxResults int) ([]uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"registeredAt": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"registrationRetryAt": bson.M{"$exists": false}},
+			{"registrationRetryAt": bson.M{"$lte": time.Now()}},
+		},
+	}
+	opts := options.Find().
+		SetLimit(int64(maxResults)).
+		SetProjection(bson.M{"_id": 1})
+	cur, err := ms.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	fids := []uint64{}
+	for cur.Next(ctx) {
+		var user struct {
+			ID uint64 `bson:"_id"`
+		}
+		if err := cur.Decode(&user); err != nil {
+			log.Warn(err)
+			continue
+		}
+		fids = append(fids, user.ID)
+	}
+	return fids, cur.Err()
+}
+
+// UsersWithOutdatedSigners returns up to maxResults FIDs of the users whose
+// signers have never been synced or were synced before the given time. The
+// users synced longer ago are returned first. The users whose sync has been
+// delayed after a failure are skipped until their retry time.
+func (ms *MongoStorage) UsersWithOutdatedSigners(before time.Time, maxResults int) ([]uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"signersSyncedAt": bson.M{"$exists": false}},
+			{"signersSyncedAt": bson.M{"$lt": before}},
+		}},
+		{"$or": []bson.M{
+			{"signersRetryAt": bson.M{"$exists": false}},
+			{"signersRetryAt": bson.M{"$lte": time.Now()}},
+		}},
+	}}
+	opts := options.Find().
+		SetSort(bson.M{"signersSyncedAt": 1}).
+		SetLimit(int64(maxResults)).
+		SetProjection(bson.M{"_id": 1})
+	cur, err := ms.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	fids := []uint64{}
+	for cur.Next(ctx) {
+		var user struct {
+			ID uint64 `bson:"_id"`
+		}
+		if err := cur.Decode(&user); err != nil {
+			log.Warn(err)
+			continue
+		}
+		fids = append(fids, user.ID)
+	}
+	return fids, cur.Err()
+}
+
 func (ms *MongoStorage) DelUser(userFID uint64) error {
 	ms.keysLock.Lock()
 	defer ms.keysLock.Unlock()
@@ -130,6 +301,88 @@ func (ms *MongoStorage) DelUser(userFID uint64) error {
 	return err
 }
 
+// UsersWithMinFollowers returns the FIDs of the users of the list provided
+// that are in the database and have at least the given number of followers.
+func (ms *MongoStorage) UsersWithMinFollowers(fids []uint64, minFollowers uint64) ([]uint64, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	result := []uint64{}
+	filter := bson.M{"followers": bson.M{"$gte": minFollowers}}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	err := findIn(ms.users, "_id", fids, filter, opts, func(cursor *mongo.Cursor) error {
+		var user struct {
+			UserID uint64 `bson:"_id"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		result = append(result, user.UserID)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	return result, nil
+}
+
+// UsersByFIDs returns the users of the list provided that are in the
+// database, indexed by FID.
+func (ms *MongoStorage) UsersByFIDs(fids []uint64) (map[uint64]*User, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+	result := make(map[uint64]*User, len(fids))
+	err := findIn(ms.users, "_id", fids, bson.M{}, options.Find(), func(cursor *mongo.Cursor) error {
+		user := &User{}
+		if err := cursor.Decode(user); err != nil {
+			return err
+		}
+		result[user.UserID] = user
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	return result, nil
+}
+
+// findIn runs the find query with the filter provided over the documents of
+// the collection whose field has any of the values provided. The values are
+// queried in batches to keep the size of the filter bounded. The function
+// provided is called for every document found.
+func findIn[T any](collection *mongo.Collection, field string, values []T, filter bson.M,
+	opts *options.FindOptions, fn func(*mongo.Cursor) error,
+) error {
+	const batchSize = 1000
+	for i := 0; i < len(values); i += batchSize {
+		to := i + batchSize
+		if to > len(values) {
+			to = len(values)
+		}
+		batchFilter := bson.M{field: bson.M{"$in": values[i:to]}}
+		for k, v := range filter {
+			batchFilter[k] = v
+		}
+		if err := func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cursor, err := collection.Find(ctx, batchFilter, opts)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				if err := fn(cursor); err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UsersWithPendingProfile returns the list of users that have not set their username yet.
 // This call is limited to 32 users.
 func (ms *MongoStorage) UsersWithPendingProfile() ([]uint64, error) {
@@ -177,6 +430,55 @@ func (ms *MongoStorage) UserByAddress(address string) (*User, error) {
 	return &userByAddress, nil
 }
 
+// UsersByAddresses returns the users that have any of the given addresses
+// verified. The addresses are queried in batches to keep the size of the
+// filter bounded. Every user is returned once, even if many of its addresses
+// are provided.
+func (ms *MongoStorage) UsersByAddresses(addresses []string) ([]*User, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+
+	seen := make(map[uint64]bool)
+	users := []*User{}
+	err := findIn(ms.users, "addresses", addresses, bson.M{}, options.Find(), func(cursor *mongo.Cursor) error {
+		user := &User{}
+		if err := cursor.Decode(user); err != nil {
+			return err
+		}
+		if !seen[user.UserID] {
+			seen[user.UserID] = true
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users by addresses: %w", err)
+	}
+	return users, nil
+}
+
+// UsersByUsernames returns the users of the list of usernames provided that
+// are in the database, indexed by username. The usernames are queried in
+// batches to keep the size of the filter bounded.
+func (ms *MongoStorage) UsersByUsernames(usernames []string) (map[string]*User, error) {
+	ms.keysLock.RLock()
+	defer ms.keysLock.RUnlock()
+
+	users := make(map[string]*User, len(usernames))
+	err := findIn(ms.users, "username", usernames, bson.M{}, options.Find(), func(cursor *mongo.Cursor) error {
+		user := &User{}
+		if err := cursor.Decode(user); err != nil {
+			return err
+		}
+		users[user.Username] = user
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users by usernames: %w", err)
+	}
+	return users, nil
+}
+
 // UserBySigner returns the user that has the given signer. If the user is not found, it returns an error.
 func (ms *MongoStorage) UserBySigner(signer string) (*User, error) {
 	ms.keysLock.RLock()
@@ -14,7 +14,10 @@ import (
 	"go.vocdoni.io/dvote/types"
 )
 
-func (ms *MongoStorage) IncreaseVoteCount(userFID uint64, electionID types.HexBytes, weight *big.Int) error {
+// IncreaseVoteCount increases the vote count of the user and the election, and
+// adds the voter to the election. The choice is the index of the option voted,
+// used to keep the headcount per choice, or -1 if it is unknown.
+func (ms *MongoStorage) IncreaseVoteCount(userFID uint64, electionID types.HexBytes, weight *big.Int, choice int) error {
 	ms.keysLock.Lock()
 	defer ms.keysLock.Unlock()
 	log.Debugw("increase vote count", "userID", userFID, "electionID", electionID.String(), "weight", weight.String())
@@ -53,6 +56,13 @@ func (ms *MongoStorage) IncreaseVoteCount(userFID uint64, electionID types.HexBy
 		accCastedWeight = new(big.Int).SetUint64(0)
 	}
 	election.CastedWeight = new(big.Int).Add(accCastedWeight, weight).String()
+	// count the vote for the choice selected, if it is known
+	if choice >= 0 {
+		for len(election.ChoicesHeadcount) <= choice {
+			election.ChoicesHeadcount = append(election.ChoicesHeadcount, 0)
+		}
+		election.ChoicesHeadcount[choice]++
+	}
 
 	if err := ms.updateElection(election); err != nil {
 		return err
@@ -31,7 +31,13 @@ func (v *vocdoniHandler) checkIfElectionFinishedAndHandle(electionID types.HexBy
 	if pngResults == nil {
 		return false
 	}
+	// the final results image was rendered with the settings of the election
+	var settings *mongo.ResultsImageSettings
+	if electiondb, err := v.db.Election(electionID); err == nil {
+		settings = electiondb.ResultsImage
+	}
 	response := strings.ReplaceAll(frame(frameFinalResults), "{image}", imageLink(imageframe.AddImageToCache(pngResults)))
+	response = strings.ReplaceAll(response, "{aspectRatio}", imageframe.AspectRatio(settings))
 	response = strings.ReplaceAll(response, "{processID}", electionID.String())
 	response = strings.ReplaceAll(response, "{title}", "Final results")
 
@@ -79,6 +85,7 @@ func (v *vocdoniHandler) results(msg *apirest.APIdata, ctx *httprouter.HTTPConte
 			return errorImageResponse(ctx, fmt.Errorf("failed to create final results: %w", err))
 		}
 		response := strings.ReplaceAll(frame(frameFinalResults), "{image}", imageLink(id))
+		response = strings.ReplaceAll(response, "{aspectRatio}", imageframe.AspectRatio(resultsImageSettings(electiondb)))
 		response = strings.ReplaceAll(response, "{processID}", electionID)
 		response = strings.ReplaceAll(response, "{title}", "Final results")
 
@@ -98,7 +105,8 @@ func (v *vocdoniHandler) results(msg *apirest.APIdata, ctx *httprouter.HTTPConte
 	}
 
 	// if not final results, create the dynamic PNG image with the results
-	response := strings.ReplaceAll(frame(frameResults), "{image}", resultsPNGfile(election, electiondb, totalWeightStr))
+	response := strings.ReplaceAll(frame(frameResults), "{image}", v.resultsPNGfile(election, electiondb, totalWeightStr))
+	response = strings.ReplaceAll(response, "{aspectRatio}", imageframe.AspectRatio(resultsImageSettings(electiondb)))
 	response = strings.ReplaceAll(response, "{title}", election.Metadata.Title["default"])
 	response = strings.ReplaceAll(response, "{processID}", electionID)
 	ctx.SetResponseContentType("text/html; charset=utf-8")
@@ -121,7 +129,9 @@ func (v *vocdoniHandler) finalizeElectionResults(election *api.Election, electio
 		totalWeightStr = census.TotalWeight
 	}
 
-	id, err := imageframe.ResultsImage(election, electiondb, totalWeightStr)
+	// the final results must be part of the timeline before rendering it
+	v.recordTallySnapshot(election.ElectionID, election, true)
+	id, err := v.resultsImage(election, electiondb, totalWeightStr, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create image: %w", err)
 	}
@@ -217,10 +227,10 @@ func (v *vocdoniHandler) settleResultsIntoCommunityHub(electiondb *mongo.Electio
 	return nil
 }
 
-func resultsPNGfile(election *api.Election, electiondb *mongo.Election, totalWeightStr string) string {
+func (v *vocdoniHandler) resultsPNGfile(election *api.Election, electiondb *mongo.Election, totalWeightStr string) string {
 	resultsPNGgenerationMutex.Lock()
 	defer resultsPNGgenerationMutex.Unlock()
-	id, err := imageframe.ResultsImage(election, electiondb, totalWeightStr)
+	id, err := v.resultsImage(election, electiondb, totalWeightStr, nil)
 	if err != nil {
 		log.Warnw("failed to create results image", "error", err)
 		return imageLink(imageframe.NotFoundImage())
@@ -228,6 +238,15 @@ func resultsPNGfile(election *api.Election, electiondb *mongo.Election, totalWei
 	return imageLink(id)
 }
 
+// resultsImageSettings returns the results image settings of the election
+// provided, or nil if the election is nil or it has no settings.
+func resultsImageSettings(electiondb *mongo.Election) *mongo.ResultsImageSettings {
+	if electiondb == nil {
+		return nil
+	}
+	return electiondb.ResultsImage
+}
+
 // updateAndFetchResultsFromDatabase updates the results on the database and returns them updated.
 // It also updates the LRU cached election.
 // If election is nil, it fetches it from the vochain API.
@@ -253,6 +272,7 @@ func (v *vocdoniHandler) updateAndFetchResultsFromDatabase(
 	if err := v.db.SetPartialResults(electionID, choices, votesString); err != nil {
 		return nil, fmt.Errorf("failed to update results: %w", err)
 	}
+	v.recordTallySnapshot(electionID, election, false)
 
 	// Fetch results from the database to return them in the response
 	results, err := v.db.Results(electionID)
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/vocdoni/vote-frame/helpers"
+	"github.com/vocdoni/vote-frame/imageframe"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// tallySnapshotInterval is the minimum time between two tally snapshots
+	// of the same election, so the timeline does not grow with every results
+	// request.
+	tallySnapshotInterval = 5 * time.Minute
+	// tallySnapshotsCheckInterval is the time between two checks of the
+	// results of the active elections to record their tally snapshots.
+	tallySnapshotsCheckInterval = time.Minute
+	// tallySnapshotsBatchSize is the maximum number of elections whose
+	// results are checked by every instance on every check.
+	tallySnapshotsBatchSize = 100
+)
+
+// recordTallySnapshot stores a snapshot of the current results of the election
+// if they changed since the last snapshot and it is older than the snapshot
+// interval. If final is true, the interval is ignored so the final results are
+// always part of the timeline.
+func (v *vocdoniHandler) recordTallySnapshot(electionID types.HexBytes, election *api.Election, final bool) {
+	if election == nil {
+		return
+	}
+	_, votes := helpers.ExtractResults(election, 0)
+	votesString := helpers.BigIntsToStrings(votes)
+	last, err := v.db.LastTallySnapshot(electionID)
+	if err != nil && !errors.Is(err, mongo.ErrNoTallySnapshots) {
+		log.Warnw("failed to fetch last tally snapshot", "electionID", electionID.String(), "error", err)
+		return
+	}
+	if last != nil {
+		if last.VoteCount == election.VoteCount && slices.Equal(last.Votes, votesString) {
+			return
+		}
+		if !final && time.Since(last.Timestamp) < tallySnapshotInterval {
+			return
+		}
+	}
+	castedWeight := new(big.Int)
+	for _, vote := range votes {
+		castedWeight.Add(castedWeight, vote)
+	}
+	if err := v.db.AddTallySnapshot(&mongo.TallySnapshot{
+		ElectionID:   electionID.String(),
+		VoteCount:    election.VoteCount,
+		CastedWeight: castedWeight.String(),
+		Votes:        votesString,
+	}); err != nil {
+		log.Warnw("failed to add tally snapshot", "electionID", electionID.String(), "error", err)
+	}
+}
+
+// recordTallySnapshotsAtBackground periodically records a tally snapshot of
+// the active elections, so the timeline of the elections is recorded even if
+// nobody requests their results. Every election is claimed by a single
+// instance once per snapshot interval, and the elections checked on every
+// tick are limited, so the work is shared by the instances and bounded. It
+// must run in the background.
+func (v *vocdoniHandler) recordTallySnapshotsAtBackground(ctx context.Context) {
+	ticker := time.NewTicker(tallySnapshotsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			electionIDs, err := v.db.ClaimTallySnapshotElections(tallySnapshotsBatchSize, tallySnapshotInterval)
+			if err != nil {
+				// record the snapshots of the elections claimed anyway
+				log.Warnw("failed to claim elections to snapshot", "error", err)
+			}
+			for _, electionID := range electionIDs {
+				electionIDbytes, err := hex.DecodeString(electionID)
+				if err != nil {
+					log.Warnw("failed to decode electionID", "electionID", electionID, "error", err)
+					continue
+				}
+				election, err := v.cli.Election(electionIDbytes)
+				if err != nil {
+					log.Warnw("failed to get election from API", "electionID", electionID, "error", err)
+					continue
+				}
+				v.recordTallySnapshot(electionIDbytes, election, false)
+			}
+		}
+	}
+}
+
+// resultsTimeline returns the tally snapshots of an election, which describe
+// the evolution of its results over time.
+func (v *vocdoniHandler) resultsTimeline(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(ctx.URLParam("electionID"))
+	if err != nil {
+		return ctx.Send([]byte("invalid electionID"), http.StatusBadRequest)
+	}
+	election, err := v.election(electionID)
+	if err != nil {
+		return ctx.Send([]byte("election not found"), http.StatusNotFound)
+	}
+	snapshots, err := v.db.TallySnapshots(electionID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tally snapshots: %w", err)
+	}
+	choices, _ := helpers.ExtractResults(election, 0)
+	timeline := &ResultsTimeline{
+		ElectionID: hex.EncodeToString(electionID),
+		Choices:    choices,
+		Snapshots:  []ResultsTimelinePoint{},
+	}
+	for _, snapshot := range snapshots {
+		timeline.Snapshots = append(timeline.Snapshots, ResultsTimelinePoint{
+			Timestamp:    snapshot.Timestamp,
+			VoteCount:    snapshot.VoteCount,
+			CastedWeight: snapshot.CastedWeight,
+			Votes:        snapshot.Votes,
+		})
+	}
+	jresponse, err := json.Marshal(map[string]any{
+		"timeline": timeline,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	ctx.SetResponseContentType("application/json")
+	return ctx.Send(jresponse, http.StatusOK)
+}
+
+// resultsImage creates the results image of the election with the settings
+// provided, or the settings of the electiondb if nil. If the visualization is
+// the timeline one, the image is built from the tally snapshots of the
+// election, falling back to the default visualization if there are none.
+func (v *vocdoniHandler) resultsImage(election *api.Election, electiondb *mongo.Election,
+	totalWeightStr string, settings *mongo.ResultsImageSettings,
+) (string, error) {
+	if settings == nil {
+		settings = resultsImageSettings(electiondb)
+	}
+	theme := v.communityTheme(electiondb)
+	if settings != nil && settings.Visualization == imageframe.VisualizationTimeline {
+		snapshots, err := v.db.TallySnapshots(election.ElectionID)
+		if err != nil {
+			log.Warnw("failed to fetch tally snapshots", "electionID", election.ElectionID.String(), "error", err)
+		}
+		if len(snapshots) > 0 {
+			return imageframe.TimelineImage(election, snapshots, theme, settings)
+		}
+		settings = &mongo.ResultsImageSettings{
+			Visualization: imageframe.VisualizationBars,
+			AspectRatio:   settings.AspectRatio,
+		}
+	}
+	return imageframe.ResultsImage(election, electiondb, totalWeightStr, theme, settings)
+}
@@ -0,0 +1,664 @@
+package tokenholders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	c3web3 "github.com/vocdoni/census3/helpers/web3"
+	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// defaultScanBlocks is the number of blocks of every logs query. It is
+	// reduced if the web3 provider rejects a query, for example because the
+	// range contains too many logs.
+	defaultScanBlocks = 2000
+	// minScanBlocks is the minimum number of blocks of a logs query.
+	minScanBlocks = 10
+	// checkpointBlocks is the number of blocks scanned between two checkpoints
+	// of the scan stored in the database.
+	checkpointBlocks = 100000
+	// maxRequestScanBlocks is the maximum number of blocks scanned while
+	// serving a request, bigger scans like the first one of a token are
+	// executed in background.
+	maxRequestScanBlocks = checkpointBlocks
+)
+
+var (
+	// transferTopic is the topic of the ERC20 and ERC721 Transfer event. The
+	// ERC721 version has the token id indexed, so its log has one topic more.
+	transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	// transferSingleTopic is the topic of the ERC1155 TransferSingle event.
+	transferSingleTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	// transferBatchTopic is the topic of the ERC1155 TransferBatch event.
+	transferBatchTopic = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+	// decimalsSelector is the selector of the ERC20 decimals method.
+	decimalsSelector = crypto.Keccak256([]byte("decimals()"))[:4]
+
+	// blockchainChainIDs maps the names of the supported blockchains to their
+	// chain IDs. Only the ones with an endpoint in the web3 pool are available.
+	blockchainChainIDs = map[string]uint64{
+		"ethereum": 1,
+		"optimism": 10,
+		"polygon":  137,
+		"base":     8453,
+		"arbitrum": 42161,
+		"zora":     7777777,
+		"degen":    666666666,
+	}
+)
+
+// OnchainProvider is a TokenHolderProvider that reconstructs the balances of
+// the holders of ERC20, ERC721 and ERC1155 tokens from their transfer logs,
+// using the endpoints of a web3 pool. The progress of the scan of every token
+// is stored in the database, so the following requests only scan the new
+// blocks. The first scan of a token is executed in background, and the tokens
+// with more holders than the maximum are not supported. The balance of an
+// ERC721 holder is the number of tokens it owns, and the balance of an ERC1155
+// holder is the sum of its balances of every id.
+type OnchainProvider struct {
+	ctx        context.Context
+	w3p        *c3web3.Web3Pool
+	db         *mongo.MongoStorage
+	maxHolders uint32
+	scans      sync.Map // scan id -> *sync.Mutex
+	indexing   sync.Map // scan id -> *scanProgress
+}
+
+// scanProgress is the progress of a token scan executed in background.
+type scanProgress struct {
+	from, to uint64
+	current  atomic.Uint64
+}
+
+// percent returns the progress of the scan as a percentage.
+func (sp *scanProgress) percent() uint64 {
+	if sp.to <= sp.from {
+		return 0
+	}
+	return 100 * (sp.current.Load() - sp.from) / (sp.to - sp.from)
+}
+
+// NewOnchainProvider creates a new OnchainProvider with the web3 pool and the
+// database provided. The background scans are stopped when the context is
+// done. The tokens with more holders than the maximum provided are rejected,
+// if it is not 0.
+func NewOnchainProvider(ctx context.Context, w3p *c3web3.Web3Pool, db *mongo.MongoStorage,
+	maxHolders uint32,
+) (*OnchainProvider, error) {
+	if w3p == nil {
+		return nil, fmt.Errorf("web3 pool is required")
+	}
+	if db == nil {
+		return nil, fmt.Errorf("database is required")
+	}
+	return &OnchainProvider{
+		ctx:        ctx,
+		w3p:        w3p,
+		db:         db,
+		maxHolders: maxHolders,
+	}, nil
+}
+
+// Blockchains returns the names of the supported blockchains that have an
+// endpoint in the web3 pool, sorted alphabetically.
+func (p *OnchainProvider) Blockchains() []string {
+	blockchains := []string{}
+	for name, chainID := range blockchainChainIDs {
+		if _, err := p.w3p.Client(chainID); err == nil {
+			blockchains = append(blockchains, name)
+		}
+	}
+	slices.Sort(blockchains)
+	return blockchains
+}
+
+// MaxHolders returns the maximum number of holders of the tokens scanned, or
+// 0 if it is not limited.
+func (p *OnchainProvider) MaxHolders() uint32 {
+	return p.maxHolders
+}
+
+// TokenDecimals returns the number of decimals of the token calling its
+// decimals method. It fails if the token does not implement it, like the
+// ERC721 and ERC1155 tokens.
+func (p *OnchainProvider) TokenDecimals(ctx context.Context, address common.Address, blockchain string) (int, error) {
+	client, _, err := p.client(blockchain)
+	if err != nil {
+		return 0, err
+	}
+	res, err := client.CallContract(ctx, ethereum.CallMsg{To: &address, Data: decimalsSelector}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get token decimals: %w", err)
+	}
+	if len(res) < common.HashLength {
+		return 0, fmt.Errorf("token %s has no decimals", address)
+	}
+	return int(new(big.Int).SetBytes(res[:common.HashLength]).Uint64()), nil
+}
+
+// BlockByTimestamp returns the number of the last block of the blockchain
+// created before or at the given unix timestamp, using a binary search over
+// the block numbers.
+func (p *OnchainProvider) BlockByTimestamp(ctx context.Context, blockchain string, timestamp int64) (uint64, error) {
+	client, _, err := p.client(blockchain)
+	if err != nil {
+		return 0, err
+	}
+	lastBlock, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get last block number: %w", err)
+	}
+	block, err := searchBlockByTimestamp(lastBlock, timestamp, func(block uint64) (int64, error) {
+		return p.BlockTime(ctx, blockchain, block)
+	})
+	if errors.Is(err, errBeforeGenesis) {
+		return 0, fmt.Errorf("timestamp %d is before the genesis of %s", timestamp, blockchain)
+	}
+	return block, err
+}
+
+// errBeforeGenesis is returned by searchBlockByTimestamp when the timestamp is
+// before the first block.
+var errBeforeGenesis = errors.New("timestamp before genesis")
+
+// searchBlockByTimestamp returns the number of the last block, up to the last
+// block given, created before or at the given unix timestamp. The blocks time
+// is provided by the blockTime function, which must be non-decreasing with
+// the block numbers. It returns errBeforeGenesis if the first block was
+// created after the timestamp.
+func searchBlockByTimestamp(lastBlock uint64, timestamp int64, blockTime func(uint64) (int64, error)) (uint64, error) {
+	if lastTime, err := blockTime(lastBlock); err != nil {
+		return 0, err
+	} else if lastTime <= timestamp {
+		return lastBlock, nil
+	}
+	if genesisTime, err := blockTime(0); err != nil {
+		return 0, err
+	} else if genesisTime > timestamp {
+		return 0, errBeforeGenesis
+	}
+	low, high := uint64(0), lastBlock
+	for low < high {
+		mid := low + (high-low+1)/2
+		midTime, err := blockTime(mid)
+		if err != nil {
+			return 0, err
+		}
+		if midTime <= timestamp {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low, nil
+}
+
+// BlockTime returns the unix timestamp of the given block of the blockchain.
+func (p *OnchainProvider) BlockTime(ctx context.Context, blockchain string, block uint64) (int64, error) {
+	client, _, err := p.client(blockchain)
+	if err != nil {
+		return 0, err
+	}
+	header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+	if err != nil {
+		return 0, fmt.Errorf("cannot get header of block %d: %w", block, err)
+	}
+	return int64(header.Time), nil
+}
+
+// TokenHolders returns the holders of the token and their current balances.
+// It resumes the scan of the token logs from the last checkpoint stored in
+// the database, or starts it from the block where the token was created. The
+// scans of the same token are not executed concurrently. If the token has not
+// been scanned yet, or the scan is too far behind, it is scanned in background
+// and ErrTokenIndexing is returned.
+func (p *OnchainProvider) TokenHolders(ctx context.Context, address common.Address, blockchain string) ([]*TokenHolder, error) {
+	client, _, err := p.client(blockchain)
+	if err != nil {
+		return nil, err
+	}
+	lastBlock, err := client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get last block number: %w", err)
+	}
+	return p.TokenHoldersAt(ctx, address, blockchain, lastBlock)
+}
+
+// TokenHoldersAt returns the holders of the token and their balances at the
+// given block. If the block is after the last checkpoint of the token scan,
+// the scan is resumed up to the block, in background if there are too many
+// blocks to scan. Otherwise, the transfers after the block are reverted from
+// the balances of the last checkpoint, without modifying it.
+func (p *OnchainProvider) TokenHoldersAt(ctx context.Context, address common.Address, blockchain string,
+	block uint64,
+) ([]*TokenHolder, error) {
+	client, chainID, err := p.client(blockchain)
+	if err != nil {
+		return nil, err
+	}
+	lastBlock, err := client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get last block number: %w", err)
+	}
+	if block > lastBlock {
+		return nil, fmt.Errorf("block %d not reached yet, last block is %d", block, lastBlock)
+	}
+	scanID := mongo.TokenHolderScanID(chainID, address.Hex())
+	if progress, ok := p.indexing.Load(scanID); ok {
+		return nil, indexingError(address, progress.(*scanProgress))
+	}
+	lock, _ := p.scans.LoadOrStore(scanID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	scan, balances, err := p.loadScan(ctx, client, chainID, address)
+	if err != nil {
+		return nil, err
+	}
+	if scan.HoldersExceeded != 0 {
+		return nil, fmt.Errorf("%w: %s has more than %d holders", ErrTooManyHolders, address.Hex(), scan.HoldersExceeded)
+	}
+	switch {
+	case block < scan.StartBlock:
+		// the token did not exist yet
+		return []*TokenHolder{}, nil
+	case block >= scan.LastBlock && block-scan.LastBlock > maxRequestScanBlocks:
+		// scan the token up to the last block in background
+		progress := p.startIndexing(client, scan, balances, lastBlock)
+		return nil, indexingError(address, progress)
+	case block >= scan.LastBlock:
+		if err := p.scan(ctx, client, scan, balances, block, nil); err != nil {
+			return nil, err
+		}
+	default:
+		err := transferLogs(ctx, client, address, block+1, scan.LastBlock, func(logs []types.Log, _ uint64) error {
+			revertTransferLogs(logs, balances)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	holders := holdersFromBalances(balances)
+	log.Debugw("token holders scanned",
+		"token", address.Hex(),
+		"chainID", chainID,
+		"block", block,
+		"holders", len(holders))
+	return holders, nil
+}
+
+// startIndexing scans the token in background from the last block of the
+// scan provided to the given block, unless it is already being scanned. It
+// returns the progress of the scan, which is available until it finishes.
+func (p *OnchainProvider) startIndexing(client *c3web3.Client, scan *mongo.TokenHolderScan,
+	balances map[string]*big.Int, toBlock uint64,
+) *scanProgress {
+	progress := &scanProgress{from: scan.LastBlock, to: toBlock}
+	progress.current.Store(scan.LastBlock)
+	if running, ok := p.indexing.LoadOrStore(scan.ID, progress); ok {
+		return running.(*scanProgress)
+	}
+	log.Infow("indexing token holders in background",
+		"token", scan.Address,
+		"chainID", scan.ChainID,
+		"fromBlock", scan.LastBlock,
+		"toBlock", toBlock)
+	go func() {
+		defer p.indexing.Delete(scan.ID)
+		lock, _ := p.scans.LoadOrStore(scan.ID, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+		if err := p.scan(p.ctx, client, scan, balances, toBlock, progress); err != nil {
+			log.Warnw("cannot index token holders", "token", scan.Address, "chainID", scan.ChainID, "error", err)
+			return
+		}
+		log.Infow("token holders indexed", "token", scan.Address, "chainID", scan.ChainID, "holders", len(balances))
+	}()
+	return progress
+}
+
+// indexingError returns an ErrTokenIndexing error with the progress of the
+// background scan of the token provided.
+func indexingError(address common.Address, progress *scanProgress) error {
+	return fmt.Errorf("%w: %s is being scanned (%d%%), try again in a few minutes",
+		ErrTokenIndexing, address.Hex(), progress.percent())
+}
+
+// client returns the web3 client and the chain ID of the blockchain provided.
+func (p *OnchainProvider) client(blockchain string) (*c3web3.Client, uint64, error) {
+	chainID, ok := blockchainChainIDs[blockchain]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported blockchain %s", blockchain)
+	}
+	client, err := p.w3p.Client(chainID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("no web3 endpoint for blockchain %s: %w", blockchain, err)
+	}
+	return client, chainID, nil
+}
+
+// loadScan returns the checkpoint of the scan of the token and the balances of
+// its holders at the last block scanned. If the last scan was interrupted while
+// storing the balances, the update is resumed. If the token has not been
+// scanned yet, or it exceeded a lower maximum number of holders, it returns a
+// new scan that starts at the block where the token was created.
+func (p *OnchainProvider) loadScan(ctx context.Context, client *c3web3.Client, chainID uint64,
+	address common.Address,
+) (*mongo.TokenHolderScan, map[string]*big.Int, error) {
+	scan, err := p.db.TokenHolderScan(chainID, address.Hex())
+	switch {
+	case err == nil && scan.HoldersExceeded != 0 && (p.maxHolders == 0 || p.maxHolders > scan.HoldersExceeded):
+		log.Infow("maximum number of holders increased, scanning token again",
+			"token", address.Hex(),
+			"chainID", chainID,
+			"exceeded", scan.HoldersExceeded)
+		if err := p.db.DeleteTokenHolderScan(chainID, address.Hex()); err != nil {
+			return nil, nil, err
+		}
+	case err == nil && scan.HoldersExceeded != 0:
+		return scan, nil, nil
+	case err == nil:
+		balances, updated, err := p.db.TokenHolderBalances(chainID, address.Hex(), scan.LastBlock)
+		if err != nil {
+			return nil, nil, err
+		}
+		if scan.PendingBlock != 0 {
+			if err := p.resumeScan(ctx, client, scan, balances, updated); err != nil {
+				return nil, nil, err
+			}
+		}
+		return scan, balances, nil
+	case !errors.Is(err, mongo.ErrTokenScanUnknown):
+		return nil, nil, err
+	}
+	startBlock, err := creationBlock(ctx, client, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mongo.TokenHolderScan{
+		ID:         mongo.TokenHolderScanID(chainID, address.Hex()),
+		ChainID:    chainID,
+		Address:    address.Hex(),
+		StartBlock: startBlock,
+		LastBlock:  max(startBlock, 1) - 1,
+	}, make(map[string]*big.Int), nil
+}
+
+// resumeScan completes the update of the balances of an interrupted scan from
+// its last block to its pending block. The transfers of that range are only
+// applied to the balances that were not updated before the interruption.
+func (p *OnchainProvider) resumeScan(ctx context.Context, client *c3web3.Client, scan *mongo.TokenHolderScan,
+	balances map[string]*big.Int, updated map[string]bool,
+) error {
+	log.Warnw("token scan was interrupted, resuming it",
+		"token", scan.Address,
+		"chainID", scan.ChainID,
+		"lastBlock", scan.LastBlock,
+		"pendingBlock", scan.PendingBlock)
+	deltas := make(map[string]*big.Int)
+	err := transferLogs(ctx, client, common.HexToAddress(scan.Address), scan.LastBlock+1, scan.PendingBlock,
+		func(logs []types.Log, _ uint64) error {
+			for _, l := range logs {
+				if !l.Removed {
+					applyTransferLog(&l, deltas, nil, false)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	changed := resumeBalances(balances, updated, deltas)
+	scan.LastBlock = scan.PendingBlock
+	return p.db.UpdateTokenHolderScan(scan, changed)
+}
+
+// scan applies the transfer logs of the token from the block after the last
+// one of the scan to the block provided to the balances, storing a checkpoint
+// in the database periodically and at the end. If the token exceeds the
+// maximum number of holders, the scan is stopped and stored as exceeded. The
+// progress provided, if any, is updated after every chunk of logs.
+func (p *OnchainProvider) scan(ctx context.Context, client *c3web3.Client, scan *mongo.TokenHolderScan,
+	balances map[string]*big.Int, toBlock uint64, progress *scanProgress,
+) error {
+	changed := make(map[string]*big.Int)
+	checkpoint := scan.LastBlock
+	return transferLogs(ctx, client, common.HexToAddress(scan.Address), scan.LastBlock+1, toBlock,
+		func(logs []types.Log, to uint64) error {
+			for _, l := range logs {
+				if !l.Removed {
+					applyTransferLog(&l, balances, changed, false)
+				}
+			}
+			if p.maxHolders != 0 && len(balances) > int(p.maxHolders) {
+				if err := p.db.SetTokenHolderScanExceeded(scan, p.maxHolders); err != nil {
+					return err
+				}
+				return fmt.Errorf("%w: %s has more than %d holders", ErrTooManyHolders, scan.Address, p.maxHolders)
+			}
+			if progress != nil {
+				progress.current.Store(to)
+			}
+			scan.LastBlock = to
+			if to-checkpoint >= checkpointBlocks || to == toBlock {
+				if err := p.db.UpdateTokenHolderScan(scan, changed); err != nil {
+					return err
+				}
+				log.Debugw("token scan checkpoint", "token", scan.Address, "block", to, "target", toBlock)
+				changed = make(map[string]*big.Int)
+				checkpoint = to
+			}
+			return nil
+		})
+}
+
+// transferLogs gets the transfer logs of the token in the range of blocks
+// provided, both included, in chunks. The function provided is called with the
+// logs and the last block of every chunk, in order. The chunks are reduced
+// when the web3 provider rejects a query, and they grow back after every
+// successful one.
+func transferLogs(ctx context.Context, client *c3web3.Client, address common.Address, fromBlock, toBlock uint64,
+	fn func(logs []types.Log, to uint64) error,
+) error {
+	blocks := uint64(defaultScanBlocks)
+	for from := fromBlock; from <= toBlock; {
+		to := min(from+blocks-1, toBlock)
+		logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{address},
+			Topics:    [][]common.Hash{{transferTopic, transferSingleTopic, transferBatchTopic}},
+		})
+		if err != nil {
+			if blocks > minScanBlocks {
+				blocks /= 2
+				continue
+			}
+			return fmt.Errorf("cannot get logs of token %s from block %d to %d: %w", address.Hex(), from, to, err)
+		}
+		if err := fn(logs, to); err != nil {
+			return err
+		}
+		from = to + 1
+		blocks = min(blocks*2, defaultScanBlocks)
+	}
+	return nil
+}
+
+// revertTransferLogs undoes the transfer logs provided from the balances, to
+// get the balances before them.
+func revertTransferLogs(logs []types.Log, balances map[string]*big.Int) {
+	for i := len(logs) - 1; i >= 0; i-- {
+		if !logs[i].Removed {
+			applyTransferLog(&logs[i], balances, nil, true)
+		}
+	}
+}
+
+// resumeBalances applies the balance deltas of an interrupted update to the
+// balances of the holders that were not updated yet, and returns the balances
+// changed.
+func resumeBalances(balances map[string]*big.Int, updated map[string]bool, deltas map[string]*big.Int) map[string]*big.Int {
+	changed := make(map[string]*big.Int)
+	for holder, delta := range deltas {
+		if updated[holder] {
+			continue
+		}
+		updateBalance(balances, changed, common.HexToAddress(holder), delta)
+	}
+	return changed
+}
+
+// holdersFromBalances returns the holders with a positive balance.
+func holdersFromBalances(balances map[string]*big.Int) []*TokenHolder {
+	holders := make([]*TokenHolder, 0, len(balances))
+	for holder, balance := range balances {
+		if balance.Sign() > 0 {
+			holders = append(holders, &TokenHolder{
+				Address: common.HexToAddress(holder),
+				Balance: balance,
+			})
+		}
+	}
+	return holders
+}
+
+// applyTransferLog updates the balances of the sender and the recipient of
+// the transfer log provided, and registers them as changed if the changed map
+// is not nil. Mints and burns only update the balance of the recipient or the
+// sender respectively. If revert is true, the transfer is undone.
+func applyTransferLog(l *types.Log, balances, changed map[string]*big.Int, revert bool) {
+	if len(l.Topics) == 0 {
+		return
+	}
+	var from, to common.Address
+	amount := new(big.Int)
+	switch {
+	case l.Topics[0] == transferTopic && len(l.Topics) == 3 && len(l.Data) >= common.HashLength:
+		// ERC20 transfer, the amount is not indexed
+		from, to = common.BytesToAddress(l.Topics[1].Bytes()), common.BytesToAddress(l.Topics[2].Bytes())
+		amount.SetBytes(l.Data[:common.HashLength])
+	case l.Topics[0] == transferTopic && len(l.Topics) == 4:
+		// ERC721 transfer, the token id is indexed
+		from, to = common.BytesToAddress(l.Topics[1].Bytes()), common.BytesToAddress(l.Topics[2].Bytes())
+		amount.SetUint64(1)
+	case l.Topics[0] == transferSingleTopic && len(l.Topics) == 4 && len(l.Data) >= 2*common.HashLength:
+		from, to = common.BytesToAddress(l.Topics[2].Bytes()), common.BytesToAddress(l.Topics[3].Bytes())
+		amount.SetBytes(l.Data[common.HashLength : 2*common.HashLength])
+	case l.Topics[0] == transferBatchTopic && len(l.Topics) == 4:
+		values, ok := transferBatchValues(l.Data)
+		if !ok {
+			return
+		}
+		from, to = common.BytesToAddress(l.Topics[2].Bytes()), common.BytesToAddress(l.Topics[3].Bytes())
+		for _, value := range values {
+			amount.Add(amount, value)
+		}
+	default:
+		return
+	}
+	if revert {
+		from, to = to, from
+	}
+	if from != (common.Address{}) {
+		updateBalance(balances, changed, from, new(big.Int).Neg(amount))
+	}
+	if to != (common.Address{}) {
+		updateBalance(balances, changed, to, amount)
+	}
+}
+
+// updateBalance adds the delta to the balance of the holder and registers it
+// as changed if the changed map is not nil. The holders without balance are
+// removed from the balances.
+func updateBalance(balances, changed map[string]*big.Int, holder common.Address, delta *big.Int) {
+	key := holder.Hex()
+	balance, ok := balances[key]
+	if !ok {
+		balance = new(big.Int)
+	}
+	balance = new(big.Int).Add(balance, delta)
+	if balance.Sign() == 0 {
+		delete(balances, key)
+	} else {
+		balances[key] = balance
+	}
+	if changed != nil {
+		changed[key] = balance
+	}
+}
+
+// transferBatchValues decodes the values of the data of an ERC1155
+// TransferBatch log, which contains the ABI encoded ids and values arrays.
+func transferBatchValues(data []byte) ([]*big.Int, bool) {
+	word := func(offset uint64) (uint64, bool) {
+		if offset+common.HashLength > uint64(len(data)) {
+			return 0, false
+		}
+		value := new(big.Int).SetBytes(data[offset : offset+common.HashLength])
+		return value.Uint64(), value.IsUint64()
+	}
+	offset, ok := word(common.HashLength)
+	if !ok {
+		return nil, false
+	}
+	length, ok := word(offset)
+	if !ok || length > uint64(len(data))/common.HashLength {
+		return nil, false
+	}
+	start := offset + common.HashLength
+	if start+length*common.HashLength > uint64(len(data)) {
+		return nil, false
+	}
+	values := make([]*big.Int, 0, length)
+	for i := uint64(0); i < length; i++ {
+		position := start + i*common.HashLength
+		values = append(values, new(big.Int).SetBytes(data[position:position+common.HashLength]))
+	}
+	return values, true
+}
+
+// creationBlock returns the first block where the contract has code, using a
+// binary search over the block numbers. If the web3 provider does not support
+// queries of historical state, it returns the genesis block, so the token is
+// scanned from the beginning of the chain.
+func creationBlock(ctx context.Context, client *c3web3.Client, address common.Address) (uint64, error) {
+	lastBlock, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get last block number: %w", err)
+	}
+	code, err := client.CodeAt(ctx, address, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get code of %s: %w", address, err)
+	}
+	if len(code) == 0 {
+		return 0, fmt.Errorf("no contract found at %s", address)
+	}
+	low, high := uint64(0), lastBlock
+	for low < high {
+		mid := low + (high-low)/2
+		code, err := client.CodeAt(ctx, address, new(big.Int).SetUint64(mid))
+		if err != nil {
+			log.Warnw("cannot find token creation block, scanning from genesis", "token", address.Hex(), "error", err)
+			return 0, nil
+		}
+		if len(code) > 0 {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	return low, nil
+}
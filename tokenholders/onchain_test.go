@@ -0,0 +1,294 @@
+package tokenholders
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+)
+
+func word(n uint64) []byte {
+	return common.BigToHash(new(big.Int).SetUint64(n)).Bytes()
+}
+
+func addressTopic(address common.Address) common.Hash {
+	return common.BytesToHash(address.Bytes())
+}
+
+func erc20Transfer(from, to common.Address, amount uint64) types.Log {
+	return types.Log{
+		Topics: []common.Hash{transferTopic, addressTopic(from), addressTopic(to)},
+		Data:   word(amount),
+	}
+}
+
+func erc721Transfer(from, to common.Address, id uint64) types.Log {
+	return types.Log{
+		Topics: []common.Hash{transferTopic, addressTopic(from), addressTopic(to), common.BytesToHash(word(id))},
+	}
+}
+
+func erc1155TransferSingle(from, to common.Address, id, amount uint64) types.Log {
+	return types.Log{
+		Topics: []common.Hash{transferSingleTopic, addressTopic(alice), addressTopic(from), addressTopic(to)},
+		Data:   append(word(id), word(amount)...),
+	}
+}
+
+// batchData returns the ABI encoding of the ids and values arrays of an
+// ERC1155 TransferBatch log.
+func batchData(ids, values []uint64) []byte {
+	data := append(word(2*common.HashLength), word(uint64(3+len(ids))*common.HashLength)...)
+	data = append(data, word(uint64(len(ids)))...)
+	for _, id := range ids {
+		data = append(data, word(id)...)
+	}
+	data = append(data, word(uint64(len(values)))...)
+	for _, value := range values {
+		data = append(data, word(value)...)
+	}
+	return data
+}
+
+func erc1155TransferBatch(from, to common.Address, ids, values []uint64) types.Log {
+	return types.Log{
+		Topics: []common.Hash{transferBatchTopic, addressTopic(alice), addressTopic(from), addressTopic(to)},
+		Data:   batchData(ids, values),
+	}
+}
+
+func balancesOf(values map[common.Address]int64) map[string]*big.Int {
+	balances := make(map[string]*big.Int)
+	for holder, value := range values {
+		balances[holder.Hex()] = big.NewInt(value)
+	}
+	return balances
+}
+
+// balanceStrings returns the decimal representation of the balances provided, to be
+// compared in the tests.
+func balanceStrings(balances map[string]*big.Int) map[string]string {
+	values := make(map[string]string)
+	for holder, balance := range balances {
+		values[holder] = balance.String()
+	}
+	return values
+}
+
+func TestApplyTransferLog(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name     string
+		logs     []types.Log
+		revert   bool
+		initial  map[common.Address]int64
+		expected map[common.Address]int64
+	}{
+		{
+			name:     "erc20 mint and transfer",
+			logs:     []types.Log{erc20Transfer(common.Address{}, alice, 100), erc20Transfer(alice, bob, 40)},
+			expected: map[common.Address]int64{alice: 60, bob: 40},
+		},
+		{
+			name:     "erc20 burn",
+			logs:     []types.Log{erc20Transfer(alice, common.Address{}, 60)},
+			initial:  map[common.Address]int64{alice: 60, bob: 40},
+			expected: map[common.Address]int64{bob: 40},
+		},
+		{
+			name:     "erc721 transfers",
+			logs:     []types.Log{erc721Transfer(common.Address{}, alice, 1), erc721Transfer(common.Address{}, alice, 2), erc721Transfer(alice, bob, 1)},
+			expected: map[common.Address]int64{alice: 1, bob: 1},
+		},
+		{
+			name:     "erc1155 single and batch",
+			logs:     []types.Log{erc1155TransferSingle(common.Address{}, alice, 1, 10), erc1155TransferBatch(alice, bob, []uint64{1, 1}, []uint64{3, 4})},
+			expected: map[common.Address]int64{alice: 3, bob: 7},
+		},
+		{
+			name:     "revert",
+			logs:     []types.Log{erc20Transfer(alice, bob, 40)},
+			revert:   true,
+			initial:  map[common.Address]int64{alice: 60, bob: 40},
+			expected: map[common.Address]int64{alice: 100},
+		},
+		{
+			name: "unknown and malformed logs",
+			logs: []types.Log{
+				{},
+				{Topics: []common.Hash{common.HexToHash("0x01"), addressTopic(alice), addressTopic(bob)}, Data: word(1)},
+				{Topics: []common.Hash{transferTopic, addressTopic(alice), addressTopic(bob)}},
+				{Topics: []common.Hash{transferBatchTopic, addressTopic(alice), addressTopic(alice), addressTopic(bob)}, Data: word(1)},
+			},
+			initial:  map[common.Address]int64{alice: 1},
+			expected: map[common.Address]int64{alice: 1},
+		},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			balances := balancesOf(test.initial)
+			changed := make(map[string]*big.Int)
+			for _, l := range test.logs {
+				applyTransferLog(&l, balances, changed, test.revert)
+			}
+			c.Assert(balanceStrings(balances), qt.DeepEquals, balanceStrings(balancesOf(test.expected)))
+			for holder := range test.expected {
+				if test.initial[holder] != test.expected[holder] {
+					c.Assert(changed[holder.Hex()].Int64(), qt.Equals, test.expected[holder])
+				}
+			}
+		})
+	}
+}
+
+func TestTransferBatchValues(t *testing.T) {
+	c := qt.New(t)
+
+	values, ok := transferBatchValues(batchData([]uint64{1, 2, 3}, []uint64{10, 20, 30}))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(values, qt.HasLen, 3)
+	for i, value := range values {
+		c.Assert(value.Int64(), qt.Equals, int64(10*(i+1)))
+	}
+
+	values, ok = transferBatchValues(batchData(nil, nil))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(values, qt.HasLen, 0)
+
+	// truncated values array
+	data := batchData([]uint64{1, 2}, []uint64{10, 20})
+	_, ok = transferBatchValues(data[:len(data)-1])
+	c.Assert(ok, qt.IsFalse)
+	// values offset out of the data
+	_, ok = transferBatchValues(append(word(0), word(1024)...))
+	c.Assert(ok, qt.IsFalse)
+	// length bigger than the data
+	_, ok = transferBatchValues(append(append(word(0), word(2*common.HashLength)...), word(1<<40)...))
+	c.Assert(ok, qt.IsFalse)
+	// no values offset
+	_, ok = transferBatchValues(word(0))
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestRevertTransferLogs(t *testing.T) {
+	c := qt.New(t)
+
+	// balances at the last block scanned, after the logs of every block
+	blocks := [][]types.Log{
+		{erc20Transfer(common.Address{}, alice, 100)},
+		{erc20Transfer(alice, bob, 40), erc20Transfer(bob, carol, 10)},
+		{erc20Transfer(carol, alice, 10), erc20Transfer(bob, common.Address{}, 30)},
+	}
+	balances := make(map[string]*big.Int)
+	for _, logs := range blocks {
+		for _, l := range logs {
+			applyTransferLog(&l, balances, nil, false)
+		}
+	}
+	c.Assert(balanceStrings(balances), qt.DeepEquals, balanceStrings(balancesOf(map[common.Address]int64{alice: 70})))
+
+	// revert the last block to get the balances at the second one, the
+	// removed logs are ignored
+	removed := erc20Transfer(alice, carol, 70)
+	removed.Removed = true
+	revertTransferLogs(append(blocks[2], removed), balances)
+	c.Assert(balanceStrings(balances), qt.DeepEquals, balanceStrings(balancesOf(map[common.Address]int64{alice: 60, bob: 30, carol: 10})))
+
+	holders := holdersFromBalances(balances)
+	c.Assert(holders, qt.HasLen, 3)
+	for _, holder := range holders {
+		c.Assert(holder.Balance.Cmp(balances[holder.Address.Hex()]), qt.Equals, 0)
+	}
+
+	// revert the rest to get the balances before the token creation
+	revertTransferLogs(blocks[1], balances)
+	revertTransferLogs(blocks[0], balances)
+	c.Assert(balances, qt.HasLen, 0)
+}
+
+func TestResumeBalances(t *testing.T) {
+	c := qt.New(t)
+
+	// the update of the transfers from alice to bob and carol was interrupted
+	// after storing the balance of bob
+	balances := balancesOf(map[common.Address]int64{alice: 100, bob: 40})
+	updated := map[string]bool{bob.Hex(): true}
+	deltas := make(map[string]*big.Int)
+	for _, l := range []types.Log{erc20Transfer(alice, bob, 40), erc20Transfer(alice, carol, 60)} {
+		applyTransferLog(&l, deltas, nil, false)
+	}
+
+	changed := resumeBalances(balances, updated, deltas)
+	c.Assert(balanceStrings(balances), qt.DeepEquals, balanceStrings(balancesOf(map[common.Address]int64{bob: 40, carol: 60})))
+	c.Assert(balanceStrings(changed), qt.DeepEquals, map[string]string{alice.Hex(): "0", carol.Hex(): "60"})
+}
+
+func TestSearchBlockByTimestamp(t *testing.T) {
+	c := qt.New(t)
+	// several blocks can have the same time
+	times := []int64{100, 100, 102, 105, 105, 105, 110}
+	blockTime := func(times []int64) func(uint64) (int64, error) {
+		return func(block uint64) (int64, error) {
+			return times[block], nil
+		}
+	}
+	tests := []struct {
+		name      string
+		times     []int64
+		timestamp int64
+		expected  uint64
+		err       error
+	}{
+		{name: "before genesis", times: times, timestamp: 99, err: errBeforeGenesis},
+		{name: "genesis time", times: times, timestamp: 100, expected: 1},
+		{name: "between blocks", times: times, timestamp: 101, expected: 1},
+		{name: "block time", times: times, timestamp: 102, expected: 2},
+		{name: "last of equal blocks", times: times, timestamp: 105, expected: 5},
+		{name: "before last block", times: times, timestamp: 109, expected: 5},
+		{name: "last block time", times: times, timestamp: 110, expected: 6},
+		{name: "after last block", times: times, timestamp: 1000, expected: 6},
+		{name: "single block", times: []int64{100}, timestamp: 100, expected: 0},
+		{name: "single block before genesis", times: []int64{100}, timestamp: 50, err: errBeforeGenesis},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			block, err := searchBlockByTimestamp(uint64(len(test.times)-1), test.timestamp, blockTime(test.times))
+			if test.err != nil {
+				c.Assert(err, qt.ErrorIs, test.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(block, qt.Equals, test.expected)
+		})
+	}
+
+	// the search takes a logarithmic number of blocks
+	calls := 0
+	block, err := searchBlockByTimestamp(1<<20, 1000, func(block uint64) (int64, error) {
+		calls++
+		return int64(block), nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(block, qt.Equals, uint64(1000))
+	c.Assert(calls <= 22, qt.IsTrue, qt.Commentf("%d calls", calls))
+
+	// the errors getting the time of the blocks are returned
+	errHeader := fmt.Errorf("header not found")
+	_, err = searchBlockByTimestamp(10, 5, func(block uint64) (int64, error) {
+		if block == 5 {
+			return 0, errHeader
+		}
+		return int64(block), nil
+	})
+	c.Assert(err, qt.ErrorIs, errHeader)
+}
@@ -0,0 +1,58 @@
+// Package tokenholders defines the providers of the holders of a token and
+// their balances, used to create NFT and ERC20 based censuses.
+package tokenholders
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrTokenIndexing is returned when the holders of a token are not
+	// available yet because the token is being scanned in background.
+	ErrTokenIndexing = fmt.Errorf("token is being indexed")
+	// ErrTooManyHolders is returned when a token has more holders than the
+	// maximum supported by the provider.
+	ErrTooManyHolders = fmt.Errorf("token has too many holders")
+)
+
+// TokenHolder wraps a token holder with its address and balance of a certain
+// token.
+type TokenHolder struct {
+	Address common.Address
+	Balance *big.Int
+}
+
+// TokenHolderProvider is the interface that every source of token holders
+// must implement.
+type TokenHolderProvider interface {
+	// Blockchains returns the names of the blockchains supported.
+	Blockchains() []string
+	// MaxHolders returns the maximum number of holders that a token can have
+	// to be supported, or 0 if there is no limit.
+	MaxHolders() uint32
+	// TokenDecimals returns the number of decimals of the token with the
+	// given address in the given blockchain.
+	TokenDecimals(ctx context.Context, address common.Address, blockchain string) (int, error)
+	// TokenHolders returns the holders of the token with the given address in
+	// the given blockchain and their current balances.
+	TokenHolders(ctx context.Context, address common.Address, blockchain string) ([]*TokenHolder, error)
+}
+
+// SnapshotProvider is the interface of the sources of token holders that can
+// also resolve the holders of a token at a past block.
+type SnapshotProvider interface {
+	TokenHolderProvider
+	// BlockByTimestamp returns the number of the last block of the given
+	// blockchain created before or at the given unix timestamp.
+	BlockByTimestamp(ctx context.Context, blockchain string, timestamp int64) (uint64, error)
+	// BlockTime returns the unix timestamp of the given block of the given
+	// blockchain.
+	BlockTime(ctx context.Context, blockchain string, block uint64) (int64, error)
+	// TokenHoldersAt returns the holders of the token with the given address
+	// in the given blockchain and their balances at the given block.
+	TokenHoldersAt(ctx context.Context, address common.Address, blockchain string, block uint64) ([]*TokenHolder, error)
+}
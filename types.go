@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/vocdoni/vote-frame/mongo"
+	"go.vocdoni.io/dvote/types"
 )
 
 const (
@@ -36,38 +37,61 @@ type ElectionCreateRequest struct {
 	NotifyUsers      bool              `json:"notifyUsers"`
 	NotificationText string            `json:"notificationText"`
 	CommunityID      *uint64           `json:"community,omitempty"`
+	SavedCensusID    types.HexBytes    `json:"savedCensusId,omitempty"`
 }
 
 // ElectionDescription defines the parameters for a new election.
 type ElectionDescription struct {
-	Question          string        `json:"question"`
-	Options           []string      `json:"options"`
-	Duration          time.Duration `json:"duration"`
-	Overwrite         bool          `json:"overwrite"`
-	UsersCount        uint32        `json:"usersCount"`
-	UsersCountInitial uint32        `json:"usersCountInitial"`
+	Question          string                      `json:"question"`
+	Description       string                      `json:"description,omitempty"`
+	Options           []string                    `json:"options"`
+	Duration          time.Duration               `json:"duration"`
+	Overwrite         bool                        `json:"overwrite"`
+	UsersCount        uint32                      `json:"usersCount"`
+	UsersCountInitial uint32                      `json:"usersCountInitial"`
+	ResultsImage      *mongo.ResultsImageSettings `json:"resultsImage,omitempty"`
 }
 
 // ElectionInfo defines the full details for an election, used by the API.
 type ElectionInfo struct {
-	CreatedTime             time.Time                `json:"createdTime"`
-	ElectionID              string                   `json:"electionId"`
-	LastVoteTime            time.Time                `json:"lastVoteTime"`
-	EndTime                 time.Time                `json:"endTime"`
-	Question                string                   `json:"question"`
-	CastedVotes             uint64                   `json:"voteCount"`
-	CastedWeight            string                   `json:"castedWeight,omitempty"`
-	CensusParticipantsCount uint64                   `json:"censusParticipantsCount"`
-	Turnout                 float32                  `json:"turnout"`
-	FID                     uint64                   `json:"createdByFID,omitempty"`
-	Username                string                   `json:"createdByUsername,omitempty"`
-	Displayname             string                   `json:"createdByDisplayname,omitempty"`
-	TotalWeight             string                   `json:"totalWeight,omitempty"`
-	Participants            []uint64                 `json:"participants,omitempty"`
-	Choices                 []string                 `json:"options,omitempty"`
-	Votes                   []string                 `json:"tally,omitempty"`
-	Finalized               bool                     `json:"finalized"`
-	Community               *mongo.ElectionCommunity `json:"community,omitempty"`
+	CreatedTime             time.Time                   `json:"createdTime"`
+	ElectionID              string                      `json:"electionId"`
+	LastVoteTime            time.Time                   `json:"lastVoteTime"`
+	EndTime                 time.Time                   `json:"endTime"`
+	Question                string                      `json:"question"`
+	CastedVotes             uint64                      `json:"voteCount"`
+	CastedWeight            string                      `json:"castedWeight,omitempty"`
+	CensusParticipantsCount uint64                      `json:"censusParticipantsCount"`
+	Turnout                 float32                     `json:"turnout"`
+	FID                     uint64                      `json:"createdByFID,omitempty"`
+	Username                string                      `json:"createdByUsername,omitempty"`
+	Displayname             string                      `json:"createdByDisplayname,omitempty"`
+	TotalWeight             string                      `json:"totalWeight,omitempty"`
+	Participants            []uint64                    `json:"participants,omitempty"`
+	Choices                 []string                    `json:"options,omitempty"`
+	Votes                   []string                    `json:"tally,omitempty"`
+	Finalized               bool                        `json:"finalized"`
+	Community               *mongo.ElectionCommunity    `json:"community,omitempty"`
+	ResultsImage            *mongo.ResultsImageSettings `json:"resultsImage,omitempty"`
+	CensusSnapshot          *mongo.CensusSnapshot       `json:"censusSnapshot,omitempty"`
+	CensusWeightStrategy    *mongo.CensusWeightStrategy `json:"censusWeightStrategy,omitempty"`
+}
+
+// ResultsTimeline defines the evolution of the results of an election over
+// time, used by the API.
+type ResultsTimeline struct {
+	ElectionID string                 `json:"electionId"`
+	Choices    []string               `json:"options"`
+	Snapshots  []ResultsTimelinePoint `json:"snapshots"`
+}
+
+// ResultsTimelinePoint defines the state of the results of an election at a
+// given time, used by the API.
+type ResultsTimelinePoint struct {
+	Timestamp    time.Time `json:"timestamp"`
+	VoteCount    uint64    `json:"voteCount"`
+	CastedWeight string    `json:"castedWeight"`
+	Votes        []string  `json:"tally"`
 }
 
 // RankedElection defines the attributes of a ranked election
@@ -98,6 +122,54 @@ type CensusToken struct {
 // CensusTokensRequest wraps a token census creation request
 type CensusTokensRequest struct {
 	Tokens []*CensusToken `json:"tokens"`
+	// Block or Timestamp (unix seconds) optionally define the block at which
+	// the token balances are resolved, instead of the last one. They require
+	// all the tokens to be in the same blockchain.
+	Block     uint64 `json:"block,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// CastCensusRequest wraps a cast reactions census creation request. It
+// selects the reactions to the cast that make a user eligible and the weight
+// of each of them. If no reaction is selected, all of them are included. If
+// the weight of a reaction is not provided, it defaults to 1.
+type CastCensusRequest struct {
+	Likes   bool              `json:"likes"`
+	Recasts bool              `json:"recasts"`
+	Replies bool              `json:"replies"`
+	Weights map[string]uint64 `json:"weights,omitempty"`
+}
+
+// GraphCensusRequest wraps a follow graph census creation request. The mode
+// defines the relation with the accounts provided that makes a user eligible.
+// If the minimum number of followers is provided, the users with less
+// followers are excluded.
+type GraphCensusRequest struct {
+	Mode         string   `json:"mode"`
+	FIDs         []uint64 `json:"fids"`
+	MinFollowers uint64   `json:"minFollowers,omitempty"`
+}
+
+// CompositeCensusRequest wraps a composite census creation request. The
+// census is built from the expression tree of sources, combining the weights
+// of the participants included by more than one source with the weight rule.
+type CompositeCensusRequest struct {
+	Source     *CensusSourceNode `json:"source"`
+	WeightRule string            `json:"weightRule,omitempty"`
+}
+
+// CensusSourceNode defines a node of the expression tree of a composite
+// census. A node is either an operation (union, intersection or difference)
+// over its sources or a leaf of one of the supported source types.
+type CensusSourceNode struct {
+	Operation  string              `json:"operation,omitempty"`
+	Sources    []*CensusSourceNode `json:"sources,omitempty"`
+	Type       string              `json:"type,omitempty"`
+	CSV        string              `json:"csv,omitempty"`
+	Channel    string              `json:"channel,omitempty"`
+	UserFID    uint64              `json:"userFid,omitempty"`
+	Tokens     []*CensusToken      `json:"tokens,omitempty"`
+	ElectionID types.HexBytes      `json:"electionId,omitempty"`
 }
 
 // Channel defines the attributes of a channel
@@ -134,17 +206,18 @@ type CensusAddress struct {
 // (FarcasterProfile), the census addresses (CensusAddress) and the channels
 // (Channel)
 type Community struct {
-	ID              uint64           `json:"id"`
-	Name            string           `json:"name"`
-	LogoURL         string           `json:"logoURL"`
-	GroupChatURL    string           `json:"groupChat"`
-	Admins          []*User          `json:"admins,omitempty"`
-	Notifications   bool             `json:"notifications"`
-	CensusType      string           `json:"censusType,omitempty"`
-	CensusAddresses []*CensusAddress `json:"censusAddresses,omitempty"`
-	CensusChannel   *Channel         `json:"censusChannel,omitempty"`
-	Channels        []string         `json:"channels,omitempty"`
-	Disabled        bool             `json:"disabled"`
+	ID              uint64                `json:"id"`
+	Name            string                `json:"name"`
+	LogoURL         string                `json:"logoURL"`
+	GroupChatURL    string                `json:"groupChat"`
+	Admins          []*User               `json:"admins,omitempty"`
+	Notifications   bool                  `json:"notifications"`
+	CensusType      string                `json:"censusType,omitempty"`
+	CensusAddresses []*CensusAddress      `json:"censusAddresses,omitempty"`
+	CensusChannel   *Channel              `json:"censusChannel,omitempty"`
+	Channels        []string              `json:"channels,omitempty"`
+	Disabled        bool                  `json:"disabled"`
+	Theme           *mongo.CommunityTheme `json:"theme,omitempty"`
 }
 
 // CommunityList defines the list of communities
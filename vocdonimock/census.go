@@ -0,0 +1,205 @@
+package vocdonimock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/censustree"
+	"go.vocdoni.io/dvote/data/ipfs"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// census is a weighted census backed by an arbo merkle tree. Once it is
+// published it can not be modified and it is also available by its root.
+type census struct {
+	id        types.HexBytes
+	tree      *censustree.Tree
+	root      types.HexBytes
+	uri       string
+	published bool
+}
+
+// leafKey returns the key of the census tree leaf for the voter key provided,
+// hashed and truncated as the Vocdoni API does for the weighted censuses.
+func (c *census) leafKey(key []byte) ([]byte, error) {
+	leafKey, err := c.tree.Hash(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(leafKey) > censustree.DefaultMaxKeyLen {
+		leafKey = leafKey[:censustree.DefaultMaxKeyLen]
+	}
+	return leafKey, nil
+}
+
+// lookupCensus returns the census with the given ID or root. It must be
+// called with the lock held.
+func (s *Server) lookupCensus(censusID string) (*census, bool) {
+	id, err := parseHex(censusID)
+	if err != nil {
+		return nil, false
+	}
+	if c, ok := s.censuses[id.String()]; ok {
+		return c, true
+	}
+	c, ok := s.published[id.String()]
+	return c, ok
+}
+
+func (s *Server) newCensusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("type") != api.CensusTypeWeighted {
+		sendError(w, http.StatusBadRequest, "unsupported census type %s", r.PathValue("type"))
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	censusID := types.HexBytes(util.RandomBytes(32))
+	tree, err := censustree.New(censustree.Options{
+		ParentDB:   s.db,
+		Name:       censusID.String(),
+		MaxLevels:  censustree.DefaultMaxLevels,
+		CensusType: models.Census_ARBO_BLAKE2B,
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "failed to create census: %v", err)
+		return
+	}
+	s.censuses[censusID.String()] = &census{id: censusID, tree: tree}
+	sendJSON(w, &api.Census{CensusID: censusID})
+}
+
+func (s *Server) censusAddHandler(w http.ResponseWriter, r *http.Request) {
+	participants := &api.CensusParticipants{}
+	if err := json.NewDecoder(r.Body).Decode(participants); err != nil {
+		sendError(w, http.StatusBadRequest, "invalid participants: %v", err)
+		return
+	}
+	if len(participants.Participants) == 0 {
+		sendError(w, http.StatusBadRequest, "missing participants")
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.lookupCensus(r.PathValue("censusID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "census not found")
+		return
+	}
+	if c.published {
+		sendError(w, http.StatusBadRequest, "census already published")
+		return
+	}
+	keys, values := [][]byte{}, [][]byte{}
+	for i, p := range participants.Participants {
+		if p.Key == nil {
+			sendError(w, http.StatusBadRequest, "missing key of participant %d", i)
+			return
+		}
+		if p.Weight == nil {
+			p.Weight = new(types.BigInt).SetUint64(1)
+		}
+		key, err := c.leafKey(p.Key)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "invalid key of participant %d: %v", i, err)
+			return
+		}
+		keys = append(keys, key)
+		values = append(values, c.tree.BigIntToBytes(p.Weight.MathBigInt()))
+	}
+	if _, err := c.tree.AddBatch(keys, values); err != nil {
+		sendError(w, http.StatusInternalServerError, "failed to add participants: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) censusSizeHandler(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.lookupCensus(r.PathValue("censusID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "census not found")
+		return
+	}
+	size, err := c.tree.Size()
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "failed to get census size: %v", err)
+		return
+	}
+	sendJSON(w, &api.Census{Size: size})
+}
+
+// censusPublishHandler publishes the census immediately, so the following
+// check request already returns its root and URI.
+func (s *Server) censusPublishHandler(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.lookupCensus(r.PathValue("censusID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "census not found")
+		return
+	}
+	if !c.published {
+		root, err := c.tree.Root()
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "failed to get census root: %v", err)
+			return
+		}
+		c.root = root
+		c.uri = "ipfs://" + ipfs.CalculateCIDv1json(root)
+		c.published = true
+		s.published[c.root.String()] = c
+	}
+	sendJSON(w, &api.Census{CensusID: c.id})
+}
+
+func (s *Server) censusCheckHandler(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.lookupCensus(r.PathValue("censusID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "census not found")
+		return
+	}
+	if !c.published {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sendJSON(w, &api.Census{CensusID: c.root, URI: c.uri})
+}
+
+func (s *Server) censusProofHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := parseHex(r.PathValue("key"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "invalid key: %v", err)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.lookupCensus(r.PathValue("censusID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "census not found")
+		return
+	}
+	leafKey, err := c.leafKey(key)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "invalid key: %v", err)
+		return
+	}
+	response := &api.Census{Type: api.CensusTypeWeighted}
+	if response.Value, response.CensusProof, err = c.tree.GenProof(leafKey); err != nil {
+		sendError(w, http.StatusNotFound, "key not found in census: %x", leafKey)
+		return
+	}
+	if response.CensusRoot, err = c.tree.Root(); err != nil {
+		sendError(w, http.StatusInternalServerError, "failed to get census root: %v", err)
+		return
+	}
+	if len(response.Value) > 0 {
+		response.Weight = (*types.BigInt)(c.tree.BytesToBigInt(response.Value))
+	}
+	sendJSON(w, response)
+}
@@ -0,0 +1,326 @@
+package vocdonimock
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction/proofs/farcasterproof"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// election is an election created in the server with its votes and results.
+type election struct {
+	id           types.HexBytes
+	organization types.HexBytes
+	process      *models.Process
+	metadata     *api.ElectionMetadata
+	creationTime time.Time
+	startDate    time.Time
+	endDate      time.Time
+	votes        map[string]*vote
+	results      [][]*big.Int
+}
+
+// vote is a vote registered in an election, indexed by its nullifier.
+type vote struct {
+	choices    []int
+	weight     *big.Int
+	overwrites uint32
+}
+
+// info returns the API representation of the election at the given time. The
+// results are final once the end date is reached.
+func (e *election) info(now time.Time) *api.Election {
+	info := &api.Election{
+		ElectionSummary: api.ElectionSummary{
+			ElectionID:     e.id,
+			OrganizationID: e.organization,
+			Status:         models.ProcessStatus_name[int32(models.ProcessStatus_READY)],
+			StartDate:      e.startDate,
+			EndDate:        e.endDate,
+			VoteCount:      uint64(len(e.votes)),
+			ChainID:        ChainID,
+		},
+		Census: &api.ElectionCensus{
+			CensusOrigin:  models.CensusOrigin_name[int32(e.process.CensusOrigin)],
+			CensusRoot:    e.process.CensusRoot,
+			CensusURL:     e.process.GetCensusURI(),
+			MaxCensusSize: e.process.MaxCensusSize,
+		},
+		MetadataURL:  e.process.GetMetadata(),
+		CreationTime: e.creationTime,
+		VoteMode:     api.VoteMode{EnvelopeType: e.process.EnvelopeType},
+		ElectionMode: api.ElectionMode{ProcessMode: e.process.Mode},
+		TallyMode:    api.TallyMode{ProcessVoteOptions: e.process.VoteOptions},
+		Metadata:     e.metadata,
+	}
+	if e.ended(now) {
+		info.Status = models.ProcessStatus_name[int32(models.ProcessStatus_RESULTS)]
+		info.FinalResults = true
+	}
+	for _, question := range e.results {
+		values := []*types.BigInt{}
+		for _, value := range question {
+			values = append(values, (*types.BigInt)(new(big.Int).Set(value)))
+		}
+		info.Results = append(info.Results, values)
+	}
+	return info
+}
+
+// ended returns true if the end date of the election has been reached at the
+// given time.
+func (e *election) ended(now time.Time) bool {
+	return !now.Before(e.endDate)
+}
+
+// addVote registers the vote with the given nullifier, replacing the previous
+// one of the same voter if the election allows to overwrite it.
+func (e *election) addVote(nullifier []byte, choices []int, weight *big.Int) error {
+	options := e.process.VoteOptions
+	if len(choices) == 0 || len(choices) > int(options.MaxCount) {
+		return fmt.Errorf("invalid number of choices %d", len(choices))
+	}
+	for _, choice := range choices {
+		if choice < 0 || choice > int(options.MaxValue) {
+			return fmt.Errorf("invalid choice %d", choice)
+		}
+	}
+	overwrites := uint32(0)
+	if previous, ok := e.votes[string(nullifier)]; ok {
+		if previous.overwrites >= options.MaxVoteOverwrites {
+			return fmt.Errorf("vote %x already exists", nullifier)
+		}
+		for question, choice := range previous.choices {
+			e.results[question][choice].Sub(e.results[question][choice], previous.weight)
+		}
+		overwrites = previous.overwrites + 1
+	}
+	for question, choice := range choices {
+		e.results[question][choice].Add(e.results[question][choice], weight)
+	}
+	e.votes[string(nullifier)] = &vote{
+		choices:    choices,
+		weight:     weight,
+		overwrites: overwrites,
+	}
+	return nil
+}
+
+// lookupElection returns the election with the given ID. It must be called
+// with the lock held.
+func (s *Server) lookupElection(electionID string) (*election, bool) {
+	id, err := parseHex(electionID)
+	if err != nil {
+		return nil, false
+	}
+	e, ok := s.elections[id.String()]
+	return e, ok
+}
+
+func (s *Server) newElectionHandler(w http.ResponseWriter, r *http.Request) {
+	req := &api.ElectionCreate{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		sendError(w, http.StatusBadRequest, "invalid election: %v", err)
+		return
+	}
+	sender, tx, err := decodeTx(req.TxPayload)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	newProcess := tx.GetNewProcess()
+	if newProcess == nil || newProcess.Process == nil {
+		sendError(w, http.StatusBadRequest, "not a new process transaction")
+		return
+	}
+	var metadata *api.ElectionMetadata
+	if req.Metadata != nil {
+		metadata = &api.ElectionMetadata{}
+		if err := json.Unmarshal(req.Metadata, metadata); err != nil {
+			sendError(w, http.StatusBadRequest, "invalid election metadata: %v", err)
+			return
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	acc := s.account(sender)
+	if newProcess.Nonce != acc.Nonce {
+		sendError(w, http.StatusBadRequest, "invalid nonce %d, expected %d", newProcess.Nonce, acc.Nonce)
+		return
+	}
+	process := newProcess.Process
+	now := s.now()
+	startDate := now
+	if process.StartTime > 0 {
+		startDate = time.Unix(int64(process.StartTime), 0)
+	}
+	results := make([][]*big.Int, process.VoteOptions.GetMaxCount())
+	for question := range results {
+		for i := uint32(0); i <= process.VoteOptions.GetMaxValue(); i++ {
+			results[question] = append(results[question], new(big.Int))
+		}
+	}
+	e := &election{
+		id:           ethereum.HashRaw([]byte(fmt.Sprintf("%s%x%d", ChainID, sender, acc.ElectionIndex))),
+		organization: sender,
+		process:      process,
+		metadata:     metadata,
+		creationTime: now,
+		startDate:    startDate,
+		endDate:      startDate.Add(time.Duration(process.Duration) * time.Second),
+		votes:        make(map[string]*vote),
+		results:      results,
+	}
+	process.ProcessId = e.id
+	acc.Nonce++
+	acc.ElectionIndex++
+	s.elections[e.id.String()] = e
+	sendJSON(w, &api.ElectionCreate{
+		TxHash:      ethereum.HashRaw(req.TxPayload),
+		ElectionID:  e.id,
+		MetadataURL: process.GetMetadata(),
+	})
+}
+
+func (s *Server) electionHandler(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.lookupElection(r.PathValue("electionID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "election not found")
+		return
+	}
+	sendJSON(w, e.info(s.now()))
+}
+
+func (s *Server) electionVoteCountHandler(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.lookupElection(r.PathValue("electionID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "election not found")
+		return
+	}
+	sendJSON(w, map[string]uint64{"count": uint64(len(e.votes))})
+}
+
+// voteVerifyHandler answers with an OK status if the vote with the given
+// nullifier is registered in the election, else with a not found status.
+func (s *Server) voteVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	nullifier, err := parseHex(r.PathValue("voteID"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "invalid vote ID: %v", err)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.lookupElection(r.PathValue("electionID"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "election not found")
+		return
+	}
+	if _, ok := e.votes[string(nullifier)]; !ok {
+		sendError(w, http.StatusNotFound, "vote not found")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// transactionHandler processes the transactions sent to the chain. Only the
+// vote transactions with a Farcaster frame proof are supported.
+func (s *Server) transactionHandler(w http.ResponseWriter, r *http.Request) {
+	req := &api.Transaction{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		sendError(w, http.StatusBadRequest, "invalid transaction: %v", err)
+		return
+	}
+	_, tx, err := decodeTx(req.Payload)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	envelope := tx.GetVote()
+	if envelope == nil {
+		sendError(w, http.StatusBadRequest, "unsupported transaction type %T", tx.Payload)
+		return
+	}
+	nullifier, err := s.castVote(envelope)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "invalid vote: %v", err)
+		return
+	}
+	code := uint32(0)
+	sendJSON(w, &api.Transaction{
+		Hash:     ethereum.HashRaw(req.Payload),
+		Response: nullifier,
+		Code:     &code,
+	})
+}
+
+// castVote verifies the Farcaster frame vote provided and registers it in
+// its election. It returns the nullifier of the vote.
+func (s *Server) castVote(envelope *models.VoteEnvelope) ([]byte, error) {
+	if envelope.Proof.GetFarcasterFrame() == nil {
+		return nil, fmt.Errorf("unsupported proof type")
+	}
+	frameVote, err := farcasterproof.InitializeFarcasterFrameVote(envelope, 0)
+	if err != nil {
+		return nil, err
+	}
+	votePackage := &state.VotePackage{}
+	if err := votePackage.Decode(envelope.VotePackage); err != nil {
+		return nil, fmt.Errorf("invalid vote package: %w", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.lookupElection(hex.EncodeToString(envelope.ProcessId))
+	if !ok {
+		return nil, fmt.Errorf("election %x not found", envelope.ProcessId)
+	}
+	now := s.now()
+	if now.Before(e.startDate) || e.ended(now) {
+		return nil, fmt.Errorf("election %x is not accepting votes", envelope.ProcessId)
+	}
+	verifier := &farcasterproof.FarcasterVerifier{}
+	valid, weight, err := verifier.Verify(e.process, envelope, frameVote.VoterID)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid census proof")
+	}
+	if err := e.addVote(frameVote.Nullifier, votePackage.Votes, weight); err != nil {
+		return nil, err
+	}
+	return frameVote.Nullifier, nil
+}
+
+// decodeTx decodes the signed transaction provided and returns its sender and
+// the transaction.
+func decodeTx(payload []byte) (types.HexBytes, *models.Tx, error) {
+	stx := &models.SignedTx{}
+	if err := proto.Unmarshal(payload, stx); err != nil {
+		return nil, nil, fmt.Errorf("invalid signed transaction: %w", err)
+	}
+	msg, tx, err := ethereum.BuildVocdoniTransaction(stx.Tx, ChainID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+	sender, err := ethereum.AddrFromSignature(msg, stx.Signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transaction signature: %w", err)
+	}
+	return sender.Bytes(), tx, nil
+}
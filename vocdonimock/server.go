@@ -0,0 +1,167 @@
+// Package vocdonimock implements a lightweight fake of the Vocdoni API to be
+// used with apiclient.HTTPclient in end-to-end tests and for local development
+// without network access. It supports the subset of the API used by the
+// vote-frame server: accounts, weighted censuses (create, add participants,
+// publish and proofs), elections (create and get), frame votes (submit,
+// count and verify) and the results finalization once the election ends.
+//
+// The census trees and the proofs are real arbo merkle trees, and the votes
+// are verified with the same Farcaster frame verifier used by the Vochain, so
+// the census proofs and the frame signatures must be valid.
+package vocdonimock
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+)
+
+// APIPath is the path where the API is served, as in the public Vocdoni API
+// endpoints, i.e. https://api.vocdoni.io/v2.
+const APIPath = "/v2"
+
+// ChainID is the chain identifier used to verify the transactions signatures.
+// The chain info endpoint is not served, so the clients keep the empty chain
+// ID (and skip downloading the zk circuits, which requires network access).
+const ChainID = ""
+
+// Server is a fake Vocdoni API server. It implements http.Handler, so it can
+// be served with httptest.NewServer or http.ListenAndServe. It is safe for
+// concurrent use.
+type Server struct {
+	mtx       sync.Mutex
+	mux       *http.ServeMux
+	handler   http.Handler
+	db        db.Database
+	offset    time.Duration
+	accounts  map[string]*api.Account
+	censuses  map[string]*census
+	published map[string]*census
+	elections map[string]*election
+}
+
+// New returns a new Server that stores the census trees in the directory
+// provided.
+func New(dataDir string) (*Server, error) {
+	database, err := pebbledb.New(db.Options{Path: dataDir})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open census database: %w", err)
+	}
+	s := &Server{
+		mux:       http.NewServeMux(),
+		db:        database,
+		accounts:  make(map[string]*api.Account),
+		censuses:  make(map[string]*census),
+		published: make(map[string]*census),
+		elections: make(map[string]*election),
+	}
+	s.mux.HandleFunc("GET /chain/info", s.chainInfoHandler)
+	s.mux.HandleFunc("POST /chain/transactions", s.transactionHandler)
+	s.mux.HandleFunc("GET /accounts/{address}", s.accountHandler)
+	s.mux.HandleFunc("POST /censuses/{type}", s.newCensusHandler)
+	s.mux.HandleFunc("POST /censuses/{censusID}/participants", s.censusAddHandler)
+	s.mux.HandleFunc("GET /censuses/{censusID}/size", s.censusSizeHandler)
+	s.mux.HandleFunc("POST /censuses/{censusID}/publish/async", s.censusPublishHandler)
+	s.mux.HandleFunc("GET /censuses/{censusID}/check", s.censusCheckHandler)
+	s.mux.HandleFunc("GET /censuses/{censusID}/proof/{key}", s.censusProofHandler)
+	s.mux.HandleFunc("POST /elections", s.newElectionHandler)
+	s.mux.HandleFunc("GET /elections/{electionID}", s.electionHandler)
+	s.mux.HandleFunc("GET /elections/{electionID}/votes/count", s.electionVoteCountHandler)
+	s.mux.HandleFunc("GET /votes/verify/{electionID}/{voteID}", s.voteVerifyHandler)
+	s.handler = http.StripPrefix(APIPath, s.mux)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler. The API is served under APIPath.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debugw("vocdoni mock request", "method", r.Method, "path", r.URL.Path)
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close closes the census database.
+func (s *Server) Close() error {
+	return s.db.Close()
+}
+
+// Advance moves the clock of the server forward, so the elections can be
+// ended without waiting for their end date.
+func (s *Server) Advance(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.offset += d
+}
+
+// now returns the current time of the server clock. It must be called with
+// the lock held.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// chainInfoHandler answers with no content, so the client does not try to
+// load the zk circuits of the chain.
+func (s *Server) chainInfoHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accountHandler returns the account with the given address. Every account
+// exists, so the clients never need the faucet.
+func (s *Server) accountHandler(w http.ResponseWriter, r *http.Request) {
+	address, err := parseHex(r.PathValue("address"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "invalid address: %v", err)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sendJSON(w, s.account(address))
+}
+
+// account returns the account with the given address, creating it if it does
+// not exist. It must be called with the lock held.
+func (s *Server) account(address types.HexBytes) *api.Account {
+	acc, ok := s.accounts[address.String()]
+	if !ok {
+		acc = &api.Account{
+			Address: address,
+			Balance: 1000,
+		}
+		s.accounts[address.String()] = acc
+	}
+	return acc
+}
+
+// parseHex decodes the hex string provided, with or without the 0x prefix.
+func parseHex(s string) (types.HexBytes, error) {
+	return hex.DecodeString(util.TrimHex(s))
+}
+
+func sendJSON(w http.ResponseWriter, data any) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "failed to marshal response: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func sendError(w http.ResponseWriter, status int, format string, args ...any) {
+	body, _ := json.Marshal(map[string]any{
+		"error": fmt.Sprintf(format, args...),
+		"code":  status,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
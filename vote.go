@@ -123,7 +123,7 @@ func (v *vocdoniHandler) vote(msg *apirest.APIdata, ctx *httprouter.HTTPContext)
 				log.Errorw(err, "failed to add user to database")
 			}
 		}
-		if err := v.db.IncreaseVoteCount(fid, electionIDbytes, weight); err != nil {
+		if err := v.db.IncreaseVoteCount(fid, electionIDbytes, weight, packet.UntrustedData.ButtonIndex-1); err != nil {
 			log.Errorw(err, "failed to increase vote count")
 		}
 
@@ -2,34 +2,38 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/vocdoni/vote-frame/bot"
 	"github.com/vocdoni/vote-frame/bot/poll"
 	fapi "github.com/vocdoni/vote-frame/farcasterapi"
+	"github.com/vocdoni/vote-frame/farcasterapi/mock"
 	"github.com/vocdoni/vote-frame/farcasterapi/neynar"
 	"github.com/vocdoni/vote-frame/shortener"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/log"
-	"go.vocdoni.io/dvote/types"
 )
 
 // initBot helper function initializes the bot and starts listening for new polls
 // to create elections
 func initBot(ctx context.Context, handler *vocdoniHandler, api fapi.API,
-	defaultCensus *CensusInfo,
+	stream fapi.MentionStreamer, defaultCensus *CensusInfo,
 ) (*bot.Bot, error) {
 	voteBot, err := bot.New(bot.BotConfig{
-		API: api,
+		API:    api,
+		Stream: stream,
+		Store:  handler.db,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
 	voteBot.Start(ctx)
+	limiter := newBotCensusLimiter()
+	router := newBotRouter(handler, voteBot, defaultCensus, limiter)
+	resumeBotPendingPolls(ctx, handler, voteBot, limiter)
 	// handle new messages in background
 	go func() {
 		for {
@@ -37,30 +41,16 @@ func initBot(ctx context.Context, handler *vocdoniHandler, api fapi.API,
 			case <-ctx.Done():
 				return
 			case msg := <-voteBot.Messages:
-				// check if the message is a poll and create an election
-				user, poll, isPool, err := voteBot.PollMessageHandler(ctx, msg, maxElectionDuration)
-				if err == nil && isPool {
-					if err := pollToCast(ctx, handler, poll, user, msg, voteBot, defaultCensus); err != nil {
-						log.Errorf("error creating election: %s", err)
-						continue
-					}
-					log.Debugw("poll created and reply sent",
-						"poll", poll,
-						"userdata", user,
+				cmd, err := router.Route(ctx, msg)
+				if err != nil {
+					handleBotCommandError(ctx, voteBot, msg, err)
+				} else if cmd != nil {
+					log.Debugw("bot command handled",
+						"command", cmd.Name,
+						"author", msg.Author,
 						"msg-hash", msg.Hash)
-					continue
-				}
-				// check if the message is a mute request and mute the user
-				user, parentMsg, isMuteRequest, err := voteBot.MuteRequestHandler(ctx, msg)
-				if err == nil && isMuteRequest {
-					if err := mutePollCreator(handler, user, parentMsg); err != nil {
-						log.Errorf("error muting user: %s", err)
-					}
-					log.Debugw("poll creator muted",
-						"user", user,
-						"poll", parentMsg.Embeds)
-					continue
 				}
+				voteBot.Handled()
 			}
 		}
 	}()
@@ -70,15 +60,26 @@ func initBot(ctx context.Context, handler *vocdoniHandler, api fapi.API,
 // pollToCast helper function creates an election from a poll and sends the poll
 // URL to the user replying to the message with the poll frame. If something
 // goes wrong it returns an error.
-func pollToCast(ctx context.Context, handler *vocdoniHandler, poll *poll.Poll,
+func pollToCast(ctx context.Context, handler *vocdoniHandler, p *poll.Poll,
 	user *fapi.Userdata, msg *fapi.APIMessage, voteBot *bot.Bot,
 	defaultCensus *CensusInfo,
 ) error {
 	description := &ElectionDescription{
-		Question:  poll.Question,
-		Options:   poll.Options,
-		Duration:  poll.Duration,
-		Overwrite: false,
+		Question:    p.Question,
+		Description: p.Description,
+		Options:     p.Options,
+		Duration:    p.Duration,
+		Overwrite:   p.Overwrite,
+	}
+	// if the poll has an end time, the duration is calculated and validated
+	// again since the poll can be created a while after parsing it, e.g.
+	// waiting for its census
+	if !p.EndTime.IsZero() {
+		description.Duration = time.Until(p.EndTime)
+		if description.Duration < poll.DefaultConfig.MinDuration {
+			return fmt.Errorf("%w: the poll would end in less than %s from now",
+				poll.ErrParsingEndTime, poll.DefaultConfig.MinDuration)
+		}
 	}
 	profile := &FarcasterProfile{
 		FID:           user.FID,
@@ -87,10 +88,13 @@ func pollToCast(ctx context.Context, handler *vocdoniHandler, poll *poll.Poll,
 		Verifications: user.VerificationsAddresses,
 	}
 	electionID, err := handler.createAndSaveElectionAndProfile(description,
-		defaultCensus, profile, true, false, "", ElectionSourceBot, nil)
+		defaultCensus, profile, true, false, "", ElectionSourceBot, p.CommunityID)
 	if err != nil {
 		return fmt.Errorf("error creating election: %w", err)
 	}
+	if len(defaultCensus.CensusID) > 0 {
+		handler.assignCensus(defaultCensus, electionID)
+	}
 	frameUrl := fmt.Sprintf("%s/%s", serverURL, electionID.String())
 	shortenedUrl, err := shortener.ShortURL(ctx, frameUrl)
 	if err != nil {
@@ -108,26 +112,9 @@ func pollToCast(ctx context.Context, handler *vocdoniHandler, poll *poll.Poll,
 // the election URL is included as a frame) and mutes the user that created the
 // election for the given user. If something goes wrong it returns an error.
 func mutePollCreator(handler *vocdoniHandler, user *fapi.Userdata, parent *fapi.APIMessage) error {
-	var electionID string
-	for _, embed := range parent.Embeds {
-		if strings.HasPrefix(embed, serverURL) {
-			// get the election ID from the embed URL in the parent message
-			// removing the server URL prefix, including the slash separator
-			electionID = strings.TrimPrefix(embed, serverURL+"/")
-			break
-		}
-	}
-	if electionID == "" {
-		return fmt.Errorf("election not found in embeds")
-	}
-	// get election from the database decoding the election ID from string
-	bElectionID, err := hex.DecodeString(electionID)
+	election, err := electionFromCast(handler, parent)
 	if err != nil {
-		return fmt.Errorf("error decoding election ID: %w", err)
-	}
-	election, err := handler.db.Election(types.HexBytes(bElectionID))
-	if err != nil {
-		return fmt.Errorf("error getting election from the database: %w", err)
+		return err
 	}
 	if err := handler.db.AddNotificationMutedUser(user.FID, election.UserID); err != nil {
 		return fmt.Errorf("error muting user: %w", err)
@@ -156,3 +143,15 @@ func neynarWebhook(neynarcli *neynar.NeynarAPI, webhookSecret string) func(*apir
 		return h.Send([]byte("ok"), http.StatusOK)
 	}
 }
+
+// mockWebhook returns the handler that delivers the casts received to the
+// Farcaster API mock, so they can be sent to the bot as mentions.
+func mockWebhook(mockAPI *mock.MockAPI) func(*apirest.APIdata, *httprouter.HTTPContext) error {
+	return func(msg *apirest.APIdata, h *httprouter.HTTPContext) error {
+		if err := mockAPI.WebhookHandler(msg.Data); err != nil {
+			log.Errorf("error handling webhook: %s", err)
+			return h.Send([]byte(err.Error()), http.StatusBadRequest)
+		}
+		return h.Send([]byte("ok"), http.StatusOK)
+	}
+}